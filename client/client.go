@@ -12,8 +12,8 @@ import (
 	"net"
 	"net/http"
 	"net/url"
-	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -21,8 +21,11 @@ import (
 	"github.com/jpillora/chisel/share/ccrypto"
 	"github.com/jpillora/chisel/share/cio"
 	"github.com/jpillora/chisel/share/cnet"
+	"github.com/jpillora/chisel/share/cos"
+	"github.com/jpillora/chisel/share/ctrace"
 	"github.com/jpillora/chisel/share/settings"
 	"github.com/jpillora/chisel/share/tunnel"
+	"github.com/jpillora/sizestr"
 
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/net/proxy"
@@ -32,16 +35,72 @@ import (
 //Config represents a client configuration
 type Config struct {
 	Fingerprint      string
+	Fingerprints     []string
+	KnownHosts       string
 	Auth             string
 	KeepAlive        time.Duration
 	MaxRetryCount    int
 	MaxRetryInterval time.Duration
+	MinRetryInterval time.Duration
+	RetryJitter      bool
+	FailFast         bool
 	Server           string
 	Proxy            string
+	ProxyPAC         string
 	Remotes          []string
-	Headers          http.Header
-	TLS              TLSConfig
-	DialContext      func(ctx context.Context, network, addr string) (net.Conn, error)
+	//Vars are expanded in the remotes, as $NAME or ${NAME}, before
+	//those of VarsFile and the environment, see expandRemote
+	Vars     map[string]string
+	VarsFile string
+	//AuthKey is the path of a private key, and SSHAgent uses the keys
+	//of the ssh-agent, to authenticate as the user of Auth, which the
+	//server verifies with its authorized keys
+	AuthKey     string
+	SSHAgent    bool
+	Headers     http.Header
+	TLS         TLSConfig
+	DialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+	//Tracing exports connection spans when its Endpoint is set
+	Tracing ctrace.OTLPConfig
+	//Servers are failed over to, after those of
+	//Server, which may be comma separated
+	Servers []ServerConfig
+	//Control is the path of the unix socket of the
+	//control API, which updates the remotes at runtime
+	Control string
+	//ConfigFile is the file the remotes were loaded from, which
+	//are reloaded when it changes, or on SIGHUP
+	ConfigFile string
+	//Status is the listen address of the status endpoint, which
+	//reports the connection state and the traffic of each remote
+	Status string
+	//MaxUp and MaxDown limit the bandwidth of all of the
+	//tunnels, in bytes per second, e.g. "1MB"
+	MaxUp, MaxDown string
+	//AcceptPushed binds the remotes the server pushes, of its own
+	//or of the user, so the client may be started without remotes.
+	//It lets the server open connections through the client.
+	AcceptPushed bool
+	//DialTimeout bounds connecting to the server, up to the
+	//websocket upgrade, HandshakeTimeout bounds the SSH handshake
+	//and config exchange which follow, both default to the
+	//CHISEL_WS_TIMEOUT and CHISEL_SSH_TIMEOUT environment variables
+	DialTimeout      time.Duration
+	HandshakeTimeout time.Duration
+	//KeepAliveTimeout closes the connection when a keepalive
+	//isn't answered in time, zero waits for the transport
+	KeepAliveTimeout time.Duration
+	//Hooks react to the client connecting, disconnecting
+	//and failing to bind remotes
+	Hooks HooksConfig
+	//OnState, OnBind and OnError are called, for embedding, when the
+	//client's State changes, as it binds (or fails to bind) each of
+	//its forward remotes, when the server can't yet bind a reverse
+	//remote and binds it on retry, and on connection errors. They are called
+	//from the client's goroutines, so must return promptly.
+	OnState func(State)
+	OnBind  func(remote string, err error)
+	OnError func(error)
 }
 
 //TLSConfig for a Client
@@ -50,6 +109,9 @@ type TLSConfig struct {
 	CA         string
 	Cert       string
 	Key        string
+	//ServerName is sent with SNI and verified against the
+	//server's certificate, it defaults to the Host header
+	ServerName string
 }
 
 //Client represents a client instance
@@ -60,52 +122,79 @@ type Client struct {
 	sshConfig *ssh.ClientConfig
 	tlsConfig *tls.Config
 	proxyURL  *url.URL
-	server    string
+	proxies   *proxyDiscovery
+	servers   *serverPool
 	connCount cnet.ConnCount
 	stop      func()
 	eg        *errgroup.Group
 	tunnel    *tunnel.Tunnel
+	hooks     *hooks
+	state     connState
+	vars      map[string]string
+	//pending are the reverse remotes being retried, ports those
+	//the server allocated for ephemeral remotes, and urls those of
+	//the public remotes, by remote
+	pending map[string]*pendingRemote
+	ports   map[string]string
+	urls    map[string]string
+	//pushed are the remotes the server pushed on the last connection
+	pushed settings.Remotes
+	//goneAway is set when the server is shutting down,
+	//the next connection is to another server
+	goneAway int32
+	//affinity is the server of the cluster last connected to,
+	//which reconnects ask to be routed back to
+	affinity string
+
+	//remotesMut guards the remotes of computed, which is sent on
+	//each connection, as the control API updates them in ctx
+	remotesMut sync.Mutex
+	updateMut  sync.Mutex
+	ctx        context.Context
+	//err is the configuration error of New
+	err error
 }
 
 //NewClient creates a new client instance
 func NewClient(c *Config) (*Client, error) {
-	//apply default scheme
-	if !strings.HasPrefix(c.Server, "http") {
-		c.Server = "http://" + c.Server
+	if _, err := settings.LoadEnviron(); err != nil {
+		return nil, err
 	}
 	if c.MaxRetryInterval < time.Second {
 		c.MaxRetryInterval = 5 * time.Minute
 	}
-	u, err := url.Parse(c.Server)
+	if c.DialTimeout <= 0 {
+		c.DialTimeout = settings.Environment().WSTimeout
+	}
+	if c.HandshakeTimeout <= 0 {
+		c.HandshakeTimeout = settings.Environment().SSHTimeout
+	}
+	servers, err := newServerPool(c.Server, c.Servers)
 	if err != nil {
 		return nil, err
 	}
-	//swap to websockets scheme
-	u.Scheme = strings.Replace(u.Scheme, "http", "ws", 1)
-	//apply default port
-	if !regexp.MustCompile(`:\d+$`).MatchString(u.Host) {
-		if u.Scheme == "wss" {
-			u.Host = u.Host + ":443"
-		} else {
-			u.Host = u.Host + ":80"
-		}
-	}
 	hasReverse := false
 	hasSocks := false
+	hasHTTPProxy := false
 	hasStdio := false
 	client := &Client{
 		Logger: cio.NewLogger("client"),
 		config: c,
 		computed: settings.Config{
-			Version: chshare.BuildVersion,
+			Version:      chshare.BuildVersion,
+			RetryRemotes: true,
+			AcceptPushed: c.AcceptPushed,
 		},
-		server:    u.String(),
+		servers:   servers,
 		tlsConfig: nil,
+		pending:   map[string]*pendingRemote{},
+		ports:     map[string]string{},
+		urls:      map[string]string{},
 	}
 	//set default log level
 	client.Logger.Info = true
 	//configure tls
-	if u.Scheme == "wss" {
+	if servers.TLS() {
 		tc := &tls.Config{}
 		//certificate verification config
 		if c.TLS.SkipVerify {
@@ -132,10 +221,37 @@ func NewClient(c *Config) (*Client, error) {
 		} else if c.TLS.Cert != "" || c.TLS.Key != "" {
 			return nil, fmt.Errorf("Please specify client BOTH cert and key")
 		}
+		//otherwise tls uses the host of the server's url
+		tc.ServerName = c.TLS.ServerName
+		if host := c.Headers.Get("Host"); tc.ServerName == "" && host != "" {
+			if h, _, err := net.SplitHostPort(host); err == nil {
+				host = h
+			}
+			tc.ServerName = host
+		}
+		if tc.ServerName != "" {
+			client.Infof("TLS server name %s", tc.ServerName)
+		}
 		client.tlsConfig = tc
 	}
+	//vars of the remotes
+	client.vars = map[string]string{}
+	if c.VarsFile != "" {
+		vars, err := loadVars(c.VarsFile)
+		if err != nil {
+			return nil, err
+		}
+		client.vars = vars
+	}
+	for k, v := range c.Vars {
+		client.vars[k] = v
+	}
 	//validate remotes
 	for _, s := range c.Remotes {
+		s, err := client.expandRemote(s)
+		if err != nil {
+			return nil, err
+		}
 		r, err := settings.DecodeRemote(s)
 		if err != nil {
 			return nil, fmt.Errorf("Failed to decode remote '%s': %s", s, err)
@@ -143,6 +259,9 @@ func NewClient(c *Config) (*Client, error) {
 		if r.Socks {
 			hasSocks = true
 		}
+		if r.HTTPProxy {
+			hasHTTPProxy = true
+		}
 		if r.Reverse {
 			hasReverse = true
 		}
@@ -164,27 +283,78 @@ func NewClient(c *Config) (*Client, error) {
 		if err != nil {
 			return nil, fmt.Errorf("Invalid proxy URL (%s)", err)
 		}
+	} else {
+		client.proxies = newProxyDiscovery(c.ProxyPAC)
 	}
 	//ssh auth and config
 	user, pass := settings.ParseAuth(c.Auth)
+	if user == "" {
+		//a user without a password, who authenticates with keys
+		user = c.Auth
+	}
+	auth := []ssh.AuthMethod{ssh.Password(pass)}
+	keys, err := newKeyAuth(c.AuthKey, c.SSHAgent)
+	if err != nil {
+		return nil, err
+	}
+	if keys != nil {
+		auth = append([]ssh.AuthMethod{keys.method()}, auth...)
+	}
 	client.sshConfig = &ssh.ClientConfig{
 		User:            user,
-		Auth:            []ssh.AuthMethod{ssh.Password(pass)},
+		Auth:            auth,
 		ClientVersion:   "SSH-" + chshare.ProtocolVersion + "-client",
 		HostKeyCallback: client.verifyServer,
-		Timeout:         settings.EnvDuration("SSH_TIMEOUT", 30*time.Second),
+		Timeout:         c.HandshakeTimeout,
+	}
+	//bandwidth limits
+	up, err := parseBandwidth("max-up", c.MaxUp)
+	if err != nil {
+		return nil, err
 	}
+	down, err := parseBandwidth("max-down", c.MaxDown)
+	if err != nil {
+		return nil, err
+	}
+	client.hooks = newHooks(c.Hooks, client.Logger)
 	//prepare client tunnel
 	client.tunnel = tunnel.New(tunnel.Config{
 		Logger:    client.Logger,
 		Inbound:   true, //client always accepts inbound
-		Outbound:  hasReverse,
+		Outbound:  hasReverse || c.AcceptPushed,
 		Socks:     hasReverse && hasSocks,
+		HTTPProxy: hasReverse && hasHTTPProxy,
 		KeepAlive: client.config.KeepAlive,
+		//bounds the keepalive replies
+		KeepAliveTimeout: client.config.KeepAliveTimeout,
+		//reverse remotes may dial the client's unix sockets
+		UnixSockets: true,
+		Upload:      up,
+		Download:    down,
+		OnBind: func(r *settings.Remote, err error) {
+			if err != nil {
+				client.bindFailed(r, err)
+			} else if c.OnBind != nil {
+				c.OnBind(r.String(), nil)
+			}
+		},
+		OnGoAway: client.goAway,
 	})
 	return client, nil
 }
 
+//parseBandwidth parses a size per second, empty is unlimited
+func parseBandwidth(name, size string) (*cnet.Bandwidth, error) {
+	if size == "" {
+		return nil, nil
+	}
+	n, err := sizestr.Parse(size)
+	if err != nil || n <= 0 {
+		return nil, fmt.Errorf("Invalid %s '%s', expected a size like 1MB", name, size)
+	}
+	return cnet.NewBandwidth(n), nil
+}
+
 //Run starts client and blocks while connected
 func (c *Client) Run() error {
 	ctx, cancel := context.WithCancel(context.Background())
@@ -196,15 +366,33 @@ func (c *Client) Run() error {
 }
 
 func (c *Client) verifyServer(hostname string, remote net.Addr, key ssh.PublicKey) error {
-	expect := c.config.Fingerprint
-	if expect == "" {
+	expected := c.config.Fingerprints
+	if c.config.Fingerprint != "" {
+		expected = append([]string{c.config.Fingerprint}, expected...)
+	}
+	if len(expected) == 0 {
+		if c.config.KnownHosts != "" {
+			return c.verifyKnownHost(key)
+		}
 		return nil
 	}
+	//any of the pinned fingerprints, during key rotations
+	var err error
+	for _, expect := range expected {
+		if err = c.verifyFingerprint(key, expect); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+//verifyFingerprint compares the key with an expected fingerprint
+func (c *Client) verifyFingerprint(key ssh.PublicKey, expect string) error {
 	got := ccrypto.FingerprintKey(key)
 	_, err := base64.StdEncoding.DecodeString(expect)
 	if _, ok := err.(base64.CorruptInputError); ok {
 		c.Logger.Infof("Specified deprecated MD5 fingerprint (%s), please update to the new SHA256 fingerprint: %s", expect, got)
-		return c.verifyLegacyFingerprint(key)
+		return legacyFingerprint(key, expect)
 	} else if err != nil {
 		return fmt.Errorf("Error decoding fingerprint: %w", err)
 	}
@@ -218,13 +406,16 @@ func (c *Client) verifyServer(hostname string, remote net.Addr, key ssh.PublicKe
 
 //verifyLegacyFingerprint calculates and compares legacy MD5 fingerprints
 func (c *Client) verifyLegacyFingerprint(key ssh.PublicKey) error {
+	return legacyFingerprint(key, c.config.Fingerprint)
+}
+
+func legacyFingerprint(key ssh.PublicKey, expect string) error {
 	bytes := md5.Sum(key.Marshal())
 	strbytes := make([]string, len(bytes))
 	for i, b := range bytes {
 		strbytes[i] = fmt.Sprintf("%02x", b)
 	}
 	got := strings.Join(strbytes, ":")
-	expect := c.config.Fingerprint
 	if !strings.HasPrefix(got, expect) {
 		return fmt.Errorf("Invalid fingerprint (%s)", got)
 	}
@@ -233,19 +424,41 @@ func (c *Client) verifyLegacyFingerprint(key ssh.PublicKey) error {
 
 //Start client and does not block
 func (c *Client) Start(ctx context.Context) error {
+	if c.err != nil {
+		return c.err
+	}
 	ctx, cancel := context.WithCancel(ctx)
 	c.stop = cancel
 	eg, ctx := errgroup.WithContext(ctx)
 	c.eg = eg
+	c.ctx = ctx
 	via := ""
 	if c.proxyURL != nil {
 		via = " via " + c.proxyURL.String()
 	}
-	c.Infof("Connecting to %s%s\n", c.server, via)
+	if c.config.Tracing.Endpoint != "" {
+		ctrace.SetExporter(ctrace.NewOTLP(ctx, c.config.Tracing, c.Logger))
+	}
+	c.Infof("Connecting to %s%s\n", c.servers.URL(), via)
 	//connect to chisel server
 	eg.Go(func() error {
 		return c.connectionLoop(ctx)
 	})
+	if c.config.Control != "" {
+		if err := c.serveControl(ctx, c.config.Control); err != nil {
+			return err
+		}
+	}
+	if c.config.Status != "" {
+		if err := c.serveStatus(ctx, c.config.Status); err != nil {
+			return err
+		}
+	}
+	if c.config.ConfigFile != "" {
+		go c.watchConfigFile(ctx)
+	}
+	go cos.SdWatchdog(ctx, nil)
+	go c.hooks.run(ctx)
 	//listen sockets
 	eg.Go(func() error {
 		clientInbound := c.computed.Remotes.Reversed(false)
@@ -290,6 +503,9 @@ func (c *Client) setProxy(u *url.URL, d *websocket.Dialer) error {
 
 //Wait blocks while the client is running.
 func (c *Client) Wait() error {
+	if c.eg == nil {
+		return c.err
+	}
 	return c.eg.Wait()
 }
 
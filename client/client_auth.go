@@ -0,0 +1,73 @@
+package chclient
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// keyAuth is the public key authentication of the client,
+// with its key file, and the keys of the ssh-agent
+type keyAuth struct {
+	signers []ssh.Signer
+	agent   bool
+	//agentConn is reopened for each handshake
+	mut       sync.Mutex
+	agentConn net.Conn
+}
+
+func newKeyAuth(keyFile string, useAgent bool) (*keyAuth, error) {
+	if keyFile == "" && !useAgent {
+		return nil, nil
+	}
+	k := &keyAuth{agent: useAgent}
+	if keyFile != "" {
+		b, err := ioutil.ReadFile(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read auth key: %s", err)
+		}
+		signer, err := ssh.ParsePrivateKey(b)
+		if _, ok := err.(*ssh.PassphraseMissingError); ok {
+			return nil, errors.New("Auth key is encrypted, add it to the ssh-agent instead")
+		} else if err != nil {
+			return nil, fmt.Errorf("Failed to parse auth key: %s", err)
+		}
+		k.signers = append(k.signers, signer)
+	}
+	if useAgent && os.Getenv("SSH_AUTH_SOCK") == "" {
+		return nil, errors.New("SSH_AUTH_SOCK is not set, is the ssh-agent running?")
+	}
+	return k, nil
+}
+
+// method offers the keys to the server, before the password
+func (k *keyAuth) method() ssh.AuthMethod {
+	return ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+		signers := append([]ssh.Signer(nil), k.signers...)
+		if !k.agent {
+			return signers, nil
+		}
+		k.mut.Lock()
+		defer k.mut.Unlock()
+		if k.agentConn != nil {
+			k.agentConn.Close()
+		}
+		conn, err := net.Dial("unix", os.Getenv("SSH_AUTH_SOCK"))
+		if err != nil {
+			//the key file may still succeed
+			return signers, nil
+		}
+		k.agentConn = conn
+		agentSigners, err := agent.NewClient(conn).Signers()
+		if err != nil {
+			return signers, nil
+		}
+		return append(signers, agentSigners...), nil
+	})
+}
@@ -0,0 +1,263 @@
+package chclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/jpillora/chisel/share/cos"
+	"github.com/jpillora/chisel/share/settings"
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigFile is the client configuration loaded by --config,
+// in YAML or JSON, e.g.
+//
+//	server: https://chisel.example.com
+//	servers:
+//	- url: https://chisel-backup.example.com
+//	  priority: 1
+//	auth: user:pass
+//	fingerprint: Fu8J0hY7Nsjsh+5KPfJ1V8XJbzPAhmKj1L7NC1k3zaM=
+//	remotes:
+//	- 3000
+//	- R:${JOB_PORT}:localhost:22
+//	vars:
+//	  JOB_PORT: 2222
+//	proxy: http://proxy.example.com:3128
+//	keepalive: 25s
+//	tls:
+//	  ca: /etc/chisel/ca.pem
+//	  server-name: chisel.internal
+type ConfigFile struct {
+	Server string `yaml:"server"`
+	//Servers are failed over to, after Server
+	Servers      []ServerConfig `yaml:"servers"`
+	Auth         string         `yaml:"auth"`
+	AuthKey      string         `yaml:"auth-key"`
+	SSHAgent     bool           `yaml:"ssh-agent"`
+	Fingerprint  string         `yaml:"fingerprint"`
+	Fingerprints []string       `yaml:"fingerprints"`
+	KnownHosts   string         `yaml:"known-hosts"`
+	Remotes      []string       `yaml:"remotes"`
+	//Vars are expanded in the remotes, before those of VarsFile
+	Vars     map[string]string `yaml:"vars"`
+	VarsFile string            `yaml:"vars-file"`
+	Proxy    string            `yaml:"proxy"`
+	ProxyPAC string            `yaml:"proxy-pac"`
+	//KeepAlive and MaxRetryCount are pointers, zero is meaningful
+	KeepAlive        *time.Duration    `yaml:"keepalive"`
+	MaxRetryCount    *int              `yaml:"max-retry-count"`
+	MaxRetryInterval time.Duration     `yaml:"max-retry-interval"`
+	MinRetryInterval time.Duration     `yaml:"min-retry-interval"`
+	KeepAliveTimeout time.Duration     `yaml:"keepalive-timeout"`
+	DialTimeout      time.Duration     `yaml:"dial-timeout"`
+	HandshakeTimeout time.Duration     `yaml:"handshake-timeout"`
+	RetryJitter      bool              `yaml:"retry-jitter"`
+	FailFast         bool              `yaml:"fail-fast"`
+	MaxUp            string            `yaml:"max-up"`
+	MaxDown          string            `yaml:"max-down"`
+	OnConnect        string            `yaml:"on-connect"`
+	OnDisconnect     string            `yaml:"on-disconnect"`
+	OnBindFailure    string            `yaml:"on-bind-failure"`
+	HookWebhook      string            `yaml:"hook-webhook"`
+	Status           string            `yaml:"status"`
+	AcceptPushed     bool              `yaml:"accept-pushed"`
+	Headers          map[string]string `yaml:"headers"`
+	TLS              struct {
+		SkipVerify bool   `yaml:"skip-verify"`
+		CA         string `yaml:"ca"`
+		Cert       string `yaml:"cert"`
+		Key        string `yaml:"key"`
+		ServerName string `yaml:"server-name"`
+	} `yaml:"tls"`
+}
+
+// LoadFile applies the settings of a config file
+// (see ConfigFile) which are set, to the config
+func (c *Config) LoadFile(path string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("Failed to read config file: %s", err)
+	}
+	var f ConfigFile
+	if err := yaml.Unmarshal(b, &f); err != nil {
+		return errors.New("Invalid config file: " + err.Error())
+	}
+	set := func(dst *string, v string) {
+		if v != "" {
+			*dst = v
+		}
+	}
+	set(&c.Server, f.Server)
+	set(&c.Auth, f.Auth)
+	set(&c.AuthKey, f.AuthKey)
+	if f.SSHAgent {
+		c.SSHAgent = true
+	}
+	set(&c.Fingerprint, f.Fingerprint)
+	set(&c.KnownHosts, f.KnownHosts)
+	if len(f.Fingerprints) > 0 {
+		c.Fingerprints = f.Fingerprints
+	}
+	set(&c.Proxy, f.Proxy)
+	set(&c.ProxyPAC, f.ProxyPAC)
+	set(&c.MaxUp, f.MaxUp)
+	set(&c.MaxDown, f.MaxDown)
+	set(&c.Hooks.OnConnect, f.OnConnect)
+	set(&c.Hooks.OnDisconnect, f.OnDisconnect)
+	set(&c.Hooks.OnBindFailure, f.OnBindFailure)
+	set(&c.Hooks.Webhook, f.HookWebhook)
+	set(&c.Status, f.Status)
+	set(&c.TLS.CA, f.TLS.CA)
+	set(&c.TLS.Cert, f.TLS.Cert)
+	set(&c.TLS.Key, f.TLS.Key)
+	set(&c.TLS.ServerName, f.TLS.ServerName)
+	if f.TLS.SkipVerify {
+		c.TLS.SkipVerify = true
+	}
+	if len(f.Servers) > 0 {
+		c.Servers = f.Servers
+	}
+	if len(f.Remotes) > 0 {
+		c.Remotes = f.Remotes
+	}
+	set(&c.VarsFile, f.VarsFile)
+	if len(f.Vars) > 0 {
+		c.Vars = f.Vars
+	}
+	if f.KeepAlive != nil {
+		c.KeepAlive = *f.KeepAlive
+	}
+	if f.MaxRetryCount != nil {
+		c.MaxRetryCount = *f.MaxRetryCount
+	}
+	if f.MaxRetryInterval != 0 {
+		c.MaxRetryInterval = f.MaxRetryInterval
+	}
+	if f.MinRetryInterval != 0 {
+		c.MinRetryInterval = f.MinRetryInterval
+	}
+	if f.KeepAliveTimeout != 0 {
+		c.KeepAliveTimeout = f.KeepAliveTimeout
+	}
+	if f.DialTimeout != 0 {
+		c.DialTimeout = f.DialTimeout
+	}
+	if f.HandshakeTimeout != 0 {
+		c.HandshakeTimeout = f.HandshakeTimeout
+	}
+	if f.RetryJitter {
+		c.RetryJitter = true
+	}
+	if f.FailFast {
+		c.FailFast = true
+	}
+	if f.AcceptPushed {
+		c.AcceptPushed = true
+	}
+	for k, v := range f.Headers {
+		c.Headers.Set(k, v)
+	}
+	return nil
+}
+
+// watchConfigFile reloads the remotes of the config file whenever
+// the file (or its directory) changes, and on SIGHUP
+func (c *Client) watchConfigFile(ctx context.Context) {
+	reload := func() {
+		if err := c.reloadConfigFile(); err != nil {
+			c.Infof("Failed to reload config file: %s", err)
+		}
+	}
+	go cos.ReloadOnSignal(ctx, reload)
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		c.Infof("Failed to watch config file: %s", err)
+		return
+	}
+	defer watcher.Close()
+	//watch the directory, editors and config maps replace files
+	if err := watcher.Add(filepath.Dir(c.config.ConfigFile)); err != nil {
+		c.Infof("Failed to watch config file: %s", err)
+		return
+	}
+	var changed <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case _, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			//debounce bursts of events
+			changed = time.After(200 * time.Millisecond)
+		case <-changed:
+			reload()
+		}
+	}
+}
+
+// reloadConfigFile updates the remotes of the live session to
+// those of the config file, other settings need a restart
+func (c *Client) reloadConfigFile() error {
+	f := Config{Headers: http.Header{}}
+	if err := f.LoadFile(c.config.ConfigFile); err != nil {
+		return err
+	}
+	vars := map[string]string{}
+	if f.VarsFile != "" {
+		v, err := loadVars(f.VarsFile)
+		if err != nil {
+			return err
+		}
+		vars = v
+	}
+	for k, v := range f.Vars {
+		vars[k] = v
+	}
+	c.updateMut.Lock()
+	c.vars = vars
+	c.updateMut.Unlock()
+	desired := map[string]string{}
+	for _, s := range f.Remotes {
+		s, err := c.expandRemote(s)
+		if err != nil {
+			return err
+		}
+		r, err := settings.DecodeRemote(s)
+		if err != nil {
+			return fmt.Errorf("Failed to decode remote '%s': %s", s, err)
+		}
+		desired[r.String()] = s
+	}
+	if len(desired) == 0 && !c.config.AcceptPushed {
+		return errors.New("At least one remote is required")
+	}
+	var add, remove []string
+	for _, s := range c.Remotes() {
+		r, err := settings.DecodeRemote(s)
+		if err != nil {
+			return err
+		}
+		if _, ok := desired[r.String()]; ok {
+			delete(desired, r.String())
+		} else {
+			remove = append(remove, s)
+		}
+	}
+	for _, s := range desired {
+		add = append(add, s)
+	}
+	if len(add) == 0 && len(remove) == 0 {
+		c.Debugf("Config file reloaded, remotes unchanged")
+		return nil
+	}
+	return c.UpdateRemotes(add, remove)
+}
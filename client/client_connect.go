@@ -5,7 +5,11 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -13,18 +17,37 @@ import (
 	chshare "github.com/jpillora/chisel/share"
 	"github.com/jpillora/chisel/share/cnet"
 	"github.com/jpillora/chisel/share/cos"
+	"github.com/jpillora/chisel/share/ctrace"
 	"github.com/jpillora/chisel/share/settings"
 	"golang.org/x/crypto/ssh"
 )
 
 func (c *Client) connectionLoop(ctx context.Context) error {
+	defer c.setState(StateClosed)
 	//connection loop!
-	b := &backoff.Backoff{Max: c.config.MaxRetryInterval}
+	b := &backoff.Backoff{
+		Min:    c.config.MinRetryInterval,
+		Max:    c.config.MaxRetryInterval,
+		Jitter: c.config.RetryJitter,
+	}
+	everConnected := false
 	for {
 		connected, err := c.connectionOnce(ctx)
+		c.state.ended(err)
+		c.setState(StateDisconnected)
 		//reset backoff after successful connections
+		failover := false
 		if connected {
 			b.Reset()
+			c.servers.connected()
+			everConnected = true
+		} else {
+			failover = c.servers.failed()
+		}
+		//the server shut down, reconnect to another
+		if atomic.SwapInt32(&c.goneAway, 0) == 1 && connected {
+			failover = c.servers.away()
+			c.affinity = ""
 		}
 		//connection error
 		attempt := int(b.Attempt())
@@ -44,6 +67,17 @@ func (c *Client) connectionLoop(ctx context.Context) error {
 				msg += fmt.Sprintf(" (Attempt: %d/%s)", attempt, maxAttemptVal)
 			}
 			c.Infof(msg)
+			c.reportError(err)
+		}
+		//try the other servers before backing off
+		if failover {
+			c.Infof("Failing over to %s", c.servers.URL())
+			continue
+		}
+		//fail fast when the first connection fails
+		if c.config.FailFast && !everConnected && err != nil && err != io.EOF {
+			c.Close()
+			return fmt.Errorf("Failed to connect: %s", err)
 		}
 		//give up?
 		if maxAttempt >= 0 && attempt >= maxAttempt {
@@ -51,6 +85,10 @@ func (c *Client) connectionLoop(ctx context.Context) error {
 			break
 		}
 		d := b.Duration()
+		//the server is full until then
+		if ra, ok := err.(*retryAfterError); ok && d < ra.after {
+			d = ra.after
+		}
 		c.Infof("Retrying in %s...", d)
 		select {
 		case <-cos.AfterSignal(d):
@@ -73,31 +111,85 @@ func (c *Client) connectionOnce(ctx context.Context) (connected bool, err error)
 	default:
 		//still open
 	}
+	c.setState(StateConnecting)
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 	//prepare dialer
 	d := websocket.Dialer{
-		HandshakeTimeout: settings.EnvDuration("WS_TIMEOUT", 45*time.Second),
+		HandshakeTimeout: c.config.DialTimeout,
 		Subprotocols:     []string{chshare.ProtocolVersion},
 		TLSClientConfig:  c.tlsConfig,
-		ReadBufferSize:   settings.EnvInt("WS_BUFF_SIZE", 0),
-		WriteBufferSize:  settings.EnvInt("WS_BUFF_SIZE", 0),
+		ReadBufferSize:   settings.Environment().WSBuffSize,
+		WriteBufferSize:  settings.Environment().WSBuffSize,
+		NetDialContext:   c.config.DialContext,
 	}
-	//optional proxy
-	if p := c.proxyURL; p != nil {
+	//optional proxy, discovered unless set
+	p := c.proxyURL
+	if p == nil {
+		if p, err = c.proxies.proxyFor(ctx, c.servers.URL()); err != nil {
+			return false, err
+		}
+		if p != nil {
+			c.Debugf("Using proxy %s", p)
+		}
+	}
+	if p != nil {
 		if err := c.setProxy(p, &d); err != nil {
 			return false, err
 		}
 	}
-	wsConn, _, err := d.DialContext(ctx, c.server, c.config.Headers)
+	//spans cover the tunnel being established, the server continues the trace
+	establishCtx, span := ctrace.Start(ctx, "tunnel.connect", ctrace.KindClient)
+	server := c.servers.URL()
+	span.SetAttr("server.address", server)
+	headers := http.Header{}
+	for k, v := range c.config.Headers {
+		headers[k] = v
+	}
+	if span != nil {
+		headers.Set("traceparent", ctrace.Traceparent(ctrace.SpanContextFrom(establishCtx)))
+	}
+	//offer to resume the session when its websocket is lost
+	headers.Set(chshare.ResumeHeader, "new")
+	//for the server's per-user session limits
+	if c.sshConfig.User != "" {
+		headers.Set(chshare.UserHeader, c.sshConfig.User)
+	}
+	resumeHeaders := headers.Clone()
+	setAffinity(headers, c.affinity)
+	_, dialSpan := ctrace.Start(establishCtx, "websocket.dial", ctrace.KindClient)
+	wsConn, resp, err := d.DialContext(ctx, server, headers)
+	dialSpan.End(err)
 	if err != nil {
-		return false, err
+		span.End(err)
+		//the server is gone or draining, the client is routed anywhere
+		if resp != nil && (resp.StatusCode == http.StatusBadGateway || resp.StatusCode == http.StatusServiceUnavailable) {
+			c.affinity = ""
+		}
+		return false, retryAfter(resp, err)
 	}
+	c.affinity = resp.Header.Get(chshare.AffinityHeader)
 	conn := cnet.NewWebSocketConn(wsConn)
+	//the server accepted, the ssh connection survives reconnects
+	var resumable *cnet.ResumableConn
+	token := resp.Header.Get(chshare.ResumeHeader)
+	window, _ := time.ParseDuration(resp.Header.Get(chshare.ResumeWindowHeader))
+	if token != "" && window > 0 {
+		if resumable, err = cnet.NewResumableConn(conn); err != nil {
+			span.End(err)
+			return false, err
+		}
+		conn = resumable
+	}
+	//the handshake and config exchange must complete in time
+	conn.SetDeadline(time.Now().Add(c.config.HandshakeTimeout))
 	// perform SSH handshake on net.Conn
 	c.Debugf("Handshaking...")
+	_, handshakeSpan := ctrace.Start(establishCtx, "ssh.handshake", ctrace.KindInternal)
 	sshConn, chans, reqs, err := ssh.NewClientConn(conn, "", c.sshConfig)
+	handshakeSpan.End(err)
 	if err != nil {
+		span.End(err)
 		e := err.Error()
 		if strings.Contains(e, "unable to authenticate") {
 			c.Infof("Authentication failed")
@@ -112,22 +204,151 @@ func (c *Client) connectionOnce(ctx context.Context) (connected bool, err error)
 	// send configuration
 	c.Debugf("Sending config")
 	t0 := time.Now()
-	_, configerr, err := sshConn.SendRequest(
+	c.remotesMut.Lock()
+	config := settings.EncodeConfig(c.computed)
+	c.remotesMut.Unlock()
+	ok, reply, err := sshConn.SendRequest(
 		"config",
 		true,
-		settings.EncodeConfig(c.computed),
+		config,
 	)
 	if err != nil {
 		c.Infof("Config verification failed")
+		span.End(err)
 		return false, err
 	}
-	if len(configerr) > 0 {
-		return false, errors.New(string(configerr))
+	if !ok {
+		err = errors.New(string(reply))
+		span.End(err)
+		return false, err
 	}
+	//the reverse remotes the server can't bind yet, and the
+	//ports it allocated for ephemeral remotes
+	configReply := &settings.ConfigReply{}
+	if len(reply) > 0 {
+		if configReply, err = settings.DecodeConfigReply(reply); err != nil {
+			span.End(err)
+			return false, err
+		}
+	}
+	span.End(nil)
+	conn.SetDeadline(time.Time{})
 	c.Infof("Connected (Latency %s)", time.Since(t0))
+	c.state.up(server)
+	c.setState(StateConnected)
+	cos.SdNotify("READY=1\nSTATUS=Connected to " + server)
+	c.hooks.send(hookEvent{Type: eventConnected, Server: server})
+	c.setAllocated(configReply, true)
+	c.setPushed(configReply.Pushed)
+	c.retryPending(ctx, configReply)
+	defer c.stopPending()
+	if resumable != nil {
+		resumable.SetResumeWindow(window)
+		setAffinity(resumeHeaders, c.affinity)
+		resumeHeaders.Set(chshare.ResumeHeader, token)
+		go c.resume(ctx, resumable, d, server, resumeHeaders, window)
+	}
 	//connected, handover ssh connection for tunnel to use, and block
-	err = c.tunnel.BindSSH(ctx, sshConn, reqs, chans)
+	err = c.tunnel.BindSSH(establishCtx, sshConn, reqs, chans)
 	c.Infof("Disconnected")
+	cos.SdNotify("STATUS=Disconnected from " + server)
+	ev := hookEvent{Type: eventDisconnected, Server: server}
+	if err != nil && err != io.EOF {
+		ev.Error = err.Error()
+	}
+	c.hooks.send(ev)
 	connected = time.Since(t0) > 5*time.Second
 	return connected, err
 }
+
+//retryAfterError is a connection the server refused until later
+type retryAfterError struct {
+	error
+	after time.Duration
+}
+
+//retryAfter is the error of a refused websocket upgrade,
+//with the wait of the Retry-After of a 503 response
+func retryAfter(resp *http.Response, err error) error {
+	if resp == nil || resp.StatusCode != http.StatusServiceUnavailable {
+		return err
+	}
+	secs, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if secs <= 0 {
+		return err
+	}
+	msg := "Server unavailable"
+	if b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 256)); len(b) > 0 {
+		msg = strings.TrimSpace(string(b))
+	}
+	return &retryAfterError{
+		error: fmt.Errorf("%s (retry after %ds)", msg, secs),
+		after: time.Duration(secs) * time.Second,
+	}
+}
+
+//setAffinity asks to be routed to the server of the
+//cluster, by the load balancer or the server reached
+func setAffinity(h http.Header, node string) {
+	if node == "" {
+		return
+	}
+	h.Set(chshare.AffinityHeader, node)
+	cookie := (&http.Cookie{Name: chshare.AffinityCookie, Value: node}).String()
+	if c := h.Get("Cookie"); c != "" {
+		cookie = c + "; " + cookie
+	}
+	h.Set("Cookie", cookie)
+}
+
+//goAway is called when the server asks to be
+//reconnected to elsewhere as it shuts down, the session
+//continues until the server closes it
+func (c *Client) goAway() {
+	c.Infof("Server is shutting down")
+	atomic.StoreInt32(&c.goneAway, 1)
+}
+
+//resume attaches a new websocket to the session's connection
+//whenever its websocket is lost, until the window expires
+func (c *Client) resume(ctx context.Context, conn *cnet.ResumableConn, d websocket.Dialer, server string, headers http.Header, window time.Duration) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-conn.Lost():
+		}
+		if conn.Closed() {
+			return
+		}
+		c.Infof("Connection lost, resuming...")
+		c.setState(StateResuming)
+		deadline := time.Now().Add(window)
+		b := &backoff.Backoff{Min: 100 * time.Millisecond, Max: 2 * time.Second}
+		for {
+			wsConn, resp, err := d.DialContext(ctx, server, headers)
+			if err == nil {
+				if err = conn.Attach(cnet.NewWebSocketConn(wsConn)); err == nil {
+					c.Infof("Resumed")
+					c.setState(StateConnected)
+					break
+				}
+			}
+			if ctx.Err() != nil || conn.Closed() {
+				return
+			}
+			//the server ended the session, or will have
+			if (resp != nil && resp.StatusCode == http.StatusGone) || time.Now().After(deadline) {
+				c.Infof("Failed to resume: %s", err)
+				conn.Close()
+				return
+			}
+			c.Debugf("Resume failed: %s", err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(b.Duration()):
+			}
+		}
+	}
+}
@@ -0,0 +1,288 @@
+package chclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/jpillora/chisel/share/settings"
+)
+
+// controlRemotes is the body of the control API's /remotes requests
+type controlRemotes struct {
+	Remotes []string `json:"remotes"`
+}
+
+// Remotes returns the current remotes of the client
+func (c *Client) Remotes() []string {
+	c.remotesMut.Lock()
+	defer c.remotesMut.Unlock()
+	return c.computed.Remotes.Encode()
+}
+
+// UpdateRemotes removes, then adds, remotes of the running client
+// without reconnecting. The server authorizes the update and binds
+// the reverse remotes, then the client binds the forward remotes.
+func (c *Client) UpdateRemotes(add, remove []string) error {
+	if c.ctx == nil {
+		return errors.New("client not started")
+	}
+	c.updateMut.Lock()
+	defer c.updateMut.Unlock()
+	u := settings.RemotesUpdate{}
+	current := map[string]*settings.Remote{}
+	for _, r := range c.computed.Remotes {
+		current[r.String()] = r
+	}
+	for _, s := range remove {
+		s, err := c.expandRemote(s)
+		if err != nil {
+			return err
+		}
+		r, err := settings.DecodeRemote(s)
+		if err != nil {
+			return fmt.Errorf("Failed to decode remote '%s': %s", s, err)
+		}
+		existing, ok := current[r.String()]
+		if !ok {
+			return fmt.Errorf("Remote %s not found", r)
+		}
+		delete(current, r.String())
+		u.Remove = append(u.Remove, existing)
+	}
+	for _, s := range add {
+		s, err := c.expandRemote(s)
+		if err != nil {
+			return err
+		}
+		r, err := settings.DecodeRemote(s)
+		if err != nil {
+			return fmt.Errorf("Failed to decode remote '%s': %s", s, err)
+		}
+		if _, ok := current[r.String()]; ok {
+			return fmt.Errorf("Remote %s already exists", r)
+		}
+		switch {
+		case r.Stdio:
+			return errors.New("stdio remotes cannot be added at runtime")
+		case r.Reverse && !c.tunnel.Outbound:
+			return errors.New("Reverse remotes can only be added when the client started with one")
+		case r.Reverse && r.Socks && !c.tunnel.Socks:
+			return errors.New("Reverse socks remotes can only be added when the client started with one")
+		case r.Reverse && r.HTTPProxy && !c.tunnel.HTTPProxy:
+			return errors.New("Reverse http remotes can only be added when the client started with one")
+		case !r.Reverse && !r.CanListen():
+			return fmt.Errorf("Client cannot listen on %s", r)
+		}
+		current[r.String()] = r
+		u.Add = append(u.Add, r)
+	}
+	ctx, cancel := context.WithTimeout(c.ctx, settings.Environment().SSHTimeout)
+	defer cancel()
+	reply, err := c.tunnel.Request(ctx, "remotes", settings.EncodeRemotesUpdate(u))
+	if err != nil {
+		return fmt.Errorf("Server refused the update: %s", err)
+	}
+	if err := c.remotesReplied(reply); err != nil {
+		return err
+	}
+	for _, r := range u.Remove.Reversed(false) {
+		c.tunnel.RemoveRemote(r)
+	}
+	var bindErr error
+	for _, r := range u.Add.Reversed(false) {
+		if err := c.tunnel.AddRemote(c.ctx, r); err != nil {
+			//the server accepted it, reconnecting drops it
+			c.Infof("Failed to bind %s: %s", r, err)
+			bindErr = err
+			u.Add = removeRemote(u.Add, r)
+		}
+	}
+	c.remotesMut.Lock()
+	remotes := c.computed.Remotes
+	for _, r := range u.Remove {
+		remotes = removeRemote(remotes, r)
+		//no longer retried
+		if p, ok := c.pending[r.String()]; ok {
+			p.cancel()
+			delete(c.pending, r.String())
+		}
+		delete(c.ports, r.String())
+		delete(c.urls, r.String())
+	}
+	c.computed.Remotes = append(remotes, u.Add...)
+	c.remotesMut.Unlock()
+	c.Infof("Remotes updated (added %d, removed %d)", len(u.Add), len(u.Remove))
+	return bindErr
+}
+
+// remotesReplied applies the server's reply to a "remotes" request
+func (c *Client) remotesReplied(reply []byte) error {
+	if len(reply) == 0 {
+		return nil
+	}
+	r, err := settings.DecodeConfigReply(reply)
+	if err != nil {
+		return err
+	}
+	c.setAllocated(r, false)
+	return nil
+}
+
+// setAllocated records the ports the server allocated for ephemeral
+// remotes, and the URLs of public remotes, replacing those of a
+// previous connection when reset
+func (c *Client) setAllocated(reply *settings.ConfigReply, reset bool) {
+	c.remotesMut.Lock()
+	defer c.remotesMut.Unlock()
+	if reset {
+		c.ports = map[string]string{}
+		c.urls = map[string]string{}
+	}
+	for r, port := range reply.Ports {
+		c.Infof("Server allocated port %s for %s", port, r)
+		c.ports[r] = port
+	}
+	for r, u := range reply.URLs {
+		c.Infof("Server exposed %s at %s", r, u)
+		c.urls[r] = u
+	}
+}
+
+// setPushed binds the forward remotes the server pushed, and
+// unbinds those it no longer pushes, as of the last connection.
+// The server binds the reverse remotes.
+func (c *Client) setPushed(pushed settings.Remotes) {
+	c.remotesMut.Lock()
+	previous := c.pushed
+	c.remotesMut.Unlock()
+	bound := map[string]bool{}
+	for _, r := range previous {
+		bound[r.String()] = true
+	}
+	current := settings.Remotes{}
+	for _, r := range pushed {
+		if bound[r.String()] {
+			delete(bound, r.String())
+			current = append(current, r)
+			continue
+		}
+		if !r.Reverse {
+			if err := c.tunnel.AddRemote(c.ctx, r); err != nil {
+				//retried on the next connection
+				c.Infof("Failed to bind pushed remote %s: %s", r, err)
+				c.bindFailed(r, err)
+				continue
+			}
+		}
+		c.Infof("Server pushed %s", r)
+		current = append(current, r)
+	}
+	for _, r := range previous {
+		if !bound[r.String()] {
+			continue
+		}
+		c.Infof("Server no longer pushes %s", r)
+		if !r.Reverse {
+			c.tunnel.RemoveRemote(r)
+		}
+	}
+	c.remotesMut.Lock()
+	c.pushed = current
+	c.remotesMut.Unlock()
+}
+
+func removeRemote(rs settings.Remotes, r *settings.Remote) settings.Remotes {
+	out := settings.Remotes{}
+	for _, o := range rs {
+		if o.String() != r.String() {
+			out = append(out, o)
+		}
+	}
+	return out
+}
+
+// serveControl serves the control API on a unix socket:
+// GET /remotes lists the remotes, POST /remotes adds and
+// DELETE /remotes removes those of a {"remotes": [...]} body,
+// and GET /status reports the client's status
+func (c *Client) serveControl(ctx context.Context, path string) error {
+	//remove the socket of a previous run
+	os.Remove(path)
+	l, err := listenControl(path)
+	if err != nil {
+		return fmt.Errorf("control: %s", err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/remotes", c.handleControlRemotes)
+	mux.HandleFunc("/status", c.handleStatus)
+	h := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		h.Close()
+		os.Remove(path)
+	}()
+	go h.Serve(l)
+	c.Infof("Control API listening on %s", path)
+	return nil
+}
+
+// listenControl creates the socket in a private directory, where
+// no one else can connect before its mode is set, then moves it
+// to path
+func listenControl(path string) (net.Listener, error) {
+	dir, err := ioutil.TempDir(filepath.Dir(path), ".chisel-control-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	tmp := filepath.Join(dir, "control.sock")
+	l, err := net.Listen("unix", tmp)
+	if err != nil {
+		return nil, err
+	}
+	//the socket is removed from path once the server stops
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	if err = os.Chmod(tmp, 0600); err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+func (c *Client) handleControlRemotes(w http.ResponseWriter, r *http.Request) {
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost, http.MethodDelete:
+		var body controlRemotes
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || len(body.Remotes) == 0 {
+			http.Error(w, "Expected remotes", http.StatusBadRequest)
+			return
+		}
+		if r.Method == http.MethodPost {
+			err = c.UpdateRemotes(body.Remotes, nil)
+		} else {
+			err = c.UpdateRemotes(nil, body.Remotes)
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(controlRemotes{Remotes: c.Remotes()})
+}
@@ -0,0 +1,68 @@
+// Package chclient is the chisel client, which other programs
+// may embed rather than running the chisel binary, e.g.
+//
+//	c := chclient.New(&chclient.Config{
+//		Server:  "https://chisel.example.com",
+//		Remotes: []string{"3000"},
+//		OnState: func(s chclient.State) { log.Printf("tunnel %s", s) },
+//		OnBind:  func(remote string, err error) { ... },
+//		OnError: func(err error) { ... },
+//	})
+//	if err := c.Start(ctx); err != nil {
+//		return err
+//	}
+//	return c.Wait()
+package chclient
+
+import (
+	"github.com/jpillora/chisel/share/cio"
+)
+
+// State of the client's connection to the server
+type State string
+
+// client states, see Config.OnState
+const (
+	StateConnecting   State = "connecting"
+	StateConnected    State = "connected"
+	StateResuming     State = "resuming"
+	StateDisconnected State = "disconnected"
+	StateClosed       State = "closed"
+)
+
+// New creates a client, like NewClient, though
+// its configuration errors are returned by Start
+func New(c *Config) *Client {
+	client, err := NewClient(c)
+	if err != nil {
+		return &Client{Logger: cio.NewLogger("client"), config: c, err: err}
+	}
+	return client
+}
+
+// State returns the state of the client's connection
+func (c *Client) State() State {
+	c.state.mut.Lock()
+	defer c.state.mut.Unlock()
+	if c.state.name == "" {
+		return StateDisconnected
+	}
+	return c.state.name
+}
+
+// setState calls OnState when the state changes
+func (c *Client) setState(s State) {
+	c.state.mut.Lock()
+	changed := c.state.name != s
+	c.state.name = s
+	c.state.mut.Unlock()
+	if changed && c.config.OnState != nil {
+		c.config.OnState(s)
+	}
+}
+
+func (c *Client) reportError(err error) {
+	if c.config.OnError != nil {
+		c.config.OnError(err)
+	}
+}
@@ -0,0 +1,167 @@
+package chclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"time"
+
+	"github.com/jpillora/chisel/share/cio"
+)
+
+// hook event types
+const (
+	eventConnected    = "tunnel.connected"
+	eventDisconnected = "tunnel.disconnected"
+	eventBindFailed   = "remote.bind_failed"
+)
+
+// hooksQueue is how many events may wait for their hooks,
+// later events are dropped while the hooks are slow
+const hooksQueue = 100
+
+// HooksConfig runs commands and posts to a webhook on the
+// client's lifecycle events, so dependent services can react
+type HooksConfig struct {
+	//OnConnect, OnDisconnect and OnBindFailure are shell commands,
+	//run with the environment variables CHISEL_EVENT, CHISEL_SERVER,
+	//CHISEL_REMOTE and CHISEL_ERROR describing the event
+	OnConnect     string
+	OnDisconnect  string
+	OnBindFailure string
+	//Webhook receives each event as a JSON POST
+	Webhook string
+	//Timeout bounds each command and webhook request
+	Timeout time.Duration
+}
+
+// hookEvent is also the body of a webhook request
+type hookEvent struct {
+	Type   string    `json:"type"`
+	Time   time.Time `json:"time"`
+	Server string    `json:"server,omitempty"`
+	Remote string    `json:"remote,omitempty"`
+	Error  string    `json:"error,omitempty"`
+}
+
+// hooks runs the hooks of events in order from a
+// queue, so the tunnel never waits on them
+type hooks struct {
+	*cio.Logger
+	config HooksConfig
+	client *http.Client
+	queue  chan hookEvent
+}
+
+func newHooks(c HooksConfig, l *cio.Logger) *hooks {
+	if c.OnConnect == "" && c.OnDisconnect == "" && c.OnBindFailure == "" && c.Webhook == "" {
+		return nil
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = 30 * time.Second
+	}
+	return &hooks{
+		Logger: l.Fork("hooks"),
+		config: c,
+		client: &http.Client{Timeout: c.Timeout},
+		queue:  make(chan hookEvent, hooksQueue),
+	}
+}
+
+// run runs the hooks of queued events until ctx is cancelled
+func (h *hooks) run(ctx context.Context) {
+	if h == nil {
+		return
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev := <-h.queue:
+			h.handle(ctx, ev)
+		}
+	}
+}
+
+// send queues an event
+func (h *hooks) send(ev hookEvent) {
+	if h == nil {
+		return
+	}
+	ev.Time = time.Now()
+	select {
+	case h.queue <- ev:
+	default:
+		h.Debugf("Queue full, dropped %s event", ev.Type)
+	}
+}
+
+func (h *hooks) handle(ctx context.Context, ev hookEvent) {
+	command := ""
+	switch ev.Type {
+	case eventConnected:
+		command = h.config.OnConnect
+	case eventDisconnected:
+		command = h.config.OnDisconnect
+	case eventBindFailed:
+		command = h.config.OnBindFailure
+	}
+	if command != "" {
+		if err := h.exec(ctx, command, ev); err != nil {
+			h.Infof("%s command failed: %s", ev.Type, err)
+		}
+	}
+	if h.config.Webhook != "" {
+		if err := h.post(ctx, ev); err != nil {
+			h.Infof("%s webhook failed: %s", ev.Type, err)
+		}
+	}
+}
+
+// exec runs the command with the system shell
+func (h *hooks) exec(ctx context.Context, command string, ev hookEvent) error {
+	ctx, cancel := context.WithTimeout(ctx, h.config.Timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	}
+	cmd.Env = append(os.Environ(),
+		"CHISEL_EVENT="+ev.Type,
+		"CHISEL_SERVER="+ev.Server,
+		"CHISEL_REMOTE="+ev.Remote,
+		"CHISEL_ERROR="+ev.Error,
+	)
+	out, err := cmd.CombinedOutput()
+	if len(out) > 0 {
+		h.Debugf("%s: %s", ev.Type, bytes.TrimSpace(out))
+	}
+	return err
+}
+
+func (h *hooks) post(ctx context.Context, ev hookEvent) error {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", h.config.Webhook, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
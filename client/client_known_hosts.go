@@ -0,0 +1,70 @@
+package chclient
+
+import (
+	"bufio"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/jpillora/chisel/share/ccrypto"
+	"golang.org/x/crypto/ssh"
+)
+
+// verifyKnownHost trusts the key of a server on first use, recording
+// its fingerprint in the known hosts file, and refuses changed keys.
+// Each line of the file is a server's host and port, and a fingerprint.
+func (c *Client) verifyKnownHost(key ssh.PublicKey) error {
+	path := c.config.KnownHosts
+	host := c.servers.URL()
+	if u, err := url.Parse(host); err == nil {
+		host = u.Host
+	}
+	got := ccrypto.FingerprintKey(key)
+	known, err := readKnownHosts(path)
+	if err != nil {
+		return err
+	}
+	if fps, ok := known[host]; ok {
+		for _, fp := range fps {
+			if fp == got {
+				c.Debugf("Fingerprint %s known for %s", got, host)
+				return nil
+			}
+		}
+		c.Warnf("The key of %s CHANGED to %s (known as %s in %s), someone may be impersonating the server. "+
+			"If its key was rotated, remove its line from %s", host, got, strings.Join(fps, ", "), path, path)
+		return fmt.Errorf("Server key of %s changed (%s)", host, got)
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("Failed to record known host: %s", err)
+	}
+	defer f.Close()
+	if _, err := fmt.Fprintf(f, "%s %s\n", host, got); err != nil {
+		return fmt.Errorf("Failed to record known host: %s", err)
+	}
+	c.Infof("Trusting %s on first use, recorded fingerprint %s in %s", host, got, path)
+	return nil
+}
+
+// readKnownHosts returns the fingerprints of each host, a missing file has none
+func readKnownHosts(path string) (map[string][]string, error) {
+	known := map[string][]string{}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return known, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("Failed to read known hosts: %s", err)
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) != 2 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		known[fields[0]] = append(known[fields[0]], fields[1])
+	}
+	return known, s.Err()
+}
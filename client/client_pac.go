@@ -0,0 +1,184 @@
+package chclient
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/robertkrimen/otto"
+)
+
+// pacEvalTimeout bounds running the PAC file, replaced by tests
+var pacEvalTimeout = 5 * time.Second
+
+// errPACTimeout interrupts a PAC file which runs too long
+var errPACTimeout = errors.New("PAC took too long")
+
+// pacScript is a proxy auto-config file, run by a JavaScript
+// interpreter with the standard PAC helper functions defined,
+// see the client's --proxy-pac. The interpreter isn't safe for
+// concurrent use.
+type pacScript struct {
+	mut sync.Mutex
+	vm  *otto.Otto
+}
+
+// parsePAC runs a PAC file, which must define FindProxyForURL
+func parsePAC(src string) (*pacScript, error) {
+	s := &pacScript{vm: otto.New()}
+	for _, name := range pacFunctions {
+		name := name
+		if err := s.vm.Set(name, func(call otto.FunctionCall) otto.Value {
+			args := make([]string, len(call.ArgumentList))
+			for i, a := range call.ArgumentList {
+				args[i] = a.String()
+			}
+			v, err := pacCall(name, args)
+			if err != nil {
+				panic(call.Otto.MakeCustomError("Error", err.Error()))
+			}
+			result, _ := call.Otto.ToValue(v)
+			return result
+		}); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := s.run(func() (otto.Value, error) { return s.vm.Run(src) }); err != nil {
+		return nil, fmt.Errorf("Invalid PAC file: %s", err)
+	}
+	if fn, err := s.vm.Get("FindProxyForURL"); err != nil || !fn.IsFunction() {
+		return nil, errors.New("PAC has no FindProxyForURL function")
+	}
+	return s, nil
+}
+
+// FindProxyForURL runs the script's function, returning
+// its result, e.g. "PROXY proxy:8080; DIRECT"
+func (s *pacScript) FindProxyForURL(url, host string) (string, error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	v, err := s.run(func() (otto.Value, error) { return s.vm.Call("FindProxyForURL", nil, url, host) })
+	if err != nil {
+		return "", fmt.Errorf("PAC failed: %s", err)
+	}
+	if !v.IsString() {
+		return "", errors.New("PAC returned a non-string")
+	}
+	return v.String(), nil
+}
+
+// run calls fn, interrupting it after pacEvalTimeout
+func (s *pacScript) run(fn func() (otto.Value, error)) (v otto.Value, err error) {
+	s.vm.Interrupt = make(chan func(), 1)
+	t := time.AfterFunc(pacEvalTimeout, func() {
+		s.vm.Interrupt <- func() { panic(errPACTimeout) }
+	})
+	defer t.Stop()
+	defer func() {
+		if r := recover(); r != nil {
+			if r != errPACTimeout {
+				panic(r)
+			}
+			err = errPACTimeout
+		}
+	}()
+	return fn()
+}
+
+// pacFunctions are the standard PAC helper functions
+var pacFunctions = []string{
+	"isPlainHostName", "dnsDomainIs", "localHostOrDomainIs", "shExpMatch",
+	"isResolvable", "dnsResolve", "isInNet", "myIpAddress", "dnsDomainLevels",
+}
+
+// pacLookup resolves hostnames, replaced by tests
+var pacLookup = net.LookupIP
+
+// pacCall calls a standard PAC helper function
+func pacCall(name string, args []string) (interface{}, error) {
+	str := func(i int) string {
+		if i < len(args) {
+			return args[i]
+		}
+		return ""
+	}
+	resolve := func(host string) net.IP {
+		if ip := net.ParseIP(host); ip != nil {
+			return ip
+		}
+		ips, err := pacLookup(host)
+		if err != nil {
+			return nil
+		}
+		for _, ip := range ips {
+			if ip.To4() != nil {
+				return ip
+			}
+		}
+		if len(ips) > 0 {
+			return ips[0]
+		}
+		return nil
+	}
+	switch name {
+	case "isPlainHostName":
+		return !strings.Contains(str(0), "."), nil
+	case "dnsDomainIs":
+		return strings.HasSuffix(strings.ToLower(str(0)), strings.ToLower(str(1))), nil
+	case "localHostOrDomainIs":
+		host, hostdom := strings.ToLower(str(0)), strings.ToLower(str(1))
+		return host == hostdom || (!strings.Contains(host, ".") && strings.HasPrefix(hostdom, host+".")), nil
+	case "shExpMatch":
+		return pacGlob(str(1), str(0)), nil
+	case "isResolvable":
+		return resolve(str(0)) != nil, nil
+	case "dnsResolve":
+		if ip := resolve(str(0)); ip != nil {
+			return ip.String(), nil
+		}
+		return "", nil
+	case "isInNet":
+		ip := resolve(str(0))
+		pattern, mask := net.ParseIP(str(1)), net.ParseIP(str(2))
+		if ip == nil || pattern == nil || mask == nil || ip.To4() == nil {
+			return false, nil
+		}
+		m := net.IPMask(mask.To4())
+		return ip.To4().Mask(m).Equal(pattern.To4().Mask(m)), nil
+	case "myIpAddress":
+		addrs, err := net.InterfaceAddrs()
+		if err == nil {
+			for _, a := range addrs {
+				if n, ok := a.(*net.IPNet); ok && !n.IP.IsLoopback() && n.IP.To4() != nil {
+					return n.IP.String(), nil
+				}
+			}
+		}
+		return "127.0.0.1", nil
+	case "dnsDomainLevels":
+		return strings.Count(str(0), "."), nil
+	}
+	return nil, fmt.Errorf("PAC calls an unsupported function '%s'", name)
+}
+
+// pacGlob matches shell expressions, * and ? match any characters
+func pacGlob(pattern, s string) bool {
+	if pattern == "" {
+		return s == ""
+	}
+	switch pattern[0] {
+	case '*':
+		for i := 0; i <= len(s); i++ {
+			if pacGlob(pattern[1:], s[i:]) {
+				return true
+			}
+		}
+		return false
+	case '?':
+		return s != "" && pacGlob(pattern[1:], s[1:])
+	}
+	return s != "" && s[0] == pattern[0] && pacGlob(pattern[1:], s[1:])
+}
@@ -0,0 +1,129 @@
+package chclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+// pacFetchTimeout bounds downloading the PAC file
+const pacFetchTimeout = 10 * time.Second
+
+// proxyDiscovery chooses the proxy of each server
+// when --proxy isn't set, with the PAC file when
+// there is one, else with the environment
+type proxyDiscovery struct {
+	pacLocation string
+	env         func(*url.URL) (*url.URL, error)
+	//pac is loaded on first use, and retried until it loads
+	mut sync.Mutex
+	pac *pacScript
+}
+
+func newProxyDiscovery(pacLocation string) *proxyDiscovery {
+	return &proxyDiscovery{
+		pacLocation: pacLocation,
+		env:         httpproxy.FromEnvironment().ProxyFunc(),
+	}
+}
+
+// proxyFor is the proxy which reaches the server
+// (a ws:// or wss:// URL), nil when direct
+func (d *proxyDiscovery) proxyFor(ctx context.Context, server string) (*url.URL, error) {
+	u, err := url.Parse(server)
+	if err != nil {
+		return nil, err
+	}
+	//proxies are chosen for the http equivalent
+	target := *u
+	target.Scheme = strings.Replace(u.Scheme, "ws", "http", 1)
+	if d.pacLocation == "" {
+		return d.env(&target)
+	}
+	pac, err := d.loadPAC(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result, err := pac.FindProxyForURL(target.String(), target.Hostname())
+	if err != nil {
+		return nil, err
+	}
+	return pacProxy(result)
+}
+
+func (d *proxyDiscovery) loadPAC(ctx context.Context) (*pacScript, error) {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+	if d.pac != nil {
+		return d.pac, nil
+	}
+	src, err := fetchPAC(ctx, d.pacLocation)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load PAC file: %s", err)
+	}
+	pac, err := parsePAC(src)
+	if err != nil {
+		return nil, err
+	}
+	d.pac = pac
+	return pac, nil
+}
+
+// fetchPAC reads the PAC file of a http(s) URL or a path,
+// the PAC is fetched directly, it isn't proxied
+func fetchPAC(ctx context.Context, location string) (string, error) {
+	if !strings.HasPrefix(location, "http://") && !strings.HasPrefix(location, "https://") {
+		b, err := ioutil.ReadFile(strings.TrimPrefix(location, "file://"))
+		return string(b), err
+	}
+	ctx, cancel := context.WithTimeout(ctx, pacFetchTimeout)
+	defer cancel()
+	req, err := http.NewRequest("GET", location, nil)
+	if err != nil {
+		return "", err
+	}
+	client := &http.Client{Transport: &http.Transport{Proxy: nil}}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("status %d", resp.StatusCode)
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	return string(b), err
+}
+
+// pacProxy is the first supported proxy of a PAC result,
+// e.g. "PROXY proxy:8080; DIRECT", nil when DIRECT
+func pacProxy(result string) (*url.URL, error) {
+	for _, entry := range strings.Split(result, ";") {
+		fields := strings.Fields(entry)
+		if len(fields) == 0 {
+			continue
+		}
+		kind := strings.ToUpper(fields[0])
+		if kind == "DIRECT" {
+			return nil, nil
+		}
+		if len(fields) != 2 {
+			continue
+		}
+		switch kind {
+		case "PROXY", "HTTP":
+			return &url.URL{Scheme: "http", Host: fields[1]}, nil
+		case "SOCKS", "SOCKS5":
+			return &url.URL{Scheme: "socks", Host: fields[1]}, nil
+		}
+	}
+	return nil, errors.New("PAC returned no supported proxy: " + result)
+}
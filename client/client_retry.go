@@ -0,0 +1,108 @@
+package chclient
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jpillora/backoff"
+	"github.com/jpillora/chisel/share/settings"
+)
+
+// pendingRemote is a reverse remote the server couldn't
+// listen on, which is retried until the server binds it
+type pendingRemote struct {
+	cancel  context.CancelFunc
+	retries int64
+	err     string
+}
+
+// retryPending retries each of the remotes the server reported
+// pending, independently, until they bind or ctx is cancelled
+func (c *Client) retryPending(ctx context.Context, reply *settings.ConfigReply) {
+	c.stopPending()
+	for i, r := range reply.Pending {
+		err := errors.New("Server cannot listen on " + r.String())
+		if i < len(reply.Errors) {
+			err = errors.New(reply.Errors[i])
+		}
+		c.Infof("%s, retrying", err)
+		c.bindFailed(r, err)
+		rctx, cancel := context.WithCancel(ctx)
+		p := &pendingRemote{cancel: cancel, err: err.Error()}
+		c.remotesMut.Lock()
+		c.pending[r.String()] = p
+		c.remotesMut.Unlock()
+		go c.retryRemote(rctx, r, p)
+	}
+}
+
+// stopPending stops retrying the pending remotes
+func (c *Client) stopPending() {
+	c.remotesMut.Lock()
+	defer c.remotesMut.Unlock()
+	for key, p := range c.pending {
+		p.cancel()
+		delete(c.pending, key)
+	}
+}
+
+// retryRemote asks the server to bind the remote, as
+// UpdateRemotes does, with backoff until it succeeds
+func (c *Client) retryRemote(ctx context.Context, r *settings.Remote, p *pendingRemote) {
+	b := &backoff.Backoff{
+		Min:    c.config.MinRetryInterval,
+		Max:    c.config.MaxRetryInterval,
+		Jitter: c.config.RetryJitter,
+	}
+	u := settings.EncodeRemotesUpdate(settings.RemotesUpdate{Add: settings.Remotes{r}})
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(b.Duration()):
+		}
+		//UpdateRemotes cancels removed remotes while holding updateMut
+		c.updateMut.Lock()
+		if ctx.Err() != nil {
+			c.updateMut.Unlock()
+			return
+		}
+		rctx, cancel := context.WithTimeout(ctx, settings.Environment().SSHTimeout)
+		reply, err := c.tunnel.Request(rctx, "remotes", u)
+		cancel()
+		if err == nil {
+			err = c.remotesReplied(reply)
+		}
+		c.updateMut.Unlock()
+		if ctx.Err() != nil {
+			return
+		}
+		c.remotesMut.Lock()
+		if err == nil {
+			if c.pending[r.String()] == p {
+				delete(c.pending, r.String())
+			}
+		} else {
+			p.retries++
+			p.err = err.Error()
+		}
+		c.remotesMut.Unlock()
+		if err == nil {
+			c.Infof("Server bound %s after %d retries", r, p.retries)
+			if c.config.OnBind != nil {
+				c.config.OnBind(r.String(), nil)
+			}
+			return
+		}
+		c.Debugf("Retrying %s: %s", r, err)
+	}
+}
+
+// bindFailed reports a remote which failed to bind
+func (c *Client) bindFailed(r *settings.Remote, err error) {
+	c.hooks.send(hookEvent{Type: eventBindFailed, Remote: r.String(), Error: err.Error()})
+	if c.config.OnBind != nil {
+		c.config.OnBind(r.String(), err)
+	}
+}
@@ -0,0 +1,176 @@
+package chclient
+
+import (
+	"errors"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ServerConfig is a server of the client, for failover
+type ServerConfig struct {
+	URL string `yaml:"url"`
+	//Priority orders the servers, lowest first,
+	//those of equal priority are tried in order
+	Priority int `yaml:"priority"`
+}
+
+// failureTTL is how long a failure counts against a server
+const failureTTL = 5 * time.Minute
+
+// serverPool picks the server to connect to, failing over
+// to the next healthiest one when a connection fails
+type serverPool struct {
+	mut     sync.Mutex
+	servers []*poolServer
+	current *poolServer
+}
+
+type poolServer struct {
+	ServerConfig
+	ws string
+	//failures are consecutive, reset by connecting
+	failures    int
+	lastFailure time.Time
+	//tried in the current round
+	tried bool
+}
+
+// newServerPool parses the comma separated servers,
+// of priority 0, and the configured servers
+func newServerPool(server string, servers []ServerConfig) (*serverPool, error) {
+	all := []ServerConfig{}
+	for _, s := range strings.Split(server, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			all = append(all, ServerConfig{URL: s})
+		}
+	}
+	all = append(all, servers...)
+	if len(all) == 0 {
+		return nil, errors.New("A server is required")
+	}
+	p := &serverPool{}
+	for _, s := range all {
+		u, err := websocketURL(s.URL)
+		if err != nil {
+			return nil, err
+		}
+		p.servers = append(p.servers, &poolServer{ServerConfig: s, ws: u.String()})
+	}
+	p.current = p.best(time.Now())
+	return p, nil
+}
+
+// websocketURL converts a server to its websocket URL
+func websocketURL(server string) (*url.URL, error) {
+	//apply default scheme
+	if !strings.HasPrefix(server, "http") {
+		server = "http://" + server
+	}
+	u, err := url.Parse(server)
+	if err != nil {
+		return nil, err
+	}
+	//swap to websockets scheme
+	u.Scheme = strings.Replace(u.Scheme, "http", "ws", 1)
+	//apply default port
+	if !regexp.MustCompile(`:\d+$`).MatchString(u.Host) {
+		if u.Scheme == "wss" {
+			u.Host = u.Host + ":443"
+		} else {
+			u.Host = u.Host + ":80"
+		}
+	}
+	return u, nil
+}
+
+// TLS reports whether any server is reached over TLS
+func (p *serverPool) TLS() bool {
+	for _, s := range p.servers {
+		if strings.HasPrefix(s.ws, "wss:") {
+			return true
+		}
+	}
+	return false
+}
+
+// URL returns the websocket URL of the current server
+func (p *serverPool) URL() string {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	return p.current.ws
+}
+
+// connected resets the current server's failures, and
+// starts a new round from the best server
+func (p *serverPool) connected() {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	p.current.failures = 0
+	for _, s := range p.servers {
+		s.tried = false
+	}
+	p.current = p.best(time.Now())
+}
+
+// failed counts a failure against the current server and moves
+// to the best untried server, it reports false once all were
+// tried, starting a new round from the best server
+func (p *serverPool) failed() bool {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	now := time.Now()
+	p.current.failures++
+	p.current.lastFailure = now
+	p.current.tried = true
+	if next := p.best(now); next != nil {
+		p.current = next
+		return true
+	}
+	for _, s := range p.servers {
+		s.tried = false
+	}
+	p.current = p.best(now)
+	return false
+}
+
+// away moves from the current server, which is shutting down,
+// to the best untried server without counting a failure against
+// it, it reports false when there are no others
+func (p *serverPool) away() bool {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	p.current.tried = true
+	if next := p.best(time.Now()); next != nil {
+		p.current = next
+		return true
+	}
+	p.current.tried = false
+	return false
+}
+
+// best returns the untried server of the lowest priority, then the
+// fewest recent failures, then the first listed, or nil
+func (p *serverPool) best(now time.Time) *poolServer {
+	var best *poolServer
+	for _, s := range p.servers {
+		if s.tried {
+			continue
+		}
+		if best == nil || s.Priority < best.Priority ||
+			(s.Priority == best.Priority && s.score(now) < best.score(now)) {
+			best = s
+		}
+	}
+	return best
+}
+
+// score is the number of recent failures
+func (s *poolServer) score(now time.Time) int {
+	if now.Sub(s.lastFailure) > failureTTL {
+		return 0
+	}
+	return s.failures
+}
@@ -0,0 +1,197 @@
+package chclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jpillora/chisel/share/settings"
+)
+
+// Status is the state of the client's tunnel
+type Status struct {
+	State     State  `json:"state"`
+	Connected bool   `json:"connected"`
+	Server    string `json:"server"`
+	//Since is when the client last connected or disconnected
+	Since time.Time `json:"since"`
+	//Reconnects counts the connections after the first,
+	//Failures the attempts which didn't connect
+	Reconnects int64          `json:"reconnects"`
+	Failures   int64          `json:"failures"`
+	LastError  string         `json:"last_error,omitempty"`
+	Remotes    []RemoteStatus `json:"remotes"`
+}
+
+// RemoteStatus counts the bytes through a remote
+type RemoteStatus struct {
+	Remote   string `json:"remote"`
+	Sent     int64  `json:"sent"`
+	Received int64  `json:"received"`
+	//Port is the one the server allocated for an ephemeral (R:0) remote
+	Port string `json:"port,omitempty"`
+	//URL is where the server exposes a public (R:https://name) remote
+	URL string `json:"url,omitempty"`
+	//Pushed is set for the remotes the server pushed
+	Pushed bool `json:"pushed,omitempty"`
+	//Pending is set while the server can't listen on the
+	//reverse remote, Error is why, as of the last retry
+	Pending bool   `json:"pending,omitempty"`
+	Retries int64  `json:"retries,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// connState tracks the connections of connectionLoop
+type connState struct {
+	mut         sync.Mutex
+	name        State
+	connected   bool
+	server      string
+	since       time.Time
+	connections int64
+	failures    int64
+	lastError   string
+}
+
+// up marks the client connected to server
+func (s *connState) up(server string) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.connected = true
+	s.server = server
+	s.since = time.Now()
+	s.connections++
+}
+
+// ended marks the end of a connection attempt, a
+// disconnection when it was up, else a failure
+func (s *connState) ended(err error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	if err != nil && err != io.EOF {
+		s.lastError = err.Error()
+	}
+	if s.connected {
+		s.connected = false
+		s.since = time.Now()
+	} else {
+		s.failures++
+	}
+}
+
+// Status returns the state of the client's tunnel
+func (c *Client) Status() Status {
+	c.state.mut.Lock()
+	st := Status{
+		Connected: c.state.connected,
+		Server:    c.state.server,
+		Since:     c.state.since,
+		Failures:  c.state.failures,
+		LastError: c.state.lastError,
+	}
+	if c.state.connections > 1 {
+		st.Reconnects = c.state.connections - 1
+	}
+	c.state.mut.Unlock()
+	st.State = c.State()
+	if st.Server == "" {
+		st.Server = c.servers.URL()
+	}
+	traffic := c.tunnel.Traffic()
+	c.remotesMut.Lock()
+	defer c.remotesMut.Unlock()
+	st.Remotes = []RemoteStatus{}
+	remotes := append(append(settings.Remotes{}, c.computed.Remotes...), c.pushed...)
+	for i, r := range remotes {
+		//reverse remotes are counted by the channels of the server's proxy
+		key := r.String()
+		if r.Reverse {
+			key = r.Remote()
+		}
+		rs := RemoteStatus{Remote: r.String()}
+		if t, ok := traffic[key]; ok {
+			rs.Sent, rs.Received = t.Sent(), t.Received()
+		}
+		rs.Port = c.ports[r.String()]
+		rs.URL = c.urls[r.String()]
+		rs.Pushed = i >= len(c.computed.Remotes)
+		if p, ok := c.pending[r.String()]; ok {
+			rs.Pending, rs.Retries, rs.Error = true, p.retries, p.err
+		}
+		st.Remotes = append(st.Remotes, rs)
+	}
+	sort.Slice(st.Remotes, func(i, j int) bool { return st.Remotes[i].Remote < st.Remotes[j].Remote })
+	return st
+}
+
+// serveStatus serves the status endpoint on addr: GET /status
+// as JSON, /metrics in the Prometheus text format, and /healthz
+// which fails with 503 while disconnected
+func (c *Client) serveStatus(ctx context.Context, addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("status: %s", err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", c.handleStatus)
+	mux.HandleFunc("/metrics", c.handleStatusMetrics)
+	mux.HandleFunc("/healthz", c.handleStatusHealthz)
+	h := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		h.Close()
+	}()
+	go h.Serve(l)
+	c.Infof("Status endpoint listening on %s", l.Addr())
+	return nil
+}
+
+func (c *Client) handleStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c.Status())
+}
+
+func (c *Client) handleStatusHealthz(w http.ResponseWriter, r *http.Request) {
+	if !c.Status().Connected {
+		http.Error(w, "Disconnected", http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("OK\n"))
+}
+
+func (c *Client) handleStatusMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	st := c.Status()
+	connected := 0
+	if st.Connected {
+		connected = 1
+	}
+	metric := func(name, typ, help string) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+	}
+	metric("chisel_client_connected", "gauge", "Whether the client is connected to the server.")
+	fmt.Fprintf(w, "chisel_client_connected{server=%q} %d\n", st.Server, connected)
+	metric("chisel_client_reconnects_total", "counter", "Connections after the first.")
+	fmt.Fprintf(w, "chisel_client_reconnects_total %d\n", st.Reconnects)
+	metric("chisel_client_connect_failures_total", "counter", "Connection attempts which failed.")
+	fmt.Fprintf(w, "chisel_client_connect_failures_total %d\n", st.Failures)
+	pending := 0
+	for _, rs := range st.Remotes {
+		if rs.Pending {
+			pending++
+		}
+	}
+	metric("chisel_client_remotes_pending", "gauge", "Reverse remotes the server can't yet listen on, which are retried.")
+	fmt.Fprintf(w, "chisel_client_remotes_pending %d\n", pending)
+	metric("chisel_client_remote_bytes_total", "counter", "Bytes through each remote, sent to and received from the server.")
+	for _, rs := range st.Remotes {
+		fmt.Fprintf(w, "chisel_client_remote_bytes_total{remote=%q,direction=\"sent\"} %d\n", rs.Remote, rs.Sent)
+		fmt.Fprintf(w, "chisel_client_remote_bytes_total{remote=%q,direction=\"received\"} %d\n", rs.Remote, rs.Received)
+	}
+}
@@ -1,17 +1,32 @@
 package chclient
 
 import (
+	"context"
 	"crypto/ecdsa"
 	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/gorilla/websocket"
+	chshare "github.com/jpillora/chisel/share"
 	"github.com/jpillora/chisel/share/ccrypto"
+	"github.com/jpillora/chisel/share/cio"
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/net/http/httpproxy"
 )
 
 func TestCustomHeaders(t *testing.T) {
@@ -113,3 +128,560 @@ func TestVerifyFingerprint(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestConfigFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "chisel-client-*.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("server: https://chisel.example.com\nremotes: [3000, 'R:2222:localhost:22']\nkeepalive: 0s\nheaders: {X-Team: infra}\ntls: {skip-verify: true}\n" +
+		"min-retry-interval: 2s\nretry-jitter: true\nfail-fast: true\n" +
+		"dial-timeout: 3s\nhandshake-timeout: 4s\nkeepalive-timeout: 5s\n")
+	f.Close()
+	config := Config{Headers: http.Header{}, KeepAlive: 25 * time.Second, MaxRetryCount: -1, Auth: "user:pass"}
+	if err := config.LoadFile(f.Name()); err != nil {
+		t.Fatal(err)
+	}
+	if config.Server != "https://chisel.example.com" || len(config.Remotes) != 2 || config.Remotes[0] != "3000" ||
+		config.KeepAlive != 0 || config.MaxRetryCount != -1 || config.Auth != "user:pass" ||
+		config.Headers.Get("X-Team") != "infra" || !config.TLS.SkipVerify ||
+		config.MinRetryInterval != 2*time.Second || !config.RetryJitter || !config.FailFast ||
+		config.DialTimeout != 3*time.Second || config.HandshakeTimeout != 4*time.Second || config.KeepAliveTimeout != 5*time.Second {
+		t.Fatalf("unexpected config %+v", config)
+	}
+}
+
+func TestServerFailover(t *testing.T) {
+	p, err := newServerPool("a.example.com, https://b.example.com", []ServerConfig{{URL: "c.example.com:8080", Priority: -1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.TLS() || p.URL() != "ws://c.example.com:8080" {
+		t.Fatalf("expected the lowest priority first, got %s", p.URL())
+	}
+	if !p.failed() || p.URL() != "ws://a.example.com:80" {
+		t.Fatalf("expected failover to a, got %s", p.URL())
+	}
+	if !p.failed() || p.URL() != "wss://b.example.com:443" {
+		t.Fatalf("expected failover to b, got %s", p.URL())
+	}
+	//all failed, a new round starts from c
+	if p.failed() || p.URL() != "ws://c.example.com:8080" {
+		t.Fatalf("expected a new round from c, got %s", p.URL())
+	}
+	if !p.failed() || p.URL() != "ws://a.example.com:80" {
+		t.Fatalf("expected failover to a, got %s", p.URL())
+	}
+	//b and c have recent failures, a connected
+	p.servers[2].Priority = 0
+	if p.connected(); p.URL() != "ws://a.example.com:80" {
+		t.Fatalf("expected the healthiest server, got %s", p.URL())
+	}
+}
+
+func TestServerAway(t *testing.T) {
+	p, err := newServerPool("a.example.com, b.example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.connected()
+	//a is shutting down, without counting as a failure
+	if !p.away() || p.URL() != "ws://b.example.com:80" || p.servers[0].failures != 0 {
+		t.Fatalf("expected b, got %s", p.URL())
+	}
+	p.connected()
+	//a is preferred again, and then b is the only other
+	if p.URL() != "ws://a.example.com:80" {
+		t.Fatalf("expected a, got %s", p.URL())
+	}
+	single, _ := newServerPool("a.example.com", nil)
+	if single.away() || single.URL() != "ws://a.example.com:80" {
+		t.Fatalf("expected no other server, got %s", single.URL())
+	}
+}
+
+func TestKnownHosts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chisel-known-hosts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	config := Config{Server: "chisel.example.com:8080", KnownHosts: dir + "/known_hosts"}
+	c, err := NewClient(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := func() ssh.PublicKey {
+		priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pub, err := ssh.NewPublicKey(&priv.PublicKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pub
+	}
+	first, second := key(), key()
+	if err := c.verifyServer("", nil, first); err != nil {
+		t.Fatalf("expected trust on first use, got %s", err)
+	}
+	if err := c.verifyServer("", nil, first); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.verifyServer("", nil, second); err == nil {
+		t.Fatal("expected the changed key to be refused")
+	}
+	//pinned fingerprints take precedence, any of them matches
+	config.Fingerprints = []string{ccrypto.FingerprintKey(first), ccrypto.FingerprintKey(second)}
+	if err := c.verifyServer("", nil, second); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHooks(t *testing.T) {
+	posted := make(chan hookEvent, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ev hookEvent
+		json.NewDecoder(r.Body).Decode(&ev)
+		posted <- ev
+	}))
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "hooks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "out")
+	h := newHooks(HooksConfig{
+		OnBindFailure: "echo $CHISEL_EVENT $CHISEL_REMOTE > " + out,
+		Webhook:       server.URL,
+	}, cio.NewLogger("test"))
+	h.handle(context.Background(), hookEvent{Type: eventBindFailed, Remote: "3000", Error: "in use"})
+	if b, _ := ioutil.ReadFile(out); string(b) != "remote.bind_failed 3000\n" {
+		t.Fatalf("unexpected command output %q", b)
+	}
+	if ev := <-posted; ev.Type != eventBindFailed || ev.Error != "in use" {
+		t.Fatalf("unexpected webhook event %+v", ev)
+	}
+	if newHooks(HooksConfig{}, nil) != nil {
+		t.Fatal("expected no hooks")
+	}
+}
+
+func TestProxyPAC(t *testing.T) {
+	pac, err := parsePAC(`
+		// corporate proxies
+		function FindProxyForURL(url, host) {
+			var proxy = "PROXY proxy.corp:8080";
+			if (isPlainHostName(host) || dnsDomainIs(host, ".corp.example.com"))
+				return "DIRECT";
+			else if (shExpMatch(url, "https://*.lab.example.com*") && !isInNet(host, "10.0.0.0", "255.0.0.0")) {
+				return "SOCKS socks.corp:1080";
+			}
+			/* everything else */
+			return proxy + "; DIRECT";
+		}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pacLookup = func(host string) ([]net.IP, error) {
+		return nil, errors.New("no dns in tests")
+	}
+	defer func() { pacLookup = net.LookupIP }()
+	for host, expect := range map[string]string{
+		"chisel":                   "",
+		"chisel.corp.example.com":  "",
+		"chisel.lab.example.com":   "socks://socks.corp:1080",
+		"chisel.public.example.io": "http://proxy.corp:8080",
+	} {
+		d := &proxyDiscovery{pacLocation: "-", pac: pac}
+		p, err := d.proxyFor(context.Background(), "wss://"+host+":443")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (p == nil && expect != "") || (p != nil && p.String() != expect) {
+			t.Fatalf("%s: expected %q, got %v", host, expect, p)
+		}
+	}
+	if _, err := parsePAC(`function FindProxyForURL(url, host) { return "DIRECT"`); err == nil {
+		t.Fatal("expected invalid syntax to fail")
+	}
+	if _, err := parsePAC(`var proxy = "DIRECT";`); err == nil {
+		t.Fatal("expected a PAC without FindProxyForURL to fail")
+	}
+	pacEvalTimeout = 50 * time.Millisecond
+	defer func() { pacEvalTimeout = 5 * time.Second }()
+	loop, err := parsePAC(`function FindProxyForURL(url, host) { while (true) {} }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loop.FindProxyForURL("https://chisel.example.com/", "chisel.example.com"); err == nil {
+		t.Fatal("expected a PAC which never returns to be interrupted")
+	}
+	if _, err := parsePAC(`while (true) {}`); err == nil {
+		t.Fatal("expected a PAC which never loads to be interrupted")
+	}
+	//the environment's proxy without a PAC
+	d := &proxyDiscovery{env: (&httpproxy.Config{HTTPSProxy: "http://env.proxy:3128"}).ProxyFunc()}
+	if p, err := d.proxyFor(context.Background(), "wss://chisel.example.com:443"); err != nil || p.String() != "http://env.proxy:3128" {
+		t.Fatalf("expected the environment's proxy, got %v %v", p, err)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	c, err := NewClient(&Config{
+		Server:  "http://chisel.example.com",
+		Remotes: []string{"3000", "R:2222:localhost:22"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	health := func() int {
+		rec := httptest.NewRecorder()
+		c.handleStatusHealthz(rec, httptest.NewRequest("GET", "/healthz", nil))
+		return rec.Code
+	}
+	//a failed attempt, then two connections
+	c.state.ended(errors.New("refused"))
+	if health() != http.StatusServiceUnavailable {
+		t.Fatal("expected unhealthy while disconnected")
+	}
+	c.state.up("http://chisel.example.com:80")
+	c.state.ended(io.EOF)
+	c.state.up("http://chisel.example.com:80")
+	if health() != http.StatusOK {
+		t.Fatal("expected healthy while connected")
+	}
+	rec := httptest.NewRecorder()
+	c.handleStatus(rec, httptest.NewRequest("GET", "/status", nil))
+	var st Status
+	if err := json.NewDecoder(rec.Body).Decode(&st); err != nil {
+		t.Fatal(err)
+	}
+	if !st.Connected || st.Reconnects != 1 || st.Failures != 1 || st.LastError != "refused" {
+		t.Fatalf("unexpected status %+v", st)
+	}
+	if len(st.Remotes) != 2 {
+		t.Fatalf("expected 2 remotes, got %+v", st.Remotes)
+	}
+	rec = httptest.NewRecorder()
+	c.handleStatusMetrics(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.Contains(rec.Body.String(), "chisel_client_reconnects_total 1\n") {
+		t.Fatalf("unexpected metrics %s", rec.Body)
+	}
+}
+
+func TestControl(t *testing.T) {
+	c, err := NewClient(&Config{
+		Server:  "http://chisel.example.com",
+		Remotes: []string{"3000"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "control")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "chisel.sock")
+	//a stale socket of a previous run is replaced
+	ioutil.WriteFile(path, nil, 0644)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := c.serveControl(ctx, path); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil || info.Mode()&os.ModeSocket == 0 || info.Mode().Perm() != 0600 {
+		t.Fatalf("expected a private socket, got %v %v", info, err)
+	}
+	if entries, _ := ioutil.ReadDir(dir); len(entries) != 1 {
+		t.Fatalf("expected only the socket to remain, got %d entries", len(entries))
+	}
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return net.Dial("unix", path)
+		},
+	}}
+	resp, err := client.Get("http://control/remotes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body controlRemotes
+	json.NewDecoder(resp.Body).Decode(&body)
+	resp.Body.Close()
+	if len(body.Remotes) != 1 || body.Remotes[0] != "0.0.0.0:3000:127.0.0.1:3000" {
+		t.Fatalf("unexpected remotes %v", body.Remotes)
+	}
+	for _, req := range []string{`{}`, `{"remotes":["4000"]}`} {
+		resp, err = client.Post("http://control/remotes", "application/json", strings.NewReader(req))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("%s: expected a bad request, got %d", req, resp.StatusCode)
+		}
+	}
+	if resp, err = client.Get("http://control/status"); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected the status, got %v", err)
+	}
+	resp.Body.Close()
+	//the socket is removed with the client
+	cancel()
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the socket to be removed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestInvalidEnviron(t *testing.T) {
+	os.Setenv("CHISEL_SSH_TIMEOUT", "soon")
+	defer os.Unsetenv("CHISEL_SSH_TIMEOUT")
+	if _, err := NewClient(&Config{Server: "http://chisel.example.com"}); err == nil {
+		t.Fatal("expected an invalid environment to fail")
+	}
+}
+
+func TestTLSServerName(t *testing.T) {
+	for _, tc := range []struct {
+		serverName, host, expect string
+	}{
+		{"", "", ""},
+		{"", "chisel.internal:443", "chisel.internal"},
+		{"sni.example.com", "chisel.internal", "sni.example.com"},
+	} {
+		config := Config{
+			Server:  "https://10.0.0.1",
+			Headers: http.Header{},
+			TLS:     TLSConfig{ServerName: tc.serverName},
+		}
+		if tc.host != "" {
+			config.Headers.Set("Host", tc.host)
+		}
+		c, err := NewClient(&config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.tlsConfig.ServerName != tc.expect {
+			t.Fatalf("expected server name %q, got %q", tc.expect, c.tlsConfig.ServerName)
+		}
+	}
+}
+
+func TestExpandRemotes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vars")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "vars")
+	if err := ioutil.WriteFile(path, []byte("# ports\nJOB_PORT=2222\nexport APP_PORT=\"80\"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("CHISEL_TEST_HOST", "app.internal")
+	defer os.Unsetenv("CHISEL_TEST_HOST")
+	c, err := NewClient(&Config{
+		Server:   "http://chisel.example.com",
+		VarsFile: path,
+		Vars:     map[string]string{"APP_PORT": "8080"},
+		Remotes:  []string{"R:${JOB_PORT}:${CHISEL_TEST_HOST}:$APP_PORT", "${LOCAL_PORT:-3000}:localhost:22"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := strings.Join(c.Remotes(), " "); r != "R:0.0.0.0:2222:app.internal:8080 0.0.0.0:3000:localhost:22" {
+		t.Fatalf("unexpected remotes %s", r)
+	}
+	if _, err := c.expandRemote("R:${UNDEFINED_PORT}:localhost:22"); err == nil {
+		t.Fatal("expected undefined variables to fail")
+	}
+}
+
+func TestReloadConfigFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "chisel.yaml")
+	write := func(s string) {
+		if err := ioutil.WriteFile(path, []byte(s), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("server: http://chisel.example.com\nremotes: [3000, 'R:${PORT}:localhost:22']\nvars: {PORT: 2222}\n")
+	config := Config{Headers: http.Header{}, ConfigFile: path}
+	if err := config.LoadFile(path); err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewClient(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//the same remotes, differently written
+	write("server: http://chisel.example.com\nremotes: ['R:0.0.0.0:2222:localhost:22', 3000]\n")
+	if err := c.reloadConfigFile(); err != nil {
+		t.Fatal(err)
+	}
+	write("server: http://chisel.example.com\nremotes: [3000, 4000]\n")
+	if err := c.reloadConfigFile(); err == nil || err.Error() != "client not started" {
+		t.Fatalf("expected the changed remotes to be updated, got %v", err)
+	}
+	write("server: http://chisel.example.com\nremotes: ['R:${UNSET_PORT}:localhost:22']\n")
+	if err := c.reloadConfigFile(); err == nil {
+		t.Fatal("expected undefined variables to fail")
+	}
+}
+
+func TestEmbed(t *testing.T) {
+	if err := New(&Config{Server: "http://127.0.0.1:1", Remotes: []string{"bad:remote:spec:x:y"}}).Start(context.Background()); err == nil {
+		t.Fatal("expected the configuration error from Start")
+	}
+	var mut sync.Mutex
+	var states []State
+	var errs []error
+	bound := ""
+	c := New(&Config{
+		Server:        "http://127.0.0.1:1",
+		Remotes:       []string{"127.0.0.1:0:localhost:3000"},
+		MaxRetryCount: 0,
+		OnState: func(s State) {
+			mut.Lock()
+			states = append(states, s)
+			mut.Unlock()
+		},
+		OnBind: func(remote string, err error) {
+			if err == nil {
+				mut.Lock()
+				bound = remote
+				mut.Unlock()
+			}
+		},
+		OnError: func(err error) {
+			mut.Lock()
+			errs = append(errs, err)
+			mut.Unlock()
+		},
+	})
+	if err := c.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	c.Wait()
+	mut.Lock()
+	defer mut.Unlock()
+	if fmt.Sprint(states) != "[connecting disconnected closed]" {
+		t.Fatalf("unexpected states %v", states)
+	}
+	if len(errs) != 1 || bound == "" {
+		t.Fatalf("expected a connection error and a bound remote, got %v %q", errs, bound)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	var user string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user = r.Header.Get("X-Chisel-User")
+		w.Header().Set("Retry-After", "7")
+		http.Error(w, "Too many sessions", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	c, err := NewClient(&Config{Server: server.URL, Auth: "alice:secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.connectionOnce(context.Background())
+	ra, ok := err.(*retryAfterError)
+	if !ok {
+		t.Fatalf("expected a retry after error, got %v", err)
+	}
+	if ra.after != 7*time.Second || ra.Error() != "Too many sessions (retry after 7s)" {
+		t.Fatalf("unexpected retry after %s: %s", ra.after, ra)
+	}
+	if user != "alice" {
+		t.Fatalf("expected the user to be advertised, got %q", user)
+	}
+}
+
+func TestFailFast(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		http.Error(w, "Forbidden", http.StatusForbidden)
+	}))
+	defer server.Close()
+	c, err := NewClient(&Config{Server: server.URL, MaxRetryCount: 2, MinRetryInterval: time.Millisecond, MaxRetryInterval: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.connectionLoop(context.Background()); err != nil || attempts != 3 {
+		t.Fatalf("expected to give up after 3 attempts, got %d (%v)", attempts, err)
+	}
+	attempts = 0
+	c, err = NewClient(&Config{Server: server.URL, MaxRetryCount: -1, FailFast: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.connectionLoop(context.Background()); err == nil || attempts != 1 {
+		t.Fatalf("expected to fail after the first attempt, got %d (%v)", attempts, err)
+	}
+}
+
+func TestHandshakeTimeout(t *testing.T) {
+	done := make(chan struct{})
+	defer close(done)
+	upgrader := websocket.Upgrader{Subprotocols: []string{chshare.ProtocolVersion}}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		//upgraded, but never handshakes
+		<-done
+	}))
+	defer server.Close()
+	c, err := NewClient(&Config{Server: server.URL, HandshakeTimeout: 100 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t0 := time.Now()
+	connected, err := c.connectionOnce(context.Background())
+	if connected || err == nil {
+		t.Fatal("expected the handshake to fail")
+	}
+	if d := time.Since(t0); d > 2*time.Second {
+		t.Fatalf("expected the handshake to time out, took %s", d)
+	}
+}
+
+func TestAffinity(t *testing.T) {
+	var node, cookie string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		node, cookie = r.Header.Get("X-Chisel-Affinity"), r.Header.Get("Cookie")
+		http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	c, err := NewClient(&Config{Server: server.URL, Headers: http.Header{"Cookie": {"a=1"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.affinity = "node-b"
+	c.connectionOnce(context.Background())
+	if node != "node-b" || cookie != "a=1; chisel-affinity=node-b" {
+		t.Fatalf("expected to ask for node-b, got %q %q", node, cookie)
+	}
+	//the server is draining, the next connection is routed anywhere
+	if c.affinity != "" {
+		t.Fatalf("expected the affinity to be cleared, got %q", c.affinity)
+	}
+}
@@ -0,0 +1,61 @@
+package chclient
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// loadVars reads a vars file of NAME=VALUE lines,
+// blank lines and lines starting with # are skipped
+func loadVars(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read vars file: %s", err)
+	}
+	defer f.Close()
+	vars := map[string]string{}
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		name := strings.TrimSpace(strings.TrimPrefix(kv[0], "export "))
+		if len(kv) != 2 || name == "" {
+			return nil, fmt.Errorf("Invalid vars file %s line %d", path, n)
+		}
+		vars[name] = strings.Trim(strings.TrimSpace(kv[1]), `"'`)
+	}
+	return vars, s.Err()
+}
+
+// expandRemote replaces the $NAME and ${NAME} variables of a
+// remote with the client's vars, else the environment, and
+// ${NAME:-default} with default when NAME is neither
+func (c *Client) expandRemote(s string) (string, error) {
+	var undefined []string
+	expanded := os.Expand(s, func(name string) string {
+		def := ""
+		hasDef := false
+		if i := strings.Index(name, ":-"); i >= 0 {
+			name, def, hasDef = name[:i], name[i+2:], true
+		}
+		if v, ok := c.vars[name]; ok {
+			return v
+		}
+		if v, ok := os.LookupEnv(name); ok {
+			return v
+		}
+		if !hasDef {
+			undefined = append(undefined, name)
+		}
+		return def
+	})
+	if len(undefined) > 0 {
+		return "", fmt.Errorf("Remote '%s' has undefined variables: %s", s, strings.Join(undefined, ", "))
+	}
+	return expanded, nil
+}
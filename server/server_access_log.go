@@ -0,0 +1,224 @@
+package chserver
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jpillora/chisel/share/ctrace"
+)
+
+// proxyAccessLog is a single proxied request
+type proxyAccessLog struct {
+	Time          string  `json:"time"`
+	Proxy         string  `json:"proxy"`
+	Method        string  `json:"method"`
+	Path          string  `json:"path"`
+	Status        int     `json:"status"`
+	LatencyMS     float64 `json:"latency_ms"`
+	BytesIn       int64   `json:"bytes_in"`
+	BytesOut      int64   `json:"bytes_out"`
+	ServicePrefix string  `json:"service_prefix"`
+	ProxyType     string  `json:"proxy_type"`
+	UserID        int64   `json:"user_id"`
+	JobID         int64   `json:"job_id"`
+	RemoteAddr    string  `json:"remote_addr"`
+	CorrelationID string  `json:"correlation_id,omitempty"`
+}
+
+// accessLogged wraps a dynamic proxy handler, writing one
+// JSON line per request to the server's access log
+func (s *Server) accessLogged(drProxy *DynamicReverseProxy, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t0 := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		b, _ := json.Marshal(proxyAccessLog{
+			Time:          t0.UTC().Format(time.RFC3339Nano),
+			Proxy:         drProxy.Id,
+			Method:        r.Method,
+			Path:          r.URL.Path,
+			Status:        rec.Status(),
+			LatencyMS:     float64(time.Since(t0).Microseconds()) / 1000,
+			BytesIn:       r.ContentLength,
+			BytesOut:      rec.written,
+			ServicePrefix: drProxy.ServicePrefix,
+			ProxyType:     drProxy.ProxyType,
+			UserID:        drProxy.User,
+			JobID:         drProxy.JobId,
+			RemoteAddr:    r.RemoteAddr,
+			CorrelationID: ctrace.CorrelationID(r.Context()),
+		})
+		s.accessLog.Println(string(b))
+	})
+}
+
+// statusRecorder captures the status code
+// and number of body bytes written
+type statusRecorder struct {
+	http.ResponseWriter
+	status  int
+	written int64
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.written += int64(n)
+	return n, err
+}
+
+// Status returns the response status, hijacked
+// connections report 101 Switching Protocols
+func (r *statusRecorder) Status() int {
+	if r.status == 0 {
+		return http.StatusOK
+	}
+	return r.status
+}
+
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	if r.status == 0 {
+		r.status = http.StatusSwitchingProtocols
+	}
+	return hj.Hijack()
+}
+
+// access log formats of Config.AccessLog
+const (
+	accessLogCommon   = "common"
+	accessLogCombined = "combined"
+	accessLogJSON     = "json"
+)
+
+// accessLogEntry is a request to the http listener
+type accessLogEntry struct {
+	Time       string  `json:"time"`
+	RemoteAddr string  `json:"remote_addr"`
+	User       string  `json:"user,omitempty"`
+	Method     string  `json:"method"`
+	Host       string  `json:"host"`
+	URI        string  `json:"uri"`
+	Proto      string  `json:"proto"`
+	Status     int     `json:"status"`
+	BytesIn    int64   `json:"bytes_in"`
+	BytesOut   int64   `json:"bytes_out"`
+	LatencyMS  float64 `json:"latency_ms"`
+	Referer    string  `json:"referer,omitempty"`
+	UserAgent  string  `json:"user_agent,omitempty"`
+	Proxy      string  `json:"proxy,omitempty"`
+	//CorrelationID is only logged in the json format
+	CorrelationID string `json:"correlation_id,omitempty"`
+}
+
+type accessProxyKey struct{}
+
+// setAccessLogProxy names the dynamic proxy serving the
+// request in the access log, when access logging is enabled
+func setAccessLogProxy(r *http.Request, pId string) {
+	if p, ok := r.Context().Value(accessProxyKey{}).(*string); ok {
+		*p = pId
+	}
+}
+
+// httpAccessLogged wraps the http listener, logging every request
+// except tunnels in the given format. Tunnels are logged as sessions.
+func (s *Server) httpAccessLogged(format string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isTunnelRequest(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		t0 := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		proxy := ""
+		next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), accessProxyKey{}, &proxy)))
+		user, _, _ := r.BasicAuth()
+		e := accessLogEntry{
+			RemoteAddr:    r.RemoteAddr,
+			User:          user,
+			Method:        r.Method,
+			Host:          r.Host,
+			URI:           r.RequestURI,
+			Proto:         r.Proto,
+			Status:        rec.Status(),
+			BytesOut:      rec.written,
+			LatencyMS:     float64(time.Since(t0).Microseconds()) / 1000,
+			Referer:       r.Referer(),
+			UserAgent:     r.UserAgent(),
+			Proxy:         proxy,
+			CorrelationID: ctrace.CorrelationID(r.Context()),
+		}
+		if r.ContentLength > 0 {
+			e.BytesIn = r.ContentLength
+		}
+		if format == accessLogJSON {
+			e.Time = t0.UTC().Format(time.RFC3339Nano)
+			b, _ := json.Marshal(e)
+			s.accessLog.Println(string(b))
+			return
+		}
+		s.accessLog.Println(e.clf(t0, format == accessLogCombined))
+	})
+}
+
+// clf formats the entry in the common, or combined, log format
+func (e *accessLogEntry) clf(t time.Time, combined bool) string {
+	host, _, err := net.SplitHostPort(e.RemoteAddr)
+	if err != nil {
+		host = e.RemoteAddr
+	}
+	line := fmt.Sprintf("%s - %s [%s] \"%s %s %s\" %d %s",
+		host, clfField(e.User), t.Format("02/Jan/2006:15:04:05 -0700"),
+		e.Method, e.URI, e.Proto, e.Status, clfBytes(e.BytesOut))
+	if combined {
+		line += fmt.Sprintf(" %q %q", clfField(e.Referer), clfField(e.UserAgent))
+	}
+	return line
+}
+
+func clfField(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func clfBytes(n int64) string {
+	if n == 0 {
+		return "-"
+	}
+	return strconv.FormatInt(n, 10)
+}
+
+// isTunnelRequest reports whether r is a chisel client connecting
+func isTunnelRequest(r *http.Request) bool {
+	protocol := r.Header.Get("Sec-WebSocket-Protocol")
+	return strings.ToLower(r.Header.Get("Upgrade")) == "websocket" &&
+		(strings.HasPrefix(protocol, "chisel-") || strings.HasPrefix(protocol, "craveconnect-"))
+}
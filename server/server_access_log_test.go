@@ -0,0 +1,140 @@
+package chserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jpillora/chisel/share/creport"
+)
+
+func TestHTTPAccessLog(t *testing.T) {
+	var buf bytes.Buffer
+	s := &Server{accessLog: log.New(&buf, "", 0)}
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		setAccessLogProxy(r, "docs")
+		w.Write([]byte("hello"))
+	})
+	req := httptest.NewRequest("GET", "/index.html?q=1", nil)
+	req.Header.Set("User-Agent", "curl")
+	s.httpAccessLogged(accessLogCombined, h).ServeHTTP(httptest.NewRecorder(), req)
+	line := buf.String()
+	if !strings.HasPrefix(line, "192.0.2.1 - - [") ||
+		!strings.HasSuffix(line, `] "GET /index.html?q=1 HTTP/1.1" 200 5 "-" "curl"`+"\n") {
+		t.Fatalf("unexpected combined line %q", line)
+	}
+	buf.Reset()
+	s.httpAccessLogged(accessLogJSON, h).ServeHTTP(httptest.NewRecorder(), req)
+	var e accessLogEntry
+	if err := json.Unmarshal(buf.Bytes(), &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Status != 200 || e.BytesOut != 5 || e.Proxy != "docs" || e.URI != "/index.html?q=1" {
+		t.Fatalf("unexpected json entry %+v", e)
+	}
+	buf.Reset()
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Protocol", "chisel-v3")
+	s.httpAccessLogged(accessLogCommon, h).ServeHTTP(httptest.NewRecorder(), req)
+	if buf.Len() != 0 {
+		t.Fatalf("expected tunnels to be skipped, got %q", buf.String())
+	}
+}
+
+func TestProxyAccessLog(t *testing.T) {
+	var buf bytes.Buffer
+	s := &Server{accessLog: log.New(&buf, "", 0)}
+	drProxy := &DynamicReverseProxy{Id: "abc", ServicePrefix: "web", ProxyType: "http", User: 3, JobId: 7}
+	h := s.accessLogged(drProxy, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("created"))
+	}))
+	req := httptest.NewRequest("POST", "/abc/items", strings.NewReader("{}"))
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	var e proxyAccessLog
+	if err := json.Unmarshal(buf.Bytes(), &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Proxy != "abc" || e.Method != "POST" || e.Path != "/abc/items" || e.Status != http.StatusCreated ||
+		e.BytesIn != 2 || e.BytesOut != 7 || e.ServicePrefix != "web" || e.ProxyType != "http" ||
+		e.UserID != 3 || e.JobID != 7 || e.RemoteAddr != "192.0.2.1:1234" {
+		t.Fatalf("unexpected entry %+v", e)
+	}
+	//an implicit status is logged as 200
+	buf.Reset()
+	h = s.accessLogged(drProxy, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/abc", nil))
+	e = proxyAccessLog{}
+	json.Unmarshal(buf.Bytes(), &e)
+	if e.Status != http.StatusOK || e.BytesOut != 0 {
+		t.Fatalf("unexpected entry %+v", e)
+	}
+}
+
+func TestCorrelated(t *testing.T) {
+	var buf bytes.Buffer
+	s := &Server{accessLog: log.New(&buf, "", 0)}
+	var upstream string
+	h := s.correlated(s.httpAccessLogged(accessLogJSON, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstream = r.Header.Get("X-Correlation-Id")
+	})))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	id := rec.Header().Get("X-Correlation-Id")
+	var e accessLogEntry
+	json.Unmarshal(buf.Bytes(), &e)
+	if len(id) != 16 || upstream != id || e.CorrelationID != id {
+		t.Fatalf("expected a generated id, got %q upstream %q logged %q", id, upstream, e.CorrelationID)
+	}
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Correlation-Id", "from-client")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Header().Get("X-Correlation-Id") != "from-client" || upstream != "from-client" {
+		t.Fatalf("expected the client's id, got %q", rec.Header().Get("X-Correlation-Id"))
+	}
+}
+
+func TestReportPanics(t *testing.T) {
+	r := &testReporter{}
+	creport.SetReporter(r)
+	defer creport.SetReporter(nil)
+	s := &Server{}
+	h := s.reportPanics(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		setReportTag(r, "session", "7")
+		if r.URL.Path == "/abort" {
+			panic(http.ErrAbortHandler)
+		}
+		panic("boom")
+	}))
+	for _, path := range []string{"/abort", "/boom"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatal("expected the panic to continue")
+				}
+			}()
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+		}()
+	}
+	if len(r.events) != 1 || !r.events[0].Panic || r.events[0].Tags["session"] != "7" || r.events[0].Tags["path"] != "/boom" {
+		t.Fatalf("expected one tagged panic, got %+v", r.events)
+	}
+}
+
+type testReporter struct {
+	events []creport.Event
+}
+
+func (r *testReporter) Report(e creport.Event) {
+	r.events = append(r.events, e)
+}
+
+func (r *testReporter) Flush(time.Duration) bool {
+	return true
+}
@@ -0,0 +1,228 @@
+package chserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/jpillora/chisel/share/cnet"
+)
+
+// apiSession is a live session as listed by /api/sessions
+type apiSession struct {
+	ID            int32              `json:"id"`
+	User          string             `json:"user"`
+	RemoteAddr    string             `json:"remote_addr"`
+	ClientVersion string             `json:"client_version"`
+	Remotes       []string           `json:"remotes"`
+	OpenChannels  int32              `json:"open_channels"`
+	BytesSent     int64              `json:"bytes_sent"`
+	BytesReceived int64              `json:"bytes_received"`
+	SendRate      float64            `json:"send_rate"`
+	ReceiveRate   float64            `json:"receive_rate"`
+	StartedAt     time.Time          `json:"started_at"`
+	LastSeen      time.Time          `json:"last_seen"`
+	UptimeSeconds float64            `json:"uptime_seconds"`
+	Traffic       []apiRemoteTraffic `json:"traffic"`
+	//Ports are those the server allocated for ephemeral (R:0) remotes
+	Ports []apiPort `json:"ports"`
+}
+
+// apiPort is the port allocated for an ephemeral remote
+type apiPort struct {
+	Remote string `json:"remote"`
+	Port   string `json:"port"`
+}
+
+// apiRemoteTraffic is the traffic of the channels of one remote,
+// rates are in bytes per second
+type apiRemoteTraffic struct {
+	Remote        string  `json:"remote"`
+	BytesSent     int64   `json:"bytes_sent"`
+	BytesReceived int64   `json:"bytes_received"`
+	SendRate      float64 `json:"send_rate"`
+	ReceiveRate   float64 `json:"receive_rate"`
+}
+
+func newAPISession(sess *session) apiSession {
+	a := apiSession{
+		ID:            sess.id,
+		User:          sess.user,
+		RemoteAddr:    sess.remoteAddr,
+		ClientVersion: sess.clientVersion,
+		Remotes:       sess.remoteList(),
+		Ports:         sess.portList(),
+		BytesSent:     atomic.LoadInt64(&sess.sent),
+		BytesReceived: atomic.LoadInt64(&sess.received),
+		StartedAt:     sess.startedAt,
+		LastSeen:      sess.seen(),
+		UptimeSeconds: time.Since(sess.startedAt).Seconds(),
+	}
+	rate, remoteRates := sess.rates()
+	a.SendRate, a.ReceiveRate = rate.sendRate, rate.receiveRate
+	a.Traffic = []apiRemoteTraffic{}
+	if sess.tunnel != nil {
+		a.OpenChannels = sess.tunnel.OpenChannels()
+		for remote, c := range sess.tunnel.Traffic() {
+			r := remoteRates[remote]
+			a.Traffic = append(a.Traffic, apiRemoteTraffic{
+				Remote:        remote,
+				BytesSent:     c.Sent(),
+				BytesReceived: c.Received(),
+				SendRate:      r.sendRate,
+				ReceiveRate:   r.receiveRate,
+			})
+		}
+		sort.Slice(a.Traffic, func(i, j int) bool { return a.Traffic[i].Remote < a.Traffic[j].Remote })
+	}
+	return a
+}
+
+// handleAPISessions serves GET /api/sessions, GET /api/sessions/{id}
+// and DELETE /api/sessions/{id}, which closes the session
+func (s *Server) handleAPISessions(w http.ResponseWriter, r *http.Request) {
+	idStr := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/sessions"), "/")
+	if idStr == "" {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		sessions := s.tunnels.list()
+		sort.Slice(sessions, func(i, j int) bool { return sessions[i].id < sessions[j].id })
+		out := make([]apiSession, len(sessions))
+		for i, sess := range sessions {
+			out[i] = newAPISession(sess)
+		}
+		writeJSON(w, http.StatusOK, out)
+		return
+	}
+	id, err := strconv.ParseInt(idStr, 10, 32)
+	if err != nil {
+		http.Error(w, "Invalid session id", http.StatusBadRequest)
+		return
+	}
+	sess, ok := s.tunnels.get(int32(id))
+	if !ok {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, newAPISession(sess))
+	case http.MethodDelete:
+		s.Infof("Admin API: terminating session#%d of %s (%s)", sess.id, sess.user, sess.remoteAddr)
+		if sess.close != nil {
+			sess.close()
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// apiProxy is a dynamic proxy as listed by /api/proxies
+type apiProxy struct {
+	ID           string     `json:"id"`
+	Target       string     `json:"target"`
+	Host         string     `json:"host"`
+	Subdomain    string     `json:"subdomain"`
+	Access       string     `json:"access"`
+	Source       string     `json:"source"`
+	UserID       int64      `json:"user_id"`
+	JobID        int64      `json:"job_id"`
+	Created      time.Time  `json:"created"`
+	LastSeen     *time.Time `json:"last_seen"`
+	RegisteredBy proxyActor `json:"registered_by"`
+}
+
+func (s *Server) newAPIProxy(pId string, p *DynamicReverseProxy) apiProxy {
+	a := apiProxy{
+		ID:           pId,
+		Target:       p.Target,
+		Host:         s.proxyHost(p),
+		Subdomain:    p.Subdomain,
+		Access:       p.Access,
+		Source:       p.Source,
+		UserID:       p.User,
+		JobID:        p.JobId,
+		Created:      p.Created,
+		RegisteredBy: p.RegisteredBy,
+	}
+	if ns := atomic.LoadInt64(&p.lastSeen); ns > 0 {
+		t := time.Unix(0, ns)
+		a.LastSeen = &t
+	}
+	return a
+}
+
+// handleAPIProxies serves GET /api/proxies
+func (s *Server) handleAPIProxies(w http.ResponseWriter, r *http.Request) {
+	out := []apiProxy{}
+	s.dynamicReverseProxies.Range(func(pId string, p *DynamicReverseProxy) bool {
+		out = append(out, s.newAPIProxy(pId, p))
+		return true
+	})
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	writeJSON(w, http.StatusOK, out)
+}
+
+// handleAPIAuthFailures serves GET /api/auth-failures, newest first
+func (s *Server) handleAPIAuthFailures(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.authFailures.list())
+}
+
+// apiConnection is a tracked connection as listed by /api/connections
+type apiConnection struct {
+	cnet.ConnInfo
+	AgeSeconds float64 `json:"age_seconds"`
+}
+
+// apiConnections lists the open connections with their counts by direction
+type apiConnections struct {
+	Accepted    int             `json:"accepted"`
+	Dialed      int             `json:"dialed"`
+	Connections []apiConnection `json:"connections"`
+}
+
+// handleAPIConnections serves GET /api/connections, oldest first,
+// filtered by the optional session, remote and direction parameters
+func (s *Server) handleAPIConnections(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	filter := cnet.ConnLabels{
+		Session:   q.Get("session"),
+		Remote:    q.Get("remote"),
+		Direction: q.Get("direction"),
+	}
+	out := apiConnections{Connections: []apiConnection{}}
+	for _, c := range s.conns.List(filter) {
+		out.Connections = append(out.Connections, apiConnection{ConnInfo: c, AgeSeconds: c.Age().Seconds()})
+		switch c.Direction {
+		case cnet.Accepted:
+			out.Accepted++
+		case cnet.Dialed:
+			out.Dialed++
+		}
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// handleAPIPortPools serves GET /api/port-pools, the pools of
+// --port-pool with their ports allocated to sessions
+func (s *Server) handleAPIPortPools(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.pools.list(func(id int32) string {
+		if sess, ok := s.tunnels.get(id); ok {
+			return sess.user
+		}
+		return ""
+	}))
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
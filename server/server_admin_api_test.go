@@ -0,0 +1,278 @@
+package chserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jpillora/chisel/share/cio"
+	"github.com/jpillora/chisel/share/settings"
+)
+
+func TestAPISessions(t *testing.T) {
+	s := &Server{
+		Logger:  cio.NewLogger("server"),
+		tunnels: newSessionStore(),
+	}
+	closed := false
+	s.tunnels.add(&session{id: 2, user: "bob", remoteAddr: "10.0.0.2:5000", sent: 5, startedAt: time.Now()})
+	s.tunnels.add(&session{id: 1, user: "alice", startedAt: time.Now(), close: func() error {
+		closed = true
+		return nil
+	}})
+	do := func(method, path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		s.handleAPISessions(rec, httptest.NewRequest(method, path, nil))
+		return rec
+	}
+	rec := do("GET", "/api/sessions")
+	var list []apiSession
+	if err := json.NewDecoder(rec.Body).Decode(&list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].User != "alice" || list[1].RemoteAddr != "10.0.0.2:5000" || list[1].BytesSent != 5 {
+		t.Fatalf("unexpected sessions %+v", list)
+	}
+	if rec := do("GET", "/api/sessions/3"); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", rec.Code)
+	}
+	if rec := do("DELETE", "/api/sessions/x"); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", rec.Code)
+	}
+	if rec := do("DELETE", "/api/sessions/1"); rec.Code != http.StatusNoContent || !closed {
+		t.Fatalf("expected session 1 closed, got %d", rec.Code)
+	}
+}
+
+func TestAuthFailures(t *testing.T) {
+	a := newAuthFailures()
+	for i := 0; i < maxAuthFailures+5; i++ {
+		a.add("tunnel", strconv.Itoa(i), "10.0.0.1:1", errors.New("denied"))
+	}
+	list := a.list()
+	if len(list) != maxAuthFailures || list[0].Subject != strconv.Itoa(maxAuthFailures+4) || list[len(list)-1].Subject != "5" {
+		t.Fatalf("expected the newest %d failures first, got %d from %s to %s", maxAuthFailures, len(list), list[0].Subject, list[len(list)-1].Subject)
+	}
+}
+
+func TestDashboard(t *testing.T) {
+	s := &Server{
+		Logger:                cio.NewLogger("server"),
+		config:                &Config{AdminToken: "secret"},
+		dynamicReverseProxies: NewProxyStore(),
+		authFailures:          newAuthFailures(),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := s.serveAdmin(ctx, "127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	get := func(path string, auth bool) *http.Response {
+		req, _ := http.NewRequest("GET", "http://"+s.adminAddr+path, nil)
+		if auth {
+			req.SetBasicAuth("admin", "secret")
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	resp := get("/ui/", false)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized || resp.Header.Get("WWW-Authenticate") == "" {
+		t.Fatalf("expected a basic auth challenge, got %d", resp.StatusCode)
+	}
+	for _, path := range []string{"/ui/", "/api/proxies", "/api/connections", "/api/auth-failures"} {
+		resp := get(path, true)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d", path, resp.StatusCode)
+		}
+	}
+}
+
+func TestStatus(t *testing.T) {
+	s := &Server{
+		Logger:                cio.NewLogger("server"),
+		config:                &Config{AdminToken: "secret"},
+		dynamicReverseProxies: NewProxyStore(),
+		tunnels:               newSessionStore(),
+		metrics:               newServerMetrics(),
+		started:               time.Now().Add(-time.Minute),
+	}
+	s.tunnels.add(&session{id: 1, user: "alice", sent: 10, received: 20, startedAt: time.Now()})
+	s.tunnels.add(&session{id: 2, user: "alice", sent: 1, received: 2, startedAt: time.Now()})
+	s.dynamicReverseProxies.Add("docs", &DynamicReverseProxy{Id: "docs"})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := s.serveAdmin(ctx, "127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := FetchStatus(ctx, s.adminAddr, "wrong"); err == nil {
+		t.Fatal("expected the wrong token to be rejected")
+	}
+	st, err := FetchStatus(ctx, s.adminAddr, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Sessions != 2 || st.Users != 1 || st.Proxies != 1 || st.BytesSent != 11 || st.BytesReceived != 22 ||
+		st.UptimeSeconds < 60 || st.Ready || st.Checks["listener"] != "not listening" {
+		t.Fatalf("unexpected status %+v", st)
+	}
+}
+
+func TestProxyAudit(t *testing.T) {
+	a := newProxyAudit()
+	for i := 0; i < maxProxyAudit+5; i++ {
+		a.add(proxyAuditEntry{Proxy: strconv.Itoa(i % 2), JobID: int64(i)})
+	}
+	all := a.list("")
+	if len(all) != maxProxyAudit || all[0].JobID != maxProxyAudit+4 {
+		t.Fatalf("expected the newest %d entries first, got %d from %d", maxProxyAudit, len(all), all[0].JobID)
+	}
+	if odd := a.list("1"); len(odd) != maxProxyAudit/2 || odd[0].JobID != maxProxyAudit+3 {
+		t.Fatalf("expected the entries of proxy 1, got %d from %d", len(odd), odd[0].JobID)
+	}
+	r := httptest.NewRequest("POST", "/register", nil)
+	r.RemoteAddr = "10.0.0.9:5000"
+	if by := userActor(r, 42); by.Identity != "user:42" || by.RemoteAddr != "10.0.0.9:5000" {
+		t.Fatalf("unexpected actor %+v", by)
+	}
+}
+
+func TestAPIVersion(t *testing.T) {
+	s := &Server{config: &Config{Reverse: true, Socks5: true}}
+	rec := httptest.NewRecorder()
+	s.handleAPIVersion(rec, httptest.NewRequest("GET", "/api/version", nil))
+	var v apiVersion
+	if err := json.NewDecoder(rec.Body).Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	if v.Version == "" || v.Commit == "" || len(v.Protocols) != 2 ||
+		strings.Join(v.Features, ",") != "dynamic-proxies,reverse,socks5,udp" {
+		t.Fatalf("unexpected version %+v", v)
+	}
+}
+
+func TestProxyDebug(t *testing.T) {
+	s := &Server{Logger: cio.NewLogger("server"), proxyDebug: newProxyDebug()}
+	drProxy := &DynamicReverseProxy{Id: "docs", ServicePrefix: "svc"}
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Set-Cookie", "sid=1")
+		w.WriteHeader(http.StatusTeapot)
+	})
+	serve := func() int {
+		rec := httptest.NewRecorder()
+		s.serveDebug(drProxy, upstream, rec, httptest.NewRequest("GET", "/svc/docs/", nil))
+		return rec.Code
+	}
+	if s.proxyDebug.active("svc") || serve() != http.StatusTeapot {
+		t.Fatal("expected capture to be disabled")
+	}
+	rec := httptest.NewRecorder()
+	s.handleAPIProxyDebug(rec, httptest.NewRequest("POST", "/api/proxy-debug", strings.NewReader(`{"service_prefix":"svc","duration":"2h"}`)))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected durations over an hour to be rejected, got %d", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	s.handleAPIProxyDebug(rec, httptest.NewRequest("POST", "/api/proxy-debug", strings.NewReader(`{"service_prefix":"svc"}`)))
+	if rec.Code != http.StatusOK || !s.proxyDebug.active("svc") || s.proxyDebug.active("api") {
+		t.Fatalf("expected capture of svc, got %d", rec.Code)
+	}
+	if serve() != http.StatusTeapot {
+		t.Fatal("expected the upstream response")
+	}
+	rec = httptest.NewRecorder()
+	s.handleAPIProxyDebug(rec, httptest.NewRequest("DELETE", "/api/proxy-debug?service_prefix=svc", nil))
+	if rec.Code != http.StatusNoContent || s.proxyDebug.active("svc") {
+		t.Fatalf("expected capture to stop, got %d", rec.Code)
+	}
+	s.proxyDebug.enable("svc", -time.Second)
+	if s.proxyDebug.active("svc") {
+		t.Fatal("expected capture to expire")
+	}
+	h := http.Header{"Authorization": {"Bearer x"}, "X-Api-Key": {"k"}, "Accept": {"*/*"}}
+	if got := redactHeaders(h); got != "{Accept: */*; Authorization: [redacted]; X-Api-Key: [redacted]}" {
+		t.Fatalf("unexpected headers %s", got)
+	}
+}
+
+func TestAPILogLevels(t *testing.T) {
+	s := &Server{Logger: cio.NewLogger("server")}
+	s.Info = true
+	s.Subsystem = "server"
+	defer cio.SetLevel("proxy", "")
+	put := func(body string) (int, map[string]string) {
+		rec := httptest.NewRecorder()
+		s.handleAPILogLevels(rec, httptest.NewRequest("PUT", "/api/log-levels", strings.NewReader(body)))
+		levels := map[string]string{}
+		json.NewDecoder(rec.Body).Decode(&levels)
+		return rec.Code, levels
+	}
+	if code, _ := put(`{"subsystem":"db","level":"debug"}`); code != http.StatusBadRequest {
+		t.Fatalf("expected unknown subsystem, got %d", code)
+	}
+	if code, _ := put(`{"subsystem":"proxy","level":"trace"}`); code != http.StatusBadRequest {
+		t.Fatalf("expected invalid level, got %d", code)
+	}
+	code, levels := put(`{"subsystem":"proxy","level":"debug"}`)
+	if code != http.StatusOK || levels["proxy"] != "debug" || levels["server"] != "info" {
+		t.Fatalf("unexpected levels %d %v", code, levels)
+	}
+	if !s.proxyLog().IsDebug() || s.IsDebug() || s.Fork("session#1").IsDebug() {
+		t.Fatal("expected only the proxy subsystem to debug")
+	}
+	if _, levels = put(`{"subsystem":"proxy"}`); levels["proxy"] != "info" || s.proxyLog().IsDebug() {
+		t.Fatalf("expected the proxy level to be restored, got %v", levels)
+	}
+}
+
+func TestSessionRemotes(t *testing.T) {
+	decode := func(ss ...string) settings.Remotes {
+		rs := settings.Remotes{}
+		for _, s := range ss {
+			r, err := settings.DecodeRemote(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rs = append(rs, r)
+		}
+		return rs
+	}
+	sess := &session{}
+	sess.setRemotes(decode("3000", "R:2222:localhost:22"))
+	sess.updateRemotes(&settings.RemotesUpdate{Add: decode("4000"), Remove: decode("3000")})
+	got := sess.remoteList()
+	if len(got) != 2 || got[0] != decode("R:2222:localhost:22")[0].String() || got[1] != decode("4000")[0].String() {
+		t.Fatalf("unexpected remotes %v", got)
+	}
+}
+
+func TestSessionPorts(t *testing.T) {
+	requested, err := settings.DecodeRemote("R:0:localhost:3000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sess := &session{}
+	if b := sess.bound(requested); b != requested {
+		t.Fatal("expected the requested remote before allocation")
+	}
+	sess.allocated(map[string]string{requested.String(): "40123"}, nil)
+	if b := sess.bound(requested); b.LocalPort != "40123" || b.RemotePort != "3000" {
+		t.Fatalf("expected the allocated port, got %s", b)
+	}
+	if ports := newAPISession(sess).Ports; len(ports) != 1 || ports[0].Port != "40123" {
+		t.Fatalf("unexpected ports %v", ports)
+	}
+	sess.allocated(nil, settings.Remotes{requested})
+	if len(sess.portList()) != 0 {
+		t.Fatal("expected the port of the removed remote to be forgotten")
+	}
+}
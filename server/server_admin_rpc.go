@@ -0,0 +1,250 @@
+package chserver
+
+import (
+	"context"
+	"crypto/subtle"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/jpillora/chisel/share/adminrpc"
+	"github.com/jpillora/chisel/share/settings"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// serveAdminRPC serves dcrpc.ChiselAdmin on addr until ctx is cancelled
+func (s *Server) serveAdminRPC(ctx context.Context, addr string) error {
+	if s.config.AdminToken == "" {
+		s.Infof("Admin gRPC server has no ADMIN_TOKEN, disabled")
+		return nil
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	srv := grpc.NewServer(grpc.UnaryInterceptor(s.adminRPCAuth))
+	adminrpc.RegisterChiselAdminServer(srv, &adminRPCServer{s: s})
+	reflection.Register(srv)
+	go func() {
+		<-ctx.Done()
+		srv.GracefulStop()
+	}()
+	go srv.Serve(l)
+	s.adminRPCAddr = l.Addr().String()
+	s.Infof("Admin gRPC server listening on %s", s.adminRPCAddr)
+	return nil
+}
+
+// adminRPCAuth requires the ADMIN_TOKEN as the authorization
+// metadata, optionally as a bearer token. Reflection is open.
+func (s *Server) adminRPCAuth(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	token := ""
+	if v := md.Get("authorization"); len(v) > 0 {
+		token = strings.TrimPrefix(v[0], "Bearer ")
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(s.config.AdminToken)) != 1 {
+		return nil, status.Error(codes.Unauthenticated, "invalid token")
+	}
+	return handler(ctx, req)
+}
+
+// adminRPCServer is dcrpc.ChiselAdmin, it mirrors the admin REST API
+type adminRPCServer struct {
+	adminrpc.UnimplementedChiselAdminServer
+	s *Server
+}
+
+func (a *adminRPCServer) ListSessions(ctx context.Context, req *adminrpc.ListSessionsRequest) (*adminrpc.ListSessionsResponse, error) {
+	sessions := a.s.tunnels.list()
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].id < sessions[j].id })
+	out := &adminrpc.ListSessionsResponse{}
+	for _, sess := range sessions {
+		out.Sessions = append(out.Sessions, pbSession(newAPISession(sess)))
+	}
+	return out, nil
+}
+
+func (a *adminRPCServer) session(id int32) (*session, error) {
+	sess, ok := a.s.tunnels.get(id)
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "session (%d) not found", id)
+	}
+	return sess, nil
+}
+
+func (a *adminRPCServer) GetSession(ctx context.Context, req *adminrpc.SessionRequest) (*adminrpc.Session, error) {
+	sess, err := a.session(req.Id)
+	if err != nil {
+		return nil, err
+	}
+	return pbSession(newAPISession(sess)), nil
+}
+
+func (a *adminRPCServer) TerminateSession(ctx context.Context, req *adminrpc.SessionRequest) (*adminrpc.TerminateSessionResponse, error) {
+	sess, err := a.session(req.Id)
+	if err != nil {
+		return nil, err
+	}
+	a.s.Infof("Admin gRPC: terminating session#%d of %s (%s)", sess.id, sess.user, sess.remoteAddr)
+	if sess.close != nil {
+		sess.close()
+	}
+	return &adminrpc.TerminateSessionResponse{}, nil
+}
+
+func (a *adminRPCServer) ListProxies(ctx context.Context, req *adminrpc.ListProxiesRequest) (*adminrpc.ListProxiesResponse, error) {
+	proxies := []apiProxy{}
+	a.s.dynamicReverseProxies.Range(func(pId string, p *DynamicReverseProxy) bool {
+		proxies = append(proxies, a.s.newAPIProxy(pId, p))
+		return true
+	})
+	sort.Slice(proxies, func(i, j int) bool { return proxies[i].ID < proxies[j].ID })
+	out := &adminrpc.ListProxiesResponse{}
+	for _, p := range proxies {
+		out.Proxies = append(out.Proxies, pbProxy(p))
+	}
+	return out, nil
+}
+
+func (a *adminRPCServer) RemoveProxy(ctx context.Context, req *adminrpc.ProxyRequest) (*adminrpc.RemoveProxyResponse, error) {
+	if _, ok := a.s.removeDynamicProxy(req.Id, rpcActor(ctx, "admin")); !ok {
+		return nil, status.Errorf(codes.NotFound, "proxy (%s) not found", req.Id)
+	}
+	a.s.Infof("Admin gRPC: removed proxy %s", req.Id)
+	return &adminrpc.RemoveProxyResponse{}, nil
+}
+
+func (a *adminRPCServer) ListUsers(ctx context.Context, req *adminrpc.ListUsersRequest) (*adminrpc.ListUsersResponse, error) {
+	users := a.s.users.List()
+	sort.Slice(users, func(i, j int) bool { return users[i].Name < users[j].Name })
+	out := &adminrpc.ListUsersResponse{}
+	for _, u := range users {
+		user := &adminrpc.User{Name: u.Name, Addrs: []string{}, Push: u.Pushed.Encode()}
+		for _, addr := range u.Addrs {
+			user.Addrs = append(user.Addrs, addr.String())
+		}
+		out.Users = append(out.Users, user)
+	}
+	return out, nil
+}
+
+func (a *adminRPCServer) AddUser(ctx context.Context, req *adminrpc.AddUserRequest) (*adminrpc.AddUserResponse, error) {
+	if req.Name == "" {
+		return nil, status.Error(codes.InvalidArgument, "missing name")
+	}
+	pushed, err := settings.DecodePushed(req.Push)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := a.s.addUser(req.Name, req.Password, pushed, req.Addrs); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	a.s.Infof("Admin gRPC: added user %s", req.Name)
+	return &adminrpc.AddUserResponse{}, nil
+}
+
+func (a *adminRPCServer) DeleteUser(ctx context.Context, req *adminrpc.DeleteUserRequest) (*adminrpc.DeleteUserResponse, error) {
+	if _, ok := a.s.users.Get(req.Name); !ok {
+		return nil, status.Errorf(codes.NotFound, "user (%s) not found", req.Name)
+	}
+	a.s.DeleteUser(req.Name)
+	a.s.Infof("Admin gRPC: deleted user %s", req.Name)
+	return &adminrpc.DeleteUserResponse{}, nil
+}
+
+func (a *adminRPCServer) ListAuthFailures(ctx context.Context, req *adminrpc.ListAuthFailuresRequest) (*adminrpc.ListAuthFailuresResponse, error) {
+	out := &adminrpc.ListAuthFailuresResponse{}
+	for _, f := range a.s.authFailures.list() {
+		out.Failures = append(out.Failures, &adminrpc.AuthFailure{
+			Time:       timestamppb.New(f.Time),
+			Kind:       f.Kind,
+			Subject:    f.Subject,
+			RemoteAddr: f.RemoteAddr,
+			Reason:     f.Reason,
+		})
+	}
+	return out, nil
+}
+
+func (a *adminRPCServer) ListProxyAudit(ctx context.Context, req *adminrpc.ListProxyAuditRequest) (*adminrpc.ListProxyAuditResponse, error) {
+	out := &adminrpc.ListProxyAuditResponse{}
+	for _, e := range a.s.proxyAudit.list(req.Id) {
+		out.Entries = append(out.Entries, &adminrpc.ProxyAuditEntry{
+			Time:      timestamppb.New(e.Time),
+			Action:    e.Action,
+			Proxy:     e.Proxy,
+			Target:    e.Target,
+			Subdomain: e.Subdomain,
+			Source:    e.Source,
+			UserId:    e.UserID,
+			JobId:     e.JobID,
+			By:        pbActor(e.By),
+		})
+	}
+	return out, nil
+}
+
+func pbSession(s apiSession) *adminrpc.Session {
+	out := &adminrpc.Session{
+		Id:            s.ID,
+		User:          s.User,
+		RemoteAddr:    s.RemoteAddr,
+		Remotes:       s.Remotes,
+		OpenChannels:  s.OpenChannels,
+		BytesSent:     s.BytesSent,
+		BytesReceived: s.BytesReceived,
+		SendRate:      s.SendRate,
+		ReceiveRate:   s.ReceiveRate,
+		StartedAt:     timestamppb.New(s.StartedAt),
+		LastSeen:      timestamppb.New(s.LastSeen),
+		UptimeSeconds: s.UptimeSeconds,
+		ClientVersion: s.ClientVersion,
+	}
+	for _, t := range s.Traffic {
+		out.Traffic = append(out.Traffic, &adminrpc.RemoteTraffic{
+			Remote:        t.Remote,
+			BytesSent:     t.BytesSent,
+			BytesReceived: t.BytesReceived,
+			SendRate:      t.SendRate,
+			ReceiveRate:   t.ReceiveRate,
+		})
+	}
+	for _, p := range s.Ports {
+		out.Ports = append(out.Ports, &adminrpc.AllocatedPort{Remote: p.Remote, Port: p.Port})
+	}
+	return out
+}
+
+func pbProxy(p apiProxy) *adminrpc.Proxy {
+	out := &adminrpc.Proxy{
+		Id:           p.ID,
+		Target:       p.Target,
+		Host:         p.Host,
+		Subdomain:    p.Subdomain,
+		Access:       p.Access,
+		Source:       p.Source,
+		UserId:       p.UserID,
+		JobId:        p.JobID,
+		Created:      timestamppb.New(p.Created),
+		RegisteredBy: pbActor(p.RegisteredBy),
+	}
+	if p.LastSeen != nil {
+		out.LastSeen = timestamppb.New(*p.LastSeen)
+	}
+	return out
+}
+
+func pbActor(a proxyActor) *adminrpc.ProxyActor {
+	return &adminrpc.ProxyActor{
+		Identity:      a.Identity,
+		RemoteAddr:    a.RemoteAddr,
+		CorrelationId: a.CorrelationID,
+		Reason:        a.Reason,
+	}
+}
@@ -0,0 +1,95 @@
+package chserver
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jpillora/chisel/share/adminrpc"
+	"github.com/jpillora/chisel/share/cio"
+	"github.com/jpillora/chisel/share/settings"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestAdminRPC(t *testing.T) {
+	closed := false
+	s := &Server{
+		Logger:                cio.NewLogger("server"),
+		config:                &Config{AdminToken: "secret"},
+		dynamicReverseProxies: NewProxyStore(),
+		proxyHosts:            newProxyHosts(),
+		events:                newDCMasterEvents(nil),
+		jobs:                  newJobCache(0),
+		tunnels:               newSessionStore(),
+		users:                 settings.NewUserIndex(cio.NewLogger("users")),
+		authFailures:          newAuthFailures(),
+		proxyAudit:            newProxyAudit(),
+	}
+	s.tunnels.add(&session{id: 7, user: "alice", sent: 10, close: func() error { closed = true; return nil }})
+	s.dynamicReverseProxies.Add("docs", &DynamicReverseProxy{Id: "docs", Target: "http://10.0.0.5:8080"})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := s.serveAdminRPC(ctx, "127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := grpc.Dial(s.adminRPCAddr, grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := adminrpc.NewChiselAdminClient(conn)
+	if _, err := client.ListSessions(ctx, &adminrpc.ListSessionsRequest{}); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected unauthenticated, got %v", err)
+	}
+	ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer secret")
+	sessions, err := client.ListSessions(ctx, &adminrpc.ListSessionsRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions.Sessions) != 1 || sessions.Sessions[0].User != "alice" || sessions.Sessions[0].BytesSent != 10 {
+		t.Fatalf("unexpected sessions %v", sessions)
+	}
+	if _, err := client.TerminateSession(ctx, &adminrpc.SessionRequest{Id: 7}); err != nil || !closed {
+		t.Fatalf("expected session to be closed, got %v", err)
+	}
+	if _, err := client.GetSession(ctx, &adminrpc.SessionRequest{Id: 8}); status.Code(err) != codes.NotFound {
+		t.Fatalf("expected not found, got %v", err)
+	}
+	proxies, err := client.ListProxies(ctx, &adminrpc.ListProxiesRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(proxies.Proxies) != 1 || proxies.Proxies[0].Target != "http://10.0.0.5:8080" {
+		t.Fatalf("unexpected proxies %v", proxies)
+	}
+	if _, err := client.RemoveProxy(ctx, &adminrpc.ProxyRequest{Id: "docs"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.dynamicReverseProxies.Get("docs"); ok {
+		t.Fatal("expected docs to be removed")
+	}
+	audit, err := client.ListProxyAudit(ctx, &adminrpc.ListProxyAuditRequest{Id: "docs"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(audit.Entries) != 1 || audit.Entries[0].Action != eventProxyRemoved || audit.Entries[0].By.Identity != "admin" {
+		t.Fatalf("unexpected audit %v", audit)
+	}
+	if _, err := client.AddUser(ctx, &adminrpc.AddUserRequest{Name: "bob", Password: "pw", Addrs: []string{"^10\\."}, Push: []string{"R:2222:localhost:22"}}); err != nil {
+		t.Fatal(err)
+	}
+	users, err := client.ListUsers(ctx, &adminrpc.ListUsersRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users.Users) != 1 || users.Users[0].Addrs[0] != "^10\\." || users.Users[0].Push[0] != "R:0.0.0.0:2222:localhost:22" {
+		t.Fatalf("unexpected users %v", users)
+	}
+	for _, eve := range []*adminrpc.AddUserRequest{{Name: "eve", Addrs: []string{"("}}, {Name: "eve", Push: []string{"stdio:22"}}} {
+		if _, err := client.AddUser(ctx, eve); status.Code(err) != codes.InvalidArgument {
+			t.Fatalf("expected invalid argument, got %v", err)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package chserver
+
+import (
+	"sync"
+	"time"
+)
+
+// maxAuthFailures is how many recent failures are kept
+const maxAuthFailures = 100
+
+// authFailure is a rejected tunnel login or proxy request
+type authFailure struct {
+	Time       time.Time `json:"time"`
+	Kind       string    `json:"kind"`
+	Subject    string    `json:"subject"`
+	RemoteAddr string    `json:"remote_addr"`
+	Reason     string    `json:"reason"`
+}
+
+// authFailures keeps the most recent failures, for the dashboard
+type authFailures struct {
+	mut    sync.Mutex
+	ring   []authFailure
+	next   int
+	filled bool
+}
+
+func newAuthFailures() *authFailures {
+	return &authFailures{ring: make([]authFailure, maxAuthFailures)}
+}
+
+func (a *authFailures) add(kind, subject, remoteAddr string, err error) authFailure {
+	f := authFailure{
+		Time:       time.Now(),
+		Kind:       kind,
+		Subject:    subject,
+		RemoteAddr: remoteAddr,
+		Reason:     err.Error(),
+	}
+	if a == nil {
+		return f
+	}
+	a.mut.Lock()
+	defer a.mut.Unlock()
+	a.ring[a.next] = f
+	a.next = (a.next + 1) % len(a.ring)
+	if a.next == 0 {
+		a.filled = true
+	}
+	return f
+}
+
+// list returns the failures, newest first
+func (a *authFailures) list() []authFailure {
+	if a == nil {
+		return []authFailure{}
+	}
+	a.mut.Lock()
+	defer a.mut.Unlock()
+	n := a.next
+	if a.filled {
+		n = len(a.ring)
+	}
+	out := make([]authFailure, 0, n)
+	for i := 1; i <= n; i++ {
+		out = append(out, a.ring[(a.next-i+len(a.ring))%len(a.ring)])
+	}
+	return out
+}
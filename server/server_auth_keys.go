@@ -0,0 +1,107 @@
+package chserver
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// authKey authenticates a client by its public key, which must be
+// listed in the authorized keys file of its user, the file named
+// after the user in the AuthorizedKeys directory
+func (s *Server) authKey(c ssh.ConnMetadata, key ssh.PublicKey) (p *ssh.Permissions, err error) {
+	t0 := time.Now()
+	name := c.User()
+	fingerprint := ssh.FingerprintSHA256(key)
+	defer func() {
+		s.metrics.auth(time.Since(t0), err)
+		if err != nil {
+			s.authFailed("tunnel", name, c.RemoteAddr().String(), err)
+		}
+	}()
+	keys, err := loadAuthorizedKeys(s.config.AuthorizedKeys, name)
+	if err != nil {
+		s.Debugf("Key login failed for user: %s (%s)", name, err)
+		return nil, errors.New("Invalid key for user: " + name)
+	}
+	var found *authorizedKey
+	for i, k := range keys {
+		if bytes.Equal(k.key.Marshal(), key.Marshal()) {
+			found = &keys[i]
+			break
+		}
+	}
+	if found == nil {
+		s.Infof("Key login failed for user: %s (%s)", name, fingerprint)
+		return nil, errors.New("Invalid key for user: " + name)
+	}
+	//access is that of the authfile's user of the same name
+	if s.users.Len() > 0 {
+		user, ok := s.users.Get(name)
+		if !ok {
+			s.Infof("Key login failed for user: %s (%s), not in the authfile", name, fingerprint)
+			return nil, errors.New("Invalid key for user: " + name)
+		}
+		s.sessions.Set(string(c.SessionID()), user)
+	}
+	s.Infof("Key login success for user: %s (%s)", name, fingerprint)
+	//the crave user of the key, else the
+	//restrictions of unauthenticated access
+	p = &ssh.Permissions{
+		CriticalOptions: map[string]string{},
+		Extensions:      map[string]string{"pubkey-fp": fingerprint},
+	}
+	if found.craveUser != "" {
+		p.CriticalOptions["AllowedUser"] = found.craveUser
+	} else {
+		p.CriticalOptions["AllowedPorts"] = "22"
+	}
+	return p, nil
+}
+
+// authorizedKey is a key of an authorized_keys file, with the
+// crave user id of its crave-user="<id>" option, if any
+type authorizedKey struct {
+	key       ssh.PublicKey
+	craveUser string
+}
+
+// loadAuthorizedKeys reads the authorized_keys
+// formatted file of the user in dir
+func loadAuthorizedKeys(dir, user string) ([]authorizedKey, error) {
+	if user == "" || strings.ContainsAny(user, `/\`) || strings.HasPrefix(user, ".") {
+		return nil, fmt.Errorf("invalid user name %q", user)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dir, user))
+	if err != nil {
+		return nil, err
+	}
+	//lines which aren't keys are skipped
+	var keys []authorizedKey
+	for {
+		key, _, options, rest, err := ssh.ParseAuthorizedKey(b)
+		if err != nil {
+			break
+		}
+		b = rest
+		k := authorizedKey{key: key}
+		for _, o := range options {
+			if v := strings.TrimPrefix(o, "crave-user="); v != o {
+				k.craveUser = strings.Trim(v, `"`)
+			}
+		}
+		//the id is queried as is, keys with invalid ones are skipped
+		if _, err := strconv.ParseInt(k.craveUser, 10, 64); k.craveUser != "" && err != nil {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
@@ -0,0 +1,108 @@
+package chserver
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jpillora/chisel/share/cio"
+	"github.com/jpillora/chisel/share/settings"
+	"golang.org/x/crypto/ssh"
+)
+
+// connMeta is the ssh.ConnMetadata of a login
+type connMeta struct {
+	ssh.ConnMetadata
+	user string
+}
+
+func (c connMeta) User() string          { return c.user }
+func (c connMeta) SessionID() []byte     { return []byte("sid-" + c.user) }
+func (c connMeta) RemoteAddr() net.Addr  { return &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1} }
+func (c connMeta) ClientVersion() []byte { return []byte("SSH-2.0-test") }
+
+func newTestKey(t *testing.T) ssh.PublicKey {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestAuthKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chisel-keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	authorized, other, crave, invalid := newTestKey(t), newTestKey(t), newTestKey(t), newTestKey(t)
+	file := "# alice's laptop\n" + string(ssh.MarshalAuthorizedKey(authorized)) +
+		`crave-user="33" ` + string(ssh.MarshalAuthorizedKey(crave)) +
+		`crave-user="33 OR 1=1" ` + string(ssh.MarshalAuthorizedKey(invalid))
+	for _, name := range []string{"alice", "carol"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(file), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := &Server{
+		Logger:       cio.NewLogger("server"),
+		config:       &Config{AuthorizedKeys: dir},
+		users:        settings.NewUserIndex(cio.NewLogger("server")),
+		sessions:     settings.NewUsers(),
+		authFailures: newAuthFailures(),
+	}
+	//the authfile user of the same name limits the access
+	limited := &settings.User{Name: "alice"}
+	s.users.Set("alice", limited)
+	if _, err := s.authKey(connMeta{user: "alice"}, other); err == nil {
+		t.Fatal("expected an unlisted key to be refused")
+	}
+	//carol has keys but is not in the authfile
+	for _, name := range []string{"bob", "carol", "../alice", ".alice", ""} {
+		if _, err := s.authKey(connMeta{user: name}, authorized); err == nil {
+			t.Fatalf("expected user %q to be refused", name)
+		}
+	}
+	p, err := s.authKey(connMeta{user: "alice"}, authorized)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Extensions["pubkey-fp"] != ssh.FingerprintSHA256(authorized) {
+		t.Fatalf("expected the key's fingerprint, got %q", p.Extensions["pubkey-fp"])
+	}
+	if u, ok := s.sessions.Get("sid-alice"); !ok || u != limited {
+		t.Fatal("expected the session to have the authfile user's access")
+	}
+	if _, err := s.authKey(connMeta{user: "alice"}, invalid); err == nil {
+		t.Fatal("expected a key with an invalid crave user to be refused")
+	}
+	if n := len(s.authFailures.list()); n != 7 {
+		t.Fatalf("expected 7 auth failures, got %d", n)
+	}
+	//keys without a crave user are restricted like unauthenticated access
+	r, err := settings.DecodeRemote("3000:10.0.0.2:8080")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.checkRemote(s.Logger, nil, &ssh.ServerConn{Permissions: p}, r); err == nil {
+		t.Fatal("expected the key login's access to be checked")
+	}
+	if p.CriticalOptions["AllowedPorts"] != "22" {
+		t.Fatalf("expected the key login to be restricted, got %v", p.CriticalOptions)
+	}
+	p, err = s.authKey(connMeta{user: "alice"}, crave)
+	if err != nil || p.CriticalOptions["AllowedUser"] != "33" {
+		t.Fatalf("expected the key's crave user, got %v %v", p, err)
+	}
+	if err := s.checkRemote(s.Logger, nil, &ssh.ServerConn{Permissions: p}, r); err == nil {
+		t.Fatal("expected the crave user's access to be checked")
+	}
+}
@@ -0,0 +1,85 @@
+package chserver
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"regexp"
+	"testing"
+
+	"github.com/jpillora/chisel/share/cio"
+	"github.com/jpillora/chisel/share/settings"
+	"golang.org/x/crypto/ssh"
+)
+
+// sshLogin performs the ssh handshake of a client
+// logging in with the password, as the server sees it
+func sshLogin(t *testing.T, s *Server, user, pass string) (*ssh.ServerConn, error) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{PasswordCallback: s.authUser}
+	config.AddHostKey(signer)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	client, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		conn, _, _, err := ssh.NewClientConn(client, "", &ssh.ClientConfig{
+			User:            user,
+			Auth:            []ssh.AuthMethod{ssh.Password(pass)},
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		})
+		if err == nil {
+			conn.Close()
+		}
+		client.Close()
+	}()
+	conn, _, _, err := ssh.NewServerConn(server, config)
+	return conn, err
+}
+
+func TestAuthUser(t *testing.T) {
+	s := &Server{
+		Logger:       cio.NewLogger("server"),
+		config:       &Config{Reverse: true},
+		users:        settings.NewUserIndex(cio.NewLogger("server")),
+		sessions:     settings.NewUsers(),
+		authFailures: newAuthFailures(),
+	}
+	s.users.Set("alice", &settings.User{Name: "alice", Pass: "secret", Addrs: []*regexp.Regexp{settings.UserAllowAll}})
+	s.users.Set("all", &settings.User{Name: "all", Pass: "all", Addrs: []*regexp.Regexp{settings.UserAllowAll}})
+	//passwords are crave tokens, an authfile password is
+	//no login of its own, as it would have no crave permissions
+	if _, err := sshLogin(t, s, "alice", "secret"); err == nil {
+		t.Fatal("expected the authfile password to be refused")
+	}
+	if f := s.authFailures.list(); len(f) != 1 || f[0].Kind != "tunnel" || f[0].Subject != "alice" {
+		t.Fatalf("expected the failed login to be audited, got %+v", f)
+	}
+	//crave's unauthenticated access has the "all" user
+	conn, err := sshLogin(t, s, "bob", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user, ok := s.sessions.Get(string(conn.SessionID())); !ok || user.Name != "all" {
+		t.Fatalf("expected the session of all, got %v", user)
+	}
+	if conn.Permissions.CriticalOptions["AllowedPorts"] != "22" {
+		t.Fatalf("expected unauthenticated access to be restricted, got %v", conn.Permissions.CriticalOptions)
+	}
+}
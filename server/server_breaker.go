@@ -0,0 +1,118 @@
+package chserver
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// BreakerConfig configures the circuit breakers which
+// protect the server from dead dynamic proxy targets
+type BreakerConfig struct {
+	//Threshold is the number of consecutive upstream
+	//failures which opens the circuit, zero disables
+	Threshold int
+	//Cooldown is how long the circuit stays open before
+	//a single probe request is let through
+	Cooldown time.Duration
+}
+
+// circuitBreaker tracks consecutive failures of a single target
+type circuitBreaker struct {
+	config    BreakerConfig
+	mut       sync.Mutex
+	failures  int
+	openUntil time.Time
+}
+
+// allow reports whether a request may be sent upstream, and
+// if not, how long until the circuit will be half-open
+func (b *circuitBreaker) allow() (bool, time.Duration) {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	if b.failures < b.config.Threshold {
+		return true, 0
+	}
+	if wait := time.Until(b.openUntil); wait > 0 {
+		return false, wait
+	}
+	//half-open, let one probe through and hold the
+	//rest back until it reports (or another cooldown)
+	b.openUntil = time.Now().Add(b.config.Cooldown)
+	return true, 0
+}
+
+func (b *circuitBreaker) success() {
+	b.mut.Lock()
+	b.failures = 0
+	b.mut.Unlock()
+}
+
+// failure records an upstream failure, returning true
+// when it caused the circuit to open
+func (b *circuitBreaker) failure() bool {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	b.failures++
+	if b.failures >= b.config.Threshold {
+		b.openUntil = time.Now().Add(b.config.Cooldown)
+		return true
+	}
+	return false
+}
+
+// breakers holds one circuit breaker per target host,
+// shared by all dynamic proxies of that target
+type breakers struct {
+	config BreakerConfig
+	mut    sync.Mutex
+	inner  map[string]*circuitBreaker
+}
+
+func (bs *breakers) get(target string) *circuitBreaker {
+	if bs == nil || bs.config.Threshold <= 0 {
+		return nil
+	}
+	bs.mut.Lock()
+	defer bs.mut.Unlock()
+	if bs.inner == nil {
+		bs.inner = map[string]*circuitBreaker{}
+	}
+	b, ok := bs.inner[target]
+	if !ok {
+		b = &circuitBreaker{config: bs.config}
+		bs.inner[target] = b
+	}
+	return b
+}
+
+// wrap rejects requests while the target's circuit is open
+func (b *circuitBreaker) wrap(next http.Handler) http.Handler {
+	if b == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ok, wait := b.allow(); !ok {
+			secs := int(wait/time.Second) + 1
+			w.Header().Set("Retry-After", strconv.Itoa(secs))
+			http.Error(w, "Upstream unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// observe records the outcome of an upstream round trip
+func (s *Server) observeUpstream(b *circuitBreaker, target string, err error, status int) {
+	if b == nil {
+		return
+	}
+	if err == nil && status != http.StatusBadGateway && status != http.StatusGatewayTimeout {
+		b.success()
+		return
+	}
+	if b.failure() {
+		s.Infof("Circuit opened for %s for %s (error: %v, status: %d)", target, b.config.Cooldown, err, status)
+	}
+}
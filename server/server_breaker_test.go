@@ -0,0 +1,42 @@
+package chserver
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	bs := &breakers{config: BreakerConfig{Threshold: 2, Cooldown: 50 * time.Millisecond}}
+	b := bs.get("target:80")
+	if bs.get("target:80") != b {
+		t.Fatal("expected breakers to be shared per target")
+	}
+	b.failure()
+	if ok, _ := b.allow(); !ok {
+		t.Fatal("expected circuit to be closed after one failure")
+	}
+	if !b.failure() {
+		t.Fatal("expected circuit to open")
+	}
+	if ok, wait := b.allow(); ok || wait <= 0 {
+		t.Fatalf("expected open circuit, got %v %s", ok, wait)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if ok, _ := b.allow(); !ok {
+		t.Fatal("expected a probe to be allowed")
+	}
+	if ok, _ := b.allow(); ok {
+		t.Fatal("expected only one probe")
+	}
+	b.success()
+	if ok, _ := b.allow(); !ok {
+		t.Fatal("expected circuit to close after a successful probe")
+	}
+}
+
+func TestCircuitBreakerDisabled(t *testing.T) {
+	bs := &breakers{}
+	if bs.get("target:80") != nil {
+		t.Fatal("expected no breaker when disabled")
+	}
+}
@@ -0,0 +1,261 @@
+package chserver
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	chshare "github.com/jpillora/chisel/share"
+	"github.com/jpillora/chisel/share/cdb"
+	"github.com/jpillora/chisel/share/cio"
+)
+
+// clusterInterval is how often the routes of the server are
+// published, those not refreshed for three intervals are stale
+const clusterInterval = 2 * time.Second
+
+// clusterCacheSize bounds the looked up routes which are
+// cached, since their names come from the requests
+const clusterCacheSize = 4096
+
+// clusterHopHeader marks a request forwarded by another
+// server of the cluster, which is never forwarded again
+const clusterHopHeader = "X-Chisel-Cluster-Hop"
+
+// cluster shares the routes of the server, its dynamic proxies
+// ("proxy:<id or subdomain>"), public remotes ("public:<subdomain>")
+// and itself ("node:<name>"), with the servers sharing its database,
+// in chisel_routes, and forwards the requests of their routes to them
+type cluster struct {
+	*cio.Logger
+	db   *cdb.DB
+	node string
+	url  string
+	mut  sync.Mutex
+	//cache is the server of each route looked up,
+	//empty when none is, until it expires
+	cache   *routeCache
+	proxies map[string]*httputil.ReverseProxy
+}
+
+// routeCache is an LRU of the servers of routes
+type routeCache struct {
+	size    int
+	lru     *list.List
+	entries map[string]*list.Element
+}
+
+type clusterRoute struct {
+	route   string
+	url     string
+	expires time.Time
+}
+
+func newRouteCache(size int) *routeCache {
+	return &routeCache{size: size, lru: list.New(), entries: map[string]*list.Element{}}
+}
+
+// get is the server of the route, unless it is not cached or expired
+func (rc *routeCache) get(route string) (string, bool) {
+	el, ok := rc.entries[route]
+	if !ok {
+		return "", false
+	}
+	r := el.Value.(*clusterRoute)
+	if time.Now().After(r.expires) {
+		rc.lru.Remove(el)
+		delete(rc.entries, route)
+		return "", false
+	}
+	rc.lru.MoveToFront(el)
+	return r.url, true
+}
+
+// put caches the server of the route, evicting the least recently used
+func (rc *routeCache) put(route, url string, expires time.Time) {
+	if el, ok := rc.entries[route]; ok {
+		rc.lru.Remove(el)
+	}
+	rc.entries[route] = rc.lru.PushFront(&clusterRoute{route: route, url: url, expires: expires})
+	for rc.lru.Len() > rc.size {
+		r := rc.lru.Remove(rc.lru.Back()).(*clusterRoute)
+		delete(rc.entries, r.route)
+	}
+}
+
+func newCluster(db *cdb.DB, node, advertise string, l *cio.Logger) (*cluster, error) {
+	if db == nil {
+		return nil, errors.New("Clustering requires a database")
+	}
+	u, err := url.Parse(advertise)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, errors.New("Invalid cluster URL (" + advertise + "), expected http(s)://host:port")
+	}
+	return &cluster{
+		Logger:  l.Fork("cluster"),
+		db:      db,
+		node:    node,
+		url:     u.String(),
+		cache:   newRouteCache(clusterCacheSize),
+		proxies: map[string]*httputil.ReverseProxy{},
+	}, nil
+}
+
+// clusterRoutes are the routes served by this server
+func (s *Server) clusterRoutes() []string {
+	var routes []string
+	s.dynamicReverseProxies.Range(func(pId string, p *DynamicReverseProxy) bool {
+		routes = append(routes, "proxy:"+pId)
+		if p.Subdomain != "" {
+			routes = append(routes, "proxy:"+p.Subdomain)
+		}
+		return true
+	})
+	for _, subdomain := range s.publicHosts.subdomains() {
+		routes = append(routes, "public:"+subdomain)
+	}
+	//clients are no longer routed back while the sessions drain
+	if s.cluster != nil && atomic.LoadInt32(&s.shuttingDown) == 0 {
+		routes = append(routes, "node:"+s.cluster.node)
+	}
+	return routes
+}
+
+// affinity is the server of the cluster a reconnecting
+// client was connected to, if it asks to be routed back
+func affinity(r *http.Request) string {
+	if node := r.Header.Get(chshare.AffinityHeader); node != "" {
+		return node
+	}
+	if c, err := r.Cookie(chshare.AffinityCookie); err == nil {
+		return c.Value
+	}
+	return ""
+}
+
+// affinityHeader is the websocket upgrade response header,
+// which names this server for the client to be routed back to
+func (c *cluster) affinityHeader() http.Header {
+	h := http.Header{}
+	if c == nil {
+		return h
+	}
+	h.Set(chshare.AffinityHeader, c.node)
+	h.Add("Set-Cookie", (&http.Cookie{Name: chshare.AffinityCookie, Value: c.node, Path: "/"}).String())
+	return h
+}
+
+// publishRoutes replaces the routes of this server every
+// interval, until ctx is cancelled, when they are removed
+func (s *Server) publishRoutes(ctx context.Context, interval time.Duration) {
+	c := s.cluster
+	ctx = cdb.WithSubsystem(ctx, "cluster")
+	if err := c.db.Migrate(ctx, migrations); err != nil {
+		c.Infof("Clustering disabled, failed to create tables: %s", err)
+		return
+	}
+	c.Infof("Sharing routes as %s at %s", c.node, c.url)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := c.publish(ctx, s.clusterRoutes()); err != nil {
+			c.Debugf("Failed to publish routes: %s", err)
+		}
+		select {
+		case <-ctx.Done():
+			cleanup, cancel := context.WithTimeout(cdb.WithSubsystem(context.Background(), "cluster"), 5*time.Second)
+			c.db.Exec(cleanup, "DELETE FROM chisel_routes WHERE server = $1", c.node)
+			cancel()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// publish replaces the routes of this server in one transaction
+func (c *cluster) publish(ctx context.Context, routes []string) error {
+	return pgx.BeginFunc(ctx, c.db, func(tx pgx.Tx) error {
+		b := &pgx.Batch{}
+		b.Queue("DELETE FROM chisel_routes WHERE server = $1", c.node)
+		for _, route := range routes {
+			b.Queue(`INSERT INTO chisel_routes (route, server, url, last_seen) VALUES ($1, $2, $3, now())
+				ON CONFLICT DO NOTHING`, route, c.node, c.url)
+		}
+		return tx.SendBatch(ctx, b).Close()
+	})
+}
+
+// owner is the url of another server with the route, if any
+func (c *cluster) owner(ctx context.Context, route string) (string, error) {
+	if c == nil {
+		return "", nil
+	}
+	var u string
+	err := c.db.QueryRow(cdb.WithSubsystem(ctx, "cluster"), `SELECT url FROM chisel_routes
+		WHERE route = $1 AND server <> $2 AND last_seen > now() - $3::interval
+		ORDER BY last_seen DESC LIMIT 1`, route, c.node, (3 * clusterInterval).String()).Scan(&u)
+	if err == pgx.ErrNoRows {
+		return "", nil
+	}
+	return u, err
+}
+
+// lookup is the owner of the route, cached for an interval
+func (c *cluster) lookup(ctx context.Context, route string) string {
+	c.mut.Lock()
+	u, ok := c.cache.get(route)
+	c.mut.Unlock()
+	if ok {
+		return u
+	}
+	u, err := c.owner(ctx, route)
+	if err != nil {
+		c.Debugf("Failed to look up %s: %s", route, err)
+		return ""
+	}
+	c.mut.Lock()
+	c.cache.put(route, u, time.Now().Add(clusterInterval))
+	c.mut.Unlock()
+	return u
+}
+
+// forward sends the request to the server with the route,
+// reporting whether another server has it
+func (c *cluster) forward(w http.ResponseWriter, r *http.Request, route string) bool {
+	if c == nil || r.Header.Get(clusterHopHeader) != "" {
+		return false
+	}
+	u := c.lookup(r.Context(), route)
+	if u == "" {
+		return false
+	}
+	c.Debugf("Forwarding %s to %s", route, u)
+	r.Header.Set(clusterHopHeader, c.node)
+	c.proxy(u).ServeHTTP(w, r)
+	return true
+}
+
+// proxy is the reverse proxy to another server, which
+// keeps the request's host for the server to route
+func (c *cluster) proxy(u string) *httputil.ReverseProxy {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	if p, ok := c.proxies[u]; ok {
+		return p
+	}
+	target, _ := url.Parse(u)
+	p := httputil.NewSingleHostReverseProxy(target)
+	p.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		c.Debugf("Forwarding to %s: %s", u, err)
+		http.Error(w, "Cluster server unavailable", http.StatusBadGateway)
+	}
+	c.proxies[u] = p
+	return p
+}
@@ -0,0 +1,115 @@
+package chserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"testing"
+	"time"
+
+	"github.com/jpillora/chisel/share/cio"
+)
+
+func TestClusterForward(t *testing.T) {
+	//the server with the route
+	var host, hop string
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, hop = r.Host, r.Header.Get(clusterHopHeader)
+		w.Write([]byte("from b"))
+	}))
+	defer other.Close()
+	c := &cluster{
+		Logger:  cio.NewLogger("cluster"),
+		node:    "a",
+		cache:   newRouteCache(clusterCacheSize),
+		proxies: map[string]*httputil.ReverseProxy{},
+	}
+	expires := time.Now().Add(time.Hour)
+	c.cache.put("proxy:docs", other.URL, expires)
+	c.cache.put("proxy:missing", "", expires)
+	//the host is kept for the other server to route
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "http://docs.proxy.example.com/x", nil)
+	if !c.forward(w, r, "proxy:docs") || w.Body.String() != "from b" {
+		t.Fatalf("expected the request to be forwarded, got %d %s", w.Code, w.Body)
+	}
+	if host != "docs.proxy.example.com" || hop != "a" {
+		t.Fatalf("unexpected forwarded request host %s hop %s", host, hop)
+	}
+	if c.forward(httptest.NewRecorder(), httptest.NewRequest("GET", "/missing", nil), "proxy:missing") {
+		t.Fatal("expected a route of no server not to be forwarded")
+	}
+	//forwarded requests are never forwarded again
+	r = httptest.NewRequest("GET", "http://docs.proxy.example.com/x", nil)
+	r.Header.Set(clusterHopHeader, "b")
+	if c.forward(httptest.NewRecorder(), r, "proxy:docs") {
+		t.Fatal("expected a forwarded request not to be forwarded again")
+	}
+	var none *cluster
+	if none.forward(httptest.NewRecorder(), r, "proxy:docs") {
+		t.Fatal("expected no forwarding without a cluster")
+	}
+	if _, err := newCluster(nil, "a", "http://10.0.0.1:8080", c.Logger); err == nil {
+		t.Fatal("expected clustering to require a database")
+	}
+}
+
+func TestClusterAffinity(t *testing.T) {
+	var node string
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		node = r.Header.Get(clusterHopHeader)
+	}))
+	defer other.Close()
+	c := &cluster{
+		Logger:  cio.NewLogger("cluster"),
+		node:    "a",
+		cache:   newRouteCache(clusterCacheSize),
+		proxies: map[string]*httputil.ReverseProxy{},
+	}
+	expires := time.Now().Add(time.Hour)
+	c.cache.put("node:b", other.URL, expires)
+	c.cache.put("node:a", "", expires)
+	//the upgrade response names the server, as a header and a cookie
+	h := c.affinityHeader()
+	if h.Get("X-Chisel-Affinity") != "a" || h.Get("Set-Cookie") != "chisel-affinity=a; Path=/" {
+		t.Fatalf("unexpected affinity header %v", h)
+	}
+	var none *cluster
+	if len(none.affinityHeader()) != 0 {
+		t.Fatal("expected no affinity without a cluster")
+	}
+	//reconnecting clients, by cookie, are routed back to their server
+	r := httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(&http.Cookie{Name: "chisel-affinity", Value: "b"})
+	if affinity(r) != "b" || !c.forward(httptest.NewRecorder(), r, "node:"+affinity(r)) || node != "a" {
+		t.Fatalf("expected the client to be forwarded to b, got %q", node)
+	}
+	r = httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("X-Chisel-Affinity", "a")
+	if affinity(r) != "a" || c.forward(httptest.NewRecorder(), r, "node:a") {
+		t.Fatal("expected a client of this server not to be forwarded")
+	}
+}
+
+func TestClusterRouteCache(t *testing.T) {
+	rc := newRouteCache(2)
+	expires := time.Now().Add(time.Hour)
+	rc.put("proxy:a", "http://10.0.0.1", expires)
+	rc.put("proxy:b", "", expires)
+	//the least recently used route is evicted
+	if _, ok := rc.get("proxy:a"); !ok {
+		t.Fatal("expected proxy:a to be cached")
+	}
+	rc.put("proxy:c", "", expires)
+	if _, ok := rc.get("proxy:b"); ok || len(rc.entries) != 2 || rc.lru.Len() != 2 {
+		t.Fatalf("expected proxy:b to be evicted, got %d routes", len(rc.entries))
+	}
+	if u, ok := rc.get("proxy:a"); !ok || u != "http://10.0.0.1" {
+		t.Fatalf("expected proxy:a to be kept, got %q", u)
+	}
+	//expired routes are removed
+	rc.put("proxy:c", "", time.Now().Add(-time.Second))
+	if _, ok := rc.get("proxy:c"); ok || len(rc.entries) != 1 {
+		t.Fatal("expected proxy:c to expire")
+	}
+}
@@ -0,0 +1,237 @@
+package chserver
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// CompressConfig enables on-the-fly compression of proxied responses
+type CompressConfig struct {
+	Enabled bool
+	//MinSize is the smallest body which will be compressed
+	MinSize int
+	//Types are the compressible media types, a trailing
+	//"/*" matches all subtypes, e.g. "text/*"
+	Types []string
+}
+
+var defaultCompressTypes = []string{
+	"text/*",
+	"application/javascript",
+	"application/json",
+	"application/xml",
+	"application/wasm",
+	"image/svg+xml",
+}
+
+// compressMiddleware compresses responses the upstream left
+// uncompressed, when the client advertises support
+type compressMiddleware struct {
+	minSize int
+	types   []string
+}
+
+func newCompressMiddleware(c CompressConfig) *compressMiddleware {
+	m := &compressMiddleware{minSize: c.MinSize, types: c.Types}
+	if m.minSize <= 0 {
+		m.minSize = 1024
+	}
+	if len(m.types) == 0 {
+		m.types = defaultCompressTypes
+	}
+	return m
+}
+
+// compressed wraps h in the compression middleware, if enabled
+func (s *Server) compressed(h http.Handler) http.Handler {
+	if s.compress == nil {
+		return h
+	}
+	return s.compress.wrap(h)
+}
+
+func (m *compressMiddleware) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		enc := acceptedEncoding(r.Header.Get("Accept-Encoding"))
+		if enc == "" || r.Method == http.MethodHead || r.Header.Get("Upgrade") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cw := &compressWriter{ResponseWriter: w, m: m, encoding: enc}
+		defer cw.Close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+func (m *compressMiddleware) compressible(contentType string) bool {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, t := range m.types {
+		if t == mt || (strings.HasSuffix(t, "/*") && strings.HasPrefix(mt, t[:len(t)-1])) {
+			return true
+		}
+	}
+	return false
+}
+
+// acceptedEncoding picks brotli over gzip from an Accept-Encoding header
+func acceptedEncoding(header string) string {
+	gz := false
+	for _, part := range strings.Split(header, ",") {
+		name, q := part, 1.0
+		if i := strings.Index(part, ";"); i >= 0 {
+			name = part[:i]
+			if p := strings.TrimSpace(part[i+1:]); strings.HasPrefix(p, "q=") {
+				if f, err := strconv.ParseFloat(p[2:], 64); err == nil {
+					q = f
+				}
+			}
+		}
+		if q <= 0 {
+			continue
+		}
+		switch strings.TrimSpace(name) {
+		case "br":
+			return "br"
+		case "gzip":
+			gz = true
+		}
+	}
+	if gz {
+		return "gzip"
+	}
+	return ""
+}
+
+// compressWriter buffers the response until it can decide
+// whether compression is worthwhile
+type compressWriter struct {
+	http.ResponseWriter
+	m        *compressMiddleware
+	encoding string
+	status   int
+	decided  bool
+	buff     bytes.Buffer
+	enc      io.WriteCloser
+}
+
+func (c *compressWriter) WriteHeader(status int) {
+	if c.status != 0 {
+		return
+	}
+	c.status = status
+	h := c.Header()
+	//only compress when it's possible and
+	//the upstream hasn't done it already
+	if status < 200 || status == http.StatusNoContent || status == http.StatusNotModified ||
+		h.Get("Content-Encoding") != "" || !c.m.compressible(h.Get("Content-Type")) {
+		c.decide(false)
+		return
+	}
+	if cl, err := strconv.Atoi(h.Get("Content-Length")); err == nil && cl < c.m.minSize {
+		c.decide(false)
+	}
+}
+
+func (c *compressWriter) Write(b []byte) (int, error) {
+	if c.status == 0 {
+		if c.Header().Get("Content-Type") == "" {
+			c.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		c.WriteHeader(http.StatusOK)
+	}
+	if c.decided {
+		if c.enc != nil {
+			return c.enc.Write(b)
+		}
+		return c.ResponseWriter.Write(b)
+	}
+	c.buff.Write(b)
+	if c.buff.Len() >= c.m.minSize {
+		if err := c.decide(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// decide commits the headers and flushes any buffered body
+func (c *compressWriter) decide(compress bool) error {
+	if c.decided {
+		return nil
+	}
+	c.decided = true
+	h := c.Header()
+	if compress {
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", c.encoding)
+		h.Add("Vary", "Accept-Encoding")
+		if c.encoding == "br" {
+			c.enc = brotli.NewWriterLevel(c.ResponseWriter, brotli.DefaultCompression)
+		} else {
+			c.enc, _ = gzip.NewWriterLevel(c.ResponseWriter, gzip.DefaultCompression)
+		}
+	}
+	if c.status == 0 {
+		c.status = http.StatusOK
+	}
+	c.ResponseWriter.WriteHeader(c.status)
+	if c.buff.Len() == 0 {
+		return nil
+	}
+	var err error
+	if c.enc != nil {
+		_, err = c.enc.Write(c.buff.Bytes())
+	} else {
+		_, err = c.ResponseWriter.Write(c.buff.Bytes())
+	}
+	c.buff = bytes.Buffer{}
+	return err
+}
+
+// Flush forces a decision, streamed responses
+// are compressed if their type allows it
+func (c *compressWriter) Flush() {
+	if !c.decided && c.status != 0 {
+		c.decide(true)
+	}
+	if f, ok := c.enc.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	if f, ok := c.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Close ends the response, bodies smaller than
+// the threshold are sent uncompressed
+func (c *compressWriter) Close() error {
+	if c.status == 0 {
+		return nil
+	}
+	if !c.decided {
+		c.decide(false)
+	}
+	if c.enc != nil {
+		return c.enc.Close()
+	}
+	return nil
+}
+
+func (c *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hj, ok := c.ResponseWriter.(http.Hijacker); ok {
+		return hj.Hijack()
+	}
+	return nil, nil, http.ErrNotSupported
+}
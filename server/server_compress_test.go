@@ -0,0 +1,99 @@
+package chserver
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+)
+
+func TestAcceptedEncoding(t *testing.T) {
+	for header, expected := range map[string]string{
+		"":                     "",
+		"identity":             "",
+		"gzip":                 "gzip",
+		"gzip, deflate, br":    "br",
+		"br;q=0, gzip":         "gzip",
+		"gzip;q=0":             "",
+		" gzip ; q=0.5 , br;q": "br",
+	} {
+		if got := acceptedEncoding(header); got != expected {
+			t.Errorf("%q: expected %q, got %q", header, expected, got)
+		}
+	}
+}
+
+func TestCompressMiddleware(t *testing.T) {
+	body := strings.Repeat("hello chisel ", 200)
+	h := newCompressMiddleware(CompressConfig{}).wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/small":
+			w.Write([]byte("hi"))
+		case "/image":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte(body))
+		case "/encoded":
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Write([]byte(body))
+		default:
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.Write([]byte(body[:600]))
+			w.Write([]byte(body[600:]))
+		}
+	}))
+	get := func(path, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		if accept != "" {
+			req.Header.Set("Accept-Encoding", accept)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	//gzip
+	rec := get("/", "gzip")
+	if rec.Header().Get("Content-Encoding") != "gzip" || rec.Header().Get("Vary") != "Accept-Encoding" {
+		t.Fatalf("expected gzip, got headers %v", rec.Header())
+	}
+	gr, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadAll(gr); string(b) != body {
+		t.Fatalf("gzip body mismatch, got %d bytes", len(b))
+	}
+	//brotli is preferred
+	rec = get("/", "gzip, br")
+	if rec.Header().Get("Content-Encoding") != "br" {
+		t.Fatalf("expected br, got headers %v", rec.Header())
+	}
+	if b, _ := ioutil.ReadAll(brotli.NewReader(rec.Body)); string(b) != body {
+		t.Fatalf("brotli body mismatch, got %d bytes", len(b))
+	}
+	//left alone
+	for path, accept := range map[string]string{
+		"/":        "",
+		"/small":   "gzip",
+		"/image":   "gzip",
+		"/encoded": "br",
+	} {
+		rec = get(path, accept)
+		if path == "/encoded" {
+			if rec.Header().Get("Content-Encoding") != "gzip" || rec.Body.String() != body {
+				t.Fatalf("%s: expected the upstream encoding to be kept, got %v", path, rec.Header())
+			}
+			continue
+		}
+		if rec.Header().Get("Content-Encoding") != "" {
+			t.Fatalf("%s (%q): expected no compression, got %v", path, accept, rec.Header())
+		}
+		if path == "/small" && rec.Body.String() != "hi" {
+			t.Fatalf("%s: unexpected body %q", path, rec.Body.String())
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package chserver
+
+import (
+	"net/http"
+
+	"github.com/jpillora/chisel/share/ctrace"
+)
+
+// correlated gives every request a correlation ID, taken from the
+// X-Correlation-Id header when the client sent a valid one. It is
+// set on the request, so proxies pass it upstream, on the response,
+// and on the request context, which carries it to dcrpc calls.
+func (s *Server) correlated(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(ctrace.CorrelationHeader)
+		if !ctrace.ValidCorrelationID(id) {
+			id = ctrace.NewCorrelationID()
+			r.Header.Set(ctrace.CorrelationHeader, id)
+		}
+		w.Header().Set(ctrace.CorrelationHeader, id)
+		next.ServeHTTP(w, r.WithContext(ctrace.WithCorrelationID(r.Context(), id)))
+	})
+}
@@ -0,0 +1,122 @@
+package chserver
+
+import "net/http"
+
+// handleDashboard serves the admin web UI, which polls the
+// sessions, proxies and auth failures APIs
+func (s *Server) handleDashboard(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/ui/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(dashboardHTML))
+}
+
+// dashboardHTML is the single page of the dashboard, throughput
+// is derived from the byte counts of successive polls
+const dashboardHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>chisel</title>
+<style>
+body { font: 14px sans-serif; margin: 2em; color: #222; }
+h1 { font-size: 20px; }
+h2 { font-size: 16px; margin-top: 2em; }
+table { border-collapse: collapse; width: 100%; }
+th, td { text-align: left; padding: 4px 8px; border-bottom: 1px solid #ddd; white-space: nowrap; }
+th { background: #f4f4f4; }
+button { cursor: pointer; }
+svg { vertical-align: middle; }
+.muted { color: #888; }
+</style>
+</head>
+<body>
+<h1>chisel <span class="muted" id="updated"></span></h1>
+<h2>Sessions</h2>
+<table>
+<thead><tr><th>ID</th><th>User</th><th>Remote</th><th>Channels</th><th>In</th><th>Out</th><th>Throughput</th><th>Uptime</th><th></th></tr></thead>
+<tbody id="sessions"></tbody>
+</table>
+<h2>Dynamic proxies</h2>
+<table>
+<thead><tr><th>ID</th><th>Target</th><th>Host</th><th>Access</th><th>Source</th><th>User</th><th>Job</th><th>Last request</th></tr></thead>
+<tbody id="proxies"></tbody>
+</table>
+<h2>Recent auth failures</h2>
+<table>
+<thead><tr><th>Time</th><th>Kind</th><th>Subject</th><th>Remote</th><th>Reason</th></tr></thead>
+<tbody id="failures"></tbody>
+</table>
+<script>
+var rates = {}, last = {}, points = 60, interval = 2000;
+function esc(s) {
+  return String(s == null ? "" : s).replace(/[&<>"]/g, function(c) {
+    return {"&": "&amp;", "<": "&lt;", ">": "&gt;", "\"": "&quot;"}[c];
+  });
+}
+function size(n) {
+  var units = ["B", "KB", "MB", "GB", "TB"], i = 0;
+  while (n >= 1024 && i < units.length - 1) { n /= 1024; i++; }
+  return n.toFixed(i ? 1 : 0) + units[i];
+}
+function duration(s) {
+  s = Math.floor(s);
+  if (s < 60) return s + "s";
+  if (s < 3600) return Math.floor(s / 60) + "m" + (s % 60) + "s";
+  return Math.floor(s / 3600) + "h" + Math.floor(s % 3600 / 60) + "m";
+}
+function sparkline(values) {
+  var max = Math.max.apply(null, values.concat([1])), w = 120, h = 24;
+  var pts = values.map(function(v, i) {
+    return (i * w / (points - 1)).toFixed(1) + "," + (h - v / max * h).toFixed(1);
+  }).join(" ");
+  return '<svg width="' + w + '" height="' + h + '"><polyline fill="none" stroke="#36c" points="' + pts + '"/></svg> ' +
+    size(values[values.length - 1] || 0) + "/s";
+}
+function get(path) {
+  return fetch(path, {credentials: "same-origin"}).then(function(r) { return r.json(); });
+}
+function kill(id) {
+  if (confirm("Terminate session " + id + "?")) {
+    fetch("/api/sessions/" + id, {method: "DELETE", credentials: "same-origin"}).then(refresh);
+  }
+}
+function refresh() {
+  get("/api/sessions").then(function(sessions) {
+    var now = Date.now(), seen = {};
+    document.getElementById("sessions").innerHTML = sessions.map(function(s) {
+      seen[s.id] = true;
+      var total = s.bytes_sent + s.bytes_received, h = rates[s.id] || [];
+      if (last[s.id]) h.push((total - last[s.id].total) * 1000 / (now - last[s.id].time));
+      last[s.id] = {total: total, time: now};
+      rates[s.id] = h.slice(-points);
+      return "<tr><td>" + s.id + "</td><td>" + esc(s.user) + "</td><td>" + esc(s.remote_addr) +
+        "</td><td>" + s.open_channels + "</td><td>" + size(s.bytes_received) + "</td><td>" + size(s.bytes_sent) +
+        "</td><td>" + sparkline(rates[s.id]) + "</td><td>" + duration(s.uptime_seconds) +
+        '</td><td><button onclick="kill(' + s.id + ')">Terminate</button></td></tr>';
+    }).join("");
+    for (var id in rates) if (!seen[id]) { delete rates[id]; delete last[id]; }
+  });
+  get("/api/proxies").then(function(proxies) {
+    document.getElementById("proxies").innerHTML = proxies.map(function(p) {
+      return "<tr><td>" + esc(p.id) + "</td><td>" + esc(p.target) + "</td><td>" + esc(p.host) +
+        "</td><td>" + esc(p.access) + "</td><td>" + esc(p.source) + "</td><td>" + p.user_id +
+        "</td><td>" + p.job_id + "</td><td>" + esc(p.last_seen ? new Date(p.last_seen).toLocaleString() : "never") + "</td></tr>";
+    }).join("");
+  });
+  get("/api/auth-failures").then(function(failures) {
+    document.getElementById("failures").innerHTML = failures.map(function(f) {
+      return "<tr><td>" + esc(new Date(f.time).toLocaleString()) + "</td><td>" + esc(f.kind) + "</td><td>" +
+        esc(f.subject) + "</td><td>" + esc(f.remote_addr) + "</td><td>" + esc(f.reason) + "</td></tr>";
+    }).join("");
+  });
+  document.getElementById("updated").textContent = new Date().toLocaleTimeString();
+}
+refresh();
+setInterval(refresh, interval);
+</script>
+</body>
+</html>
+`
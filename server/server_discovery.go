@@ -0,0 +1,136 @@
+package chserver
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/jpillora/backoff"
+)
+
+// defaultStartupRetries bounds the startup lookups
+// when Config.StartupRetries is not set
+const defaultStartupRetries = 5
+
+// lookupDCMasterPort asks the discoverer for the dcmaster port,
+// retrying with exponential backoff. Negative attempts retry until
+// the context is cancelled.
+func (s *Server) lookupDCMasterPort(ctx context.Context, attempts int) (string, error) {
+	b := &backoff.Backoff{Min: time.Second, Max: s.config.StartupRetryMax}
+	for attempt := 1; ; attempt++ {
+		lookup, cancel := context.WithTimeout(ctx, 30*time.Second)
+		port, err := s.discovery.DCMasterPort(lookup)
+		cancel()
+		if err == nil && port == "" {
+			err = errors.New("empty port")
+		}
+		if err == nil {
+			return port, nil
+		}
+		if attempts >= 0 && attempt >= attempts {
+			return "", err
+		}
+		d := b.Duration()
+		s.Infof("Failed to get DCMasterPort from %s (attempt %d): %v, retrying in %s", s.discovery, attempt, err, d)
+		select {
+		case <-time.After(d):
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+}
+
+// watchDCMasterPort first recovers a degraded server, then
+// polls for changes to the dcmaster port, moving the pooled
+// connections over without dropping any proxies
+func (s *Server) watchDCMasterPort(ctx context.Context, interval time.Duration) {
+	if s.dcmaster.Port() == "" {
+		port, err := s.lookupDCMasterPort(ctx, -1)
+		if err != nil {
+			return
+		}
+		s.dcmaster.SetPort(port)
+		s.Infof("Got dcmaster port: %v (%s), leaving degraded mode", port, s.discovery)
+	}
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		port, err := s.lookupDCMasterPort(ctx, 1)
+		if err != nil {
+			s.Debugf("Failed to poll DCMasterPort from %s: %v", s.discovery, err)
+			continue
+		}
+		if prev := s.dcmaster.Port(); port != prev {
+			s.Infof("dcmaster port changed from %s to %s (%s)", prev, port, s.discovery)
+			s.dcmaster.SetPort(port)
+			s.jobs.reset()
+		}
+	}
+}
+
+// lookupDCMasterEndpoints asks the catalog for dcmaster endpoints,
+// retrying with exponential backoff like lookupDCMasterPort
+func (s *Server) lookupDCMasterEndpoints(ctx context.Context, attempts int) ([]string, error) {
+	b := &backoff.Backoff{Min: time.Second, Max: s.config.StartupRetryMax}
+	for attempt := 1; ; attempt++ {
+		lookup, cancel := context.WithTimeout(ctx, 30*time.Second)
+		endpoints, err := s.catalog.Watch(lookup, nil)
+		cancel()
+		if err == nil && len(endpoints) == 0 {
+			err = errors.New("no healthy endpoints")
+		}
+		if err == nil {
+			return endpoints, nil
+		}
+		if attempts >= 0 && attempt >= attempts {
+			return nil, err
+		}
+		d := b.Duration()
+		s.Infof("Failed to get dcmaster endpoints from %s (attempt %d): %v, retrying in %s", s.catalog, attempt, err, d)
+		select {
+		case <-time.After(d):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// watchDCMasterCatalog follows changes to the dcmaster endpoints,
+// an empty catalog keeps the last endpoints
+func (s *Server) watchDCMasterCatalog(ctx context.Context) {
+	b := &backoff.Backoff{Min: time.Second, Max: time.Minute}
+	last := s.config.DCMasterEndpoints
+	for {
+		endpoints, err := s.catalog.Watch(ctx, last)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			d := b.Duration()
+			s.Debugf("Failed to watch %s: %v, retrying in %s", s.catalog, err, d)
+			select {
+			case <-time.After(d):
+			case <-ctx.Done():
+				return
+			}
+			continue
+		}
+		b.Reset()
+		last = endpoints
+		if len(endpoints) == 0 {
+			s.Infof("No healthy dcmaster endpoints in %s, keeping the current ones", s.catalog)
+			continue
+		}
+		s.Infof("dcmaster endpoints changed to %s (%s)", strings.Join(endpoints, ", "), s.catalog)
+		s.dcmaster.SetEndpoints(endpoints)
+	}
+}
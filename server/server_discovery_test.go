@@ -0,0 +1,99 @@
+package chserver
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jpillora/chisel/share/cio"
+)
+
+type failingDiscoverer struct{ calls int }
+
+func (d *failingDiscoverer) DCMasterPort(ctx context.Context) (string, error) {
+	d.calls++
+	return "", errors.New("unavailable")
+}
+
+func (d *failingDiscoverer) String() string { return "failing" }
+
+type failingCatalog struct{ calls int }
+
+func (c *failingCatalog) Watch(ctx context.Context, last []string) ([]string, error) {
+	c.calls++
+	return nil, nil
+}
+
+func (c *failingCatalog) String() string { return "failing" }
+
+func TestLookupDCMasterPortRetries(t *testing.T) {
+	d := &failingDiscoverer{}
+	s := &Server{
+		Logger:    cio.NewLogger("server"),
+		config:    &Config{StartupRetryMax: time.Millisecond},
+		discovery: d,
+	}
+	if _, err := s.lookupDCMasterPort(context.Background(), 3); err == nil || d.calls != 3 {
+		t.Fatalf("expected 3 failed attempts, got %d %v", d.calls, err)
+	}
+	//negative attempts retry until cancelled
+	d.calls = 0
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := s.lookupDCMasterPort(ctx, -1); err != context.DeadlineExceeded || d.calls < 2 {
+		t.Fatalf("expected retries until cancelled, got %d %v", d.calls, err)
+	}
+	//an unset number of retries is bounded
+	done := make(chan error, 1)
+	go func() {
+		_, err := NewServer(&Config{Discovery: "env", StartupRetryMax: time.Millisecond})
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("expected the server to fail without a dcmaster port")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the startup retries to be bounded")
+	}
+}
+
+func TestLookupDCMasterEndpointsRetries(t *testing.T) {
+	c := &failingCatalog{}
+	s := &Server{
+		Logger:  cio.NewLogger("server"),
+		config:  &Config{StartupRetryMax: time.Millisecond},
+		catalog: c,
+	}
+	if _, err := s.lookupDCMasterEndpoints(context.Background(), 3); err == nil || c.calls != 3 {
+		t.Fatalf("expected 3 failed attempts, got %d %v", c.calls, err)
+	}
+	//zero attempts no longer retry forever
+	c.calls = 0
+	if _, err := s.lookupDCMasterEndpoints(context.Background(), 0); err == nil || c.calls != 1 {
+		t.Fatalf("expected a single attempt, got %d %v", c.calls, err)
+	}
+}
+
+func TestDCMasterPortEnv(t *testing.T) {
+	os.Setenv("CHISEL_DCMASTER_PORT", "20000")
+	defer os.Unsetenv("CHISEL_DCMASTER_PORT")
+	s, err := NewServer(&Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.config.DCMasterPort != "20000" || s.discovery.String() != "static:20000" {
+		t.Fatalf("expected the env's port to bypass discovery, got %s (%s)", s.config.DCMasterPort, s.discovery)
+	}
+	//the flag wins over the env
+	if s, err = NewServer(&Config{DCMasterPort: "20001"}); err != nil || s.config.DCMasterPort != "20001" {
+		t.Fatalf("expected the given port, got %v", err)
+	}
+	os.Setenv("CHISEL_DCMASTER_PORT", "http")
+	if _, err := NewServer(&Config{}); err == nil {
+		t.Fatal("expected an invalid DCMASTER_PORT to fail")
+	}
+}
@@ -0,0 +1,53 @@
+package chserver
+
+import (
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jpillora/sizestr"
+)
+
+// DumpState logs a snapshot of the sessions, dynamic proxies,
+// goroutines and memory, for when the admin API is unreachable
+func (s *Server) DumpState() {
+	mem := runtime.MemStats{}
+	runtime.ReadMemStats(&mem)
+	sessions := s.tunnels.list()
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].id < sessions[j].id })
+	s.Infof("State dump: %d sessions, %d dynamic proxies, %d goroutines",
+		len(sessions), s.dynamicReverseProxies.Len(), runtime.NumGoroutine())
+	lastGC := "never"
+	if mem.LastGC > 0 {
+		lastGC = time.Since(time.Unix(0, int64(mem.LastGC))).Round(time.Second).String() + " ago"
+	}
+	s.Infof("Memory: alloc %s, heap in use %s, sys %s, %d heap objects, %d GCs (last %s)",
+		sizestr.ToString(int64(mem.Alloc)), sizestr.ToString(int64(mem.HeapInuse)), sizestr.ToString(int64(mem.Sys)),
+		mem.HeapObjects, mem.NumGC, lastGC)
+	for _, sess := range sessions {
+		a := newAPISession(sess)
+		s.Infof("  session#%d %s from %s, up %s, idle %s, %d channels, in %s out %s, remotes [%s]",
+			a.ID, a.User, a.RemoteAddr, time.Since(a.StartedAt).Round(time.Second),
+			time.Since(a.LastSeen).Round(time.Second), a.OpenChannels,
+			sizestr.ToString(a.BytesReceived), sizestr.ToString(a.BytesSent), strings.Join(a.Remotes, " "))
+	}
+	proxies := []apiProxy{}
+	s.dynamicReverseProxies.Range(func(pId string, p *DynamicReverseProxy) bool {
+		proxies = append(proxies, s.newAPIProxy(pId, p))
+		return true
+	})
+	sort.Slice(proxies, func(i, j int) bool { return proxies[i].ID < proxies[j].ID })
+	for _, p := range proxies {
+		last := "never"
+		if p.LastSeen != nil {
+			last = time.Since(*p.LastSeen).Round(time.Second).String() + " ago"
+		}
+		source := p.Source
+		if source == "" {
+			source = "http"
+		}
+		s.Infof("  proxy %s => %s (from %s, user %d, job %d, last request %s)",
+			p.ID, p.Target, source, p.UserID, p.JobID, last)
+	}
+}
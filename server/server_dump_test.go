@@ -0,0 +1,40 @@
+package chserver
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jpillora/chisel/share/cio"
+)
+
+func TestDumpState(t *testing.T) {
+	var buf bytes.Buffer
+	cio.SetOutput(&buf)
+	defer cio.SetOutput(os.Stderr)
+	s := &Server{
+		Logger:                cio.NewLogger("server"),
+		config:                &Config{},
+		dynamicReverseProxies: NewProxyStore(),
+		tunnels:               newSessionStore(),
+	}
+	s.Info = true
+	s.tunnels.add(&session{id: 3, user: "alice", remoteAddr: "10.0.0.9:5000", startedAt: time.Now(), remotes: []string{"R:8080=>80"}})
+	s.dynamicReverseProxies.Add("docs", &DynamicReverseProxy{Id: "docs", Target: "http://10.0.0.5:8080", Source: "file:p.yaml"})
+	s.DumpState()
+	out := buf.String()
+	for _, want := range []string{
+		"State dump: 1 sessions, 1 dynamic proxies",
+		"Memory: alloc",
+		"session#3 alice from 10.0.0.9:5000",
+		"remotes [R:8080=>80]",
+		"proxy docs => http://10.0.0.5:8080 (from file:p.yaml",
+		"last request never",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("expected %q in:\n%s", want, out)
+		}
+	}
+}
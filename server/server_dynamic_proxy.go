@@ -1,6 +1,9 @@
 package chserver
 
 import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/json"
 	"errors"
 	"hash/crc64"
@@ -11,14 +14,14 @@ import (
 	//"log"
 	"net"
 	"net/http"
-	"net/http/httputil"
 	"net/url"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/jpillora/chisel/dcrpc"
 	"github.com/jpillora/chisel/share/craveauth"
-	"google.golang.org/grpc"
 )
 
 var AMS_COOKIE_NAME = "_acp_at"
@@ -48,7 +51,7 @@ func (s *Server) getAuthorizationCookie(r *http.Request) (authKey []byte, err er
 	if err != nil {
 		authKey = []byte(r.Header.Get("Authorization"))
 		if len(authKey) == 0 {
-			s.Infof("Cookie err: %v, no authorization token in header.", err)
+			s.proxyLog().Infof("Cookie err: %v, no authorization token in header.", err)
 			return authKey, s.Errorf("No authorization token in header or cookie.")
 		} else {
 			err = nil
@@ -58,33 +61,42 @@ func (s *Server) getAuthorizationCookie(r *http.Request) (authKey []byte, err er
 }
 
 func (s *Server) disconnectResourceDcMaster(drProxy *DynamicReverseProxy) {
-	drProxy.GrpcConn.Close()
+	if drProxy.DcMaster != nil {
+		drProxy.DcMaster.Release()
+	}
 }
 
 // Authorize user to the target, ideally sets the connection.
-func (s *Server) checkResourceAvailableDcMaster(drProxy *DynamicReverseProxy, pId string, createNew bool) (err error) {
-	var client dcrpc.DcMasterRPCClient
+// The dcrpc call is bounded by ctx and the configured timeout.
+func (s *Server) checkResourceAvailableDcMaster(ctx context.Context, drProxy *DynamicReverseProxy, pId string, createNew bool) (err error) {
 	u, _ := url.Parse(drProxy.Target)
 	ip, _, _ := net.SplitHostPort(u.Host)
 
 	if createNew {
-		var conn *grpc.ClientConn
-		s.Infof("Connecting to resource host %s.", ip)
-		client, conn, err = craveauth.ConnectDCMasterRPC(ip, s.config.DCMasterPort, s.Logger)
+		// borrow a shared connection, the pool keeps it healthy
+		drProxy.DcMaster, err = s.dcmaster.Acquire(ip)
 		if err != nil {
 			return
 		}
-		drProxy.DcMasterClient = client
-		drProxy.GrpcConn = conn
 	} else {
 		// s.Infof("Checking availability of resource ip: %s, job: %v.", ip, drProxy.JobId)
-		err = craveauth.CheckForJob(drProxy.DcMasterClient, pId, drProxy.JobId)
+		if s.jobs.fresh(ip, drProxy.JobId) {
+			return
+		}
+		var client dcrpc.DcMasterRPCClient
+		client, err = drProxy.DcMaster.Client()
+		if err == nil {
+			ctx, cancel := s.dcrpcContext(ctx)
+			err = craveauth.CheckForJob(ctx, client, pId, drProxy.JobId)
+			cancel()
+		}
 		if err != nil {
 			err = s.Errorf("Resource unavailable. Error: %v", err)
-			s.Infof("%v", err)
+			s.proxyLog().Infof("%v", err)
 			return
 		}
-		s.Infof("Available resource ip: %s, job: %v.", ip, drProxy.JobId)
+		s.proxyLog().With("job_id", drProxy.JobId).Infof("Available resource ip: %s, job: %v.", ip, drProxy.JobId)
+		s.jobs.store(ip, drProxy.JobId)
 	}
 	return
 }
@@ -103,17 +115,17 @@ func (s *Server) checkResourceAccessDcMaster(drProxy *DynamicReverseProxy) (err
 		var jobId int64
 
 		// s.Infof("Checking access to resource %s:%s for user: %v", rHost, rPort, drProxy.User)
-		jobId, allowed, err = craveauth.CheckTargetUser(rHost, rPort, fmt.Sprint(drProxy.User), s.Logger)
+		jobId, allowed, err = craveauth.CheckTargetUser(s.db, rHost, rPort, fmt.Sprint(drProxy.User), s.Logger)
 		if !allowed {
-			s.Infof("Access to resource %s:%s for user: %v denied.", rHost, rPort, drProxy.User)
+			s.proxyLog().Infof("Access to resource %s:%s for user: %v denied.", rHost, rPort, drProxy.User)
 			err = errors.New("Access to requested resource denied.")
 			return
 		}
 		if err != nil {
-			s.Infof("Access to resource %s:%s for user: %v denied. Error: %v", rHost, rPort, drProxy.User, err)
+			s.proxyLog().Infof("Access to resource %s:%s for user: %v denied. Error: %v", rHost, rPort, drProxy.User, err)
 			return
 		}
-		s.Infof("Granted access to resource %s:%s for user: %v, job: %v", rHost, rPort, drProxy.User, jobId)
+		s.proxyLog().With("job_id", jobId).With("user", drProxy.User).Infof("Granted access to resource %s:%s for user: %v, job: %v", rHost, rPort, drProxy.User, jobId)
 		drProxy.JobId = jobId
 	}
 	return
@@ -126,21 +138,21 @@ func (s *Server) authRequest(r *http.Request, useCache bool, drProxy *DynamicRev
 	subdomain := os.Getenv("SUBDOMAIN")
 	domain := os.Getenv("DOMAIN")
 	if len(subdomain) == 0 || len(domain) == 0 {
-		s.Infof("could not get SUBDOMAIN")
+		s.proxyLog().Infof("could not get SUBDOMAIN")
 		err = errors.New("Could not create service fqdn.")
 		return
 	}
 
 	authKey, err := s.getAuthorizationCookie(r)
 	if err != nil {
-		s.Infof("Authkey error: %v", err)
+		s.proxyLog().Infof("Authkey error: %v", err)
 		return
 	}
 
 	// if useCache, match cookie, else validate
 	// false for register and unregister, so a reverse proxy should exist.
 	if useCache {
-		if string(authKey) == string(drProxy.AuthKey) {
+		if drProxy.hasAuthKey(authKey) {
 			return
 		}
 	}
@@ -150,17 +162,92 @@ func (s *Server) authRequest(r *http.Request, useCache bool, drProxy *DynamicRev
 	userId, err = craveauth.ValidateSignedInUser(authKey, r.Header.Get("User-Agent"),
 		fmt.Sprintf("%s.%s", subdomain, domain), s.Logger)
 	if err != nil {
-		s.Infof("User access denied. Error: %v", err)
+		s.proxyLog().Infof("User access denied. Error: %v", err)
 		return
 	}
 	drProxy.User = userId
 	drProxy.AuthKey = authKey
+	drProxy.authKeyHash = nil
 
 	return checkResourceAccess(drProxy)
 }
 
+// Access policies for requests to a dynamic proxy. A request carrying the
+// proxy's AuthKey is always allowed, the policy decides what other tokens
+// are accepted.
+const (
+	// ProxyAccessAuthKey only accepts the AuthKey used to register the proxy
+	ProxyAccessAuthKey = "authkey"
+	// ProxyAccessUser accepts any valid token of the registering user
+	ProxyAccessUser = "user"
+	// ProxyAccessJob accepts any valid token of a user with access to the job
+	ProxyAccessJob = "job"
+)
+
+// hashAuthKey is the hash of an AuthKey kept in the saved state
+func hashAuthKey(authKey []byte) []byte {
+	h := sha256.Sum256(authKey)
+	return h[:]
+}
+
+// hasAuthKey reports whether authKey is the proxy's AuthKey,
+// restored proxies only have its hash
+func (p *DynamicReverseProxy) hasAuthKey(authKey []byte) bool {
+	if p.authKeyHash != nil {
+		return subtle.ConstantTimeCompare(hashAuthKey(authKey), p.authKeyHash) == 1
+	}
+	return subtle.ConstantTimeCompare(authKey, p.AuthKey) == 1
+}
+
+// savedAuthKey is the hash of the AuthKey to save
+func (p *DynamicReverseProxy) savedAuthKey() []byte {
+	if p.authKeyHash != nil || len(p.AuthKey) == 0 {
+		return p.authKeyHash
+	}
+	return hashAuthKey(p.AuthKey)
+}
+
+// authorizeProxyRequest checks the request token against the proxy's
+// access policy. The proxy itself is never modified.
+func (s *Server) authorizeProxyRequest(r *http.Request, drProxy *DynamicReverseProxy) error {
+	if drProxy.Access == ProxyAccessPublic {
+		return nil
+	}
+	authKey, err := s.getAuthorizationCookie(r)
+	if err != nil {
+		return err
+	}
+	if drProxy.hasAuthKey(authKey) {
+		return nil
+	}
+	switch drProxy.Access {
+	case ProxyAccessUser, ProxyAccessJob:
+	default:
+		return s.Errorf("Invalid authorization token for proxy.")
+	}
+	// validate against a copy, so the registered identity is kept
+	requester := *drProxy
+	err = s.authRequest(r, false, &requester, s.checkResourceAccessDcMaster)
+	if err != nil {
+		return err
+	}
+	if drProxy.Access == ProxyAccessUser && requester.User != drProxy.User {
+		s.proxyLog().Infof("User %v denied access to proxy of user %v", requester.User, drProxy.User)
+		return errors.New("Access to requested resource denied.")
+	}
+	if requester.JobId != drProxy.JobId {
+		s.proxyLog().Infof("User %v denied access to job %v, has job %v", requester.User, drProxy.JobId, requester.JobId)
+		return errors.New("Access to requested resource denied.")
+	}
+	return nil
+}
+
 // handleDynamicProxy is the main http websocket handler for the chisel server
 func (s *Server) handleDynamicProxy(w http.ResponseWriter, r *http.Request) (handled bool) {
+	// subdomain routed requests never reach the register endpoints
+	if pId, ok := s.proxyIDFromHost(r.Host); ok {
+		return s.serveDynamicProxy(w, withHostRouted(r), pId)
+	}
 	var pathPrefix string
 	// res, _ := httputil.DumpRequest(r, true)
 	if strings.HasPrefix(r.URL.Path, "/") {
@@ -170,7 +257,7 @@ func (s *Server) handleDynamicProxy(w http.ResponseWriter, r *http.Request) (han
 		}
 	}
 
-	s.Infof("Got a dynamic proxy path %v", pathPrefix)
+	s.proxyLog().Infof("Got a dynamic proxy path %v", pathPrefix)
 	switch pathPrefix {
 	case REGISTER_ENDPOINT:
 		s.createDynamicProxy(w, r)
@@ -188,10 +275,60 @@ type ProxyData struct {
 	Target        string `json:"target"`
 	ServicePrefix string `json:"serviceprefix"`
 	ProxyType     string `json:"proxytype"`
+	Access        string `json:"access"`
+	AccessLog     bool   `json:"accesslog"`
+	Subdomain     string `json:"subdomain,omitempty"`
+	// FlushInterval is a duration between flushes of the response
+	// to the client, "immediate" (or negative) flushes after every write
+	FlushInterval string `json:"flushinterval,omitempty"`
+	// optional limits, overriding the server defaults
+	DialTimeout           string `json:"dialtimeout,omitempty"`
+	ResponseHeaderTimeout string `json:"responseheadertimeout,omitempty"`
+	Timeout               string `json:"timeout,omitempty"`
+	MaxBodySize           int64  `json:"maxbodysize,omitempty"`
+	// optional middleware configuration
+	Cache *ProxyCacheConfig `json:"cache,omitempty"`
+	// TLS configures connections to https targets
+	TLS *ProxyTLSConfig `json:"tls,omitempty"`
+}
+
+// parseProxyOptions validates the optional proxy settings
+// of pd and applies them to drProxy
+func (s *Server) parseProxyOptions(pd *ProxyData, drProxy *DynamicReverseProxy) (err error) {
+	drProxy.Limits, err = parseProxyLimits(pd, s.config.Limits)
+	if err != nil {
+		return err
+	}
+	if pd.TLS != nil {
+		//only the proxies file may refer to local files
+		drProxy.TLS, err = pd.TLS.load(strings.HasPrefix(drProxy.Source, "file:"))
+		if err != nil {
+			return err
+		}
+		if pd.TLS.SkipVerify {
+			s.proxyLog().Infof("TLS verification disabled for target %s", pd.Target)
+		}
+	}
+	switch pd.FlushInterval {
+	case "":
+	case "immediate":
+		drProxy.FlushInterval = -1
+	default:
+		d, err := time.ParseDuration(pd.FlushInterval)
+		if err != nil {
+			return fmt.Errorf("Invalid flush interval (%s)", pd.FlushInterval)
+		}
+		if d < 0 {
+			d = -1
+		}
+		drProxy.FlushInterval = d
+	}
+	return nil
 }
 
 type ProxyRegisterResponse struct {
-	Id string `json:"id"`
+	Id   string `json:"id"`
+	Host string `json:"host,omitempty"`
 }
 
 func (s *Server) getProxyData(w http.ResponseWriter, r *http.Request, pd *ProxyData) (err error) {
@@ -214,7 +351,7 @@ func (s *Server) getProxyHashFromTarget(input string) string {
 
 func (s *Server) getServiceFQDN(drProxy *DynamicReverseProxy, hash string) (fqdn string, err error) {
 	fqdn = fmt.Sprintf("%v.%v.%v", hash, drProxy.JobId, "svc")
-	s.Infof("Generating fqdn prefix: v", fqdn)
+	s.proxyLog().Infof("Generating fqdn prefix: v", fqdn)
 	return
 }
 
@@ -255,57 +392,86 @@ func (s *Server) createDynamicProxy(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	switch pd.Access {
+	case "":
+		pd.Access = ProxyAccessAuthKey
+	case ProxyAccessAuthKey, ProxyAccessUser, ProxyAccessJob:
+	default:
+		http.Error(w, s.Errorf("Unknown access policy (%s)", pd.Access).Error(), http.StatusBadRequest)
+		return
+	}
+	pd.Subdomain = strings.ToLower(pd.Subdomain)
+	if pd.Subdomain != "" && (s.config.ProxyDomain == "" || !subdomainLabel.MatchString(pd.Subdomain)) {
+		http.Error(w, s.Errorf("Invalid subdomain (%s)", pd.Subdomain).Error(), http.StatusBadRequest)
+		return
+	}
+	if !s.dcmaster.Available() {
+		http.Error(w, s.Errorf("Dynamic proxies unavailable, server is degraded").Error(), http.StatusServiceUnavailable)
+		return
+	}
+	proxyType, ok := getProxyType(pd.ProxyType)
+	if !ok {
+		http.Error(w, s.Errorf("Unknown proxy type (%s), expected one of %s",
+			pd.ProxyType, strings.Join(ProxyTypes(), ", ")).Error(), http.StatusBadRequest)
+		return
+	}
+
 	drProxy.Target = pd.Target
 	drProxy.ServicePrefix = pd.ServicePrefix
 	drProxy.ProxyType = pd.ProxyType
-	s.Infof("Creating reverse proxy for target: %v:%v:%v", pd.ServicePrefix, pd.ProxyType, pd.Target)
+	drProxy.Access = pd.Access
+	drProxy.AccessLog = pd.AccessLog
+	drProxy.Subdomain = pd.Subdomain
+	if err := s.parseProxyOptions(&pd, &drProxy); err != nil {
+		http.Error(w, s.Errorf("%s", err).Error(), http.StatusBadRequest)
+		return
+	}
+	s.proxyLog().Infof("Creating reverse proxy for target: %v:%v:%v", pd.ServicePrefix, pd.ProxyType, pd.Target)
 	err = s.authRequest(r, false, &drProxy, s.checkResourceAccessDcMaster)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusUnauthorized)
 		return
 	}
 	pId := s.getProxyHashFromTarget(pd.Target)
-	err = s.checkResourceAvailableDcMaster(&drProxy, pId, true)
+	if existing, ok := s.dynamicReverseProxies.Get(pId); ok && existing.Source != "" {
+		http.Error(w, s.Errorf("Proxy (%s) is managed by %s", pId, existing.Source).Error(), http.StatusConflict)
+		return
+	}
+	if err := s.checkProxyHost(pId, pd.Subdomain); err != nil {
+		http.Error(w, s.Errorf("%s", err).Error(), http.StatusConflict)
+		return
+	}
+	err = s.checkResourceAvailableDcMaster(r.Context(), &drProxy, pId, true)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
 	}
-	reverseProxy := httputil.NewSingleHostReverseProxy(u)
-
-	//always use proxy host
-	reverseProxy.Director = func(r *http.Request) {
-		r.URL.Scheme = u.Scheme
-		r.URL.Host = u.Host
-		r.Host = u.Host
-		r.Header.Set("X-Forwarded-Host", r.Header.Get("Host"))
-		r.Header.Set("Origin", u.Scheme+"://"+u.Host)
-
-		if r.Header.Get("X-Subservice-Type") == "true" {
-			pId, err = s.getProxyIDFromFQDN(r.Host)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusBadRequest)
-			}
-			return
-		} else {
-			// legacy
-			path := r.URL.Path
-			path = strings.TrimPrefix(path, "/")
-			pathParts := strings.SplitN(path, "/", 2)
-			pId = pathParts[0]
-			// s.Infof("Setting path : %v %v", path, pathParts)
-			if len(pathParts) >= 2 && pathParts[1] != "" {
-				path = "/" + pathParts[1]
-			} else {
-				path = "/"
-			}
-			// s.Infof("Setting path : %v", path)
-			r.URL.Path = path
+	drProxy.Id = pId
+	drProxy.Created = time.Now()
+	drProxy.RegisteredBy = userActor(r, drProxy.User)
+	drProxy.drain = newProxyDrain()
+	drProxy.data = &pd
+	drProxy.Handler, err = s.newDynamicProxyHandler(proxyType, &pd, &drProxy, u)
+	if err != nil {
+		s.disconnectResourceDcMaster(&drProxy)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if drProxy.Subdomain != "" && !s.proxyHosts.claim(drProxy.Subdomain, pId) {
+		s.disconnectResourceDcMaster(&drProxy)
+		http.Error(w, s.Errorf("Subdomain (%s) already in use", drProxy.Subdomain).Error(), http.StatusConflict)
+		return
+	}
+	if prev := s.dynamicReverseProxies.Add(pId, &drProxy); prev != nil {
+		if prev.Subdomain != drProxy.Subdomain {
+			s.proxyHosts.release(prev.Subdomain, pId)
 		}
-
-		s.Infof("Redirecting request to %s at %s\n", r.URL, time.Now().UTC())
+		s.events.proxyRemoved(prev)
+		s.disconnectResourceDcMaster(prev)
 	}
-	drProxy.Handler = reverseProxy
-	s.dynamicReverseProxies[pId] = &drProxy
-	s.Infof("Registering for pid: %v", pId)
+	s.events.proxyAdded(&drProxy)
+	s.notifyProxy(eventProxyRegistered, pId, &drProxy, drProxy.RegisteredBy)
+	s.proxyLog().Infof("Registering for pid: %v", pId)
 
 	w.Header().Set("Content-Type", "application/json")
 	if drProxy.ProxyType == "build" {
@@ -315,7 +481,7 @@ func (s *Server) createDynamicProxy(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	}
-	prr := ProxyRegisterResponse{Id: pId}
+	prr := ProxyRegisterResponse{Id: pId, Host: s.proxyHost(&drProxy)}
 	err = json.NewEncoder(w).Encode(prr)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("error building the response, %v", err), http.StatusInternalServerError)
@@ -334,15 +500,25 @@ func (s *Server) deleteDynamicProxy(w http.ResponseWriter, r *http.Request) {
 	}
 
 	pId := s.getProxyHashFromTarget(pd.Target)
-	if proxy, ok := s.dynamicReverseProxies[pId]; ok {
-		s.Infof("Deleting reverse proxy for %v", pId)
-		err = s.authRequest(r, false, proxy, s.checkResourceAccessNoop)
+	if proxy, ok := s.dynamicReverseProxies.Get(pId); ok {
+		if proxy.Source != "" {
+			http.Error(w, s.Errorf("Proxy (%s) is managed by %s", pId, proxy.Source).Error(), http.StatusConflict)
+			return
+		}
+		s.proxyLog().Infof("Deleting reverse proxy for %v", pId)
+		requester := *proxy
+		err = s.authRequest(r, false, &requester, func(requester *DynamicReverseProxy) error {
+			//its user, or one with access to its target as when registering
+			if requester.User == proxy.User {
+				return nil
+			}
+			return s.checkResourceAccessDcMaster(requester)
+		})
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusUnauthorized)
 			return
 		}
-		s.disconnectResourceDcMaster(proxy)
-		delete(s.dynamicReverseProxies, pId)
+		s.removeDynamicProxy(pId, userActor(r, requester.User))
 		if proxy.ProxyType == "build" {
 			pId, err = s.getServiceFQDN(proxy, pId)
 			if err != nil {
@@ -356,6 +532,20 @@ func (s *Server) deleteDynamicProxy(w http.ResponseWriter, r *http.Request) {
 	return
 }
 
+// removeDynamicProxy unregisters the proxy, releasing its subdomain
+// and dcmaster connection only if this call won the race to delete
+func (s *Server) removeDynamicProxy(pId string, by proxyActor) (*DynamicReverseProxy, bool) {
+	removed, ok := s.dynamicReverseProxies.Delete(pId)
+	if ok {
+		s.proxyHosts.release(removed.Subdomain, pId)
+		s.events.proxyRemoved(removed)
+		s.notifyProxy(eventProxyRemoved, pId, removed, by)
+		s.jobs.invalidate(removed.JobId)
+		s.disconnectResourceDcMaster(removed)
+	}
+	return removed, ok
+}
+
 // executeDynamicProxy is the main http websocket handler for the chisel server
 func (s *Server) executeDynamicProxy(w http.ResponseWriter, r *http.Request) bool {
 	var err error
@@ -382,20 +572,46 @@ func (s *Server) executeDynamicProxy(w http.ResponseWriter, r *http.Request) boo
 	// 	}
 	// }
 	// s.Infof("Got pid: %v", pId)
+	return s.serveDynamicProxy(w, r, pId)
+}
+
+// serveDynamicProxy authorizes and serves the request with the given proxy
+func (s *Server) serveDynamicProxy(w http.ResponseWriter, r *http.Request, pId string) bool {
 	//just serve the reverse proxy request.
-	if proxy, ok := s.dynamicReverseProxies[pId]; ok {
-		err := s.authRequest(r, true, proxy, s.checkResourceAccessDcMaster)
+	if proxy, ok := s.dynamicReverseProxies.Get(pId); ok {
+		setAccessLogProxy(r, pId)
+		setReportTag(r, "proxy", pId)
+		if proxy.JobId != 0 {
+			setReportTag(r, "job", strconv.FormatInt(proxy.JobId, 10))
+		}
+		err := s.authorizeProxyRequest(r, proxy)
 		if err != nil {
+			s.authFailed("proxy", pId, r.RemoteAddr, err)
 			http.Error(w, err.Error(), http.StatusUnauthorized)
 			return ok
 		}
-		err = s.checkResourceAvailableDcMaster(proxy, pId, false)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusUnauthorized)
+		// proxies file targets are not dcmaster jobs, and jobs
+		// pushed over dcmaster events need no per-request check
+		if proxy.DcMaster != nil && !s.events.connected(proxy.DcMaster.IP()) {
+			err = s.checkResourceAvailableDcMaster(r.Context(), proxy, pId, false)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return ok
+			}
+		}
+		if !proxy.drain.enter() {
+			http.Error(w, "Proxy is shutting down", http.StatusServiceUnavailable)
 			return ok
 		}
-		proxy.Handler.ServeHTTP(w, r)
+		defer proxy.drain.leave()
+		atomic.StoreInt64(&proxy.lastSeen, time.Now().UnixNano())
+		rec := &statusRecorder{ResponseWriter: w}
+		proxy.drain.serve(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			s.serveDebug(proxy, proxy.Handler, w, r)
+		}), rec, r)
+		s.metrics.proxyRequest(rec.Status())
 		return ok
 	}
-	return false
+	//registered on another server of the cluster?
+	return s.cluster.forward(w, r, "proxy:"+pId)
 }
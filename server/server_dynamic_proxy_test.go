@@ -0,0 +1,104 @@
+package chserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/jpillora/chisel/share/cio"
+)
+
+// withUserAPI serves the crave user API, whose
+// tokens are "<user id>-<anything>"
+func withUserAPI(t *testing.T) func() {
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get("Authorization")
+		if i := strings.Index(token, "-"); i > 0 {
+			w.Write([]byte(`{"success": true, "data": {"userId": ` + token[:i] + `}}`))
+			return
+		}
+		w.Write([]byte(`{"success": false}`))
+	}))
+	env := map[string]string{"API_URL": api.URL, "SUBDOMAIN": "chisel", "DOMAIN": "example.com"}
+	for k, v := range env {
+		os.Setenv(k, v)
+	}
+	return func() {
+		for k := range env {
+			os.Unsetenv(k)
+		}
+		api.Close()
+	}
+}
+
+func proxyRequest(token string) *http.Request {
+	r := httptest.NewRequest("GET", "http://docs.example.com/", nil)
+	if token != "" {
+		r.AddCookie(&http.Cookie{Name: AMS_COOKIE_NAME, Value: token})
+	}
+	return r
+}
+
+func TestAuthorizeProxyRequest(t *testing.T) {
+	defer withUserAPI(t)()
+	s := &Server{Logger: cio.NewLogger("server"), config: &Config{}}
+	//a proxy of user 1, whose target is not checked with dcmaster
+	p := &DynamicReverseProxy{Id: "docs", Target: "http://docs", User: 1, AuthKey: []byte("1-key")}
+	for _, c := range []struct {
+		access, token string
+		allowed       bool
+	}{
+		{ProxyAccessPublic, "", true},
+		{ProxyAccessAuthKey, "", false},
+		{ProxyAccessAuthKey, "1-key", true},
+		{ProxyAccessAuthKey, "1-other", false},
+		{ProxyAccessUser, "1-other", true},
+		{ProxyAccessUser, "2-other", false},
+		{ProxyAccessUser, "invalid", false},
+		{ProxyAccessJob, "2-other", true},
+	} {
+		p.Access = c.access
+		err := s.authorizeProxyRequest(proxyRequest(c.token), p)
+		if (err == nil) != c.allowed {
+			t.Fatalf("expected %s access with %q allowed=%v, got %v", c.access, c.token, c.allowed, err)
+		}
+	}
+	if p.User != 1 || string(p.AuthKey) != "1-key" {
+		t.Fatalf("expected the proxy to be unchanged, got %+v", p)
+	}
+}
+
+func TestDeleteDynamicProxy(t *testing.T) {
+	defer withUserAPI(t)()
+	s := &Server{
+		Logger:                cio.NewLogger("server"),
+		config:                &Config{},
+		dynamicReverseProxies: NewProxyStore(),
+		proxyHosts:            newProxyHosts(),
+	}
+	target := "http://10.0.0.1:8080"
+	pId := s.getProxyHashFromTarget(target)
+	s.dynamicReverseProxies.Add(pId, &DynamicReverseProxy{Id: pId, Target: target, User: 1, AuthKey: []byte("1-key")})
+	unregister := func(token string) int {
+		r := httptest.NewRequest("POST", "/unregister", strings.NewReader(`{"target": "`+target+`"}`))
+		r.AddCookie(&http.Cookie{Name: AMS_COOKIE_NAME, Value: token})
+		w := httptest.NewRecorder()
+		s.deleteDynamicProxy(w, r)
+		return w.Code
+	}
+	//users without access to the target can't delete it
+	if code := unregister("2-key"); code != http.StatusUnauthorized {
+		t.Fatalf("expected another user to be refused, got %d", code)
+	}
+	if _, ok := s.dynamicReverseProxies.Get(pId); !ok {
+		t.Fatal("expected the proxy to be kept")
+	}
+	if code := unregister("1-other"); code != http.StatusOK {
+		t.Fatalf("expected its user to delete it, got %d", code)
+	}
+	if _, ok := s.dynamicReverseProxies.Get(pId); ok {
+		t.Fatal("expected the proxy to be deleted")
+	}
+}
@@ -0,0 +1,240 @@
+package chserver
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/jpillora/backoff"
+	"github.com/jpillora/chisel/share/craveauth"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// dcmasterEvents keeps one events stream open per resource host,
+// pushing proxy changes to dcmaster and tearing down the proxies
+// of jobs dcmaster reports as finished. Hosts whose dcmaster does
+// not implement the stream fall back to per-request checks.
+type dcmasterEvents struct {
+	s           *Server
+	ctx         context.Context
+	mut         sync.Mutex
+	streams     map[string]*eventStream
+	unsupported map[string]bool
+}
+
+type eventStream struct {
+	ip     string
+	events *craveauth.EventStream
+	cancel context.CancelFunc
+	//sendMut serializes Send, as required by grpc
+	sendMut sync.Mutex
+	//ready is set once dcmaster has acknowledged the stream
+	ready bool
+}
+
+func newDCMasterEvents(s *Server) *dcmasterEvents {
+	return &dcmasterEvents{
+		s:           s,
+		streams:     map[string]*eventStream{},
+		unsupported: map[string]bool{},
+	}
+}
+
+// start enables the streams until ctx is cancelled,
+// events before start are not sent
+func (e *dcmasterEvents) start(ctx context.Context) {
+	e.mut.Lock()
+	e.ctx = ctx
+	e.mut.Unlock()
+}
+
+// connected reports whether dcmaster is pushing job
+// state for the host, so per-request checks can be skipped
+func (e *dcmasterEvents) connected(ip string) bool {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	st, ok := e.streams[ip]
+	return ok && st.ready
+}
+
+// proxyAdded announces a registered proxy
+func (e *dcmasterEvents) proxyAdded(p *DynamicReverseProxy) {
+	st, opened := e.stream(p)
+	//a newly opened stream has already announced all proxies
+	if st != nil && !opened {
+		e.send(st, proxyEvent(craveauth.EventProxyAdded, p))
+	}
+}
+
+// proxyRemoved announces an unregistered proxy
+func (e *dcmasterEvents) proxyRemoved(p *DynamicReverseProxy) {
+	if p.DcMaster == nil {
+		return
+	}
+	e.mut.Lock()
+	st := e.streams[p.DcMaster.IP()]
+	e.mut.Unlock()
+	if st != nil {
+		e.send(st, proxyEvent(craveauth.EventProxyRemoved, p))
+	}
+}
+
+func proxyEvent(typ string, p *DynamicReverseProxy) craveauth.Event {
+	return craveauth.Event{
+		Type:    typ,
+		ProxyId: p.Id,
+		JobId:   p.JobId,
+		UserId:  p.User,
+		Target:  p.Target,
+	}
+}
+
+// stream returns the open stream to the proxy's host,
+// opening it if needed, nil when unavailable
+func (e *dcmasterEvents) stream(p *DynamicReverseProxy) (*eventStream, bool) {
+	if p.DcMaster == nil {
+		return nil, false
+	}
+	ip := p.DcMaster.IP()
+	e.mut.Lock()
+	if st, ok := e.streams[ip]; ok || e.ctx == nil || e.unsupported[ip] {
+		e.mut.Unlock()
+		return st, false
+	}
+	ctx := e.ctx
+	e.mut.Unlock()
+	st, err := e.open(ctx, p.DcMaster)
+	if err != nil {
+		e.s.Debugf("Failed to open dcmaster events to %s: %s", ip, err)
+		return nil, false
+	}
+	e.mut.Lock()
+	if prev, ok := e.streams[ip]; ok {
+		//lost the race to open
+		e.mut.Unlock()
+		st.cancel()
+		return prev, false
+	}
+	e.streams[ip] = st
+	e.mut.Unlock()
+	e.sync(st)
+	go e.receive(ctx, st, p.DcMaster)
+	return st, true
+}
+
+func (e *dcmasterEvents) open(ctx context.Context, lease *craveauth.DCMasterLease) (*eventStream, error) {
+	conn, err := lease.Conn()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	events, err := craveauth.OpenEventStream(ctx, conn)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return &eventStream{ip: lease.IP(), events: events, cancel: cancel}, nil
+}
+
+// sync announces every proxy on the stream's host
+func (e *dcmasterEvents) sync(st *eventStream) {
+	e.s.dynamicReverseProxies.Range(func(pId string, p *DynamicReverseProxy) bool {
+		if p.DcMaster != nil && p.DcMaster.IP() == st.ip {
+			e.send(st, proxyEvent(craveauth.EventProxyAdded, p))
+		}
+		return true
+	})
+}
+
+func (e *dcmasterEvents) send(st *eventStream, ev craveauth.Event) {
+	st.sendMut.Lock()
+	err := st.events.Send(ev)
+	st.sendMut.Unlock()
+	if err != nil {
+		//the receive loop sees the same error and reconnects
+		e.s.Debugf("Failed to send %s event to %s: %s", ev.Type, st.ip, err)
+	}
+}
+
+// receive handles events from dcmaster, reconnecting with
+// backoff while the host still has proxies
+func (e *dcmasterEvents) receive(ctx context.Context, st *eventStream, lease *craveauth.DCMasterLease) {
+	b := &backoff.Backoff{Min: time.Second, Max: time.Minute}
+	for {
+		err := e.receiveAll(ctx, st)
+		e.mut.Lock()
+		if e.streams[st.ip] == st {
+			delete(e.streams, st.ip)
+		}
+		e.mut.Unlock()
+		st.cancel()
+		if ctx.Err() != nil {
+			return
+		}
+		if status.Code(err) == codes.Unimplemented {
+			e.s.Infof("dcmaster on %s does not support events, checking jobs per request", st.ip)
+			e.mut.Lock()
+			e.unsupported[st.ip] = true
+			e.mut.Unlock()
+			return
+		}
+		e.s.Infof("dcmaster events from %s closed: %s", st.ip, err)
+		for {
+			select {
+			case <-time.After(b.Duration()):
+			case <-ctx.Done():
+				return
+			}
+			if !e.hasProxies(st.ip) {
+				return
+			}
+			next, err := e.open(ctx, lease)
+			if err != nil {
+				e.s.Debugf("Failed to reopen dcmaster events to %s: %s", st.ip, err)
+				continue
+			}
+			e.mut.Lock()
+			if _, ok := e.streams[st.ip]; ok {
+				//reopened by a new proxy in the meantime
+				e.mut.Unlock()
+				next.cancel()
+				return
+			}
+			e.streams[st.ip] = next
+			e.mut.Unlock()
+			st = next
+			e.sync(st)
+			break
+		}
+	}
+}
+
+func (e *dcmasterEvents) receiveAll(ctx context.Context, st *eventStream) error {
+	for {
+		ev, err := st.events.Recv()
+		if err != nil {
+			return err
+		}
+		switch ev.Type {
+		case craveauth.EventReady:
+			e.mut.Lock()
+			st.ready = true
+			e.mut.Unlock()
+			e.s.Infof("dcmaster on %s is pushing job events", st.ip)
+		case craveauth.EventJobFinished:
+			e.s.reapJob(ctx, ev.JobId, st.ip, "reported by dcmaster")
+		default:
+			e.s.Debugf("Unknown dcmaster event %q from %s", ev.Type, st.ip)
+		}
+	}
+}
+
+func (e *dcmasterEvents) hasProxies(ip string) bool {
+	found := false
+	e.s.dynamicReverseProxies.Range(func(pId string, p *DynamicReverseProxy) bool {
+		found = p.DcMaster != nil && p.DcMaster.IP() == ip
+		return !found
+	})
+	return found
+}
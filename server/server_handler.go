@@ -1,14 +1,20 @@
 package chserver
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync/atomic"
 	"time"
 
 	chshare "github.com/jpillora/chisel/share"
+	"github.com/jpillora/chisel/share/cio"
 	"github.com/jpillora/chisel/share/cnet"
 	"github.com/jpillora/chisel/share/craveauth"
+	"github.com/jpillora/chisel/share/ctrace"
 	"github.com/jpillora/chisel/share/settings"
 	"github.com/jpillora/chisel/share/tunnel"
 	"golang.org/x/crypto/ssh"
@@ -18,9 +24,8 @@ import (
 // handleClientHandler is the main http websocket handler for the chisel server
 func (s *Server) handleClientHandler(w http.ResponseWriter, r *http.Request) {
 	//websockets upgrade AND has chisel prefix
-	upgrade := strings.ToLower(r.Header.Get("Upgrade"))
 	protocol := r.Header.Get("Sec-WebSocket-Protocol")
-	if upgrade == "websocket" && (strings.HasPrefix(protocol, "chisel-") || strings.HasPrefix(protocol, "craveconnect-")) {
+	if isTunnelRequest(r) {
 		if protocol == chshare.ProtocolVersion || protocol == chshare.CraveProtocolVersion {
 			s.handleWebsocket(w, r)
 			s.Infof("Using client version %v", protocol)
@@ -30,9 +35,18 @@ func (s *Server) handleClientHandler(w http.ResponseWriter, r *http.Request) {
 		s.Infof("ignored client connection using protocol '%s', expected '%s'",
 			protocol, chshare.ProtocolVersion)
 	}
+	//the public remotes of sessions
+	if s.handlePublicHost(w, r) {
+		return
+	}
+	//the proxy target of the request's host
+	if p := s.vhosts.get(r.Host); p != nil {
+		s.compressed(p).ServeHTTP(w, r)
+		return
+	}
 	//proxy target was provided
 	if s.reverseProxy != nil {
-		s.reverseProxy.ServeHTTP(w, r)
+		s.compressed(s.reverseProxy).ServeHTTP(w, r)
 		return
 	}
 	if s.handleDynamicProxy(w, r) {
@@ -41,12 +55,18 @@ func (s *Server) handleClientHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	//no proxy defined, provide access to health/version checks
 	switch r.URL.String() {
-	case "/health":
-		w.Write([]byte("OK\n"))
+	case "/health", "/healthz":
+		s.handleHealthz(w, r)
+		return
+	case "/readyz":
+		s.handleReadyz(w, r)
 		return
 	case "/version":
 		w.Write([]byte(chshare.BuildVersion))
 		return
+	case "/api/version":
+		s.handleAPIVersion(w, r)
+		return
 	}
 	//missing :O
 	w.WriteHeader(404)
@@ -55,19 +75,91 @@ func (s *Server) handleClientHandler(w http.ResponseWriter, r *http.Request) {
 
 // handleWebsocket is responsible for handling the websocket connection
 func (s *Server) handleWebsocket(w http.ResponseWriter, req *http.Request) {
+	//a client reconnecting to the server of the cluster holding its
+	//listeners, and its session, when that is another one
+	if node := affinity(req); node != "" && s.cluster.forward(w, req, "node:"+node) {
+		return
+	}
+	//a client reconnecting to its session
+	if token := req.Header.Get(chshare.ResumeHeader); token != "" && token != "new" {
+		s.handleResume(w, req, token)
+		return
+	}
+	//clients reconnect elsewhere while the sessions drain
+	if atomic.LoadInt32(&s.shuttingDown) == 1 {
+		s.metrics.sessionRefused("shutdown")
+		http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	//the session limits, before the cost of a handshake
+	if !s.limits.acquire() {
+		s.refuseSession(w, "sessions", "Too many sessions")
+		return
+	}
+	defer s.limits.release()
+	if user := req.Header.Get(chshare.UserHeader); user != "" && s.limits.userFull(user) {
+		s.refuseSession(w, "user", "Too many sessions for user "+user)
+		return
+	}
 	id := atomic.AddInt32(&s.sessCount, 1)
-	l := s.Fork("session#%d", id)
-	wsConn, err := upgrader.Upgrade(w, req, nil)
+	l := s.Fork("session#%d", id).With("session_id", id).With("remote_addr", req.RemoteAddr).
+		With("correlation_id", ctrace.CorrelationID(req.Context()))
+	//spans cover the tunnel being established, continuing the client's trace
+	ctx := ctrace.WithRemote(req.Context(), ctrace.ParseTraceparent(req.Header.Get("traceparent")))
+	ctx, span := ctrace.Start(ctx, "tunnel.establish", ctrace.KindServer)
+	span.SetAttr("net.peer.addr", req.RemoteAddr)
+	var spanErr error
+	defer func() { span.End(spanErr) }()
+	_, upgradeSpan := ctrace.Start(ctx, "websocket.upgrade", ctrace.KindInternal)
+	t0 := time.Now()
+	//offer the client a token to resume the session with
+	respHeader := s.cluster.affinityHeader()
+	resumeToken := ""
+	if s.config.ResumeWindow > 0 && req.Header.Get(chshare.ResumeHeader) == "new" {
+		resumeToken = newResumeToken()
+		respHeader.Set(chshare.ResumeHeader, resumeToken)
+		respHeader.Set(chshare.ResumeWindowHeader, s.config.ResumeWindow.String())
+	}
+	wsConn, err := upgrader.Upgrade(w, req, respHeader)
+	upgradeSpan.End(err)
+	s.metrics.upgrade(time.Since(t0))
 	if err != nil {
 		l.Debugf("Failed to upgrade (%s)", err)
+		spanErr = err
 		return
 	}
-	conn := cnet.NewWebSocketConn(wsConn)
+	setReportTag(req, "session", strconv.Itoa(int(id)))
+	sess := &session{id: id, remoteAddr: req.RemoteAddr, startedAt: time.Now(), lastSeen: time.Now().UnixNano()}
+	defer s.metrics.sessionClosed(sess)
+	transport := cnet.NewWebSocketConn(wsConn)
+	var resumable *cnet.ResumableConn
+	if resumeToken != "" {
+		if resumable, err = cnet.NewResumableConn(transport); err != nil {
+			l.Debugf("Failed to start resumable connection (%s)", err)
+			spanErr = err
+			return
+		}
+		transport = resumable
+	}
+	conn := &sessionConn{Conn: transport, sess: sess}
 	// perform SSH handshake on net.Conn
 	l.Debugf("Handshaking with %s...", req.RemoteAddr)
-	sshConn, chans, reqs, err := ssh.NewServerConn(conn, s.sshConfig)
+	_, handshakeSpan := ctrace.Start(ctx, "ssh.handshake", ctrace.KindInternal)
+	//auth is timed separately from the key exchange
+	var authTime time.Duration
+	sshConfig := *s.sshConfig
+	sshConfig.PasswordCallback = func(c ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+		t0 := time.Now()
+		defer func() { authTime += time.Since(t0) }()
+		return s.authUser(c, password)
+	}
+	t0 = time.Now()
+	sshConn, chans, reqs, err := ssh.NewServerConn(conn, &sshConfig)
+	handshakeSpan.End(err)
+	s.metrics.sshHandshake(time.Since(t0) - authTime)
 	if err != nil {
 		s.Debugf("Failed to handshake (%s)", err)
+		spanErr = err
 		return
 	}
 	// pull the users from the session map
@@ -88,14 +180,16 @@ func (s *Server) handleWebsocket(w http.ResponseWriter, req *http.Request) {
 	var r *ssh.Request
 	select {
 	case r = <-reqs:
-	case <-time.After(settings.EnvDuration("CONFIG_TIMEOUT", 10*time.Second)):
+	case <-time.After(settings.Environment().ConfigTimeout):
 		l.Debugf("Timeout waiting for configuration")
 		sshConn.Close()
+		spanErr = errors.New("timeout waiting for configuration")
 		return
 	}
 	failed := func(err error) {
 		l.Debugf("Failed: %s", err)
 		r.Reply(false, []byte(err.Error()))
+		spanErr = err
 	}
 	if r.Type != "config" {
 		failed(s.Errorf("expecting config request"))
@@ -107,76 +201,143 @@ func (s *Server) handleWebsocket(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 	//print if client and server  versions dont match
+	sess.clientVersion = c.Version
+	if sess.clientVersion == "" {
+		sess.clientVersion = "<unknown>"
+	}
+	l = l.With("client_version", sess.clientVersion)
+	l.Debugf("Client advertised version %s over %s", sess.clientVersion, req.Header.Get("Sec-WebSocket-Protocol"))
 	if c.Version != chshare.BuildVersion {
-		v := c.Version
-		if v == "" {
-			v = "<unknown>"
-		}
 		l.Infof("Client version (%s) differs from server version (%s)",
-			v, chshare.BuildVersion)
-	}
-	//validate remotes
-	for _, r := range c.Remotes {
-		//if user is provided, ensure they have
-		//access to the desired remotes
-		if user != nil {
-			addr := r.UserAddr()
-			if !user.HasAccess(addr) {
-				failed(s.Errorf("access to '%s' denied", addr))
-				return
-			}
+			sess.clientVersion, chshare.BuildVersion)
+	}
+	//the session limit of its user, which
+	//clients may not have advertised
+	name := sshConn.User()
+	if sshConn.Permissions != nil {
+		if val, ok := sshConn.Permissions.CriticalOptions["AllowedUser"]; ok {
+			name = val
 		}
-
-		if val, ok := sshConn.Permissions.CriticalOptions["AllowedPorts"]; ok {
-			allowed, err := craveauth.CheckTargetConatinerPort(r.RemoteHost, r.RemotePort, val, l)
-			if !allowed || err != nil {
-				failed(s.Errorf("access to port %s:%s:%s denied err: %v", r.RemoteHost, r.RemotePort, val, err))
-				return
-			}
+	}
+	if !s.limits.acquireUser(name) {
+		s.metrics.sessionRefused("user")
+		failed(s.Errorf("Too many sessions for user %s", name))
+		return
+	}
+	defer s.limits.releaseUser(name)
+	sess.user = name
+	//validate remotes, those which can't bind yet
+	//are pending when the client retries them
+	defer s.pools.releaseSession(id)
+	defer s.publicHosts.releaseSession(id)
+	var reply settings.ConfigReply
+	remotes := settings.Remotes{}
+	//followed by those pushed to the client, which
+	//are dropped rather than refusing the session
+	requested := len(c.Remotes)
+	var pushed settings.Remotes
+	if c.AcceptPushed {
+		pushed = s.pushRemotes(user, c.Remotes)
+	}
+	for i, r := range append(c.Remotes, pushed...) {
+		err := s.checkRemote(l, user, sshConn, r)
+		bound := r
+		if err == nil {
+			bound, err = s.allocateRemote(l, user, sess, r, &reply)
 		}
-		if val, ok := sshConn.Permissions.CriticalOptions["AllowedUser"]; ok {
-			_, allowed, err := craveauth.CheckTargetUser(r.RemoteHost, r.RemotePort, val, l)
-			if !allowed || err != nil {
-				failed(s.Errorf("access to port %s:%s:%s denied err: %v", r.RemoteHost, r.RemotePort, val, err))
+		if err != nil {
+			if _, ok := err.(bindError); ok && c.RetryRemotes {
+				l.Infof("%s, pending the client's retry", err)
+				reply.Pending = append(reply.Pending, r)
+				reply.Errors = append(reply.Errors, err.Error())
+			} else if i >= requested {
+				l.Infof("Not pushing %s: %s", r, err)
+				continue
+			} else {
+				failed(err)
 				return
 			}
+		} else {
+			remotes = append(remotes, bound)
 		}
-		//confirm reverse tunnels are allowed
-		if r.Reverse && !s.config.Reverse {
-			l.Debugf("Denied reverse port forwarding request, please enable --reverse")
-			failed(s.Errorf("Reverse port forwaring not enabled on server"))
-			return
-		}
-		//confirm reverse tunnel is available
-		if r.Reverse && !r.CanListen() {
-			failed(s.Errorf("Server cannot listen on %s", r.String()))
-			return
+		if i >= requested {
+			reply.Pushed = append(reply.Pushed, r)
 		}
 	}
+	c.Remotes = remotes
 	//successfuly validated config!
-	r.Reply(true, nil)
+	if len(reply.Pushed) > 0 || c.RetryRemotes && (len(reply.Pending) > 0 || len(reply.Ports) > 0 || len(reply.URLs) > 0) {
+		r.Reply(true, settings.EncodeConfigReply(reply))
+	} else {
+		r.Reply(true, nil)
+	}
+	s.metrics.handshake(time.Since(sess.startedAt))
+	span.SetAttr("enduser.id", sshConn.User())
+	span.End(nil)
+	sess.setRemotes(c.Remotes)
+	sess.allocated(reply.Ports, nil)
+	l = l.With("user", sess.user)
+	setReportTag(req, "user", sess.user)
 	//tunnel per ssh connection
-	tunnel := tunnel.New(tunnel.Config{
-		Logger:    l,
-		Inbound:   s.config.Reverse,
-		Outbound:  true, //server always accepts outbound
-		Socks:     s.config.Socks5,
-		KeepAlive: s.config.KeepAlive,
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var tun *tunnel.Tunnel
+	tun = tunnel.New(tunnel.Config{
+		Logger:         l,
+		Inbound:        s.config.Reverse,
+		Outbound:       true, //server always accepts outbound
+		Socks:          s.config.Socks5,
+		HTTPProxy:      s.config.HTTPProxy,
+		Transparent:    s.config.Transparent,
+		DialTimeout:    s.config.DialTimeout,
+		KeepAlive:      s.config.KeepAlive,
+		Conns:          s.conns,
+		Session:        strconv.Itoa(int(id)),
+		MaxConns:       s.config.MaxSessionConns,
+		SlowWrite:      s.config.SlowWrite,
+		SlowWriteLimit: s.config.SlowWriteLimit,
+		Resolver:       s.config.DNSResolver,
+		UnixSockets:    s.config.UnixSockets,
+		//forgotten tunnels are closed
+		IdleChannelTimeout: s.config.IdleChannelTimeout,
+		OnSlowWrite: func(remote string, d time.Duration, closed bool) {
+			s.metrics.slowWrite(sess.user, remote, closed)
+		},
+		OnRemotes: func(u *settings.RemotesUpdate) ([]byte, error) {
+			return s.updateRemotes(ctx, l, user, sshConn, tun, sess, u)
+		},
 	})
+	sess.tunnel = tun
+	sess.close = sshConn.Close
+	if resumable != nil {
+		resumable.SetResumeWindow(s.config.ResumeWindow)
+		sess.resumable, sess.resumeToken = resumable, resumeToken
+	}
+	s.tunnels.add(sess)
+	s.webhook.send(eventSessionConnected, newAPISession(sess))
+	defer func() {
+		s.tunnels.del(id)
+		s.webhook.send(eventSessionDisconnected, newAPISession(sess))
+	}()
 	//bind
-	eg, ctx := errgroup.WithContext(req.Context())
+	eg, ctx := errgroup.WithContext(ctx)
 	eg.Go(func() error {
 		//connected, handover ssh connection for tunnel to use, and block
-		return tunnel.BindSSH(ctx, sshConn, reqs, chans)
+		return tun.BindSSH(ctx, sshConn, reqs, chans)
 	})
+	if s.config.IdleSessionTimeout > 0 {
+		eg.Go(func() error {
+			return s.closeIdleSession(ctx, l, sess)
+		})
+	}
 	eg.Go(func() error {
 		//connected, setup reversed-remotes?
-		serverInbound := c.Remotes.Reversed(true)
+		serverInbound := c.Remotes.Reversed(true).Listened(true)
 		if len(serverInbound) == 0 {
 			return nil
 		}
 		//block
-		return tunnel.BindRemotes(ctx, serverInbound)
+		return tun.BindRemotes(ctx, serverInbound)
 	})
 	err = eg.Wait()
 	if err != nil && !strings.HasSuffix(err.Error(), "EOF") {
@@ -185,3 +346,228 @@ func (s *Server) handleWebsocket(w http.ResponseWriter, req *http.Request) {
 		l.Debugf("Closed connection")
 	}
 }
+
+// checkRemote authorizes a remote requested by the session's client
+func (s *Server) checkRemote(l *cio.Logger, user *settings.User, sshConn *ssh.ServerConn, r *settings.Remote) error {
+	//if user is provided, ensure they have
+	//access to the desired remotes
+	if user != nil {
+		addr := r.UserAddr()
+		if !user.HasAccess(addr) {
+			return s.Errorf("access to '%s' denied", addr)
+		}
+	}
+
+	//sessions of servers without users have no permissions
+	var opts map[string]string
+	if sshConn.Permissions != nil {
+		opts = sshConn.Permissions.CriticalOptions
+	}
+	if val, ok := opts["AllowedPorts"]; ok {
+		allowed, err := craveauth.CheckTargetConatinerPort(s.db, r.RemoteHost, r.RemotePort, val, l)
+		if !allowed || err != nil {
+			return s.Errorf("access to port %s:%s:%s denied err: %v", r.RemoteHost, r.RemotePort, val, err)
+		}
+	}
+	if val, ok := opts["AllowedUser"]; ok {
+		_, allowed, err := craveauth.CheckTargetUser(s.db, r.RemoteHost, r.RemotePort, val, l)
+		if !allowed || err != nil {
+			return s.Errorf("access to port %s:%s:%s denied err: %v", r.RemoteHost, r.RemotePort, val, err)
+		}
+	}
+	//confirm socks remotes are allowed
+	if r.Socks && !r.Reverse && !s.config.Socks5 {
+		l.Debugf("Denied socks remote, please enable --socks5")
+		return s.Errorf("SOCKS5 not enabled on server")
+	}
+	if r.HTTPProxy && !r.Reverse && !s.config.HTTPProxy {
+		l.Debugf("Denied http proxy remote, please enable --http-proxy")
+		return s.Errorf("HTTP proxy not enabled on server")
+	}
+	if r.Transparent && !s.config.Transparent {
+		l.Debugf("Denied transparent remote, please enable --transparent")
+		return s.Errorf("Transparent remotes not enabled on server")
+	}
+	//confirm the server's unix sockets are allowed
+	serverUnix := (!r.Reverse && r.RemoteProto == "unix") || (r.Reverse && r.LocalProto == "unix")
+	if serverUnix && !s.config.UnixSockets {
+		l.Debugf("Denied unix socket remote, please enable --unix-sockets")
+		return s.Errorf("Unix sockets not enabled on server")
+	}
+	//confirm reverse tunnels are allowed
+	if r.Reverse && !s.config.Reverse {
+		l.Debugf("Denied reverse port forwarding request, please enable --reverse")
+		return s.Errorf("Reverse port forwaring not enabled on server")
+	}
+	//public remotes are served at a subdomain, see handlePublicHost
+	if r.Public() {
+		if s.config.PublicDomain == "" {
+			return s.Errorf("Public remotes not enabled on server, see --public-domain")
+		}
+		if r.LocalProto == "https" && !s.servesTLS() {
+			return s.Errorf("Server does not serve TLS, use R:http://%s", r.Subdomain)
+		}
+		return nil
+	}
+	//the ports of pools are allocated, see allocateRemote
+	if pool := r.Pool(); pool != "" {
+		if !s.pools.has(pool) {
+			return s.Errorf("Unknown port pool %s", pool)
+		}
+		return nil
+	}
+	//confirm the user may bind the port, ephemeral
+	//ports are allocated from those they may bind
+	if r.Reverse && r.LocalProto != "unix" && !r.Ephemeral() && user != nil && !user.CanBind(r.LocalPort) {
+		return s.Errorf("access to port %s denied, %s may bind %s", r.LocalPort, user.Name, portRanges(user.ReversePorts))
+	}
+	//confirm reverse tunnel is available
+	if r.Reverse && !r.CanListen() {
+		return bindError{s.Errorf("Server cannot listen on %s", r.String())}
+	}
+	return nil
+}
+
+// allocateRemote picks the port of an ephemeral remote, or of a
+// pool's remote owned by the session, returning the remote to
+// bind, with the port recorded in the reply. The port the user's
+// remote was last allocated is preferred. Public remotes are
+// exposed instead, with their URL in the reply.
+func (s *Server) allocateRemote(l *cio.Logger, user *settings.User, sess *session, r *settings.Remote, reply *settings.ConfigReply) (*settings.Remote, error) {
+	if r.Public() {
+		if err := s.exposeRemote(sess.id, r, reply); err != nil {
+			return nil, bindError{s.Errorf("Server cannot expose %s: %s", r, err)}
+		}
+		l.Infof("Exposed %s at %s", r, s.publicURL(r))
+		return r, nil
+	}
+	if !r.Ephemeral() && r.Pool() == "" {
+		return r, nil
+	}
+	var port string
+	var err error
+	prev := s.persist.port(sess.user, r)
+	if r.Pool() != "" {
+		port, err = s.pools.allocate(sess.id, r, prev)
+	} else if prev != "" && canReuse(user, r, prev) {
+		port = prev
+	} else if user != nil && len(user.ReversePorts) > 0 {
+		port, err = allocateFrom(r, user.ReversePorts)
+	} else {
+		port, err = r.AllocatePort()
+	}
+	if err != nil {
+		return nil, bindError{s.Errorf("Server cannot allocate a port for %s: %s", r, err)}
+	}
+	l.Infof("Allocated port %s for %s", port, r)
+	s.persist.allocated(sess.user, r, port)
+	if reply.Ports == nil {
+		reply.Ports = map[string]string{}
+	}
+	reply.Ports[r.String()] = port
+	b := *r
+	b.LocalPort = port
+	return &b, nil
+}
+
+// canReuse reports whether an ephemeral remote
+// of the user can listen on the port again
+func canReuse(user *settings.User, r *settings.Remote, port string) bool {
+	if user != nil && !user.CanBind(port) {
+		return false
+	}
+	b := *r
+	b.LocalPort = port
+	return b.CanListen()
+}
+
+// allocateFrom picks the first port of the ranges
+// which an ephemeral remote can listen on
+func allocateFrom(r *settings.Remote, ranges []settings.PortRange) (string, error) {
+	for _, pr := range ranges {
+		for p := pr.From; p <= pr.To; p++ {
+			b := *r
+			b.LocalPort = strconv.Itoa(p)
+			if b.CanListen() {
+				return b.LocalPort, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no free port in %s", portRanges(ranges))
+}
+
+// portRanges lists the ranges for messages
+func portRanges(ranges []settings.PortRange) string {
+	s := make([]string, len(ranges))
+	for i, r := range ranges {
+		s[i] = r.String()
+	}
+	return strings.Join(s, ",")
+}
+
+// bindError is a reverse remote the server cannot listen on,
+// such as a port in use, which may be retried
+type bindError struct{ error }
+
+// updateRemotes applies a client's request to unbind and bind remotes
+// of its established session, binding the reverse remotes on the server
+func (s *Server) updateRemotes(ctx context.Context, l *cio.Logger, user *settings.User, sshConn *ssh.ServerConn,
+	tun *tunnel.Tunnel, sess *session, u *settings.RemotesUpdate) ([]byte, error) {
+	//the remotes as bound, with their allocated ports
+	var reply settings.ConfigReply
+	applied := &settings.RemotesUpdate{}
+	//the pool ports of a failed update are freed
+	failed := func(err error) ([]byte, error) {
+		for _, port := range reply.Ports {
+			s.pools.release(sess.id, port)
+		}
+		for _, r := range u.Add {
+			if _, ok := reply.URLs[r.String()]; ok {
+				s.publicHosts.release(sess.id, r.Subdomain)
+			}
+		}
+		return nil, err
+	}
+	for _, r := range u.Add {
+		if err := s.checkRemote(l, user, sshConn, r); err != nil {
+			return failed(err)
+		}
+		a, err := s.allocateRemote(l, user, sess, r, &reply)
+		if err != nil {
+			return failed(err)
+		}
+		applied.Add = append(applied.Add, a)
+	}
+	for _, r := range u.Remove {
+		applied.Remove = append(applied.Remove, sess.bound(r))
+	}
+	for _, r := range applied.Remove.Reversed(true).Listened(true) {
+		tun.RemoveRemote(r)
+	}
+	var bound settings.Remotes
+	for _, r := range applied.Add.Reversed(true).Listened(true) {
+		if err := tun.AddRemote(ctx, r); err != nil {
+			//all or nothing
+			for _, b := range bound {
+				tun.RemoveRemote(b)
+			}
+			return failed(s.Errorf("%s", err))
+		}
+		bound = append(bound, r)
+	}
+	sess.updateRemotes(applied)
+	for _, r := range applied.Remove {
+		if r.Public() {
+			s.publicHosts.release(sess.id, r.Subdomain)
+		} else {
+			s.pools.release(sess.id, r.LocalPort)
+		}
+	}
+	sess.allocated(reply.Ports, u.Remove)
+	l.Infof("Remotes updated (added %s, removed %s)",
+		strings.Join(applied.Add.Encode(), " "), strings.Join(applied.Remove.Encode(), " "))
+	if len(reply.Ports) == 0 && len(reply.URLs) == 0 {
+		return nil, nil
+	}
+	return settings.EncodeConfigReply(reply), nil
+}
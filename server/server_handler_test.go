@@ -0,0 +1,76 @@
+package chserver
+
+import (
+	"net"
+	"regexp"
+	"strconv"
+	"testing"
+
+	"github.com/jpillora/chisel/share/cio"
+	"github.com/jpillora/chisel/share/settings"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestReversePorts(t *testing.T) {
+	s := &Server{Logger: cio.NewLogger("server"), config: &Config{Reverse: true}}
+	sshConn := &ssh.ServerConn{Permissions: &ssh.Permissions{}}
+	//a free port, and the one after it
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	user := &settings.User{
+		Name:         "alice",
+		Addrs:        []*regexp.Regexp{settings.UserAllowAll},
+		ReversePorts: []settings.PortRange{{From: port, To: port + 1}},
+	}
+	remote := func(s string) *settings.Remote {
+		r, err := settings.DecodeRemote(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+	if err := s.checkRemote(s.Logger, user, sshConn, remote("R:127.0.0.1:80:localhost:3000")); err == nil {
+		t.Fatal("expected a port outside of the ranges to be denied")
+	}
+	next := strconv.Itoa(port + 1)
+	if err := s.checkRemote(s.Logger, user, sshConn, remote("R:127.0.0.1:"+next+":localhost:3000")); err != nil {
+		t.Fatalf("expected a port in the ranges, got %s", err)
+	}
+	//the first port is in use, the next is allocated
+	r := remote("R:127.0.0.1:0:localhost:3000")
+	if err := s.checkRemote(s.Logger, user, sshConn, r); err != nil {
+		t.Fatal(err)
+	}
+	var reply settings.ConfigReply
+	b, err := s.allocateRemote(s.Logger, user, &session{id: 1}, r, &reply)
+	if err != nil || b.LocalPort != next {
+		t.Fatalf("expected port %s, got %v %v", next, b, err)
+	}
+	//none are free
+	l2, err := net.Listen("tcp", "127.0.0.1:"+next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l2.Close()
+	l.Close()
+	user.ReversePorts = []settings.PortRange{{From: port + 1, To: port + 1}}
+	if _, err := s.allocateRemote(s.Logger, user, &session{id: 1}, r, &reply); err == nil {
+		t.Fatal("expected no free port")
+	}
+}
+
+func TestCheckRemoteWithoutUsers(t *testing.T) {
+	s := &Server{Logger: cio.NewLogger("server"), config: &Config{}}
+	//servers without users authenticate no one, with no permissions
+	sshConn := &ssh.ServerConn{}
+	r, err := settings.DecodeRemote("3000:localhost:3000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.checkRemote(s.Logger, nil, sshConn, r); err != nil {
+		t.Fatal(err)
+	}
+}
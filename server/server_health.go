@@ -0,0 +1,64 @@
+package chserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// readinessTimeout bounds the database ping of a readiness check
+const readinessTimeout = 2 * time.Second
+
+// handleHealthz reports liveness, the process is serving requests
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("OK\n"))
+}
+
+// handleReadyz reports whether this instance should receive
+// traffic, with the result of each check as JSON
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	checks := s.readiness(r.Context())
+	ready := true
+	out := map[string]string{}
+	for name, err := range checks {
+		out[name] = "ok"
+		if err != nil {
+			ready = false
+			out[name] = err.Error()
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if !ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"ready": ready, "checks": out})
+}
+
+// alive feeds the systemd watchdog while the listener is up
+func (s *Server) alive() error {
+	if atomic.LoadInt32(&s.listening) == 0 {
+		return errors.New("not listening")
+	}
+	return nil
+}
+
+// readiness checks the listener, the database when
+// configured, and the connections to dcmaster
+func (s *Server) readiness(ctx context.Context) map[string]error {
+	checks := map[string]error{"listener": nil}
+	if atomic.LoadInt32(&s.listening) == 0 {
+		checks["listener"] = errors.New("not listening")
+	}
+	if s.db != nil {
+		ctx, cancel := context.WithTimeout(ctx, readinessTimeout)
+		checks["db"] = s.db.Ping(ctx)
+		cancel()
+	}
+	if s.dcmaster != nil {
+		checks["dcmaster"] = s.dcmaster.Healthy()
+	}
+	return checks
+}
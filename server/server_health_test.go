@@ -0,0 +1,40 @@
+package chserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jpillora/chisel/share/cio"
+	"github.com/jpillora/chisel/share/craveauth"
+)
+
+func TestReadyz(t *testing.T) {
+	s := &Server{
+		Logger:   cio.NewLogger("server"),
+		dcmaster: craveauth.NewDCMasterPool("", time.Second, cio.NewLogger("test")),
+	}
+	ready := func() (int, map[string]interface{}) {
+		rec := httptest.NewRecorder()
+		s.handleReadyz(rec, httptest.NewRequest("GET", "/readyz", nil))
+		var body map[string]interface{}
+		json.NewDecoder(rec.Body).Decode(&body)
+		return rec.Code, body
+	}
+	code, body := ready()
+	if code != http.StatusServiceUnavailable || body["ready"] != false {
+		t.Fatalf("expected not ready, got %d %v", code, body)
+	}
+	checks := body["checks"].(map[string]interface{})
+	if checks["listener"] != "not listening" || checks["dcmaster"] != "dcmaster port unknown" {
+		t.Fatalf("unexpected checks %v", checks)
+	}
+	atomic.StoreInt32(&s.listening, 1)
+	s.dcmaster.SetPort("20000")
+	if code, body := ready(); code != http.StatusOK || body["ready"] != true {
+		t.Fatalf("expected ready, got %d %v", code, body)
+	}
+}
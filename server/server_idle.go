@@ -0,0 +1,47 @@
+package chserver
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jpillora/chisel/share/cio"
+	"github.com/jpillora/chisel/share/cnet"
+)
+
+// errIdleSession ends sessions without open channels
+// for longer than the IdleSessionTimeout
+var errIdleSession = errors.New("idle session")
+
+// closeIdleSession ends the session once it has had no open
+// channels, in either direction, for the IdleSessionTimeout
+func (s *Server) closeIdleSession(ctx context.Context, l *cio.Logger, sess *session) error {
+	timeout := s.config.IdleSessionTimeout
+	interval := timeout / 10
+	if interval > time.Minute {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	busy := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		if s.openChannels(sess) > 0 {
+			busy = time.Now()
+		} else if idle := time.Since(busy); idle >= timeout {
+			l.Infof("Closing idle session, no open channels for %s", idle.Round(time.Second))
+			return errIdleSession
+		}
+	}
+}
+
+// openChannels counts the channels of the session, those it dialed
+// for the client, and the connections of its reverse remotes
+func (s *Server) openChannels(sess *session) int {
+	n := int(sess.tunnel.OpenChannels())
+	return n + s.conns.Count(cnet.ConnLabels{Session: sess.tunnel.Session, Direction: cnet.Accepted})
+}
@@ -0,0 +1,44 @@
+package chserver
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jpillora/chisel/share/cio"
+	"github.com/jpillora/chisel/share/cnet"
+	"github.com/jpillora/chisel/share/tunnel"
+)
+
+func TestCloseIdleSession(t *testing.T) {
+	s := &Server{
+		Logger: cio.NewLogger("server"),
+		config: &Config{IdleSessionTimeout: 100 * time.Millisecond},
+		conns:  cnet.NewRegistry(),
+	}
+	sess := &session{tunnel: tunnel.New(tunnel.Config{Logger: s.Logger, Session: "1"})}
+	//a connection of a reverse remote keeps the session open
+	a, b := net.Pipe()
+	defer b.Close()
+	c := s.conns.Track(a, cnet.ConnLabels{Session: "1", Direction: cnet.Accepted})
+	done := make(chan error, 1)
+	go func() { done <- s.closeIdleSession(context.Background(), s.Logger, sess) }()
+	time.Sleep(200 * time.Millisecond)
+	select {
+	case <-done:
+		t.Fatal("expected a session with open channels to stay open")
+	default:
+	}
+	c.Close()
+	closed := time.Now()
+	select {
+	case err := <-done:
+		//busy is last seen up to one tick before the close
+		if err != errIdleSession || time.Since(closed) < 100*time.Millisecond-10*time.Millisecond {
+			t.Fatalf("expected the session to idle after its channels closed, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the idle session to be closed")
+	}
+}
@@ -0,0 +1,82 @@
+package chserver
+
+import (
+	"sync"
+	"time"
+)
+
+// jobCache remembers which jobs dcmaster recently reported as
+// running, so proxied requests skip the dcrpc round trip. Only
+// successful checks are cached, failures are always rechecked.
+type jobCache struct {
+	ttl     time.Duration
+	mut     sync.Mutex
+	running map[jobKey]time.Time
+}
+
+type jobKey struct {
+	ip    string
+	jobId int64
+}
+
+func newJobCache(ttl time.Duration) *jobCache {
+	return &jobCache{ttl: ttl, running: map[jobKey]time.Time{}}
+}
+
+// fresh reports whether the job was seen running within the ttl
+func (c *jobCache) fresh(ip string, jobId int64) bool {
+	if c == nil || c.ttl <= 0 {
+		return false
+	}
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	k := jobKey{ip, jobId}
+	expires, ok := c.running[k]
+	if ok && time.Now().After(expires) {
+		delete(c.running, k)
+		ok = false
+	}
+	return ok
+}
+
+// store records that the job was just seen running
+func (c *jobCache) store(ip string, jobId int64) {
+	if c == nil || c.ttl <= 0 {
+		return
+	}
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	//drop expired entries as we go, so finished jobs don't pile up
+	now := time.Now()
+	for k, expires := range c.running {
+		if now.After(expires) {
+			delete(c.running, k)
+		}
+	}
+	c.running[jobKey{ip, jobId}] = now.Add(c.ttl)
+}
+
+// invalidate forgets the job on every host, the
+// next request for it goes to dcmaster
+func (c *jobCache) invalidate(jobId int64) {
+	if c == nil {
+		return
+	}
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	for k := range c.running {
+		if k.jobId == jobId {
+			delete(c.running, k)
+		}
+	}
+}
+
+// reset forgets every job, used when dcmaster moves
+func (c *jobCache) reset() {
+	if c == nil {
+		return
+	}
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	c.running = map[jobKey]time.Time{}
+}
@@ -0,0 +1,31 @@
+package chserver
+
+import (
+	"testing"
+	"time"
+)
+
+func TestJobCache(t *testing.T) {
+	c := newJobCache(50 * time.Millisecond)
+	if c.fresh("10.0.0.1", 1) {
+		t.Fatal("expected unknown job to be stale")
+	}
+	c.store("10.0.0.1", 1)
+	c.store("10.0.0.2", 2)
+	if !c.fresh("10.0.0.1", 1) || c.fresh("10.0.0.2", 1) {
+		t.Fatal("expected job to be fresh on its host only")
+	}
+	c.invalidate(1)
+	if c.fresh("10.0.0.1", 1) {
+		t.Fatal("expected invalidated job to be stale")
+	}
+	time.Sleep(60 * time.Millisecond)
+	if c.fresh("10.0.0.2", 2) {
+		t.Fatal("expected job to expire")
+	}
+	var disabled *jobCache
+	disabled.store("10.0.0.1", 1)
+	if disabled.fresh("10.0.0.1", 1) {
+		t.Fatal("expected nil cache to be disabled")
+	}
+}
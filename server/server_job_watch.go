@@ -0,0 +1,125 @@
+package chserver
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/jpillora/chisel/share/craveauth"
+	"github.com/jpillora/chisel/share/creport"
+)
+
+// watchJobs polls dcmaster for the jobs of all registered
+// proxies, tearing down the proxies of finished jobs
+func (s *Server) watchJobs(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.reapFinishedJobs(ctx)
+		}
+	}
+}
+
+// reapFinishedJobs checks each job once, using any of its
+// proxies' dcmaster connections
+func (s *Server) reapFinishedJobs(ctx context.Context) {
+	jobs := map[int64][]*DynamicReverseProxy{}
+	s.dynamicReverseProxies.Range(func(pId string, p *DynamicReverseProxy) bool {
+		if p.DcMaster != nil {
+			jobs[p.JobId] = append(jobs[p.JobId], p)
+		}
+		return true
+	})
+	for jobId, proxies := range jobs {
+		client, err := proxies[0].DcMaster.Client()
+		if err == nil {
+			callCtx, cancel := s.dcrpcContext(ctx)
+			err = craveauth.CheckForJob(callCtx, client, proxies[0].Id, jobId)
+			cancel()
+		}
+		if !craveauth.JobFinished(err) {
+			continue
+		}
+		s.reapProxies(ctx, jobId, proxies, err.Error())
+	}
+}
+
+// reapJob removes the proxies of a finished job on the given host
+func (s *Server) reapJob(ctx context.Context, jobId int64, ip, reason string) {
+	proxies := []*DynamicReverseProxy{}
+	s.dynamicReverseProxies.Range(func(pId string, p *DynamicReverseProxy) bool {
+		if p.DcMaster != nil && p.JobId == jobId && p.DcMaster.IP() == ip {
+			proxies = append(proxies, p)
+		}
+		return true
+	})
+	s.reapProxies(ctx, jobId, proxies, reason)
+}
+
+// reapProxies removes the proxies of a finished job, first draining
+// their requests in flight when a drain timeout is set
+func (s *Server) reapProxies(ctx context.Context, jobId int64, proxies []*DynamicReverseProxy, reason string) {
+	if len(proxies) == 0 {
+		return
+	}
+	timeout := s.config.DrainTimeout
+	if timeout <= 0 {
+		s.removeJobProxies(ctx, jobId, proxies, reason)
+		return
+	}
+	proxies = startDrain(proxies)
+	if len(proxies) == 0 {
+		return
+	}
+	s.With("job_id", jobId).Infof("Job %d finished (%s), draining %d proxies", jobId, reason, len(proxies))
+	go func() {
+		s.waitDrain(proxies, timeout)
+		s.removeJobProxies(ctx, jobId, proxies, reason)
+	}()
+}
+
+// removeJobProxies removes the proxies of a finished job from memory,
+// then deletes their rows when state is published. The two aren't one
+// transaction: rows whose delete fails remain until the next state
+// write replaces them, and a re-registered proxy's row is kept.
+func (s *Server) removeJobProxies(ctx context.Context, jobId int64, proxies []*DynamicReverseProxy, reason string) error {
+	l := s.With("job_id", jobId)
+	removed := []*DynamicReverseProxy{}
+	s.stateMut.Lock()
+	for _, p := range proxies {
+		//only remove the proxy we checked, it may have been re-registered
+		if s.dynamicReverseProxies.DeleteIf(p.Id, p) {
+			removed = append(removed, p)
+		}
+	}
+	s.stateMut.Unlock()
+	for _, p := range removed {
+		s.proxyHosts.release(p.Subdomain, p.Id)
+		s.events.proxyRemoved(p)
+		s.notifyProxy(eventProxyRemoved, p.Id, p, proxyActor{Identity: "dcmaster", Reason: reason})
+		s.jobs.invalidate(jobId)
+		s.disconnectResourceDcMaster(p)
+		l.With("proxy_id", p.Id).Infof("Job %d finished (%s), removed proxy %s to %s", jobId, reason, p.Id, p.Target)
+	}
+	if len(removed) == 0 {
+		return nil
+	}
+	if err := s.deleteStateProxies(ctx, removed); err != nil {
+		l.Warnf("Job %d finished (%s), but removing its proxies from the database failed: %s", jobId, reason, err)
+		creport.Report(err, creport.Tags{"job": strconv.FormatInt(jobId, 10)})
+		return err
+	}
+	return nil
+}
+
+// dcrpcContext bounds a dcrpc call by the configured timeout
+func (s *Server) dcrpcContext(parent context.Context) (context.Context, context.CancelFunc) {
+	if s.config.DCMasterTimeout > 0 {
+		return context.WithTimeout(parent, s.config.DCMasterTimeout)
+	}
+	return context.WithCancel(parent)
+}
@@ -0,0 +1,102 @@
+package chserver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jpillora/chisel/share/cdb"
+	"github.com/jpillora/chisel/share/cio"
+)
+
+func TestRemoveJobProxies(t *testing.T) {
+	//the database refuses connections, so deleting the rows
+	//fails after its retries
+	db, err := cdb.Open(cdb.Config{URL: "postgres://chisel@127.0.0.1:1/chisel?connect_timeout=1"}, cio.NewLogger("db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	s := &Server{
+		Logger:                cio.NewLogger("server"),
+		config:                &Config{StateInterval: time.Minute},
+		db:                    db,
+		dynamicReverseProxies: NewProxyStore(),
+		proxyHosts:            newProxyHosts(),
+		events:                newDCMasterEvents(nil),
+		jobs:                  newJobCache(0),
+		proxyAudit:            newProxyAudit(),
+	}
+	p := &DynamicReverseProxy{Id: "docs", JobId: 9, Target: "http://10.0.0.5:8080"}
+	s.dynamicReverseProxies.Add("docs", p)
+	//a re-registered proxy is kept
+	again := &DynamicReverseProxy{Id: "api", JobId: 9, Target: "http://10.0.0.5:8081"}
+	s.dynamicReverseProxies.Add("api", again)
+	done := make(chan error, 1)
+	go func() {
+		done <- s.removeJobProxies(context.Background(), 9, []*DynamicReverseProxy{p, {Id: "api"}}, "finished")
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, ok := s.dynamicReverseProxies.Get("docs"); !ok {
+			break
+		}
+		select {
+		case <-done:
+			t.Fatal("expected the proxy to be removed before its rows")
+		default:
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the proxy to be removed")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if _, ok := s.dynamicReverseProxies.Get("api"); !ok {
+		t.Fatal("expected the re-registered proxy to be kept")
+	}
+	//state writes aren't blocked by the retries
+	locked := make(chan struct{})
+	go func() {
+		s.stateMut.Lock()
+		s.stateMut.Unlock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-done:
+		t.Fatal("expected the rows to still be retrying")
+	case <-time.After(time.Second):
+		t.Fatal("expected stateMut to be free while the rows are deleted")
+	}
+	if err := <-done; err == nil {
+		t.Fatal("expected deleting the rows to fail")
+	}
+	if entries := s.proxyAudit.list("docs"); len(entries) != 1 || entries[0].Action != eventProxyRemoved {
+		t.Fatalf("expected the removal to be audited, got %+v", entries)
+	}
+}
+
+func TestDCRPCContext(t *testing.T) {
+	s := &Server{config: &Config{DCMasterTimeout: 50 * time.Millisecond}}
+	ctx, cancel := s.dcrpcContext(context.Background())
+	defer cancel()
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("expected the call to time out")
+	}
+	//the originating request's cancellation applies too
+	parent, cancelParent := context.WithCancel(context.Background())
+	s.config.DCMasterTimeout = 0
+	ctx, cancel = s.dcrpcContext(parent)
+	defer cancel()
+	if _, ok := ctx.Deadline(); ok {
+		t.Fatal("expected no deadline when the timeout is disabled")
+	}
+	cancelParent()
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("expected the call to be cancelled with the request")
+	}
+}
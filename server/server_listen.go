@@ -1,6 +1,7 @@
 package chserver
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"errors"
@@ -10,6 +11,7 @@ import (
 	"os/user"
 	"path/filepath"
 
+	"github.com/jpillora/chisel/share/cos"
 	"github.com/jpillora/chisel/share/settings"
 	"golang.org/x/crypto/acme/autocert"
 )
@@ -43,11 +45,26 @@ func (s *Server) listener(host, port string) (net.Listener, error) {
 			extra = " (WARNING: LetsEncrypt will attempt to connect to your domain on port 443)"
 		}
 	}
-	//tcp listen
-	l, err := net.Listen("tcp", host+":"+port)
+	//systemd socket activation replaces the tcp listen
+	activated, err := cos.SdListeners()
 	if err != nil {
 		return nil, err
 	}
+	var l net.Listener
+	if len(activated) > 0 {
+		l = activated[0]
+		host, port, _ = net.SplitHostPort(l.Addr().String())
+		for _, extra := range activated[1:] {
+			extra.Close()
+		}
+		extra += " (systemd socket)"
+	} else {
+		//tcp listen
+		l, err = net.Listen("tcp", host+":"+port)
+		if err != nil {
+			return nil, err
+		}
+	}
 	//optionally wrap in tls
 	proto := "http"
 	if tlsConf != nil {
@@ -67,11 +84,11 @@ func (s *Server) tlsLetsEncrypt(domains []string) *tls.Config {
 			s.Infof("Accepting LetsEncrypt TOS and fetching certificate...")
 			return true
 		},
-		Email:      settings.Env("LE_EMAIL"),
-		HostPolicy: autocert.HostWhitelist(domains...),
+		Email:      settings.Environment().LEEmail,
+		HostPolicy: s.hostPolicy(autocert.HostWhitelist(domains...)),
 	}
 	//configure file cache
-	c := settings.Env("LE_CACHE")
+	c := settings.Environment().LECache
 	if c == "" {
 		h := os.Getenv("HOME")
 		if h == "" {
@@ -89,6 +106,19 @@ func (s *Server) tlsLetsEncrypt(domains []string) *tls.Config {
 	return m.TLSConfig()
 }
 
+// hostPolicy extends the policy of the domains with the
+// subdomains of the public remotes, which are exposed on demand
+func (s *Server) hostPolicy(domains autocert.HostPolicy) autocert.HostPolicy {
+	return func(ctx context.Context, host string) error {
+		if subdomain, ok := s.publicSubdomain(host); ok {
+			if _, ok := s.publicHosts.get(subdomain); ok {
+				return nil
+			}
+		}
+		return domains(ctx, host)
+	}
+}
+
 func (s *Server) tlsKeyCert(key, cert string, ca string) (*tls.Config, error) {
 	keypair, err := tls.LoadX509KeyPair(cert, key)
 	if err != nil {
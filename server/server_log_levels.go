@@ -0,0 +1,72 @@
+package chserver
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/jpillora/chisel/share/cio"
+)
+
+// logSubsystems are the subsystems whose level may be changed at runtime
+var logSubsystems = []string{"server", "tunnel", "proxy", "dcrpc"}
+
+// proxyLog logs as the proxy subsystem
+func (s *Server) proxyLog() *cio.Logger {
+	return s.WithSubsystem("proxy")
+}
+
+// logLevels returns the level of each subsystem, the runtime
+// level when one is set, else the level of the -v flag
+func (s *Server) logLevels() map[string]string {
+	def := "info"
+	if s.Debug {
+		def = "debug"
+	}
+	out := map[string]string{}
+	for _, name := range logSubsystems {
+		if level, ok := cio.Level(name); ok {
+			out[name] = level
+		} else {
+			out[name] = def
+		}
+	}
+	return out
+}
+
+// logLevelRequest changes the level of a subsystem,
+// an empty level restores the level of the -v flag
+type logLevelRequest struct {
+	Subsystem string `json:"subsystem"`
+	Level     string `json:"level"`
+}
+
+// handleAPILogLevels serves GET /api/log-levels, listing the level
+// of each subsystem, and PUT, which changes the level of one
+func (s *Server) handleAPILogLevels(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut, http.MethodPost:
+		var req logLevelRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		known := false
+		for _, name := range logSubsystems {
+			known = known || name == req.Subsystem
+		}
+		if !known {
+			http.Error(w, s.Errorf("Unknown subsystem (%s)", req.Subsystem).Error(), http.StatusBadRequest)
+			return
+		}
+		if err := cio.SetLevel(req.Subsystem, req.Level); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.Infof("Admin API: set the log level of %s to %q", req.Subsystem, req.Level)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.logLevels())
+}
@@ -0,0 +1,422 @@
+package chserver
+
+import (
+	"context"
+	"crypto/subtle"
+	"expvar"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// handshakeBuckets are the upper bounds, in seconds, of
+// the handshake latency histogram
+var handshakeBuckets = []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// stageBuckets are the upper bounds, in seconds, of the
+// histograms of each stage of a handshake
+var stageBuckets = []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// serverMetrics are the counters behind /metrics, gauges
+// are read from the live state when scraped
+type serverMetrics struct {
+	//counters first, 64-bit atomics must be aligned on 32-bit platforms
+	//closedSent and closedReceived are the bytes of ended sessions
+	closedSent     int64
+	closedReceived int64
+	proxyRequests  int64
+	proxyErrors    int64
+	mut            sync.Mutex
+	handshakes     histogram
+	//the stages of a handshake, the key exchange
+	//excludes the time spent in auth
+	upgrades    histogram
+	keyExchange histogram
+	authOK      histogram
+	authFailed  histogram
+	//closedRemotes are the sent and received bytes of
+	//the remotes of ended sessions
+	closedRemotes map[string][2]int64
+	//slowWrites and slowClosed count slow consumers by user and remote
+	slowWrites map[[2]string]int64
+	slowClosed map[[2]string]int64
+	//refused counts the sessions refused by the
+	//session limits, by the limit reached
+	refused map[string]int64
+}
+
+func newServerMetrics() *serverMetrics {
+	return &serverMetrics{
+		handshakes:    newHistogram(handshakeBuckets),
+		upgrades:      newHistogram(stageBuckets),
+		keyExchange:   newHistogram(stageBuckets),
+		authOK:        newHistogram(stageBuckets),
+		authFailed:    newHistogram(stageBuckets),
+		closedRemotes: map[string][2]int64{},
+		slowWrites:    map[[2]string]int64{},
+		slowClosed:    map[[2]string]int64{},
+		refused:       map[string]int64{},
+	}
+}
+
+func (m *serverMetrics) sessionRefused(limit string) {
+	if m == nil {
+		return
+	}
+	m.mut.Lock()
+	m.refused[limit]++
+	m.mut.Unlock()
+}
+
+// sessionClosed keeps the traffic of an ended session in the totals
+func (m *serverMetrics) sessionClosed(sess *session) {
+	if m == nil {
+		return
+	}
+	atomic.AddInt64(&m.closedSent, atomic.LoadInt64(&sess.sent))
+	atomic.AddInt64(&m.closedReceived, atomic.LoadInt64(&sess.received))
+	if sess.tunnel == nil {
+		return
+	}
+	m.mut.Lock()
+	for remote, c := range sess.tunnel.Traffic() {
+		t := m.closedRemotes[remote]
+		m.closedRemotes[remote] = [2]int64{t[0] + c.Sent(), t[1] + c.Received()}
+	}
+	m.mut.Unlock()
+}
+
+func (m *serverMetrics) handshake(d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.mut.Lock()
+	m.handshakes.observe(d.Seconds())
+	m.mut.Unlock()
+}
+
+func (m *serverMetrics) upgrade(d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.mut.Lock()
+	m.upgrades.observe(d.Seconds())
+	m.mut.Unlock()
+}
+
+func (m *serverMetrics) sshHandshake(d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.mut.Lock()
+	m.keyExchange.observe(d.Seconds())
+	m.mut.Unlock()
+}
+
+func (m *serverMetrics) auth(d time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	m.mut.Lock()
+	if err == nil {
+		m.authOK.observe(d.Seconds())
+	} else {
+		m.authFailed.observe(d.Seconds())
+	}
+	m.mut.Unlock()
+}
+
+func (m *serverMetrics) slowWrite(user, remote string, closed bool) {
+	if m == nil {
+		return
+	}
+	k := [2]string{user, remote}
+	m.mut.Lock()
+	m.slowWrites[k]++
+	if closed {
+		m.slowClosed[k]++
+	}
+	m.mut.Unlock()
+}
+
+func (m *serverMetrics) proxyRequest(status int) {
+	if m == nil {
+		return
+	}
+	atomic.AddInt64(&m.proxyRequests, 1)
+	if status >= 500 {
+		atomic.AddInt64(&m.proxyErrors, 1)
+	}
+}
+
+// histogram is a cumulative Prometheus histogram
+type histogram struct {
+	bounds []float64
+	counts []int64
+	count  int64
+	sum    float64
+}
+
+func newHistogram(bounds []float64) histogram {
+	return histogram{bounds: bounds, counts: make([]int64, len(bounds))}
+}
+
+// clone copies h, so it can be written without the lock
+func (h histogram) clone() histogram {
+	h.counts = append([]int64(nil), h.counts...)
+	return h
+}
+
+func (h *histogram) observe(v float64) {
+	for i, b := range h.bounds {
+		if v <= b {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+// metricsWriter writes the Prometheus text format
+type metricsWriter struct {
+	w io.Writer
+}
+
+func (m metricsWriter) header(name, typ, help string) {
+	fmt.Fprintf(m.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func (m metricsWriter) value(name, labels string, v interface{}) {
+	if labels != "" {
+		labels = "{" + labels + "}"
+	}
+	fmt.Fprintf(m.w, "%s%s %v\n", name, labels, v)
+}
+
+func (m metricsWriter) histogram(name, labels string, h histogram) {
+	prefix := ""
+	if labels != "" {
+		prefix = labels + ","
+	}
+	for i, b := range h.bounds {
+		m.value(name+"_bucket", fmt.Sprintf("%sle=\"%g\"", prefix, b), h.counts[i])
+	}
+	m.value(name+"_bucket", prefix+`le="+Inf"`, h.count)
+	m.value(name+"_sum", labels, h.sum)
+	m.value(name+"_count", labels, h.count)
+}
+
+// handleMetrics serves the server metrics in the Prometheus text format
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	m := metricsWriter{w}
+	sessions := s.tunnels.list()
+	perUser := map[string]int{}
+	perVersion := map[string]int{}
+	sent := atomic.LoadInt64(&s.metrics.closedSent)
+	received := atomic.LoadInt64(&s.metrics.closedReceived)
+	for _, sess := range sessions {
+		perUser[sess.user]++
+		perVersion[sess.clientVersion]++
+		sent += atomic.LoadInt64(&sess.sent)
+		received += atomic.LoadInt64(&sess.received)
+	}
+	m.header("chisel_sessions_active", "gauge", "Live tunnel sessions.")
+	m.value("chisel_sessions_active", "", len(sessions))
+	m.header("chisel_user_sessions", "gauge", "Live tunnel sessions per user.")
+	users := make([]string, 0, len(perUser))
+	for u := range perUser {
+		users = append(users, u)
+	}
+	sort.Strings(users)
+	for _, u := range users {
+		m.value("chisel_user_sessions", fmt.Sprintf("user=%q", u), perUser[u])
+	}
+	m.header("chisel_client_version_sessions", "gauge", "Live tunnel sessions per client version.")
+	versions := make([]string, 0, len(perVersion))
+	for v := range perVersion {
+		versions = append(versions, v)
+	}
+	sort.Strings(versions)
+	for _, v := range versions {
+		m.value("chisel_client_version_sessions", fmt.Sprintf("version=%q", v), perVersion[v])
+	}
+	m.header("chisel_tunnel_bytes_total", "counter", "Bytes through tunnel sessions, in from and out to clients.")
+	m.value("chisel_tunnel_bytes_total", `direction="in"`, received)
+	m.value("chisel_tunnel_bytes_total", `direction="out"`, sent)
+	m.header("chisel_session_bytes_total", "counter", "Bytes through each live tunnel session, in from and out to the client.")
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].id < sessions[j].id })
+	for _, sess := range sessions {
+		labels := fmt.Sprintf("session=\"%d\",user=%q", sess.id, sess.user)
+		m.value("chisel_session_bytes_total", labels+`,direction="in"`, atomic.LoadInt64(&sess.received))
+		m.value("chisel_session_bytes_total", labels+`,direction="out"`, atomic.LoadInt64(&sess.sent))
+	}
+	s.metrics.mut.Lock()
+	handshakes := s.metrics.handshakes.clone()
+	upgrades := s.metrics.upgrades.clone()
+	keyExchange := s.metrics.keyExchange.clone()
+	authOK := s.metrics.authOK.clone()
+	authFailed := s.metrics.authFailed.clone()
+	slowWrites := labelCounts(s.metrics.slowWrites)
+	slowClosed := labelCounts(s.metrics.slowClosed)
+	refused := map[string]int64{}
+	for limit, n := range s.metrics.refused {
+		refused[limit] = n
+	}
+	remoteTotals := make(map[string][2]int64, len(s.metrics.closedRemotes))
+	for remote, t := range s.metrics.closedRemotes {
+		remoteTotals[remote] = t
+	}
+	s.metrics.mut.Unlock()
+	for _, sess := range sessions {
+		if sess.tunnel == nil {
+			continue
+		}
+		for remote, c := range sess.tunnel.Traffic() {
+			t := remoteTotals[remote]
+			remoteTotals[remote] = [2]int64{t[0] + c.Sent(), t[1] + c.Received()}
+		}
+	}
+	remotes := make([]string, 0, len(remoteTotals))
+	for remote := range remoteTotals {
+		remotes = append(remotes, remote)
+	}
+	sort.Strings(remotes)
+	m.header("chisel_remote_bytes_total", "counter", "Bytes through the channels of each remote, in from and out to clients.")
+	for _, remote := range remotes {
+		m.value("chisel_remote_bytes_total", fmt.Sprintf("remote=%q,direction=\"in\"", remote), remoteTotals[remote][1])
+		m.value("chisel_remote_bytes_total", fmt.Sprintf("remote=%q,direction=\"out\"", remote), remoteTotals[remote][0])
+	}
+	m.header("chisel_slow_writes_total", "counter", "Writes to tunnel connections which exceeded --slow-write, by user and remote.")
+	for _, c := range slowWrites {
+		m.value("chisel_slow_writes_total", c.labels, c.count)
+	}
+	m.header("chisel_slow_consumers_closed_total", "counter", "Tunnel connections closed for slow writes, by user and remote.")
+	for _, c := range slowClosed {
+		m.value("chisel_slow_consumers_closed_total", c.labels, c.count)
+	}
+	m.header("chisel_sessions_refused_total", "counter", "Tunnel sessions refused by --max-sessions and --max-user-sessions, or while shutting down, by limit.")
+	for _, limit := range []string{"sessions", "user"} {
+		m.value("chisel_sessions_refused_total", fmt.Sprintf("limit=%q", limit), refused[limit])
+	}
+	m.header("chisel_handshake_duration_seconds", "histogram", "Time from websocket upgrade to an accepted tunnel config.")
+	m.histogram("chisel_handshake_duration_seconds", "", handshakes)
+	m.header("chisel_websocket_upgrade_duration_seconds", "histogram", "Time to upgrade a tunnel request to a websocket.")
+	m.histogram("chisel_websocket_upgrade_duration_seconds", "", upgrades)
+	m.header("chisel_ssh_handshake_duration_seconds", "histogram", "Time of the SSH handshake of a tunnel, excluding auth.")
+	m.histogram("chisel_ssh_handshake_duration_seconds", "", keyExchange)
+	m.header("chisel_auth_duration_seconds", "histogram", "Time of tunnel auth calls, by result.")
+	m.histogram("chisel_auth_duration_seconds", `result="ok"`, authOK)
+	m.histogram("chisel_auth_duration_seconds", `result="fail"`, authFailed)
+	m.header("chisel_proxies", "gauge", "Registered dynamic proxies.")
+	m.value("chisel_proxies", "", s.dynamicReverseProxies.Len())
+	m.header("chisel_proxy_requests_total", "counter", "Requests served by dynamic proxies.")
+	m.value("chisel_proxy_requests_total", "", atomic.LoadInt64(&s.metrics.proxyRequests))
+	m.header("chisel_proxy_request_errors_total", "counter", "Dynamic proxy requests answered with a 5xx status.")
+	m.value("chisel_proxy_request_errors_total", "", atomic.LoadInt64(&s.metrics.proxyErrors))
+	rpc := s.rpcMetrics.Snapshot()
+	methods := make([]string, 0, len(rpc))
+	for name := range rpc {
+		methods = append(methods, name)
+	}
+	sort.Strings(methods)
+	m.header("chisel_dcrpc_calls_total", "counter", "dcrpc calls to dcmaster.")
+	for _, name := range methods {
+		m.value("chisel_dcrpc_calls_total", fmt.Sprintf("method=%q", name), rpc[name].Calls)
+	}
+	m.header("chisel_dcrpc_errors_total", "counter", "dcrpc calls to dcmaster which failed.")
+	for _, name := range methods {
+		m.value("chisel_dcrpc_errors_total", fmt.Sprintf("method=%q", name), rpc[name].Errors)
+	}
+	m.header("chisel_dcrpc_call_duration_seconds_sum", "counter", "Total time spent in dcrpc calls to dcmaster.")
+	for _, name := range methods {
+		m.value("chisel_dcrpc_call_duration_seconds_sum", fmt.Sprintf("method=%q", name), rpc[name].Latency.Seconds())
+	}
+}
+
+// labelCount is a counter with its user and remote labels
+type labelCount struct {
+	labels string
+	count  int64
+}
+
+// labelCounts sorts counts keyed by user and remote
+func labelCounts(counts map[[2]string]int64) []labelCount {
+	out := make([]labelCount, 0, len(counts))
+	for k, n := range counts {
+		out = append(out, labelCount{fmt.Sprintf("user=%q,remote=%q", k[0], k[1]), n})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].labels < out[j].labels })
+	return out
+}
+
+// serveAdmin serves the admin endpoints on addr until ctx is cancelled
+func (s *Server) serveAdmin(ctx context.Context, addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", s.handleMetrics)
+	mux.HandleFunc("/healthz", s.handleHealthz)
+	mux.HandleFunc("/readyz", s.handleReadyz)
+	mux.HandleFunc("/api/version", s.handleAPIVersion)
+	if s.config.AdminToken != "" {
+		debug := http.NewServeMux()
+		debug.HandleFunc("/debug/pprof/", pprof.Index)
+		debug.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		debug.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		debug.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		debug.HandleFunc("/debug/pprof/trace", pprof.Trace)
+		debug.Handle("/debug/vars", expvar.Handler())
+		mux.Handle("/debug/", s.adminAuth(debug))
+		api := s.adminAuth(http.HandlerFunc(s.handleAPISessions))
+		mux.Handle("/api/sessions", api)
+		mux.Handle("/api/sessions/", api)
+		mux.Handle("/api/proxies", s.adminAuth(http.HandlerFunc(s.handleAPIProxies)))
+		mux.Handle("/api/log-levels", s.adminAuth(http.HandlerFunc(s.handleAPILogLevels)))
+		mux.Handle("/api/proxy-debug", s.adminAuth(http.HandlerFunc(s.handleAPIProxyDebug)))
+		mux.Handle("/api/proxy-audit", s.adminAuth(http.HandlerFunc(s.handleAPIProxyAudit)))
+		mux.Handle("/api/status", s.adminAuth(http.HandlerFunc(s.handleAPIStatus)))
+		mux.Handle("/api/connections", s.adminAuth(http.HandlerFunc(s.handleAPIConnections)))
+		mux.Handle("/api/auth-failures", s.adminAuth(http.HandlerFunc(s.handleAPIAuthFailures)))
+		mux.Handle("/api/port-pools", s.adminAuth(http.HandlerFunc(s.handleAPIPortPools)))
+		mux.Handle("/ui/", s.adminAuth(http.HandlerFunc(s.handleDashboard)))
+	} else {
+		s.Infof("Admin server has no ADMIN_TOKEN, /debug, /api and /ui are disabled")
+	}
+	srv := &http.Server{Handler: mux}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+	go srv.Serve(l)
+	s.adminAddr = l.Addr().String()
+	s.Infof("Admin server listening on %s", s.adminAddr)
+	return nil
+}
+
+// adminAuth requires the ADMIN_TOKEN as a bearer token or, so
+// browsers can open the dashboard, as a basic auth password
+func (s *Server) adminAuth(next http.Handler) http.Handler {
+	token := []byte(s.config.AdminToken)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if _, pass, ok := r.BasicAuth(); ok {
+			given = pass
+		}
+		if subtle.ConstantTimeCompare([]byte(given), token) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="chisel admin"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,126 @@
+package chserver
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jpillora/chisel/share/cio"
+	"github.com/jpillora/chisel/share/craveauth"
+)
+
+func TestMetrics(t *testing.T) {
+	s := &Server{
+		Logger:                cio.NewLogger("server"),
+		config:                &Config{},
+		dynamicReverseProxies: NewProxyStore(),
+		tunnels:               newSessionStore(),
+		metrics:               newServerMetrics(),
+		rpcMetrics:            craveauth.NewRPCMetrics(),
+	}
+	s.tunnels.add(&session{id: 1, user: "alice", clientVersion: "1.9.0", sent: 10, received: 20})
+	s.tunnels.add(&session{id: 2, user: "alice", sent: 1, received: 2})
+	closed := &session{id: 3, user: "bob", sent: 100, received: 200}
+	s.metrics.sessionClosed(closed)
+	s.metrics.handshake(30 * time.Millisecond)
+	s.metrics.upgrade(2 * time.Millisecond)
+	s.metrics.sshHandshake(20 * time.Millisecond)
+	s.metrics.auth(200*time.Millisecond, nil)
+	s.metrics.auth(time.Second, errors.New("denied"))
+	s.metrics.slowWrite("alice", "R:8080", false)
+	s.metrics.slowWrite("alice", "R:8080", true)
+	s.metrics.proxyRequest(200)
+	s.metrics.proxyRequest(502)
+	s.dynamicReverseProxies.Add("docs", &DynamicReverseProxy{Id: "docs"})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := s.serveAdmin(ctx, "127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Get("http://" + s.adminAddr + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, _ := ioutil.ReadAll(resp.Body)
+	body := string(b)
+	for _, line := range []string{
+		"chisel_sessions_active 2",
+		`chisel_user_sessions{user="alice"} 2`,
+		`chisel_client_version_sessions{version="1.9.0"} 1`,
+		`chisel_tunnel_bytes_total{direction="in"} 222`,
+		`chisel_tunnel_bytes_total{direction="out"} 111`,
+		`chisel_session_bytes_total{session="1",user="alice",direction="in"} 20`,
+		`chisel_session_bytes_total{session="2",user="alice",direction="out"} 1`,
+		`chisel_handshake_duration_seconds_bucket{le="0.01"} 0`,
+		`chisel_handshake_duration_seconds_bucket{le="0.05"} 1`,
+		"chisel_handshake_duration_seconds_count 1",
+		`chisel_websocket_upgrade_duration_seconds_bucket{le="0.005"} 1`,
+		`chisel_ssh_handshake_duration_seconds_bucket{le="0.01"} 0`,
+		`chisel_ssh_handshake_duration_seconds_bucket{le="0.05"} 1`,
+		`chisel_auth_duration_seconds_bucket{result="ok",le="0.25"} 1`,
+		`chisel_auth_duration_seconds_bucket{result="fail",le="0.5"} 0`,
+		`chisel_auth_duration_seconds_count{result="fail"} 1`,
+		`chisel_slow_writes_total{user="alice",remote="R:8080"} 2`,
+		`chisel_slow_consumers_closed_total{user="alice",remote="R:8080"} 1`,
+		"chisel_proxies 1",
+		"chisel_proxy_requests_total 2",
+		"chisel_proxy_request_errors_total 1",
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Fatalf("expected %q in:\n%s", line, body)
+		}
+	}
+}
+
+func TestAdminDebug(t *testing.T) {
+	s := &Server{
+		Logger: cio.NewLogger("server"),
+		config: &Config{AdminToken: "secret"},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := s.serveAdmin(ctx, "127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	get := func(path, token string) int {
+		req, _ := http.NewRequest("GET", "http://"+s.adminAddr+path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	for path, want := range map[string]int{"/debug/vars": 200, "/debug/pprof/goroutine?debug=1": 200} {
+		if code := get(path, ""); code != http.StatusUnauthorized {
+			t.Fatalf("%s: expected 401 without a token, got %d", path, code)
+		}
+		if code := get(path, "wrong"); code != http.StatusUnauthorized {
+			t.Fatalf("%s: expected 401 with a wrong token, got %d", path, code)
+		}
+		if code := get(path, "secret"); code != want {
+			t.Fatalf("%s: expected %d, got %d", path, want, code)
+		}
+	}
+}
+
+func TestSessionSample(t *testing.T) {
+	start := time.Now()
+	sess := &session{startedAt: start}
+	sess.sent, sess.received = 1000, 500
+	sess.sample(start.Add(time.Second))
+	sess.sent, sess.received = 1500, 500
+	sess.sample(start.Add(3 * time.Second))
+	rate, _ := sess.rates()
+	if rate.sendRate != 250 || rate.receiveRate != 0 {
+		t.Fatalf("expected 250B/s sent and 0B/s received, got %v and %v", rate.sendRate, rate.receiveRate)
+	}
+}
@@ -0,0 +1,470 @@
+package chserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jpillora/chisel/share/cdb"
+	"github.com/jpillora/chisel/share/settings"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// persistInterval is how often the saved state is
+// written, when it has changed
+const persistInterval = time.Second
+
+// savedState is the bookkeeping restored after a restart,
+// so reconnecting clients are given the same ports and routes.
+// Passwords and authkeys are only saved as hashes, the state
+// file is still written readable by its owner only.
+type savedState struct {
+	//Ports are the ports last allocated to the
+	//ephemeral and pool remotes of each user
+	Ports   map[string]string `json:"ports,omitempty"`
+	Users   []savedUser       `json:"users,omitempty"`
+	Proxies []savedProxy      `json:"proxies,omitempty"`
+}
+
+// savedUser is a user added at runtime, see AddUser. Pass
+// is only read from states saved before PassHash.
+type savedUser struct {
+	Name         string   `json:"name"`
+	Pass         string   `json:"pass,omitempty"`
+	PassHash     []byte   `json:"pass_hash,omitempty"`
+	Addrs        []string `json:"addrs,omitempty"`
+	ReversePorts []string `json:"reverse_ports,omitempty"`
+	Push         []string `json:"push,omitempty"`
+}
+
+// savedProxy is a dynamic proxy registered over http. AuthKey
+// is only read from states saved before AuthKeyHash.
+type savedProxy struct {
+	Id           string     `json:"id"`
+	Data         ProxyData  `json:"data"`
+	AuthKey      []byte     `json:"authkey,omitempty"`
+	AuthKeyHash  []byte     `json:"authkey_hash,omitempty"`
+	User         int64      `json:"user_id"`
+	JobId        int64      `json:"job_id"`
+	RegisteredBy proxyActor `json:"registered_by"`
+	Created      time.Time  `json:"created"`
+}
+
+// persistence keeps the savedState of the server in its store
+type persistence struct {
+	store stateStore
+	mut   sync.Mutex
+	ports map[string]string
+	users map[string]savedUser
+	//proxies are those saved but not restored,
+	//kept for the next restart
+	proxies []savedProxy
+	//last is the state last saved
+	last []byte
+}
+
+// stateStore is where the savedState is kept, between restarts
+type stateStore interface {
+	load(ctx context.Context) ([]byte, error)
+	save(ctx context.Context, b []byte) error
+	String() string
+}
+
+// newPersistence opens the store of spec, "file:<path>" or "postgres"
+func newPersistence(spec string, db *cdb.DB, server string) (*persistence, error) {
+	p := &persistence{ports: map[string]string{}, users: map[string]savedUser{}}
+	switch {
+	case strings.HasPrefix(spec, "file:"):
+		p.store = stateFile(strings.TrimPrefix(spec, "file:"))
+	case spec == "postgres":
+		if db == nil {
+			return nil, errors.New("State store postgres requires a database")
+		}
+		p.store = &stateDB{db: db, server: server}
+	default:
+		return nil, fmt.Errorf("Unknown state store (%s), expected file:<path> or postgres", spec)
+	}
+	return p, nil
+}
+
+// load reads the saved state
+func (p *persistence) load(ctx context.Context) (*savedState, error) {
+	b, err := p.store.load(ctx)
+	if err != nil || len(b) == 0 {
+		return &savedState{}, err
+	}
+	state := &savedState{}
+	if err := json.Unmarshal(b, state); err != nil {
+		return &savedState{}, fmt.Errorf("Invalid saved state: %s", err)
+	}
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	for k, port := range state.Ports {
+		p.ports[k] = port
+	}
+	//states saved before hashing are hashed as they load
+	for i, u := range state.Users {
+		if u.Pass != "" {
+			hash, err := bcrypt.GenerateFromPassword([]byte(u.Pass), bcrypt.DefaultCost)
+			if err != nil {
+				return &savedState{}, err
+			}
+			u.Pass, u.PassHash = "", hash
+			state.Users[i] = u
+		}
+		p.users[u.Name] = u
+	}
+	for i, sp := range state.Proxies {
+		if len(sp.AuthKey) > 0 {
+			state.Proxies[i].AuthKeyHash = hashAuthKey(sp.AuthKey)
+			state.Proxies[i].AuthKey = nil
+		}
+	}
+	p.proxies = state.Proxies
+	p.last = b
+	return state, nil
+}
+
+func portKey(user string, r *settings.Remote) string {
+	return user + " " + r.String()
+}
+
+// port is the port last allocated to the user's remote
+func (p *persistence) port(user string, r *settings.Remote) string {
+	if p == nil {
+		return ""
+	}
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	return p.ports[portKey(user, r)]
+}
+
+// allocated records the port allocated to the user's remote
+func (p *persistence) allocated(user string, r *settings.Remote, port string) {
+	if p == nil {
+		return
+	}
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	p.ports[portKey(user, r)] = port
+}
+
+func (p *persistence) addUser(u *settings.User) {
+	if p == nil {
+		return
+	}
+	saved := savedUser{Name: u.Name, PassHash: u.PassHash, Push: u.Pushed.Encode()}
+	if u.Pass != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(u.Pass), bcrypt.DefaultCost)
+		if err != nil {
+			return
+		}
+		saved.PassHash = hash
+	}
+	for _, a := range u.Addrs {
+		saved.Addrs = append(saved.Addrs, a.String())
+	}
+	for _, r := range u.ReversePorts {
+		saved.ReversePorts = append(saved.ReversePorts, r.String())
+	}
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	p.users[u.Name] = saved
+}
+
+func (p *persistence) delUser(name string) {
+	if p == nil {
+		return
+	}
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	delete(p.users, name)
+}
+
+// user rebuilds a saved user
+func (u savedUser) user() (*settings.User, error) {
+	user := &settings.User{Name: u.Name, Pass: u.Pass, PassHash: u.PassHash}
+	for _, a := range u.Addrs {
+		re, err := regexp.Compile(a)
+		if err != nil {
+			return nil, err
+		}
+		user.Addrs = append(user.Addrs, re)
+	}
+	for _, s := range u.ReversePorts {
+		r, err := settings.ParsePortRange(s)
+		if err != nil {
+			return nil, err
+		}
+		user.ReversePorts = append(user.ReversePorts, r)
+	}
+	pushed, err := settings.DecodePushed(u.Push)
+	if err != nil {
+		return nil, err
+	}
+	user.Pushed = pushed
+	return user, nil
+}
+
+// restoreState restores the users of the saved state, its
+// proxies are restored by restoreProxies, once dcmaster is known
+func (s *Server) restoreState(state *savedState) {
+	for _, u := range state.Users {
+		user, err := u.user()
+		if err != nil {
+			s.Infof("Failed to restore user %s: %s", u.Name, err)
+			s.persist.delUser(u.Name)
+			continue
+		}
+		s.users.AddUser(user)
+	}
+	s.Infof("Restored state from %s (%d ports, %d users, %d proxies)",
+		s.persist.store, len(state.Ports), len(state.Users), len(state.Proxies))
+}
+
+// restoreProxies registers the saved proxies again, those
+// which fail are kept in the saved state for the next restart
+func (s *Server) restoreProxies(ctx context.Context) {
+	s.persist.mut.Lock()
+	saved := s.persist.proxies
+	s.persist.mut.Unlock()
+	var failed []savedProxy
+	for _, sp := range saved {
+		if err := s.restoreProxy(ctx, sp); err != nil {
+			s.proxyLog().Infof("Failed to restore proxy %s: %s", sp.Id, err)
+			failed = append(failed, sp)
+		}
+	}
+	s.persist.mut.Lock()
+	s.persist.proxies = failed
+	s.persist.mut.Unlock()
+}
+
+// restoreProxy registers a saved proxy as createDynamicProxy did
+func (s *Server) restoreProxy(ctx context.Context, sp savedProxy) error {
+	if _, ok := s.dynamicReverseProxies.Get(sp.Id); ok {
+		return nil
+	}
+	pd := sp.Data
+	u, err := url.Parse(pd.Target)
+	if err != nil {
+		return err
+	}
+	proxyType, ok := getProxyType(pd.ProxyType)
+	if !ok {
+		return fmt.Errorf("Unknown proxy type (%s)", pd.ProxyType)
+	}
+	drProxy := &DynamicReverseProxy{
+		Id:            sp.Id,
+		authKeyHash:   sp.AuthKeyHash,
+		Target:        pd.Target,
+		User:          sp.User,
+		JobId:         sp.JobId,
+		ServicePrefix: pd.ServicePrefix,
+		ProxyType:     pd.ProxyType,
+		Subdomain:     pd.Subdomain,
+		Access:        pd.Access,
+		AccessLog:     pd.AccessLog,
+		RegisteredBy:  sp.RegisteredBy,
+		Created:       sp.Created,
+		drain:         newProxyDrain(),
+		data:          &pd,
+	}
+	if err := s.parseProxyOptions(&pd, drProxy); err != nil {
+		return err
+	}
+	if err := s.checkProxyHost(sp.Id, drProxy.Subdomain); err != nil {
+		return err
+	}
+	if err := s.checkResourceAvailableDcMaster(ctx, drProxy, sp.Id, true); err != nil {
+		return err
+	}
+	drProxy.Handler, err = s.newDynamicProxyHandler(proxyType, &pd, drProxy, u)
+	if err != nil {
+		s.disconnectResourceDcMaster(drProxy)
+		return err
+	}
+	if drProxy.Subdomain != "" && !s.proxyHosts.claim(drProxy.Subdomain, sp.Id) {
+		s.disconnectResourceDcMaster(drProxy)
+		return fmt.Errorf("subdomain (%s) already in use", drProxy.Subdomain)
+	}
+	s.dynamicReverseProxies.Add(sp.Id, drProxy)
+	s.events.proxyAdded(drProxy)
+	s.notifyProxy(eventProxyRegistered, sp.Id, drProxy, proxyActor{Identity: "state", Reason: "restored"})
+	return nil
+}
+
+// snapshotState is the state to save
+func (s *Server) snapshotState() savedState {
+	p := s.persist
+	p.mut.Lock()
+	state := savedState{Ports: map[string]string{}, Proxies: append([]savedProxy{}, p.proxies...)}
+	for k, port := range p.ports {
+		state.Ports[k] = port
+	}
+	for _, u := range p.users {
+		state.Users = append(state.Users, u)
+	}
+	p.mut.Unlock()
+	//proxies of the proxies file and dcrpc are registered again by them
+	s.dynamicReverseProxies.Range(func(pId string, d *DynamicReverseProxy) bool {
+		if d.Source == "" && d.data != nil {
+			state.Proxies = append(state.Proxies, savedProxy{
+				Id:           pId,
+				Data:         *d.data,
+				AuthKeyHash:  d.savedAuthKey(),
+				User:         d.User,
+				JobId:        d.JobId,
+				RegisteredBy: d.RegisteredBy,
+				Created:      d.Created,
+			})
+		}
+		return true
+	})
+	return state
+}
+
+// sortSavedState orders the lists of the state, so
+// unchanged states are saved once
+func sortSavedState(state *savedState) {
+	sort.Slice(state.Users, func(i, j int) bool { return state.Users[i].Name < state.Users[j].Name })
+	sort.Slice(state.Proxies, func(i, j int) bool { return state.Proxies[i].Id < state.Proxies[j].Id })
+}
+
+// saveState writes the state whenever it changes, and
+// once more as the server stops
+func (s *Server) saveState(ctx context.Context, interval time.Duration) {
+	save := func(ctx context.Context) {
+		state := s.snapshotState()
+		sortSavedState(&state)
+		b, _ := json.Marshal(state)
+		s.persist.mut.Lock()
+		unchanged := bytes.Equal(b, s.persist.last)
+		s.persist.mut.Unlock()
+		if unchanged {
+			return
+		}
+		if err := s.persist.store.save(ctx, b); err != nil {
+			s.Infof("Failed to save state to %s: %s", s.persist.store, err)
+			return
+		}
+		s.persist.mut.Lock()
+		s.persist.last = b
+		s.persist.mut.Unlock()
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			final, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			save(final)
+			cancel()
+			return
+		case <-ticker.C:
+			save(ctx)
+		}
+	}
+}
+
+// stateFile saves the state in a file, replaced atomically
+type stateFile string
+
+func (f stateFile) load(ctx context.Context) ([]byte, error) {
+	b, err := ioutil.ReadFile(string(f))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return b, err
+}
+
+func (f stateFile) save(ctx context.Context, b []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(string(f)), ".chisel-state-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	//the state holds the routes of every user
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), string(f))
+}
+
+func (f stateFile) String() string {
+	return "file:" + string(f)
+}
+
+// stateDB saves the state of each server in the
+// chisel_saved_state table
+type stateDB struct {
+	db       *cdb.DB
+	server   string
+	mut      sync.Mutex
+	migrated bool
+}
+
+// ready creates the table, until it succeeds
+func (d *stateDB) ready(ctx context.Context) error {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+	if d.migrated {
+		return nil
+	}
+	if err := d.db.Migrate(ctx, migrations); err != nil {
+		return err
+	}
+	d.migrated = true
+	return nil
+}
+
+func (d *stateDB) load(ctx context.Context) ([]byte, error) {
+	ctx = cdb.WithSubsystem(ctx, "state")
+	if err := d.ready(ctx); err != nil {
+		return nil, err
+	}
+	var b []byte
+	err := d.db.QueryRow(ctx, "SELECT state FROM chisel_saved_state WHERE server = $1", d.server).Scan(&b)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	return b, err
+}
+
+func (d *stateDB) save(ctx context.Context, b []byte) error {
+	ctx = cdb.WithSubsystem(ctx, "state")
+	if err := d.ready(ctx); err != nil {
+		return err
+	}
+	_, err := d.db.Exec(ctx, `INSERT INTO chisel_saved_state (server, state, saved_at) VALUES ($1, $2, now())
+		ON CONFLICT (server) DO UPDATE SET state = EXCLUDED.state, saved_at = EXCLUDED.saved_at`, d.server, string(b))
+	return err
+}
+
+func (d *stateDB) String() string {
+	return "postgres"
+}
@@ -0,0 +1,114 @@
+package chserver
+
+import (
+	"context"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jpillora/chisel/share/cio"
+	"github.com/jpillora/chisel/share/settings"
+)
+
+func TestPersistState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chisel-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	spec := "file:" + filepath.Join(dir, "state.json")
+	//a server, restarted with the state of the previous one
+	start := func() *Server {
+		s := &Server{
+			Logger:                cio.NewLogger("server"),
+			config:                &Config{Reverse: true},
+			users:                 settings.NewUserIndex(cio.NewLogger("server")),
+			dynamicReverseProxies: NewProxyStore(),
+		}
+		if s.persist, err = newPersistence(spec, nil, ""); err != nil {
+			t.Fatal(err)
+		}
+		state, err := s.persist.load(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.restoreState(state)
+		return s
+	}
+	s := start()
+	if err := s.AddUser("bob", "pw", "^R:"); err != nil {
+		t.Fatal(err)
+	}
+	s.dynamicReverseProxies.Add("p1", &DynamicReverseProxy{Id: "p1", User: 7, AuthKey: []byte("token"), data: &ProxyData{Target: "http://10.0.0.1:80"}})
+	s.dynamicReverseProxies.Add("p2", &DynamicReverseProxy{Id: "p2", Source: "file:proxies.yml"})
+	r, err := settings.DecodeRemote("R:127.0.0.1:0:localhost:22")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reply settings.ConfigReply
+	b, err := s.allocateRemote(s.Logger, nil, &session{id: 1, user: "bob"}, r, &reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.saveState(ctx, time.Hour)
+		close(done)
+	}()
+	cancel()
+	<-done
+	//secrets are only saved as hashes, readable by the owner
+	path := filepath.Join(dir, "state.json")
+	saved, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(saved), `"pw"`) || strings.Contains(string(saved), base64.StdEncoding.EncodeToString([]byte("token"))) {
+		t.Fatalf("expected no plaintext secrets, got %s", saved)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("expected the state to be private, got %v %v", info.Mode(), err)
+	}
+	//restarted, with the users, proxies and ports
+	s = start()
+	if u, ok := s.users.Get("bob"); !ok || u.Pass != "" || !u.CheckPassword([]byte("pw")) || u.CheckPassword([]byte("pw2")) || !u.HasAccess("R:0.0.0.0:22") {
+		t.Fatalf("expected bob to be restored, got %+v", u)
+	}
+	if len(s.persist.proxies) != 1 || s.persist.proxies[0].Id != "p1" || s.persist.proxies[0].User != 7 {
+		t.Fatalf("expected the http proxy to be saved, got %+v", s.persist.proxies)
+	}
+	restored := &DynamicReverseProxy{authKeyHash: s.persist.proxies[0].AuthKeyHash}
+	if !restored.hasAuthKey([]byte("token")) || restored.hasAuthKey([]byte("other")) {
+		t.Fatal("expected the restored proxy to accept only its authkey")
+	}
+	a, err := s.allocateRemote(s.Logger, nil, &session{id: 1, user: "bob"}, r, &reply)
+	if err != nil || a.LocalPort != b.LocalPort {
+		t.Fatalf("expected port %s again, got %v %v", b.LocalPort, a, err)
+	}
+	//deleted users are no longer restored
+	s.DeleteUser("bob")
+	if state := s.snapshotState(); len(state.Users) != 0 {
+		t.Fatalf("expected no users, got %+v", state.Users)
+	}
+	//states saved in plaintext are hashed as they load
+	legacy := `{"users":[{"name":"carol","pass":"secret"}],"proxies":[{"id":"p3","data":{},"authkey":"dG9rZW4="}]}`
+	if err := ioutil.WriteFile(path, []byte(legacy), 0600); err != nil {
+		t.Fatal(err)
+	}
+	s = start()
+	if u, ok := s.users.Get("carol"); !ok || !u.CheckPassword([]byte("secret")) {
+		t.Fatalf("expected carol to be restored, got %+v", u)
+	}
+	if state := s.snapshotState(); state.Users[0].Pass != "" || state.Proxies[0].AuthKey != nil ||
+		!(&DynamicReverseProxy{authKeyHash: state.Proxies[0].AuthKeyHash}).hasAuthKey([]byte("token")) {
+		t.Fatalf("expected hashed secrets, got %+v", state)
+	}
+	if _, err := newPersistence("etcd://x", nil, ""); err == nil {
+		t.Fatal("expected an unknown store to be invalid")
+	}
+}
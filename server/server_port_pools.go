@@ -0,0 +1,182 @@
+package chserver
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jpillora/chisel/share/settings"
+)
+
+// portPools are the named ranges of server ports of --port-pool,
+// which reverse remotes such as R:@jobs:3000 are allocated from
+type portPools struct {
+	mut    sync.Mutex
+	ranges map[string][]settings.PortRange
+	//owners are the sessions of the allocated ports
+	owners map[int]portOwner
+}
+
+// portOwner is the session a port of a pool is allocated to
+type portOwner struct {
+	pool    string
+	session int32
+	remote  string
+	since   time.Time
+}
+
+var poolSpec = regexp.MustCompile(`^([\w-]+):(.+)$`)
+
+// newPortPools parses pools like "jobs:30000-32000", the
+// ranges of a pool are comma separated or repeated
+func newPortPools(specs []string) (*portPools, error) {
+	p := &portPools{
+		ranges: map[string][]settings.PortRange{},
+		owners: map[int]portOwner{},
+	}
+	for _, spec := range specs {
+		m := poolSpec.FindStringSubmatch(spec)
+		if m == nil {
+			return nil, fmt.Errorf("Invalid port pool '%s', expected a name and ports like jobs:30000-32000", spec)
+		}
+		for _, s := range strings.Split(m[2], ",") {
+			r, err := settings.ParsePortRange(s)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid port pool '%s': %s", spec, err)
+			}
+			p.ranges[m[1]] = append(p.ranges[m[1]], r)
+		}
+	}
+	return p, nil
+}
+
+func (p *portPools) has(pool string) bool {
+	if p == nil {
+		return false
+	}
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	_, ok := p.ranges[pool]
+	return ok
+}
+
+// allocate picks the preferred port, else the first free port of
+// the remote's pool which it can listen on, owned by the session
+// until released
+func (p *portPools) allocate(session int32, r *settings.Remote, prefer string) (string, error) {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	pool := r.Pool()
+	free := func(port int) bool {
+		if _, owned := p.owners[port]; owned {
+			return false
+		}
+		b := *r
+		b.LocalPort = strconv.Itoa(port)
+		return b.CanListen()
+	}
+	take := func(port int) string {
+		p.owners[port] = portOwner{pool: pool, session: session, remote: r.String(), since: time.Now()}
+		return strconv.Itoa(port)
+	}
+	if n, err := strconv.Atoi(prefer); err == nil {
+		for _, pr := range p.ranges[pool] {
+			if pr.Contains(n) && free(n) {
+				return take(n), nil
+			}
+		}
+	}
+	for _, pr := range p.ranges[pool] {
+		for port := pr.From; port <= pr.To; port++ {
+			if free(port) {
+				return take(port), nil
+			}
+		}
+	}
+	return "", fmt.Errorf("pool %s has no free ports", pool)
+}
+
+// release frees a port allocated to the session, others are ignored
+func (p *portPools) release(session int32, port string) {
+	if p == nil {
+		return
+	}
+	n, _ := strconv.Atoi(port)
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	if o, ok := p.owners[n]; ok && o.session == session {
+		delete(p.owners, n)
+	}
+}
+
+// releaseSession frees the ports of a closed session
+func (p *portPools) releaseSession(session int32) {
+	if p == nil {
+		return
+	}
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	for port, o := range p.owners {
+		if o.session == session {
+			delete(p.owners, port)
+		}
+	}
+}
+
+// apiPortPool is a pool as listed by /api/port-pools
+type apiPortPool struct {
+	Name      string        `json:"name"`
+	Ports     []string      `json:"ports"`
+	Size      int           `json:"size"`
+	Used      int           `json:"used"`
+	Allocated []apiPoolPort `json:"allocated"`
+}
+
+// apiPoolPort is a port of a pool and the session it is allocated to
+type apiPoolPort struct {
+	Port      int       `json:"port"`
+	SessionID int32     `json:"session_id"`
+	User      string    `json:"user"`
+	Remote    string    `json:"remote"`
+	Since     time.Time `json:"since"`
+}
+
+// list returns the pools by name, with their allocated ports
+func (p *portPools) list(users func(session int32) string) []apiPortPool {
+	out := []apiPortPool{}
+	if p == nil {
+		return out
+	}
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	byName := map[string]*apiPortPool{}
+	for name, ranges := range p.ranges {
+		a := &apiPortPool{Name: name, Allocated: []apiPoolPort{}}
+		for _, r := range ranges {
+			a.Ports = append(a.Ports, r.String())
+			a.Size += r.To - r.From + 1
+		}
+		byName[name] = a
+	}
+	for port, o := range p.owners {
+		a := byName[o.pool]
+		a.Used++
+		a.Allocated = append(a.Allocated, apiPoolPort{
+			Port:      port,
+			SessionID: o.session,
+			User:      users(o.session),
+			Remote:    o.remote,
+			Since:     o.since,
+		})
+	}
+	for _, a := range byName {
+		sort.Slice(a.Allocated, func(i, j int) bool { return a.Allocated[i].Port < a.Allocated[j].Port })
+		out = append(out, *a)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
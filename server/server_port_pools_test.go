@@ -0,0 +1,70 @@
+package chserver
+
+import (
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/jpillora/chisel/share/settings"
+)
+
+func TestPortPools(t *testing.T) {
+	if _, err := newPortPools([]string{"jobs"}); err == nil {
+		t.Fatal("expected a pool without ports to be invalid")
+	}
+	if _, err := newPortPools([]string{"jobs:2-1"}); err == nil {
+		t.Fatal("expected an invalid range")
+	}
+	//a port in use, and the two after it
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	port := l.Addr().(*net.TCPAddr).Port
+	p, err := newPortPools([]string{"jobs:" + strconv.Itoa(port) + "-" + strconv.Itoa(port+1), "jobs:" + strconv.Itoa(port+2)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.has("jobs") || p.has("other") {
+		t.Fatal("expected the jobs pool only")
+	}
+	r, err := settings.DecodeRemote("R:127.0.0.1:@jobs:3000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := p.allocate(1, r, "")
+	if err != nil || a != strconv.Itoa(port+1) {
+		t.Fatalf("expected the first free port, got %s %v", a, err)
+	}
+	b, err := p.allocate(2, r, "")
+	if err != nil || b != strconv.Itoa(port+2) {
+		t.Fatalf("expected the next port, got %s %v", b, err)
+	}
+	if _, err := p.allocate(3, r, ""); err == nil {
+		t.Fatal("expected the pool to be exhausted")
+	}
+	pools := p.list(func(id int32) string { return "user" + strconv.Itoa(int(id)) })
+	if len(pools) != 1 || pools[0].Size != 3 || pools[0].Used != 2 ||
+		pools[0].Allocated[0].SessionID != 1 || pools[0].Allocated[1].User != "user2" {
+		t.Fatalf("unexpected pools %+v", pools)
+	}
+	//only the owner frees a port, and closed sessions free theirs
+	p.release(2, a)
+	p.releaseSession(1)
+	if c, err := p.allocate(3, r, ""); err != nil || c != a {
+		t.Fatalf("expected the freed port, got %s %v", c, err)
+	}
+	p.release(2, b)
+	if pools := p.list(func(int32) string { return "" }); pools[0].Used != 1 {
+		t.Fatalf("expected one port in use, got %+v", pools)
+	}
+	//a port of a previous allocation is preferred
+	p.release(3, a)
+	if c, err := p.allocate(4, r, b); err != nil || c != b {
+		t.Fatalf("expected the preferred port, got %s %v", c, err)
+	}
+	if c, err := p.allocate(5, r, b); err != nil || c != a {
+		t.Fatalf("expected a free port in place of the owned one, got %s %v", c, err)
+	}
+}
@@ -0,0 +1,133 @@
+package chserver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jpillora/chisel/share/ctrace"
+	"google.golang.org/grpc/peer"
+)
+
+// maxProxyAudit is how many proxy changes are kept
+const maxProxyAudit = 1000
+
+// proxyActor is who registered or removed a proxy
+type proxyActor struct {
+	//Identity is "user:<id>" for the register endpoints,
+	//"rpc", "admin", "dcmaster" or the proxies file source
+	Identity      string `json:"identity"`
+	RemoteAddr    string `json:"remote_addr"`
+	CorrelationID string `json:"correlation_id"`
+	Reason        string `json:"reason"`
+}
+
+// httpActor is the identity behind an http request
+func httpActor(r *http.Request, identity string) proxyActor {
+	return proxyActor{
+		Identity:      identity,
+		RemoteAddr:    r.RemoteAddr,
+		CorrelationID: ctrace.CorrelationID(r.Context()),
+	}
+}
+
+// rpcActor is the identity behind a gRPC call
+func rpcActor(ctx context.Context, identity string) proxyActor {
+	a := proxyActor{Identity: identity, CorrelationID: ctrace.CorrelationID(ctx)}
+	if p, ok := peer.FromContext(ctx); ok {
+		a.RemoteAddr = p.Addr.String()
+	}
+	return a
+}
+
+// userActor is the identity of a user authenticated by authRequest
+func userActor(r *http.Request, userId int64) proxyActor {
+	return httpActor(r, fmt.Sprintf("user:%d", userId))
+}
+
+// proxyAuditEntry is a proxy registration or removal
+type proxyAuditEntry struct {
+	Time      time.Time  `json:"time"`
+	Action    string     `json:"action"`
+	Proxy     string     `json:"proxy"`
+	Target    string     `json:"target"`
+	Subdomain string     `json:"subdomain"`
+	Source    string     `json:"source"`
+	UserID    int64      `json:"user_id"`
+	JobID     int64      `json:"job_id"`
+	By        proxyActor `json:"by"`
+}
+
+// proxyAudit keeps the most recent proxy changes
+type proxyAudit struct {
+	mut    sync.Mutex
+	ring   []proxyAuditEntry
+	next   int
+	filled bool
+}
+
+func newProxyAudit() *proxyAudit {
+	return &proxyAudit{ring: make([]proxyAuditEntry, maxProxyAudit)}
+}
+
+func (a *proxyAudit) add(e proxyAuditEntry) {
+	if a == nil {
+		return
+	}
+	a.mut.Lock()
+	defer a.mut.Unlock()
+	a.ring[a.next] = e
+	a.next = (a.next + 1) % len(a.ring)
+	if a.next == 0 {
+		a.filled = true
+	}
+}
+
+// list returns the changes of the proxy, or
+// of all proxies when pId is empty, newest first
+func (a *proxyAudit) list(pId string) []proxyAuditEntry {
+	out := []proxyAuditEntry{}
+	if a == nil {
+		return out
+	}
+	a.mut.Lock()
+	defer a.mut.Unlock()
+	n := a.next
+	if a.filled {
+		n = len(a.ring)
+	}
+	for i := 1; i <= n; i++ {
+		e := a.ring[(a.next-i+len(a.ring))%len(a.ring)]
+		if pId == "" || e.Proxy == pId {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// auditProxy records a proxy event
+func (s *Server) auditProxy(typ, pId string, p *DynamicReverseProxy, by proxyActor) {
+	source := p.Source
+	if source == "" {
+		source = "http"
+	}
+	s.proxyAudit.add(proxyAuditEntry{
+		Time:      time.Now(),
+		Action:    typ,
+		Proxy:     pId,
+		Target:    p.Target,
+		Subdomain: p.Subdomain,
+		Source:    source,
+		UserID:    p.User,
+		JobID:     p.JobId,
+		By:        by,
+	})
+}
+
+// handleAPIProxyAudit serves GET /api/proxy-audit, newest
+// first, optionally only of the proxy given as ?id=
+func (s *Server) handleAPIProxyAudit(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.proxyAudit.list(r.URL.Query().Get("id")))
+}
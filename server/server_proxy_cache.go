@@ -0,0 +1,268 @@
+package chserver
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ProxyCacheConfig enables response caching for a dynamic proxy
+type ProxyCacheConfig struct {
+	//MaxSize is the total size of cached bodies in bytes
+	MaxSize int64 `json:"maxsize"`
+	//MaxEntrySize is the largest body which will be cached
+	MaxEntrySize int64 `json:"maxentrysize"`
+	//DefaultTTL is used when the upstream provides no freshness
+	//information, zero means such responses are not cached
+	DefaultTTL string `json:"defaultttl"`
+}
+
+// responseCache is an LRU of upstream GET responses, per caller,
+// which honours the upstream's Cache-Control headers
+type responseCache struct {
+	maxSize, maxEntry int64
+	defaultTTL        time.Duration
+	mut               sync.Mutex
+	size              int64
+	lru               *list.List
+	entries           map[string]*list.Element
+}
+
+type cacheEntry struct {
+	key     string
+	status  int
+	header  http.Header
+	body    []byte
+	stored  time.Time
+	expires time.Time
+}
+
+func newResponseCache(c *ProxyCacheConfig) (*responseCache, error) {
+	rc := &responseCache{
+		maxSize:  c.MaxSize,
+		maxEntry: c.MaxEntrySize,
+		lru:      list.New(),
+		entries:  map[string]*list.Element{},
+	}
+	if rc.maxSize <= 0 {
+		rc.maxSize = 32 << 20
+	}
+	if rc.maxEntry <= 0 || rc.maxEntry > rc.maxSize {
+		rc.maxEntry = rc.maxSize / 8
+	}
+	if c.DefaultTTL != "" {
+		d, err := time.ParseDuration(c.DefaultTTL)
+		if err != nil {
+			return nil, err
+		}
+		rc.defaultTTL = d
+	}
+	return rc, nil
+}
+
+func (rc *responseCache) get(key string) *cacheEntry {
+	rc.mut.Lock()
+	defer rc.mut.Unlock()
+	el, ok := rc.entries[key]
+	if !ok {
+		return nil
+	}
+	e := el.Value.(*cacheEntry)
+	if time.Now().After(e.expires) {
+		rc.remove(el)
+		return nil
+	}
+	rc.lru.MoveToFront(el)
+	return e
+}
+
+func (rc *responseCache) put(e *cacheEntry) {
+	rc.mut.Lock()
+	defer rc.mut.Unlock()
+	if el, ok := rc.entries[e.key]; ok {
+		rc.remove(el)
+	}
+	rc.entries[e.key] = rc.lru.PushFront(e)
+	rc.size += int64(len(e.body))
+	for rc.size > rc.maxSize {
+		rc.remove(rc.lru.Back())
+	}
+}
+
+func (rc *responseCache) remove(el *list.Element) {
+	e := rc.lru.Remove(el).(*cacheEntry)
+	delete(rc.entries, e.key)
+	rc.size -= int64(len(e.body))
+}
+
+// wrap places the cache in front of the given handler
+func (rc *responseCache) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.Header.Get("Upgrade") != "" ||
+			hasDirective(r.Header, "no-store") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		key := cacheKey(r)
+		if !hasDirective(r.Header, "no-cache") {
+			if e := rc.get(key); e != nil {
+				h := w.Header()
+				for k, v := range e.header {
+					h[k] = v
+				}
+				h.Set("Age", strconv.Itoa(int(time.Since(e.stored).Seconds())))
+				h.Set("X-Cache", "HIT")
+				w.WriteHeader(e.status)
+				w.Write(e.body)
+				return
+			}
+		}
+		rec := &cacheRecorder{ResponseWriter: w, limit: rc.maxEntry}
+		next.ServeHTTP(rec, r)
+		if e := rc.entry(key, rec); e != nil {
+			rc.put(e)
+		}
+	})
+}
+
+// cacheKey is the request's URL and the identity of its caller,
+// its credentials and cookies, so responses of one caller are
+// never served to another
+func cacheKey(r *http.Request) string {
+	key := r.Host + r.URL.RequestURI()
+	auth, cookie := r.Header.Get("Authorization"), r.Header.Get("Cookie")
+	if auth == "" && cookie == "" {
+		return key
+	}
+	id := sha256.Sum256([]byte(auth + "\x00" + cookie))
+	return key + "#" + hex.EncodeToString(id[:])
+}
+
+// entry converts a recorded response into a
+// cache entry, or nil if it may not be cached
+func (rc *responseCache) entry(key string, rec *cacheRecorder) *cacheEntry {
+	if rec.skip || rec.overflow {
+		return nil
+	}
+	switch rec.status {
+	case http.StatusOK, http.StatusNonAuthoritativeInfo, http.StatusMovedPermanently,
+		http.StatusNotFound, http.StatusGone:
+	default:
+		return nil
+	}
+	h := rec.Header()
+	if h.Get("Set-Cookie") != "" || h.Get("Vary") != "" ||
+		hasDirective(h, "no-store") || hasDirective(h, "no-cache") || hasDirective(h, "private") {
+		return nil
+	}
+	now := time.Now()
+	ttl := rc.defaultTTL
+	if age, ok := directiveSeconds(h, "s-maxage"); ok {
+		ttl = age
+	} else if age, ok := directiveSeconds(h, "max-age"); ok {
+		ttl = age
+	} else if exp := h.Get("Expires"); exp != "" {
+		t, err := http.ParseTime(exp)
+		if err != nil {
+			return nil
+		}
+		ttl = t.Sub(now)
+	}
+	if ttl <= 0 {
+		return nil
+	}
+	header := http.Header{}
+	for k, v := range h {
+		header[k] = append([]string(nil), v...)
+	}
+	return &cacheEntry{
+		key:     key,
+		status:  rec.status,
+		header:  header,
+		body:    rec.body.Bytes(),
+		stored:  now,
+		expires: now.Add(ttl),
+	}
+}
+
+// hasDirective checks the Cache-Control header for the given directive
+func hasDirective(h http.Header, directive string) bool {
+	_, ok := cacheDirective(h, directive)
+	return ok || (directive == "no-cache" && h.Get("Pragma") == "no-cache")
+}
+
+func directiveSeconds(h http.Header, directive string) (time.Duration, bool) {
+	v, ok := cacheDirective(h, directive)
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, false
+	}
+	return time.Duration(n) * time.Second, true
+}
+
+func cacheDirective(h http.Header, directive string) (string, bool) {
+	for _, cc := range h.Values("Cache-Control") {
+		for _, d := range strings.Split(cc, ",") {
+			d = strings.TrimSpace(d)
+			name, value := d, ""
+			if i := strings.Index(d, "="); i >= 0 {
+				name, value = d[:i], strings.Trim(d[i+1:], `"`)
+			}
+			if strings.EqualFold(name, directive) {
+				return value, true
+			}
+		}
+	}
+	return "", false
+}
+
+// cacheRecorder passes the response through while
+// keeping a copy of bodies up to the limit
+type cacheRecorder struct {
+	http.ResponseWriter
+	status   int
+	limit    int64
+	body     bytes.Buffer
+	overflow bool
+	skip     bool
+}
+
+func (c *cacheRecorder) WriteHeader(status int) {
+	if c.status == 0 {
+		c.status = status
+		if strings.HasPrefix(c.Header().Get("Content-Type"), "text/event-stream") {
+			c.skip = true
+		}
+	}
+	c.ResponseWriter.WriteHeader(status)
+}
+
+func (c *cacheRecorder) Write(b []byte) (int, error) {
+	if c.status == 0 {
+		c.WriteHeader(http.StatusOK)
+	}
+	if !c.overflow && !c.skip {
+		if int64(c.body.Len()+len(b)) > c.limit {
+			c.overflow = true
+			c.body = bytes.Buffer{}
+		} else {
+			c.body.Write(b)
+		}
+	}
+	return c.ResponseWriter.Write(b)
+}
+
+func (c *cacheRecorder) Flush() {
+	if f, ok := c.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,86 @@
+package chserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestResponseCache(t *testing.T) {
+	hits := 0
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		switch r.URL.Path {
+		case "/static":
+			w.Header().Set("Cache-Control", "public, max-age=60")
+		case "/private":
+			w.Header().Set("Cache-Control", "private, max-age=60")
+		}
+		w.Write([]byte("hello"))
+	})
+	rc, err := newResponseCache(&ProxyCacheConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := rc.wrap(upstream)
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		return rec
+	}
+	for i, test := range []struct {
+		path string
+		hits int
+		hit  bool
+	}{
+		{"/static", 1, false},
+		{"/static", 1, true},
+		{"/private", 2, false},
+		{"/private", 3, false},
+		{"/none", 4, false},
+		{"/none", 5, false},
+	} {
+		rec := get(test.path)
+		if rec.Body.String() != "hello" {
+			t.Fatalf("#%d: unexpected body %q", i+1, rec.Body.String())
+		}
+		if hits != test.hits {
+			t.Fatalf("#%d: expected %d upstream hits, got %d", i+1, test.hits, hits)
+		}
+		if hit := rec.Header().Get("X-Cache") == "HIT"; hit != test.hit {
+			t.Fatalf("#%d: expected cache hit %v", i+1, test.hit)
+		}
+	}
+}
+
+func TestResponseCacheCallers(t *testing.T) {
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte("for " + r.Header.Get("Authorization")))
+	})
+	rc, err := newResponseCache(&ProxyCacheConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := rc.wrap(upstream)
+	get := func(auth string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/me", nil)
+		if auth != "" {
+			r.Header.Set("Authorization", auth)
+		}
+		h.ServeHTTP(rec, r)
+		return rec
+	}
+	get("alice")
+	//a response is only served to its own caller
+	if rec := get("bob"); rec.Body.String() != "for bob" || rec.Header().Get("X-Cache") == "HIT" {
+		t.Fatalf("expected bob's own response, got %q", rec.Body.String())
+	}
+	if rec := get("alice"); rec.Body.String() != "for alice" || rec.Header().Get("X-Cache") != "HIT" {
+		t.Fatalf("expected alice's cached response, got %q", rec.Body.String())
+	}
+	if rec := get(""); rec.Body.String() != "for " || rec.Header().Get("X-Cache") == "HIT" {
+		t.Fatalf("expected an anonymous response, got %q", rec.Body.String())
+	}
+}
@@ -0,0 +1,175 @@
+package chserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jpillora/chisel/share/ctrace"
+)
+
+// maxProxyDebug bounds how long header capture may be enabled for
+const maxProxyDebug = time.Hour
+
+// redactedHeaders are never logged by header capture, nor are
+// headers whose names contain one of redactedWords
+var (
+	redactedHeaders = map[string]bool{
+		"Authorization":       true,
+		"Proxy-Authorization": true,
+		"Cookie":              true,
+		"Set-Cookie":          true,
+	}
+	redactedWords = []string{"token", "secret", "password", "key", "session"}
+)
+
+// proxyDebug enables header capture of the proxied requests
+// of a service prefix until a deadline
+type proxyDebug struct {
+	mut   sync.Mutex
+	until map[string]time.Time
+}
+
+func newProxyDebug() *proxyDebug {
+	return &proxyDebug{until: map[string]time.Time{}}
+}
+
+func (d *proxyDebug) enable(prefix string, dur time.Duration) time.Time {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+	until := time.Now().Add(dur)
+	d.until[prefix] = until
+	return until
+}
+
+func (d *proxyDebug) disable(prefix string) bool {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+	_, ok := d.until[prefix]
+	delete(d.until, prefix)
+	return ok
+}
+
+// active reports whether headers of the prefix are captured,
+// forgetting the prefix once its deadline passed
+func (d *proxyDebug) active(prefix string) bool {
+	if d == nil {
+		return false
+	}
+	d.mut.Lock()
+	defer d.mut.Unlock()
+	until, ok := d.until[prefix]
+	if ok && time.Now().After(until) {
+		delete(d.until, prefix)
+		return false
+	}
+	return ok
+}
+
+// list returns the enabled prefixes with their deadlines
+func (d *proxyDebug) list() map[string]time.Time {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+	out := map[string]time.Time{}
+	now := time.Now()
+	for prefix, until := range d.until {
+		if now.Before(until) {
+			out[prefix] = until
+		}
+	}
+	return out
+}
+
+// serveDebug serves the request with next, logging the request
+// and response headers when capture is enabled for the proxy
+func (s *Server) serveDebug(drProxy *DynamicReverseProxy, next http.Handler, w http.ResponseWriter, r *http.Request) {
+	if !s.proxyDebug.active(drProxy.ServicePrefix) {
+		next.ServeHTTP(w, r)
+		return
+	}
+	l := s.proxyLog().Fork("debug").With("proxy", drProxy.Id).With("correlation_id", ctrace.CorrelationID(r.Context()))
+	l.Infof("%s %s %s from %s, headers: %s", r.Method, r.URL.RequestURI(), r.Proto, r.RemoteAddr, redactHeaders(r.Header))
+	rec := &statusRecorder{ResponseWriter: w}
+	t0 := time.Now()
+	next.ServeHTTP(rec, r)
+	l.Infof("%d in %s, %d bytes, headers: %s", rec.Status(), time.Since(t0).Round(time.Millisecond), rec.written, redactHeaders(rec.Header()))
+}
+
+// redactHeaders formats headers sorted by name, with secrets redacted
+func redactHeaders(h http.Header) string {
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	out := make([]string, 0, len(names))
+	for _, name := range names {
+		value := strings.Join(h[name], ", ")
+		if redactedHeader(name) {
+			value = "[redacted]"
+		}
+		out = append(out, name+": "+value)
+	}
+	return "{" + strings.Join(out, "; ") + "}"
+}
+
+func redactedHeader(name string) bool {
+	if redactedHeaders[http.CanonicalHeaderKey(name)] {
+		return true
+	}
+	lower := strings.ToLower(name)
+	for _, w := range redactedWords {
+		if strings.Contains(lower, w) {
+			return true
+		}
+	}
+	return false
+}
+
+// proxyDebugRequest enables header capture
+type proxyDebugRequest struct {
+	ServicePrefix string `json:"service_prefix"`
+	//Duration defaults to 5m, at most 1h
+	Duration string `json:"duration"`
+}
+
+// handleAPIProxyDebug serves GET /api/proxy-debug, listing the
+// service prefixes with header capture, POST, which enables it for
+// a duration, and DELETE /api/proxy-debug?service_prefix=, which stops it
+func (s *Server) handleAPIProxyDebug(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, s.proxyDebug.list())
+	case http.MethodPost:
+		var req proxyDebugRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ServicePrefix == "" {
+			http.Error(w, "Expected a service_prefix", http.StatusBadRequest)
+			return
+		}
+		d := 5 * time.Minute
+		if req.Duration != "" {
+			var err error
+			if d, err = time.ParseDuration(req.Duration); err != nil || d <= 0 || d > maxProxyDebug {
+				http.Error(w, fmt.Sprintf("Invalid duration (%s), at most %s", req.Duration, maxProxyDebug), http.StatusBadRequest)
+				return
+			}
+		}
+		until := s.proxyDebug.enable(req.ServicePrefix, d)
+		s.Infof("Admin API: capturing headers of %s until %s", req.ServicePrefix, until.Format(time.RFC3339))
+		writeJSON(w, http.StatusOK, map[string]time.Time{req.ServicePrefix: until})
+	case http.MethodDelete:
+		prefix := r.URL.Query().Get("service_prefix")
+		if !s.proxyDebug.disable(prefix) {
+			http.Error(w, "Header capture not enabled", http.StatusNotFound)
+			return
+		}
+		s.Infof("Admin API: stopped capturing headers of %s", prefix)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,124 @@
+package chserver
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// proxyDrain tracks the requests in flight through a proxy, so
+// that its route can be removed once they finish
+type proxyDrain struct {
+	mut      sync.Mutex
+	draining bool
+	inflight int
+	idle     chan struct{}
+	kill     chan struct{}
+}
+
+func newProxyDrain() *proxyDrain {
+	return &proxyDrain{
+		idle: make(chan struct{}),
+		kill: make(chan struct{}),
+	}
+}
+
+// enter admits a request, false once draining
+func (d *proxyDrain) enter() bool {
+	if d == nil {
+		return true
+	}
+	d.mut.Lock()
+	defer d.mut.Unlock()
+	if d.draining {
+		return false
+	}
+	d.inflight++
+	return true
+}
+
+func (d *proxyDrain) leave() {
+	if d == nil {
+		return
+	}
+	d.mut.Lock()
+	defer d.mut.Unlock()
+	d.inflight--
+	if d.draining && d.inflight == 0 {
+		close(d.idle)
+	}
+}
+
+// start stops admitting requests, false if already draining
+func (d *proxyDrain) start() bool {
+	if d == nil {
+		return true
+	}
+	d.mut.Lock()
+	defer d.mut.Unlock()
+	if d.draining {
+		return false
+	}
+	d.draining = true
+	if d.inflight == 0 {
+		close(d.idle)
+	}
+	return true
+}
+
+// wait blocks until the requests in flight finish, or the
+// timeout passes and the remaining ones are cancelled
+func (d *proxyDrain) wait(timeout time.Duration) bool {
+	if d == nil {
+		return true
+	}
+	select {
+	case <-d.idle:
+		return true
+	case <-time.After(timeout):
+		close(d.kill)
+		return false
+	}
+}
+
+// serve runs h, cancelling the request if the drain times out
+func (d *proxyDrain) serve(h http.Handler, w http.ResponseWriter, r *http.Request) {
+	if d == nil {
+		h.ServeHTTP(w, r)
+		return
+	}
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	go func() {
+		select {
+		case <-d.kill:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	h.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// startDrain stops new requests to the proxies, returning those
+// which were not already draining (their removal is underway)
+func startDrain(proxies []*DynamicReverseProxy) []*DynamicReverseProxy {
+	started := []*DynamicReverseProxy{}
+	for _, p := range proxies {
+		if p.drain.start() {
+			started = append(started, p)
+		}
+	}
+	return started
+}
+
+// waitDrain waits up to timeout for the requests in flight
+// through the proxies, cancelling any left after that
+func (s *Server) waitDrain(proxies []*DynamicReverseProxy, timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for _, p := range proxies {
+		if !p.drain.wait(time.Until(deadline)) {
+			s.Infof("Proxy %s did not drain within %s, closing its requests", p.Id, timeout)
+		}
+	}
+}
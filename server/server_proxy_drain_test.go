@@ -0,0 +1,45 @@
+package chserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestProxyDrain(t *testing.T) {
+	d := newProxyDrain()
+	if !d.enter() {
+		t.Fatal("expected request to be admitted")
+	}
+	if !d.start() || d.start() {
+		t.Fatal("expected only the first start to succeed")
+	}
+	if d.enter() {
+		t.Fatal("expected draining proxy to refuse requests")
+	}
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		d.leave()
+	}()
+	if !d.wait(time.Second) {
+		t.Fatal("expected in flight request to drain")
+	}
+	//a stuck request is cancelled after the timeout
+	d = newProxyDrain()
+	d.enter()
+	done := make(chan struct{})
+	go d.serve(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		close(done)
+	}), httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	d.start()
+	if d.wait(10 * time.Millisecond) {
+		t.Fatal("expected stuck request to time out")
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected stuck request to be cancelled")
+	}
+}
@@ -0,0 +1,207 @@
+package chserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"gopkg.in/yaml.v3"
+)
+
+// ProxyAccessPublic allows any request, it may only be used
+// by proxies declared in the proxies file or pushed over dcrpc
+const ProxyAccessPublic = "public"
+
+// proxiesFile is the declarative set of dynamic proxies, e.g.
+//
+//	proxies:
+//	- id: docs
+//	  target: http://10.0.0.5:8080
+//	  subdomain: docs
+//	  access: public
+type proxiesFile struct {
+	Proxies []proxiesFileEntry `yaml:"proxies"`
+}
+
+type proxiesFileEntry struct {
+	//Id defaults to the hash of the target
+	Id string `yaml:"id"`
+	//AuthKey is the token required by the "authkey" access policy
+	AuthKey   string `yaml:"authkey"`
+	ProxyData `yaml:",inline"`
+}
+
+// loadProxiesFile loads the proxies file, returning a watcher
+// of its directory, editors and config maps replace files
+func (s *Server) loadProxiesFile() (*fsnotify.Watcher, error) {
+	if err := s.reconcileProxiesFile(); err != nil {
+		return nil, err
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	if err := watcher.Add(filepath.Dir(s.config.ProxiesFile)); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+	return watcher, nil
+}
+
+// watchProxiesFile reconciles the proxies file again whenever
+// the watcher sees a change, until ctx is cancelled
+func (s *Server) watchProxiesFile(ctx context.Context, watcher *fsnotify.Watcher) {
+	defer watcher.Close()
+	var reload <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case _, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			//debounce bursts of events
+			reload = time.After(200 * time.Millisecond)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			s.Infof("Failed to watch proxies file: %s", err)
+		case <-reload:
+			if err := s.reconcileProxiesFile(); err != nil {
+				s.Infof("Failed to reload proxies file: %s", err)
+			}
+		}
+	}
+}
+
+// reconcileProxiesFile brings the live file-sourced proxies
+// in line with the proxies file, leaving others untouched
+func (s *Server) reconcileProxiesFile() error {
+	path := s.config.ProxiesFile
+	source := "file:" + path
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("Failed to read proxies file: %s, error: %s", path, err)
+	}
+	var pf proxiesFile
+	if err := yaml.Unmarshal(b, &pf); err != nil {
+		return errors.New("Invalid YAML: " + err.Error())
+	}
+	desired := map[string]*DynamicReverseProxy{}
+	for i := range pf.Proxies {
+		drProxy, err := s.newManagedProxy(&pf.Proxies[i], source)
+		if err != nil {
+			return fmt.Errorf("proxy #%d: %s", i+1, err)
+		}
+		if _, dupe := desired[drProxy.Id]; dupe {
+			return fmt.Errorf("proxy #%d: duplicate id (%s)", i+1, drProxy.Id)
+		}
+		desired[drProxy.Id] = drProxy
+	}
+	removed, added := 0, 0
+	//remove proxies which are no longer declared
+	s.dynamicReverseProxies.Range(func(pId string, p *DynamicReverseProxy) bool {
+		if _, ok := desired[pId]; !ok && p.Source == source {
+			if _, ok := s.removeDynamicProxy(pId, proxyActor{Identity: source}); ok {
+				removed++
+			}
+		}
+		return true
+	})
+	//add new and changed proxies
+	for pId, drProxy := range desired {
+		if existing, ok := s.dynamicReverseProxies.Get(pId); ok {
+			if existing.Source != source {
+				s.Infof("Proxies file: id %s is already registered by %s, skipping", pId, existing.Source)
+				continue
+			}
+			if existing.spec == drProxy.spec {
+				continue
+			}
+		}
+		if err := s.checkProxyHost(pId, drProxy.Subdomain); err != nil {
+			s.Infof("Proxies file: %s, skipping", err)
+			continue
+		}
+		if drProxy.Subdomain != "" && !s.proxyHosts.claim(drProxy.Subdomain, pId) {
+			s.Infof("Proxies file: subdomain %s of %s already in use, skipping", drProxy.Subdomain, pId)
+			continue
+		}
+		if prev := s.dynamicReverseProxies.Add(pId, drProxy); prev != nil && prev.Subdomain != drProxy.Subdomain {
+			s.proxyHosts.release(prev.Subdomain, pId)
+		}
+		s.notifyProxy(eventProxyRegistered, pId, drProxy, drProxy.RegisteredBy)
+		added++
+	}
+	s.Infof("Proxies file %s loaded (%d declared, %d added or updated, %d removed)",
+		path, len(desired), added, removed)
+	return nil
+}
+
+// newManagedProxy validates a proxies file (or dcrpc) entry and builds its proxy
+func (s *Server) newManagedProxy(e *proxiesFileEntry, source string) (*DynamicReverseProxy, error) {
+	u, err := url.Parse(e.Target)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("Missing protocol (%s)", u)
+	}
+	if e.ProxyType == "" {
+		e.ProxyType = "legacy"
+	}
+	if e.ServicePrefix == "" {
+		e.ServicePrefix = "svc"
+	}
+	proxyType, ok := getProxyType(e.ProxyType)
+	if !ok {
+		return nil, fmt.Errorf("Unknown proxy type (%s)", e.ProxyType)
+	}
+	switch e.Access {
+	case ProxyAccessPublic:
+	case "", ProxyAccessAuthKey:
+		e.Access = ProxyAccessAuthKey
+		if e.AuthKey == "" {
+			return nil, errors.New("authkey access requires an authkey")
+		}
+	default:
+		return nil, fmt.Errorf("Access policy (%s) not supported by managed proxies", e.Access)
+	}
+	if e.Subdomain != "" && (s.config.ProxyDomain == "" || !subdomainLabel.MatchString(e.Subdomain)) {
+		return nil, fmt.Errorf("Invalid subdomain (%s)", e.Subdomain)
+	}
+	if e.Id == "" {
+		e.Id = s.getProxyHashFromTarget(e.Target)
+	}
+	spec, _ := json.Marshal(e)
+	drProxy := &DynamicReverseProxy{
+		Id:            e.Id,
+		AuthKey:       []byte(e.AuthKey),
+		Target:        e.Target,
+		ServicePrefix: e.ServicePrefix,
+		ProxyType:     e.ProxyType,
+		Subdomain:     e.Subdomain,
+		Access:        e.Access,
+		AccessLog:     e.AccessLog,
+		Source:        source,
+		RegisteredBy:  proxyActor{Identity: source},
+		spec:          string(spec),
+		Created:       time.Now(),
+	}
+	if err := s.parseProxyOptions(&e.ProxyData, drProxy); err != nil {
+		return nil, err
+	}
+	drProxy.Handler, err = s.newDynamicProxyHandler(proxyType, &e.ProxyData, drProxy, u)
+	if err != nil {
+		return nil, err
+	}
+	return drProxy, nil
+}
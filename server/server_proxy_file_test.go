@@ -0,0 +1,129 @@
+package chserver
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jpillora/chisel/share/cio"
+)
+
+func TestReconcileProxiesFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "proxies")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "proxies.yaml")
+	s := &Server{
+		Logger:                cio.NewLogger("server"),
+		config:                &Config{ProxiesFile: path, ProxyDomain: "example.com"},
+		dynamicReverseProxies: NewProxyStore(),
+		proxyHosts:            newProxyHosts(),
+	}
+	s.dynamicReverseProxies.Add("registered", &DynamicReverseProxy{Id: "registered"})
+	write := func(yaml string) {
+		if err := ioutil.WriteFile(path, []byte(yaml), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.reconcileProxiesFile(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`
+proxies:
+- id: docs
+  target: http://10.0.0.5:8080
+  subdomain: docs
+  access: public
+- target: http://10.0.0.6:8080
+  authkey: secret
+`)
+	if n := s.dynamicReverseProxies.Len(); n != 3 {
+		t.Fatalf("expected 3 proxies, got %d", n)
+	}
+	docs, ok := s.dynamicReverseProxies.Get("docs")
+	if !ok || docs.Source != "file:"+path {
+		t.Fatal("expected file proxy docs")
+	}
+	if pId, _ := s.proxyHosts.get("docs"); pId != "docs" {
+		t.Fatal("expected subdomain docs to be claimed")
+	}
+	//unchanged entries are kept as is
+	write(`
+proxies:
+- id: docs
+  target: http://10.0.0.5:8080
+  subdomain: docs
+  access: public
+`)
+	if again, _ := s.dynamicReverseProxies.Get("docs"); again != docs {
+		t.Fatal("expected unchanged proxy to be kept")
+	}
+	if n := s.dynamicReverseProxies.Len(); n != 2 {
+		t.Fatalf("expected 2 proxies, got %d", n)
+	}
+	write(`proxies: []`)
+	if _, ok := s.dynamicReverseProxies.Get("registered"); !ok || s.dynamicReverseProxies.Len() != 1 {
+		t.Fatal("expected only the registered proxy to remain")
+	}
+	if _, ok := s.proxyHosts.get("docs"); ok {
+		t.Fatal("expected subdomain docs to be released")
+	}
+	//invalid files leave proxies untouched
+	ioutil.WriteFile(path, []byte("proxies:\n- target: http://x\n  access: job\n"), 0600)
+	if err := s.reconcileProxiesFile(); err == nil {
+		t.Fatal("expected job access to be rejected")
+	}
+}
+
+func TestWatchProxiesFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "proxies")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "proxies.yaml")
+	if err := ioutil.WriteFile(path, []byte(`proxies: []`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{
+		Logger:                cio.NewLogger("server"),
+		config:                &Config{ProxiesFile: path, ProxyDomain: "example.com"},
+		dynamicReverseProxies: NewProxyStore(),
+		proxyHosts:            newProxyHosts(),
+	}
+	watcher, err := s.loadProxiesFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.watchProxiesFile(ctx, watcher)
+		close(done)
+	}()
+	if err := ioutil.WriteFile(path, []byte("proxies:\n- id: docs\n  target: http://10.0.0.5:8080\n  access: public\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for s.dynamicReverseProxies.Len() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the changed file to be reloaded")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	//the watch stops, and closes the watcher, with the server
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected the watch to stop when cancelled")
+	}
+	if _, ok := <-watcher.Events; ok {
+		t.Fatal("expected the watcher to be closed")
+	}
+}
@@ -0,0 +1,116 @@
+package chserver
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+var subdomainLabel = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// proxyHosts maps custom subdomains onto dynamic proxy ids
+type proxyHosts struct {
+	sync.RWMutex
+	inner map[string]string
+}
+
+func newProxyHosts() *proxyHosts {
+	return &proxyHosts{inner: map[string]string{}}
+}
+
+// claim assigns the subdomain to the proxy, it fails
+// if the subdomain already belongs to another proxy
+func (h *proxyHosts) claim(subdomain, pId string) bool {
+	h.Lock()
+	defer h.Unlock()
+	if owner, ok := h.inner[subdomain]; ok && owner != pId {
+		return false
+	}
+	h.inner[subdomain] = pId
+	return true
+}
+
+// release frees the subdomain if it belongs to the proxy
+func (h *proxyHosts) release(subdomain, pId string) {
+	h.Lock()
+	if h.inner[subdomain] == pId {
+		delete(h.inner, subdomain)
+	}
+	h.Unlock()
+}
+
+func (h *proxyHosts) get(subdomain string) (string, bool) {
+	h.RLock()
+	pId, ok := h.inner[subdomain]
+	h.RUnlock()
+	return pId, ok
+}
+
+// checkProxyHost refuses a subdomain which is another proxy's id, and
+// an id which is another proxy's subdomain, as claimed subdomains are
+// routed first, either would send one proxy's requests to the other
+func (s *Server) checkProxyHost(pId, subdomain string) error {
+	if subdomain != "" && subdomain != pId {
+		if _, ok := s.dynamicReverseProxies.Get(subdomain); ok {
+			return fmt.Errorf("Subdomain (%s) is the id of another proxy", subdomain)
+		}
+	}
+	if owner, ok := s.proxyHosts.get(pId); ok && owner != pId {
+		return fmt.Errorf("Id (%s) is the subdomain of another proxy", pId)
+	}
+	return nil
+}
+
+// proxyIDFromHost resolves requests to <subdomain>.<proxy-domain>,
+// where subdomain is either a claimed subdomain or a proxy id
+func (s *Server) proxyIDFromHost(host string) (string, bool) {
+	domain := s.config.ProxyDomain
+	if domain == "" {
+		return "", false
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if !strings.HasSuffix(host, "."+domain) {
+		return "", false
+	}
+	label := strings.TrimSuffix(host, "."+domain)
+	if strings.Contains(label, ".") {
+		return "", false
+	}
+	if pId, ok := s.proxyHosts.get(label); ok {
+		return pId, true
+	}
+	return label, true
+}
+
+// proxyHost is the public host of a dynamic proxy,
+// or empty when subdomain routing is disabled
+func (s *Server) proxyHost(drProxy *DynamicReverseProxy) string {
+	if s.config.ProxyDomain == "" {
+		return ""
+	}
+	label := drProxy.Subdomain
+	if label == "" {
+		label = drProxy.Id
+	}
+	return label + "." + s.config.ProxyDomain
+}
+
+type hostRoutedKey struct{}
+
+// withHostRouted marks the request as routed by its Host header,
+// so the proxy id is not stripped from its path
+func withHostRouted(r *http.Request) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), hostRoutedKey{}, true))
+}
+
+func isHostRouted(r *http.Request) bool {
+	routed, _ := r.Context().Value(hostRoutedKey{}).(bool)
+	return routed
+}
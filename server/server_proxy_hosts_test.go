@@ -0,0 +1,95 @@
+package chserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/jpillora/chisel/share/cio"
+)
+
+func TestProxyIDFromHost(t *testing.T) {
+	s := &Server{
+		config:     &Config{ProxyDomain: "proxy.example.com"},
+		proxyHosts: newProxyHosts(),
+	}
+	if !s.proxyHosts.claim("docs", "abc") || s.proxyHosts.claim("docs", "xyz") {
+		t.Fatal("expected only the first claim to succeed")
+	}
+	for host, expected := range map[string]string{
+		"abc.proxy.example.com":       "abc",
+		"ABC.Proxy.Example.com.:8443": "abc",
+		"docs.proxy.example.com":      "abc",
+		"proxy.example.com":           "",
+		"a.b.proxy.example.com":       "",
+		"abc.example.com":             "",
+	} {
+		pId, ok := s.proxyIDFromHost(host)
+		if pId != expected || ok != (expected != "") {
+			t.Errorf("%s: expected %q, got %q (%v)", host, expected, pId, ok)
+		}
+	}
+	if h := s.proxyHost(&DynamicReverseProxy{Id: "abc", Subdomain: "docs"}); h != "docs.proxy.example.com" {
+		t.Fatalf("unexpected host %s", h)
+	}
+	if h := s.proxyHost(&DynamicReverseProxy{Id: "xyz"}); h != "xyz.proxy.example.com" {
+		t.Fatalf("unexpected host %s", h)
+	}
+	s.proxyHosts.release("docs", "xyz")
+	if pId, _ := s.proxyHosts.get("docs"); pId != "abc" {
+		t.Fatal("expected another proxy's release to be ignored")
+	}
+	s.proxyHosts.release("docs", "abc")
+	if pId, _ := s.proxyIDFromHost("docs.proxy.example.com"); pId != "docs" {
+		t.Fatalf("expected the released subdomain to be treated as an id, got %q", pId)
+	}
+	s.config.ProxyDomain = ""
+	if _, ok := s.proxyIDFromHost("abc.proxy.example.com"); ok || s.proxyHost(&DynamicReverseProxy{Id: "abc"}) != "" {
+		t.Fatal("expected host routing to be disabled")
+	}
+}
+
+func TestHostRoutedPath(t *testing.T) {
+	paths := make(chan string, 1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths <- r.URL.Path
+	}))
+	defer upstream.Close()
+	s := &Server{Logger: cio.NewLogger("server"), config: &Config{}}
+	u, _ := url.Parse(upstream.URL)
+	rp := s.newDynamicReverseProxy(&DynamicReverseProxy{Id: "abc"}, u)
+	rp.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/abc/index.html", nil))
+	if p := <-paths; p != "/index.html" {
+		t.Fatalf("expected the proxy id to be stripped, got %s", p)
+	}
+	rp.ServeHTTP(httptest.NewRecorder(), withHostRouted(httptest.NewRequest("GET", "/abc/index.html", nil)))
+	if p := <-paths; p != "/abc/index.html" {
+		t.Fatalf("expected the path to be kept, got %s", p)
+	}
+}
+
+func TestCheckProxyHost(t *testing.T) {
+	s := &Server{
+		config:                &Config{ProxyDomain: "proxy.example.com"},
+		dynamicReverseProxies: NewProxyStore(),
+		proxyHosts:            newProxyHosts(),
+	}
+	s.dynamicReverseProxies.Add("victim", &DynamicReverseProxy{Id: "victim"})
+	s.dynamicReverseProxies.Add("docs", &DynamicReverseProxy{Id: "docs", Subdomain: "manual"})
+	s.proxyHosts.claim("manual", "docs")
+	//another proxy's id can't be claimed as a subdomain
+	if err := s.checkProxyHost("attacker", "victim"); err == nil {
+		t.Fatal("expected the subdomain of another proxy's id to be refused")
+	}
+	//nor can an id be another proxy's subdomain
+	if err := s.checkProxyHost("manual", ""); err == nil {
+		t.Fatal("expected the id of another proxy's subdomain to be refused")
+	}
+	//a proxy may re-register its own id and subdomain
+	for pId, subdomain := range map[string]string{"docs": "manual", "victim": "victim", "api": "api"} {
+		if err := s.checkProxyHost(pId, subdomain); err != nil {
+			t.Fatalf("%s: unexpected %s", pId, err)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package chserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ProxyLimits bound the time and resources a single
+// request to a dynamic proxy may use, zero is unlimited
+type ProxyLimits struct {
+	//DialTimeout limits connecting to the target
+	DialTimeout time.Duration
+	//ResponseHeaderTimeout limits waiting for the target's
+	//response headers, once the request has been sent
+	ResponseHeaderTimeout time.Duration
+	//Timeout limits the whole request, upgraded
+	//connections (websockets, tcp streams) are exempt
+	Timeout time.Duration
+	//MaxBodySize limits the request body in bytes
+	MaxBodySize int64
+}
+
+var errBodyTooLarge = errors.New("request body too large")
+
+// parseProxyLimits overrides the server limits with those of pd
+func parseProxyLimits(pd *ProxyData, defaults ProxyLimits) (ProxyLimits, error) {
+	l := defaults
+	for _, d := range []struct {
+		name  string
+		value string
+		dst   *time.Duration
+	}{
+		{"dial timeout", pd.DialTimeout, &l.DialTimeout},
+		{"response header timeout", pd.ResponseHeaderTimeout, &l.ResponseHeaderTimeout},
+		{"timeout", pd.Timeout, &l.Timeout},
+	} {
+		if d.value == "" {
+			continue
+		}
+		v, err := time.ParseDuration(d.value)
+		if err != nil || v < 0 {
+			return l, fmt.Errorf("Invalid %s (%s)", d.name, d.value)
+		}
+		*d.dst = v
+	}
+	if pd.MaxBodySize < 0 {
+		return l, fmt.Errorf("Invalid max body size (%d)", pd.MaxBodySize)
+	}
+	if pd.MaxBodySize > 0 {
+		l.MaxBodySize = pd.MaxBodySize
+	}
+	return l, nil
+}
+
+// dialer connects to targets within the dial timeout
+func (l ProxyLimits) dialer() *net.Dialer {
+	return &net.Dialer{Timeout: l.DialTimeout, KeepAlive: 30 * time.Second}
+}
+
+// transport is the default transport with the limits applied
+func (l ProxyLimits) transport() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.DialContext = l.dialer().DialContext
+	t.ResponseHeaderTimeout = l.ResponseHeaderTimeout
+	return t
+}
+
+// wrap enforces the request timeout and body size
+func (l ProxyLimits) wrap(next http.Handler) http.Handler {
+	if l.Timeout == 0 && l.MaxBodySize == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if l.MaxBodySize > 0 && r.Body != nil {
+			if r.ContentLength > l.MaxBodySize {
+				http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			r.Body = &limitedBody{ReadCloser: r.Body, remaining: l.MaxBodySize}
+		}
+		upgrade := r.Method == http.MethodConnect || r.Header.Get("Upgrade") != ""
+		if l.Timeout > 0 && !upgrade {
+			ctx, cancel := context.WithTimeout(r.Context(), l.Timeout)
+			defer cancel()
+			r = r.WithContext(ctx)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// limitedBody fails reads beyond the remaining bytes, unlike
+// http.MaxBytesReader its error can be told apart from upstream errors
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.remaining < 0 {
+		return 0, errBodyTooLarge
+	}
+	//read one extra byte to detect the overflow
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+	if b.remaining < 0 {
+		return n + int(b.remaining), errBodyTooLarge
+	}
+	return n, err
+}
+
+// isBodyTooLarge reports whether err came from a limitedBody,
+// the transport does not always wrap request body errors
+func isBodyTooLarge(err error) bool {
+	return errors.Is(err, errBodyTooLarge) || strings.Contains(err.Error(), errBodyTooLarge.Error())
+}
+
+// isTimeout reports whether the upstream round trip timed out
+func isTimeout(r *http.Request, err error) bool {
+	if r.Context().Err() == context.DeadlineExceeded {
+		return true
+	}
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
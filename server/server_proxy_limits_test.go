@@ -0,0 +1,61 @@
+package chserver
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jpillora/chisel/share/cio"
+)
+
+func TestProxyLimits(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			time.Sleep(200 * time.Millisecond)
+		}
+		io.Copy(ioutil.Discard, r.Body)
+	}))
+	defer upstream.Close()
+	s := &Server{Logger: cio.NewLogger("server"), config: &Config{}}
+	drProxy := &DynamicReverseProxy{Id: "abc"}
+	limits, err := parseProxyLimits(&ProxyData{Timeout: "50ms", MaxBodySize: 10}, ProxyLimits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	drProxy.Limits = limits
+	u, _ := url.Parse(upstream.URL)
+	h, err := s.newDynamicProxyHandler(ProxyTypeHandlerFunc(newHTTPProxyHandler), &ProxyData{}, drProxy, u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := httptest.NewServer(h)
+	defer proxy.Close()
+	for _, c := range []struct {
+		path   string
+		body   io.Reader
+		status int
+	}{
+		{"/ok", strings.NewReader("small"), http.StatusOK},
+		{"/big", strings.NewReader("more than ten bytes"), http.StatusRequestEntityTooLarge},
+		//unknown length, only caught while streaming
+		{"/chunked", ioutil.NopCloser(strings.NewReader("more than ten bytes")), http.StatusRequestEntityTooLarge},
+		{"/slow", nil, http.StatusGatewayTimeout},
+	} {
+		resp, err := http.Post(proxy.URL+"/abc"+c.path, "text/plain", c.body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != c.status {
+			t.Errorf("%s: expected status %d, got %d", c.path, c.status, resp.StatusCode)
+		}
+	}
+	if _, err := parseProxyLimits(&ProxyData{DialTimeout: "soon"}, ProxyLimits{}); err == nil {
+		t.Fatal("expected invalid dial timeout")
+	}
+}
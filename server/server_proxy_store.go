@@ -0,0 +1,118 @@
+package chserver
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+const proxyStoreShards = 32
+
+// ProxyStore is a concurrency-safe index of dynamic
+// reverse proxies by id. It is sharded so that lookups on
+// the request path don't contend with registrations. The
+// idle upstream connections of removed proxies are closed.
+type ProxyStore struct {
+	shards [proxyStoreShards]proxyShard
+}
+
+type proxyShard struct {
+	sync.RWMutex
+	inner map[string]*DynamicReverseProxy
+}
+
+// NewProxyStore creates an empty ProxyStore
+func NewProxyStore() *ProxyStore {
+	s := &ProxyStore{}
+	for i := range s.shards {
+		s.shards[i].inner = map[string]*DynamicReverseProxy{}
+	}
+	return s
+}
+
+func (s *ProxyStore) shard(id string) *proxyShard {
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	return &s.shards[h.Sum32()%proxyStoreShards]
+}
+
+// Add a proxy into the store, returning the
+// proxy it replaced (if any)
+func (s *ProxyStore) Add(id string, p *DynamicReverseProxy) (prev *DynamicReverseProxy) {
+	sh := s.shard(id)
+	sh.Lock()
+	prev = sh.inner[id]
+	sh.inner[id] = p
+	sh.Unlock()
+	if prev != p {
+		prev.closeIdle()
+	}
+	return prev
+}
+
+// Get a proxy from the store by id
+func (s *ProxyStore) Get(id string) (*DynamicReverseProxy, bool) {
+	sh := s.shard(id)
+	sh.RLock()
+	p, ok := sh.inner[id]
+	sh.RUnlock()
+	return p, ok
+}
+
+// Delete a proxy from the store, returning the removed
+// proxy. Only one of many concurrent deletes will succeed.
+func (s *ProxyStore) Delete(id string) (*DynamicReverseProxy, bool) {
+	sh := s.shard(id)
+	sh.Lock()
+	p, ok := sh.inner[id]
+	delete(sh.inner, id)
+	sh.Unlock()
+	p.closeIdle()
+	return p, ok
+}
+
+// DeleteIf removes the proxy only if it is still p
+func (s *ProxyStore) DeleteIf(id string, p *DynamicReverseProxy) bool {
+	sh := s.shard(id)
+	sh.Lock()
+	if sh.inner[id] != p {
+		sh.Unlock()
+		return false
+	}
+	delete(sh.inner, id)
+	sh.Unlock()
+	p.closeIdle()
+	return true
+}
+
+// Len returns the number of proxies
+func (s *ProxyStore) Len() int {
+	n := 0
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.RLock()
+		n += len(sh.inner)
+		sh.RUnlock()
+	}
+	return n
+}
+
+// Range calls fn for each proxy until fn returns false. Each
+// shard is snapshotted first, so fn may safely modify the store.
+func (s *ProxyStore) Range(fn func(id string, p *DynamicReverseProxy) bool) {
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.RLock()
+		ids := make([]string, 0, len(sh.inner))
+		ps := make([]*DynamicReverseProxy, 0, len(sh.inner))
+		for id, p := range sh.inner {
+			ids = append(ids, id)
+			ps = append(ps, p)
+		}
+		sh.RUnlock()
+		for j := range ids {
+			if !fn(ids[j], ps[j]) {
+				return
+			}
+		}
+	}
+}
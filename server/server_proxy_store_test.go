@@ -0,0 +1,143 @@
+package chserver
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jpillora/chisel/share/cio"
+)
+
+func TestProxyStore(t *testing.T) {
+	s := NewProxyStore()
+	a := &DynamicReverseProxy{Target: "http://a"}
+	b := &DynamicReverseProxy{Target: "http://b"}
+	if prev := s.Add("x", a); prev != nil {
+		t.Fatalf("expected no previous proxy, got %v", prev)
+	}
+	if prev := s.Add("x", b); prev != a {
+		t.Fatalf("expected previous proxy a, got %v", prev)
+	}
+	if p, ok := s.Get("x"); !ok || p != b {
+		t.Fatalf("expected proxy b, got %v", p)
+	}
+	if s.DeleteIf("x", a) {
+		t.Fatal("expected DeleteIf of replaced proxy to fail")
+	}
+	if p, ok := s.Delete("x"); !ok || p != b {
+		t.Fatalf("expected to delete proxy b, got %v", p)
+	}
+	if _, ok := s.Delete("x"); ok {
+		t.Fatal("expected second delete to fail")
+	}
+	if s.Len() != 0 {
+		t.Fatalf("expected empty store, got %d", s.Len())
+	}
+}
+
+func TestProxyStoreConcurrent(t *testing.T) {
+	s := NewProxyStore()
+	wg := sync.WaitGroup{}
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			id := fmt.Sprintf("p%d", i)
+			s.Add(id, &DynamicReverseProxy{})
+			s.Get(id)
+			s.Range(func(id string, p *DynamicReverseProxy) bool {
+				return true
+			})
+		}(i)
+	}
+	wg.Wait()
+	n := 0
+	s.Range(func(id string, p *DynamicReverseProxy) bool {
+		n++
+		return true
+	})
+	if n != 50 || s.Len() != 50 {
+		t.Fatalf("expected 50 proxies, got %d (len %d)", n, s.Len())
+	}
+}
+
+func TestProxyStoreClosesIdle(t *testing.T) {
+	var mut sync.Mutex
+	closed := 0
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	upstream.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateClosed {
+			mut.Lock()
+			closed++
+			mut.Unlock()
+		}
+	}
+	upstream.Start()
+	defer upstream.Close()
+	u, _ := url.Parse(upstream.URL)
+	s := &Server{Logger: cio.NewLogger("server"), config: &Config{}, breakers: &breakers{}}
+	newProxy := func() *DynamicReverseProxy {
+		p := &DynamicReverseProxy{Target: upstream.URL}
+		rp := s.newDynamicReverseProxy(p, u)
+		rec := httptest.NewRecorder()
+		rp.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected the upstream to be reached, got %d", rec.Code)
+		}
+		return p
+	}
+	waitClosed := func(n int) {
+		deadline := time.Now().Add(2 * time.Second)
+		for {
+			mut.Lock()
+			c := closed
+			mut.Unlock()
+			if c == n {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("expected %d upstream connections to be closed, got %d", n, c)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	store := NewProxyStore()
+	a := newProxy()
+	store.Add("x", a)
+	//re-adding the same proxy keeps its connections
+	store.Add("x", a)
+	//replacing, deleting and conditionally deleting close them
+	store.Add("x", newProxy())
+	waitClosed(1)
+	store.Delete("x")
+	waitClosed(2)
+	b := newProxy()
+	store.Add("y", b)
+	store.DeleteIf("y", b)
+	waitClosed(3)
+	//grpc proxies have their own http2 transport
+	h2 := httptest.NewUnstartedServer(upstream.Config.Handler)
+	h2.Config.ConnState = upstream.Config.ConnState
+	h2.EnableHTTP2 = true
+	h2.StartTLS()
+	defer h2.Close()
+	u, _ = url.Parse(h2.URL)
+	g := &DynamicReverseProxy{Target: h2.URL, TLS: h2.Client().Transport.(*http.Transport).TLSClientConfig}
+	h, err := newGRPCProxyHandler(s, g, u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("POST", "/", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected the grpc upstream to be reached, got %d", rec.Code)
+	}
+	store.Add("z", g)
+	store.Delete("z")
+	waitClosed(4)
+}
@@ -0,0 +1,75 @@
+package chserver
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"strings"
+)
+
+// ProxyTLSConfig configures TLS to https (and wss) proxy targets,
+// certificates and keys are PEM encoded
+type ProxyTLSConfig struct {
+	//CA replaces the system roots when verifying the target
+	CA string `json:"ca,omitempty"`
+	//Cert and Key are the client certificate sent to the target
+	Cert string `json:"cert,omitempty"`
+	Key  string `json:"key,omitempty"`
+	//ServerName overrides the name verified in the target's certificate
+	ServerName string `json:"servername,omitempty"`
+	//SkipVerify disables verification of the target's certificate
+	SkipVerify bool `json:"skipverify,omitempty"`
+}
+
+// load builds the tls.Config, when allowFiles is set (only for
+// the proxies file) CA, Cert and Key may also be file paths
+func (c *ProxyTLSConfig) load(allowFiles bool) (*tls.Config, error) {
+	pem := func(field, value string) ([]byte, error) {
+		if strings.HasPrefix(strings.TrimSpace(value), "-----BEGIN") {
+			return []byte(value), nil
+		}
+		if !allowFiles {
+			return nil, errors.New("tls " + field + " must be PEM encoded")
+		}
+		b, err := ioutil.ReadFile(value)
+		if err != nil {
+			return nil, errors.New("Failed to load tls " + field + ": " + err.Error())
+		}
+		return b, nil
+	}
+	tc := &tls.Config{
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.SkipVerify,
+	}
+	if c.CA != "" {
+		b, err := pem("ca", c.CA)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, errors.New("Failed to decode tls ca")
+		}
+		tc.RootCAs = pool
+	}
+	if c.Cert != "" || c.Key != "" {
+		if c.Cert == "" || c.Key == "" {
+			return nil, errors.New("tls cert and key must be set together")
+		}
+		cert, err := pem("cert", c.Cert)
+		if err != nil {
+			return nil, err
+		}
+		key, err := pem("key", c.Key)
+		if err != nil {
+			return nil, err
+		}
+		pair, err := tls.X509KeyPair(cert, key)
+		if err != nil {
+			return nil, errors.New("Invalid tls cert or key: " + err.Error())
+		}
+		tc.Certificates = []tls.Certificate{pair}
+	}
+	return tc, nil
+}
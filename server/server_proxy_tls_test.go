@@ -0,0 +1,49 @@
+package chserver
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/jpillora/chisel/share/cio"
+)
+
+func TestProxyTLSCustomCA(t *testing.T) {
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer upstream.Close()
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: upstream.Certificate().Raw})
+	s := &Server{Logger: cio.NewLogger("server"), config: &Config{}}
+	u, _ := url.Parse(upstream.URL)
+	for _, c := range []struct {
+		tls    *ProxyTLSConfig
+		status int
+	}{
+		{nil, http.StatusBadGateway},
+		{&ProxyTLSConfig{CA: string(ca)}, http.StatusNoContent},
+		{&ProxyTLSConfig{SkipVerify: true}, http.StatusNoContent},
+	} {
+		pd := &ProxyData{Target: upstream.URL, TLS: c.tls}
+		drProxy := &DynamicReverseProxy{Id: "abc"}
+		if err := s.parseProxyOptions(pd, drProxy); err != nil {
+			t.Fatal(err)
+		}
+		h, err := s.newDynamicProxyHandler(ProxyTypeHandlerFunc(newHTTPProxyHandler), pd, drProxy, u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/abc/", nil))
+		if rec.Code != c.status {
+			t.Errorf("expected status %d, got %d", c.status, rec.Code)
+		}
+	}
+	//registered proxies may not read server files
+	pd := &ProxyData{TLS: &ProxyTLSConfig{CA: "/etc/passwd"}}
+	if err := s.parseProxyOptions(pd, &DynamicReverseProxy{}); err == nil {
+		t.Fatal("expected file path to be rejected")
+	}
+}
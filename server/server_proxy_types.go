@@ -0,0 +1,267 @@
+package chserver
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jpillora/chisel/share/cio"
+	"github.com/jpillora/chisel/share/ctrace"
+	"golang.org/x/net/http2"
+)
+
+// ProxyTypeHandler creates the http.Handler which serves
+// requests for dynamic proxies of a particular ProxyType
+type ProxyTypeHandler interface {
+	NewHandler(s *Server, drProxy *DynamicReverseProxy, target *url.URL) (http.Handler, error)
+}
+
+// ProxyTypeHandlerFunc adapts a function into a ProxyTypeHandler
+type ProxyTypeHandlerFunc func(s *Server, drProxy *DynamicReverseProxy, target *url.URL) (http.Handler, error)
+
+// NewHandler calls f(s, drProxy, target)
+func (f ProxyTypeHandlerFunc) NewHandler(s *Server, drProxy *DynamicReverseProxy, target *url.URL) (http.Handler, error) {
+	return f(s, drProxy, target)
+}
+
+var proxyTypes = struct {
+	sync.RWMutex
+	inner map[string]ProxyTypeHandler
+}{inner: map[string]ProxyTypeHandler{}}
+
+// RegisterProxyType makes a proxy type available to dynamic proxy
+// registrations. Registering an existing type replaces its handler.
+func RegisterProxyType(proxyType string, h ProxyTypeHandler) {
+	proxyTypes.Lock()
+	proxyTypes.inner[proxyType] = h
+	proxyTypes.Unlock()
+}
+
+// ProxyTypes returns the sorted names of all registered proxy types
+func ProxyTypes() []string {
+	proxyTypes.RLock()
+	defer proxyTypes.RUnlock()
+	names := make([]string, 0, len(proxyTypes.inner))
+	for name := range proxyTypes.inner {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func getProxyType(proxyType string) (ProxyTypeHandler, bool) {
+	proxyTypes.RLock()
+	h, ok := proxyTypes.inner[proxyType]
+	proxyTypes.RUnlock()
+	return h, ok
+}
+
+func init() {
+	httpType := ProxyTypeHandlerFunc(newHTTPProxyHandler)
+	//legacy and build differ only in how they are addressed
+	RegisterProxyType("legacy", httpType)
+	RegisterProxyType("build", httpType)
+	RegisterProxyType("http", httpType)
+	RegisterProxyType("websocket", ProxyTypeHandlerFunc(newWebsocketProxyHandler))
+	RegisterProxyType("grpc", ProxyTypeHandlerFunc(newGRPCProxyHandler))
+	RegisterProxyType("tcp-stream", ProxyTypeHandlerFunc(newTCPStreamProxyHandler))
+}
+
+// newDynamicProxyHandler creates the handler for the given proxy
+// type and wraps it in the middleware requested at registration
+func (s *Server) newDynamicProxyHandler(proxyType ProxyTypeHandler, pd *ProxyData,
+	drProxy *DynamicReverseProxy, u *url.URL) (http.Handler, error) {
+	h, err := proxyType.NewHandler(s, drProxy, u)
+	if err != nil {
+		return nil, err
+	}
+	h = s.breakers.get(u.Host).wrap(h)
+	h = drProxy.Limits.wrap(h)
+	if pd.Cache != nil {
+		rc, err := newResponseCache(pd.Cache)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cache config: %s", err)
+		}
+		h = rc.wrap(h)
+	}
+	h = s.compressed(h)
+	if drProxy.AccessLog {
+		h = s.accessLogged(drProxy, h)
+	}
+	return h, nil
+}
+
+// newDynamicReverseProxy is the reverse proxy shared by all http based proxy types
+func (s *Server) newDynamicReverseProxy(drProxy *DynamicReverseProxy, u *url.URL) *httputil.ReverseProxy {
+	reverseProxy := httputil.NewSingleHostReverseProxy(u)
+	t := drProxy.Limits.transport()
+	t.TLSClientConfig = drProxy.TLS
+	reverseProxy.Transport = t
+	drProxy.transport = t
+	//always use proxy host
+	reverseProxy.Director = func(r *http.Request) {
+		r.URL.Scheme = u.Scheme
+		r.URL.Host = u.Host
+		r.Host = u.Host
+		r.Header.Set("X-Forwarded-Host", r.Header.Get("Host"))
+		r.Header.Set("Origin", u.Scheme+"://"+u.Host)
+		if r.Header.Get("X-Subservice-Type") == "true" || isHostRouted(r) {
+			return
+		}
+		// legacy, strip the proxy id from the path
+		r.URL.Path = stripProxyID(r.URL.Path)
+		s.proxyLog().With("correlation_id", ctrace.CorrelationID(r.Context())).Infof("Redirecting request to %s at %s\n", r.URL, time.Now().UTC())
+	}
+	//text/event-stream responses are always flushed immediately
+	reverseProxy.FlushInterval = s.config.FlushInterval
+	if drProxy.FlushInterval != 0 {
+		reverseProxy.FlushInterval = drProxy.FlushInterval
+	}
+	b := s.breakers.get(u.Host)
+	reverseProxy.ModifyResponse = func(resp *http.Response) error {
+		s.observeUpstream(b, u.Host, nil, resp.StatusCode)
+		return nil
+	}
+	reverseProxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		status := http.StatusBadGateway
+		switch {
+		case isBodyTooLarge(err):
+			//client's fault, not the upstream's
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		case isTimeout(r, err):
+			status = http.StatusGatewayTimeout
+			s.observeUpstream(b, u.Host, err, status)
+		case r.Context().Err() == nil:
+			//otherwise the client went away
+			s.observeUpstream(b, u.Host, err, 0)
+		}
+		s.proxyLog().Infof("Upstream %s error: %s", u.Host, err)
+		w.WriteHeader(status)
+	}
+	return reverseProxy
+}
+
+// stripProxyID removes the leading proxy id path segment
+func stripProxyID(path string) string {
+	path = strings.TrimPrefix(path, "/")
+	pathParts := strings.SplitN(path, "/", 2)
+	if len(pathParts) >= 2 && pathParts[1] != "" {
+		return "/" + pathParts[1]
+	}
+	return "/"
+}
+
+func newHTTPProxyHandler(s *Server, drProxy *DynamicReverseProxy, u *url.URL) (http.Handler, error) {
+	return s.newDynamicReverseProxy(drProxy, u), nil
+}
+
+func newWebsocketProxyHandler(s *Server, drProxy *DynamicReverseProxy, u *url.URL) (http.Handler, error) {
+	//httputil handles the upgrade, accept ws(s) schemes as http(s)
+	switch u.Scheme {
+	case "ws":
+		u.Scheme = "http"
+	case "wss":
+		u.Scheme = "https"
+	}
+	rp := s.newDynamicReverseProxy(drProxy, u)
+	//messages must not wait in buffers
+	rp.FlushInterval = -1
+	return rp, nil
+}
+
+func newGRPCProxyHandler(s *Server, drProxy *DynamicReverseProxy, u *url.URL) (http.Handler, error) {
+	rp := s.newDynamicReverseProxy(drProxy, u)
+	//grpc requires http2, plaintext targets use h2c
+	t := &http2.Transport{TLSClientConfig: drProxy.TLS}
+	if u.Scheme == "http" {
+		t.AllowHTTP = true
+		t.DialTLS = func(network, addr string, cfg *tls.Config) (net.Conn, error) {
+			return drProxy.Limits.dialer().Dial(network, addr)
+		}
+	}
+	rp.Transport = t
+	drProxy.transport = t
+	rp.FlushInterval = -1
+	return rp, nil
+}
+
+// tcpStreamProxy upgrades the client request to a raw
+// byte stream and pipes it to the target host
+type tcpStreamProxy struct {
+	*cio.Logger
+	s           *Server
+	addr        string
+	breaker     *circuitBreaker
+	dialTimeout time.Duration
+}
+
+func newTCPStreamProxyHandler(s *Server, drProxy *DynamicReverseProxy, u *url.URL) (http.Handler, error) {
+	if u.Port() == "" {
+		return nil, fmt.Errorf("tcp-stream target requires a port (%s)", u)
+	}
+	dialTimeout := drProxy.Limits.DialTimeout
+	if dialTimeout == 0 {
+		dialTimeout = 10 * time.Second
+	}
+	return &tcpStreamProxy{
+		Logger:      s.proxyLog().Fork("tcp-stream#%s", u.Host),
+		s:           s,
+		addr:        u.Host,
+		breaker:     s.breakers.get(u.Host),
+		dialTimeout: dialTimeout,
+	}, nil
+}
+
+func (t *tcpStreamProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodConnect && !strings.EqualFold(r.Header.Get("Upgrade"), "tcp") {
+		http.Error(w, "tcp-stream requires CONNECT or 'Upgrade: tcp'", http.StatusBadRequest)
+		return
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "connection cannot be hijacked", http.StatusInternalServerError)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), t.dialTimeout)
+	defer cancel()
+	var d net.Dialer
+	dst, err := d.DialContext(ctx, "tcp", t.addr)
+	t.s.observeUpstream(t.breaker, t.addr, err, 0)
+	if err != nil {
+		t.Infof("Dial failed: %s", err)
+		http.Error(w, "upstream unavailable", http.StatusBadGateway)
+		return
+	}
+	src, rw, err := hj.Hijack()
+	if err != nil {
+		dst.Close()
+		t.Infof("Hijack failed: %s", err)
+		return
+	}
+	if r.Method == http.MethodConnect {
+		rw.WriteString("HTTP/1.1 200 Connection established\r\n\r\n")
+	} else {
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: tcp\r\n\r\n")
+	}
+	if err := rw.Flush(); err != nil {
+		src.Close()
+		dst.Close()
+		return
+	}
+	//forward anything the client sent early
+	if n := rw.Reader.Buffered(); n > 0 {
+		b, _ := rw.Reader.Peek(n)
+		dst.Write(b)
+	}
+	sent, received := cio.Pipe(src, dst)
+	t.Debugf("Closed (sent %d received %d)", sent, received)
+}
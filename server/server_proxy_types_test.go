@@ -0,0 +1,152 @@
+package chserver
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/jpillora/chisel/share/cio"
+)
+
+func TestDynamicProxyStreamsEvents(t *testing.T) {
+	done := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: hello\n\n")
+		w.(http.Flusher).Flush()
+		//hold the stream open, the event must arrive anyway
+		<-done
+	}))
+	defer upstream.Close()
+	s := &Server{
+		Logger:   cio.NewLogger("server"),
+		config:   &Config{FlushInterval: time.Hour},
+		compress: newCompressMiddleware(CompressConfig{}),
+	}
+	u, _ := url.Parse(upstream.URL)
+	drProxy := &DynamicReverseProxy{Id: "abc"}
+	h, err := s.newDynamicProxyHandler(ProxyTypeHandlerFunc(newHTTPProxyHandler), &ProxyData{}, drProxy, u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := httptest.NewServer(h)
+	defer proxy.Close()
+	defer close(done)
+	resp, err := http.Get(proxy.URL + "/abc/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	line := make(chan string, 1)
+	go func() {
+		l, _ := bufio.NewReader(resp.Body).ReadString('\n')
+		line <- l
+	}()
+	select {
+	case l := <-line:
+		if l != "data: hello\n" {
+			t.Fatalf("unexpected event %q", l)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("event was buffered")
+	}
+}
+
+func TestRegisterProxyType(t *testing.T) {
+	for _, name := range []string{"legacy", "build", "http", "websocket", "grpc", "tcp-stream"} {
+		if _, ok := getProxyType(name); !ok {
+			t.Fatalf("expected %s to be registered", name)
+		}
+	}
+	if _, ok := getProxyType("test-type"); ok {
+		t.Fatal("unexpected test-type")
+	}
+	RegisterProxyType("test-type", ProxyTypeHandlerFunc(newHTTPProxyHandler))
+	defer func() {
+		proxyTypes.Lock()
+		delete(proxyTypes.inner, "test-type")
+		proxyTypes.Unlock()
+	}()
+	if _, ok := getProxyType("test-type"); !ok {
+		t.Fatal("expected test-type to be registered")
+	}
+	names := ProxyTypes()
+	i := sort.SearchStrings(names, "test-type")
+	if !sort.StringsAreSorted(names) || i == len(names) || names[i] != "test-type" {
+		t.Fatalf("unexpected proxy types %v", names)
+	}
+}
+
+func TestStripProxyID(t *testing.T) {
+	for path, expected := range map[string]string{
+		"":             "/",
+		"/abc":         "/",
+		"/abc/":        "/",
+		"/abc/x/y?z":   "/x/y?z",
+		"abc/index.js": "/index.js",
+	} {
+		if got := stripProxyID(path); got != expected {
+			t.Errorf("%q: expected %q, got %q", path, expected, got)
+		}
+	}
+}
+
+func TestTCPStreamProxy(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		io.Copy(c, c)
+	}()
+	s := &Server{Logger: cio.NewLogger("server")}
+	if _, err := newTCPStreamProxyHandler(s, &DynamicReverseProxy{}, &url.URL{Scheme: "tcp", Host: "127.0.0.1"}); err == nil {
+		t.Fatal("expected a target without a port to be rejected")
+	}
+	h, err := newTCPStreamProxyHandler(s, &DynamicReverseProxy{}, &url.URL{Scheme: "tcp", Host: l.Addr().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := httptest.NewServer(h)
+	defer proxy.Close()
+	resp, err := http.Get(proxy.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected plain requests to be rejected, got %d", resp.StatusCode)
+	}
+	c, err := net.Dial("tcp", proxy.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(2 * time.Second))
+	fmt.Fprint(c, "GET / HTTP/1.1\r\nHost: x\r\nConnection: Upgrade\r\nUpgrade: tcp\r\n\r\nping")
+	br := bufio.NewReader(c)
+	resp, err = http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("expected 101, got %d", resp.StatusCode)
+	}
+	b := make([]byte, 4)
+	if _, err := io.ReadFull(br, b); err != nil || string(b) != "ping" {
+		t.Fatalf("expected the early bytes echoed, got %q (%v)", b, err)
+	}
+}
@@ -0,0 +1,190 @@
+package chserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/jpillora/chisel/share/settings"
+)
+
+// publicHosts are the public reverse remotes, such as R:https://myapp,
+// served at <subdomain>.<public-domain>, by subdomain
+type publicHosts struct {
+	mut   sync.Mutex
+	inner map[string]*publicHost
+}
+
+// publicHost is the public remote of a session, and the proxy
+// which sends its requests over the session
+type publicHost struct {
+	session   int32
+	remote    *settings.Remote
+	proxy     *httputil.ReverseProxy
+	transport *http.Transport
+}
+
+func newPublicHosts() *publicHosts {
+	return &publicHosts{inner: map[string]*publicHost{}}
+}
+
+// claim exposes the session's remote at its subdomain,
+// unless another session's remote already is
+func (p *publicHosts) claim(h *publicHost) error {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	if o, ok := p.inner[h.remote.Subdomain]; ok {
+		if o.session != h.session {
+			return fmt.Errorf("subdomain %s is in use", h.remote.Subdomain)
+		}
+		o.transport.CloseIdleConnections()
+	}
+	p.inner[h.remote.Subdomain] = h
+	return nil
+}
+
+func (p *publicHosts) get(subdomain string) (*publicHost, bool) {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	h, ok := p.inner[subdomain]
+	return h, ok
+}
+
+// subdomains lists the exposed subdomains
+func (p *publicHosts) subdomains() []string {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	out := make([]string, 0, len(p.inner))
+	for subdomain := range p.inner {
+		out = append(out, subdomain)
+	}
+	return out
+}
+
+// release removes the subdomain if the session exposed it
+func (p *publicHosts) release(session int32, subdomain string) {
+	if p == nil {
+		return
+	}
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	if h, ok := p.inner[subdomain]; ok && h.session == session {
+		h.transport.CloseIdleConnections()
+		delete(p.inner, subdomain)
+	}
+}
+
+// releaseSession removes the subdomains of a closed session
+func (p *publicHosts) releaseSession(session int32) {
+	if p == nil {
+		return
+	}
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	for subdomain, h := range p.inner {
+		if h.session == session {
+			h.transport.CloseIdleConnections()
+			delete(p.inner, subdomain)
+		}
+	}
+}
+
+// exposeRemote claims the subdomain of a public remote for the
+// session, recording its URL in the reply
+func (s *Server) exposeRemote(session int32, r *settings.Remote, reply *settings.ConfigReply) error {
+	h := &publicHost{session: session, remote: r}
+	h.transport = &http.Transport{
+		//the connections are channels of the session
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			sess, ok := s.tunnels.get(session)
+			if !ok || sess.tunnel == nil {
+				return nil, errors.New("session closed")
+			}
+			return sess.tunnel.Dial(ctx, r)
+		},
+		MaxIdleConnsPerHost: 8,
+		IdleConnTimeout:     settings.Environment().WSTimeout,
+	}
+	h.proxy = httputil.NewSingleHostReverseProxy(&url.URL{Scheme: "http", Host: r.Remote()})
+	director := h.proxy.Director
+	h.proxy.Director = func(req *http.Request) {
+		host := req.Host
+		director(req)
+		//the service sees the public host
+		req.Host = host
+		req.Header.Set("X-Forwarded-Host", host)
+		req.Header.Set("X-Forwarded-Proto", r.LocalProto)
+	}
+	h.proxy.Transport = h.transport
+	h.proxy.FlushInterval = s.config.FlushInterval
+	h.proxy.ErrorHandler = func(w http.ResponseWriter, req *http.Request, err error) {
+		s.Debugf("Public remote %s: %s", r, err)
+		http.Error(w, "Tunnel unavailable", http.StatusBadGateway)
+	}
+	//subdomains are unique across the cluster
+	if u, err := s.cluster.owner(context.Background(), "public:"+r.Subdomain); err != nil {
+		return err
+	} else if u != "" {
+		return fmt.Errorf("subdomain %s is in use on %s", r.Subdomain, u)
+	}
+	if err := s.publicHosts.claim(h); err != nil {
+		return err
+	}
+	if reply.URLs == nil {
+		reply.URLs = map[string]string{}
+	}
+	reply.URLs[r.String()] = s.publicURL(r)
+	return nil
+}
+
+// publicURL is where a public remote is served
+func (s *Server) publicURL(r *settings.Remote) string {
+	return r.LocalProto + "://" + r.Subdomain + "." + s.config.PublicDomain
+}
+
+// publicSubdomain is the subdomain of a host of the public domain
+func (s *Server) publicSubdomain(host string) (string, bool) {
+	domain := s.config.PublicDomain
+	if domain == "" {
+		return "", false
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	label := strings.TrimSuffix(host, "."+domain)
+	if label == host || strings.Contains(label, ".") {
+		return "", false
+	}
+	return label, true
+}
+
+// handlePublicHost proxies the requests to <subdomain>.<public-domain>
+// over the session exposing it, it reports false for other hosts
+func (s *Server) handlePublicHost(w http.ResponseWriter, r *http.Request) bool {
+	subdomain, ok := s.publicSubdomain(r.Host)
+	if !ok {
+		return false
+	}
+	h, ok := s.publicHosts.get(subdomain)
+	if !ok {
+		if s.cluster.forward(w, r, "public:"+subdomain) {
+			return true
+		}
+		http.Error(w, "Tunnel "+subdomain+" not found", http.StatusNotFound)
+		return true
+	}
+	s.compressed(h.proxy).ServeHTTP(w, r)
+	return true
+}
+
+// servesTLS reports whether the server's listener is TLS
+func (s *Server) servesTLS() bool {
+	return len(s.config.TLS.Domains) > 0 || (s.config.TLS.Key != "" && s.config.TLS.Cert != "")
+}
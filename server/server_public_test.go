@@ -0,0 +1,55 @@
+package chserver
+
+import (
+	"testing"
+
+	"github.com/jpillora/chisel/share/cio"
+	"github.com/jpillora/chisel/share/settings"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestPublicHosts(t *testing.T) {
+	s := &Server{
+		Logger:      cio.NewLogger("server"),
+		config:      &Config{PublicDomain: "demo.example.com", Reverse: true},
+		tunnels:     newSessionStore(),
+		publicHosts: newPublicHosts(),
+	}
+	for host, expected := range map[string]string{
+		"MyApp.demo.example.com:443": "myapp",
+		"myapp.demo.example.com.":    "myapp",
+		"a.b.demo.example.com":       "",
+		"demo.example.com":           "",
+		"myapp.example.com":          "",
+	} {
+		if sub, _ := s.publicSubdomain(host); sub != expected {
+			t.Fatalf("expected %s to be '%s', got '%s'", host, expected, sub)
+		}
+	}
+	r, _ := settings.DecodeRemote("R:http://myapp:3000")
+	var reply settings.ConfigReply
+	if err := s.exposeRemote(1, r, &reply); err != nil || reply.URLs[r.String()] != "http://myapp.demo.example.com" {
+		t.Fatalf("expected the URL in the reply, got %v %v", reply.URLs, err)
+	}
+	//the subdomain belongs to session 1 until it is released
+	if err := s.exposeRemote(2, r, &reply); err == nil {
+		t.Fatal("expected the subdomain to be in use")
+	}
+	s.publicHosts.release(2, "myapp")
+	if _, ok := s.publicHosts.get("myapp"); !ok {
+		t.Fatal("expected only the owner to release the subdomain")
+	}
+	s.publicHosts.releaseSession(1)
+	if err := s.exposeRemote(2, r, &reply); err != nil {
+		t.Fatal(err)
+	}
+	//https requires a TLS listener
+	https, _ := settings.DecodeRemote("R:https://myapp:3000")
+	sshConn := &ssh.ServerConn{Permissions: &ssh.Permissions{}}
+	if err := s.checkRemote(s.Logger, nil, sshConn, https); err == nil {
+		t.Fatal("expected https to require TLS")
+	}
+	if err := s.checkRemote(s.Logger, nil, sshConn, r); err != nil {
+		t.Fatal(err)
+	}
+}
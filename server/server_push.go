@@ -0,0 +1,25 @@
+package chserver
+
+import "github.com/jpillora/chisel/share/settings"
+
+// pushRemotes are the remotes pushed to a client of the user, those
+// of the server then the user's own, less those it requested itself
+func (s *Server) pushRemotes(user *settings.User, requested settings.Remotes) settings.Remotes {
+	all := s.pushed
+	if user != nil {
+		all = append(append(settings.Remotes{}, all...), user.Pushed...)
+	}
+	seen := map[string]bool{}
+	for _, r := range requested {
+		seen[r.String()] = true
+	}
+	var out settings.Remotes
+	for _, r := range all {
+		if seen[r.String()] {
+			continue
+		}
+		seen[r.String()] = true
+		out = append(out, r)
+	}
+	return out
+}
@@ -0,0 +1,32 @@
+package chserver
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/jpillora/chisel/share/settings"
+)
+
+func TestPushRemotes(t *testing.T) {
+	decode := func(remotes ...string) settings.Remotes {
+		rs, err := settings.DecodePushed(remotes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rs
+	}
+	s := &Server{pushed: decode("R:2222:localhost:22", "3000")}
+	user := &settings.User{Pushed: decode("3000", "R:0:localhost:80")}
+	//the client's own remotes, and duplicates, aren't pushed
+	got := s.pushRemotes(user, decode("R:2222:localhost:22")).Encode()
+	expected := []string{"0.0.0.0:3000:127.0.0.1:3000", "R:0.0.0.0:0:localhost:80"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected %v, got %v", expected, got)
+	}
+	if got := s.pushRemotes(nil, nil); len(got) != 2 {
+		t.Fatalf("expected the server's remotes, got %v", got)
+	}
+	if _, err := settings.DecodePushed([]string{"stdio:localhost:22"}); err == nil {
+		t.Fatal("expected stdio remotes not to be pushed")
+	}
+}
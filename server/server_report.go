@@ -0,0 +1,40 @@
+package chserver
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/jpillora/chisel/share/creport"
+	"github.com/jpillora/chisel/share/ctrace"
+)
+
+type reportTagsKey struct{}
+
+// reportPanics reports panics of the http handlers, with the
+// tags the handler set, before net/http logs them
+func (s *Server) reportPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tags := creport.Tags{"method": r.Method, "path": r.URL.Path}
+		if id := ctrace.CorrelationID(r.Context()); id != "" {
+			tags["correlation_id"] = id
+		}
+		defer func() {
+			if p := recover(); p != nil {
+				//aborted responses are not errors
+				if p != http.ErrAbortHandler {
+					creport.Panic(p, tags)
+				}
+				panic(p)
+			}
+		}()
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), reportTagsKey{}, tags)))
+	})
+}
+
+// setReportTag adds context, such as the session or
+// job, to reports from the request's handler
+func setReportTag(r *http.Request, key, value string) {
+	if tags, ok := r.Context().Value(reportTagsKey{}).(creport.Tags); ok {
+		tags[key] = value
+	}
+}
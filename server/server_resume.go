@@ -0,0 +1,41 @@
+package chserver
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+
+	"github.com/jpillora/chisel/share/cnet"
+)
+
+// newResumeToken is the secret a client
+// resumes its session with
+func newResumeToken() string {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// handleResume attaches the websocket of a reconnecting client to
+// its session, whose connection resends the bytes lost in between
+func (s *Server) handleResume(w http.ResponseWriter, req *http.Request, token string) {
+	sess, ok := s.tunnels.resumable(token)
+	if !ok {
+		//expired or unknown, the client starts a new session
+		http.Error(w, "Session not found", http.StatusGone)
+		return
+	}
+	l := s.Fork("session#%d", sess.id)
+	wsConn, err := upgrader.Upgrade(w, req, s.cluster.affinityHeader())
+	if err != nil {
+		l.Debugf("Failed to upgrade (%s)", err)
+		return
+	}
+	if err := sess.resumable.Attach(cnet.NewWebSocketConn(wsConn)); err != nil {
+		l.Infof("Failed to resume from %s (%s)", req.RemoteAddr, err)
+		return
+	}
+	l.Infof("Resumed from %s", req.RemoteAddr)
+}
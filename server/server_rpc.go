@@ -0,0 +1,174 @@
+package chserver
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// rpcSource marks proxies pushed over dcrpc
+const rpcSource = "rpc"
+
+// rpcProxy is the RegisterProxy request, the fields of a
+// proxies file entry as a google.protobuf.Struct
+type rpcProxy struct {
+	Id      string `json:"id"`
+	AuthKey string `json:"authkey"`
+	ProxyData
+}
+
+// proxiesServiceDesc is dcrpc.ChiselProxies, which lets dcmaster (or
+// other orchestrators) push routes into chisel. Messages are
+// google.protobuf.Struct, as with the dcmaster events stream.
+var proxiesServiceDesc = grpc.ServiceDesc{
+	ServiceName: "dcrpc.ChiselProxies",
+	HandlerType: (*interface{})(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "RegisterProxy", Handler: rpcHandler("RegisterProxy", (*Server).rpcRegisterProxy)},
+		{MethodName: "RemoveProxy", Handler: rpcHandler("RemoveProxy", (*Server).rpcRemoveProxy)},
+		{MethodName: "ListProxies", Handler: rpcHandler("ListProxies", (*Server).rpcListProxies)},
+	},
+}
+
+type rpcMethod func(s *Server, ctx context.Context, req *structpb.Struct) (*structpb.Struct, error)
+
+func rpcHandler(name string, m rpcMethod) func(interface{}, context.Context, func(interface{}) error, grpc.UnaryServerInterceptor) (interface{}, error) {
+	return func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+		req := &structpb.Struct{}
+		if err := dec(req); err != nil {
+			return nil, err
+		}
+		s := srv.(*Server)
+		if interceptor == nil {
+			return m(s, ctx, req)
+		}
+		return interceptor(ctx, req, &grpc.UnaryServerInfo{Server: srv, FullMethod: "/dcrpc.ChiselProxies/" + name}, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return m(s, ctx, req.(*structpb.Struct))
+		})
+	}
+}
+
+// serveRPC serves dcrpc.ChiselProxies on addr until ctx is cancelled,
+// it requires the RPC_TOKEN, which is plaintext without RPCTLS
+func (s *Server) serveRPC(ctx context.Context, addr string) error {
+	if s.config.RPCToken == "" {
+		return errors.New("dcrpc server requires an RPC_TOKEN")
+	}
+	opts := []grpc.ServerOption{grpc.UnaryInterceptor(s.rpcAuth)}
+	if t := s.config.RPCTLS; t.Key != "" || t.Cert != "" {
+		c, err := s.tlsKeyCert(t.Key, t.Cert, t.CA)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, grpc.Creds(credentials.NewTLS(c)))
+	} else {
+		s.Infof("dcrpc server on %s has no TLS, the RPC_TOKEN is sent in the clear", addr)
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	srv := grpc.NewServer(opts...)
+	srv.RegisterService(&proxiesServiceDesc, s)
+	go func() {
+		<-ctx.Done()
+		srv.GracefulStop()
+	}()
+	go srv.Serve(l)
+	s.rpcAddr = l.Addr().String()
+	s.Infof("dcrpc server listening on %s", s.rpcAddr)
+	return nil
+}
+
+// rpcAuth requires the RPC_TOKEN as the authorization metadata
+func (s *Server) rpcAuth(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	token := ""
+	if v := md.Get("authorization"); len(v) > 0 {
+		token = v[0]
+	}
+	if s.config.RPCToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(s.config.RPCToken)) != 1 {
+		return nil, status.Error(codes.Unauthenticated, "invalid token")
+	}
+	return handler(ctx, req)
+}
+
+func (s *Server) rpcRegisterProxy(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error) {
+	b, err := req.MarshalJSON()
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	var rp rpcProxy
+	if err := json.Unmarshal(b, &rp); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	drProxy, err := s.newManagedProxy(&proxiesFileEntry{Id: rp.Id, AuthKey: rp.AuthKey, ProxyData: rp.ProxyData}, rpcSource)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	pId := drProxy.Id
+	drProxy.RegisteredBy = rpcActor(ctx, "rpc")
+	if existing, ok := s.dynamicReverseProxies.Get(pId); ok && existing.Source != rpcSource {
+		source := existing.Source
+		if source == "" {
+			source = "http"
+		}
+		return nil, status.Errorf(codes.AlreadyExists, "proxy (%s) is managed by %s", pId, source)
+	}
+	if err := s.checkProxyHost(pId, drProxy.Subdomain); err != nil {
+		return nil, status.Error(codes.AlreadyExists, err.Error())
+	}
+	if drProxy.Subdomain != "" && !s.proxyHosts.claim(drProxy.Subdomain, pId) {
+		return nil, status.Errorf(codes.AlreadyExists, "subdomain (%s) already in use", drProxy.Subdomain)
+	}
+	if prev := s.dynamicReverseProxies.Add(pId, drProxy); prev != nil && prev.Subdomain != drProxy.Subdomain {
+		s.proxyHosts.release(prev.Subdomain, pId)
+	}
+	s.notifyProxy(eventProxyRegistered, pId, drProxy, drProxy.RegisteredBy)
+	s.Infof("dcrpc: registered proxy %s to %s", pId, drProxy.Target)
+	return structpb.NewStruct(map[string]interface{}{
+		"id":   pId,
+		"host": s.proxyHost(drProxy),
+	})
+}
+
+func (s *Server) rpcRemoveProxy(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error) {
+	pId := req.GetFields()["id"].GetStringValue()
+	existing, ok := s.dynamicReverseProxies.Get(pId)
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "proxy (%s) not found", pId)
+	}
+	if existing.Source != rpcSource {
+		return nil, status.Errorf(codes.PermissionDenied, "proxy (%s) is not managed over dcrpc", pId)
+	}
+	s.removeDynamicProxy(pId, rpcActor(ctx, "rpc"))
+	s.Infof("dcrpc: removed proxy %s", pId)
+	return &structpb.Struct{}, nil
+}
+
+func (s *Server) rpcListProxies(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error) {
+	proxies := []interface{}{}
+	s.dynamicReverseProxies.Range(func(pId string, p *DynamicReverseProxy) bool {
+		proxies = append(proxies, map[string]interface{}{
+			"id":        pId,
+			"target":    p.Target,
+			"subdomain": p.Subdomain,
+			"access":    p.Access,
+			"source":    p.Source,
+			"host":      s.proxyHost(p),
+			"job_id":    p.JobId,
+			"user_id":   p.User,
+		})
+		return true
+	})
+	return structpb.NewStruct(map[string]interface{}{"proxies": proxies})
+}
@@ -0,0 +1,171 @@
+package chserver
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jpillora/chisel/share/cio"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func TestRPCProxies(t *testing.T) {
+	s := &Server{
+		Logger:                cio.NewLogger("server"),
+		config:                &Config{ProxyDomain: "example.com", RPCToken: "secret"},
+		dynamicReverseProxies: NewProxyStore(),
+		proxyHosts:            newProxyHosts(),
+	}
+	s.dynamicReverseProxies.Add("registered", &DynamicReverseProxy{Id: "registered"})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := s.serveRPC(ctx, "127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := grpc.Dial(s.rpcAddr, grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	call := func(ctx context.Context, method string, req map[string]interface{}) (*structpb.Struct, error) {
+		in, err := structpb.NewStruct(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := &structpb.Struct{}
+		return out, conn.Invoke(ctx, "/dcrpc.ChiselProxies/"+method, in, out)
+	}
+	if _, err := call(ctx, "ListProxies", nil); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected unauthenticated, got %v", err)
+	}
+	ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "secret")
+	out, err := call(ctx, "RegisterProxy", map[string]interface{}{
+		"id": "docs", "target": "http://10.0.0.5:8080", "subdomain": "docs", "access": "public",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if host := out.GetFields()["host"].GetStringValue(); host != "docs.example.com" {
+		t.Fatalf("unexpected host %s", host)
+	}
+	if p, ok := s.dynamicReverseProxies.Get("docs"); !ok || p.Source != rpcSource {
+		t.Fatal("expected docs to be registered over rpc")
+	}
+	if _, err := call(ctx, "RegisterProxy", map[string]interface{}{"id": "registered", "target": "http://10.0.0.6:8080", "access": "public"}); status.Code(err) != codes.AlreadyExists {
+		t.Fatalf("expected already exists, got %v", err)
+	}
+	if _, err := call(ctx, "RegisterProxy", map[string]interface{}{"id": "x", "target": "http://10.0.0.6:8080", "access": "user"}); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected invalid argument, got %v", err)
+	}
+	out, err = call(ctx, "ListProxies", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(out.GetFields()["proxies"].GetListValue().GetValues()); n != 2 {
+		t.Fatalf("expected 2 proxies, got %d", n)
+	}
+	if _, err := call(ctx, "RemoveProxy", map[string]interface{}{"id": "registered"}); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("expected permission denied, got %v", err)
+	}
+	if _, err := call(ctx, "RemoveProxy", map[string]interface{}{"id": "docs"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.dynamicReverseProxies.Get("docs"); ok {
+		t.Fatal("expected docs to be removed")
+	}
+}
+
+// writeTestCert writes a self-signed certificate
+// for 127.0.0.1, and its key, to dir
+func writeTestCert(t *testing.T, dir string) (key, cert string) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "chisel"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &priv.PublicKey, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := x509.MarshalECPrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, cert = filepath.Join(dir, "key.pem"), filepath.Join(dir, "cert.pem")
+	if err := ioutil.WriteFile(key, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: b}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return key, cert
+}
+
+func TestRPCSecurity(t *testing.T) {
+	s := &Server{
+		Logger:                cio.NewLogger("server"),
+		config:                &Config{},
+		dynamicReverseProxies: NewProxyStore(),
+		proxyHosts:            newProxyHosts(),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := s.serveRPC(ctx, "127.0.0.1:0"); err == nil {
+		t.Fatal("expected the dcrpc server to require a token")
+	}
+	dir, err := ioutil.TempDir("", "chisel-rpc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	key, cert := writeTestCert(t, dir)
+	s.config.RPCToken = "secret"
+	s.config.RPCTLS = TLSConfig{Key: key, Cert: cert}
+	if err := s.serveRPC(ctx, "127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	creds, err := credentials.NewClientTLSFromFile(cert, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := grpc.Dial(s.rpcAddr, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "secret")
+	if err := conn.Invoke(ctx, "/dcrpc.ChiselProxies/ListProxies", &structpb.Struct{}, &structpb.Struct{}); err != nil {
+		t.Fatal(err)
+	}
+	//plaintext clients are refused
+	plain, err := grpc.Dial(s.rpcAddr, grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer plain.Close()
+	if err := plain.Invoke(ctx, "/dcrpc.ChiselProxies/ListProxies", &structpb.Struct{}, &structpb.Struct{}); err == nil {
+		t.Fatal("expected a plaintext client to be refused")
+	}
+}
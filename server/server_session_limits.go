@@ -0,0 +1,79 @@
+package chserver
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// sessionsRetryAfter is the Retry-After of tunnel
+// requests refused for the session limits
+const sessionsRetryAfter = 30 * time.Second
+
+// sessionLimits counts the sessions, from their websocket upgrade,
+// against MaxSessions, and those of each user, from their auth,
+// against MaxUserSessions, zero limits are unlimited
+type sessionLimits struct {
+	mut     sync.Mutex
+	max     int
+	maxUser int
+	total   int
+	users   map[string]int
+}
+
+func newSessionLimits(max, maxUser int) *sessionLimits {
+	return &sessionLimits{max: max, maxUser: maxUser, users: map[string]int{}}
+}
+
+// acquire counts a session, unless the server is full
+func (l *sessionLimits) acquire() bool {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+	if l.max > 0 && l.total >= l.max {
+		return false
+	}
+	l.total++
+	return true
+}
+
+func (l *sessionLimits) release() {
+	l.mut.Lock()
+	l.total--
+	l.mut.Unlock()
+}
+
+// userFull is whether the user has its maximum sessions
+func (l *sessionLimits) userFull(user string) bool {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+	return l.maxUser > 0 && l.users[user] >= l.maxUser
+}
+
+// acquireUser counts a session of the user, unless it is full
+func (l *sessionLimits) acquireUser(user string) bool {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+	if l.maxUser > 0 && l.users[user] >= l.maxUser {
+		return false
+	}
+	l.users[user]++
+	return true
+}
+
+func (l *sessionLimits) releaseUser(user string) {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+	if l.users[user]--; l.users[user] <= 0 {
+		delete(l.users, user)
+	}
+}
+
+// refuseSession responds to a tunnel request over the
+// session limits, limit is "sessions" or "user"
+func (s *Server) refuseSession(w http.ResponseWriter, limit, msg string) {
+	s.metrics.sessionRefused(limit)
+	s.Debugf("Refused session: %s", msg)
+	w.Header().Set("Retry-After", strconv.Itoa(int(sessionsRetryAfter.Seconds())))
+	http.Error(w, msg, http.StatusServiceUnavailable)
+}
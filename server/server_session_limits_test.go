@@ -0,0 +1,64 @@
+package chserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	chshare "github.com/jpillora/chisel/share"
+	"github.com/jpillora/chisel/share/cio"
+)
+
+func TestSessionLimits(t *testing.T) {
+	l := newSessionLimits(2, 1)
+	if !l.acquire() || !l.acquire() || l.acquire() {
+		t.Fatal("expected 2 sessions")
+	}
+	l.release()
+	if !l.acquire() {
+		t.Fatal("expected a released session to be reusable")
+	}
+	if l.userFull("alice") || !l.acquireUser("alice") || !l.userFull("alice") || l.acquireUser("alice") {
+		t.Fatal("expected 1 session for alice")
+	}
+	if !l.acquireUser("bob") {
+		t.Fatal("expected users to be limited separately")
+	}
+	l.releaseUser("alice")
+	if l.userFull("alice") || len(l.users) != 1 {
+		t.Fatalf("expected alice to be released, got %v", l.users)
+	}
+	if unlimited := newSessionLimits(0, 0); !unlimited.acquire() || !unlimited.acquireUser("alice") || !unlimited.acquireUser("alice") {
+		t.Fatal("expected zero limits to be unlimited")
+	}
+}
+
+func TestRefuseSession(t *testing.T) {
+	s := &Server{
+		Logger:  cio.NewLogger("server"),
+		config:  &Config{},
+		limits:  newSessionLimits(1, 1),
+		metrics: newServerMetrics(),
+	}
+	s.limits.acquireUser("alice")
+	refused := func(user string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set(chshare.UserHeader, user)
+		w := httptest.NewRecorder()
+		s.handleWebsocket(w, req)
+		return w
+	}
+	if w := refused("alice"); w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "30" {
+		t.Fatalf("expected alice to be refused with a Retry-After, got %d", w.Code)
+	}
+	if s.limits.total != 0 {
+		t.Fatal("expected the refused session to be released")
+	}
+	s.limits.acquire()
+	if w := refused("bob"); w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected a full server to refuse, got %d", w.Code)
+	}
+	if s.metrics.refused["user"] != 1 || s.metrics.refused["sessions"] != 1 {
+		t.Fatalf("unexpected refusals %v", s.metrics.refused)
+	}
+}
@@ -0,0 +1,274 @@
+package chserver
+
+import (
+	"context"
+	"crypto/subtle"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jpillora/chisel/share/cnet"
+	"github.com/jpillora/chisel/share/settings"
+	"github.com/jpillora/chisel/share/tunnel"
+)
+
+// session is a live tunnel connection
+type session struct {
+	//counters first, 64-bit atomics must be aligned on 32-bit platforms
+	sent       int64
+	received   int64
+	lastSeen   int64
+	id         int32
+	user       string
+	remoteAddr string
+	//clientVersion is the build version the client advertised
+	clientVersion string
+	//remotes change when the client updates them
+	remotesMut sync.Mutex
+	remotes    []string
+	//ports are those allocated for the client's
+	//ephemeral remotes, by the remote requested
+	ports     map[string]string
+	startedAt time.Time
+	//tunnel and close are set before the session is added
+	tunnel *tunnel.Tunnel
+	close  func() error
+	//resumable is the connection a reconnecting
+	//client attaches to, with resumeToken
+	resumable   *cnet.ResumableConn
+	resumeToken string
+	//rates are updated by sampleTraffic
+	rateMut     sync.Mutex
+	sampled     time.Time
+	rate        trafficRate
+	remoteRates map[string]*trafficRate
+}
+
+func (sess *session) setRemotes(rs settings.Remotes) {
+	sess.remotesMut.Lock()
+	defer sess.remotesMut.Unlock()
+	sess.remotes = nil
+	for _, r := range rs {
+		sess.remotes = append(sess.remotes, r.String())
+	}
+}
+
+// updateRemotes removes, then adds, the remotes of an update
+func (sess *session) updateRemotes(u *settings.RemotesUpdate) {
+	sess.remotesMut.Lock()
+	defer sess.remotesMut.Unlock()
+	removed := map[string]bool{}
+	for _, r := range u.Remove {
+		removed[r.String()] = true
+	}
+	remotes := []string{}
+	for _, r := range sess.remotes {
+		if !removed[r] {
+			remotes = append(remotes, r)
+		}
+	}
+	for _, r := range u.Add {
+		remotes = append(remotes, r.String())
+	}
+	sess.remotes = remotes
+}
+
+// bound returns the remote bound for one the client requested,
+// which has the allocated port of an ephemeral remote
+func (sess *session) bound(r *settings.Remote) *settings.Remote {
+	sess.remotesMut.Lock()
+	defer sess.remotesMut.Unlock()
+	port, ok := sess.ports[r.String()]
+	if !ok {
+		return r
+	}
+	b := *r
+	b.LocalPort = port
+	return &b
+}
+
+// allocated records the ports allocated for the requested
+// remotes, and forgets those of the removed remotes
+func (sess *session) allocated(ports map[string]string, removed settings.Remotes) {
+	sess.remotesMut.Lock()
+	defer sess.remotesMut.Unlock()
+	if sess.ports == nil {
+		sess.ports = map[string]string{}
+	}
+	for _, r := range removed {
+		delete(sess.ports, r.String())
+	}
+	for r, port := range ports {
+		sess.ports[r] = port
+	}
+}
+
+func (sess *session) portList() []apiPort {
+	sess.remotesMut.Lock()
+	defer sess.remotesMut.Unlock()
+	ports := []apiPort{}
+	for r, port := range sess.ports {
+		ports = append(ports, apiPort{Remote: r, Port: port})
+	}
+	sort.Slice(ports, func(i, j int) bool { return ports[i].Remote < ports[j].Remote })
+	return ports
+}
+
+func (sess *session) remoteList() []string {
+	sess.remotesMut.Lock()
+	defer sess.remotesMut.Unlock()
+	return append([]string(nil), sess.remotes...)
+}
+
+// sessionStore indexes the live sessions by id
+type sessionStore struct {
+	mut   sync.Mutex
+	inner map[int32]*session
+}
+
+func newSessionStore() *sessionStore {
+	return &sessionStore{inner: map[int32]*session{}}
+}
+
+func (s *sessionStore) add(sess *session) {
+	s.mut.Lock()
+	s.inner[sess.id] = sess
+	s.mut.Unlock()
+}
+
+func (s *sessionStore) get(id int32) (*session, bool) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	sess, ok := s.inner[id]
+	return sess, ok
+}
+
+func (s *sessionStore) del(id int32) {
+	s.mut.Lock()
+	delete(s.inner, id)
+	s.mut.Unlock()
+}
+
+// resumable returns the session with the resume token
+func (s *sessionStore) resumable(token string) (*session, bool) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	for _, sess := range s.inner {
+		if sess.resumable != nil && subtle.ConstantTimeCompare([]byte(sess.resumeToken), []byte(token)) == 1 {
+			return sess, true
+		}
+	}
+	return nil, false
+}
+
+// list returns the sessions, in no particular order
+func (s *sessionStore) list() []*session {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	out := make([]*session, 0, len(s.inner))
+	for _, sess := range s.inner {
+		out = append(out, sess)
+	}
+	return out
+}
+
+func (sess *session) seen() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&sess.lastSeen))
+}
+
+// trafficSampleInterval is how often the throughput
+// reported by the admin API is sampled
+const trafficSampleInterval = 5 * time.Second
+
+// trafficRate is the throughput, in bytes per second,
+// between the last two samples of a pair of byte counters
+type trafficRate struct {
+	sent, received int64
+	sendRate       float64
+	receiveRate    float64
+}
+
+func (r *trafficRate) sample(sent, received int64, elapsed time.Duration) {
+	if secs := elapsed.Seconds(); secs > 0 {
+		r.sendRate = float64(sent-r.sent) / secs
+		r.receiveRate = float64(received-r.received) / secs
+	}
+	r.sent, r.received = sent, received
+}
+
+// sample updates the rates of the session and of its remotes
+func (sess *session) sample(now time.Time) {
+	sess.rateMut.Lock()
+	defer sess.rateMut.Unlock()
+	last := sess.sampled
+	if last.IsZero() {
+		last = sess.startedAt
+	}
+	elapsed := now.Sub(last)
+	sess.sampled = now
+	sess.rate.sample(atomic.LoadInt64(&sess.sent), atomic.LoadInt64(&sess.received), elapsed)
+	if sess.tunnel == nil {
+		return
+	}
+	if sess.remoteRates == nil {
+		sess.remoteRates = map[string]*trafficRate{}
+	}
+	for remote, c := range sess.tunnel.Traffic() {
+		r, ok := sess.remoteRates[remote]
+		if !ok {
+			r = &trafficRate{}
+			sess.remoteRates[remote] = r
+		}
+		r.sample(c.Sent(), c.Received(), elapsed)
+	}
+}
+
+// rates returns the last sampled rates of the session and of its remotes
+func (sess *session) rates() (trafficRate, map[string]trafficRate) {
+	sess.rateMut.Lock()
+	defer sess.rateMut.Unlock()
+	remotes := make(map[string]trafficRate, len(sess.remoteRates))
+	for remote, r := range sess.remoteRates {
+		remotes[remote] = *r
+	}
+	return sess.rate, remotes
+}
+
+// sampleTraffic samples the throughput of each
+// session every interval until ctx is cancelled
+func (s *Server) sampleTraffic(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C:
+			for _, sess := range s.tunnels.list() {
+				sess.sample(now)
+			}
+		}
+	}
+}
+
+// sessionConn counts the traffic of a session
+type sessionConn struct {
+	net.Conn
+	sess *session
+}
+
+func (c *sessionConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	atomic.AddInt64(&c.sess.received, int64(n))
+	atomic.StoreInt64(&c.sess.lastSeen, time.Now().UnixNano())
+	return n, err
+}
+
+func (c *sessionConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	atomic.AddInt64(&c.sess.sent, int64(n))
+	atomic.StoreInt64(&c.sess.lastSeen, time.Now().UnixNano())
+	return n, err
+}
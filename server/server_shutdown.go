@@ -0,0 +1,60 @@
+package chserver
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// shutdownPoll is how often a shutdown checks
+// whether the sessions have drained
+const shutdownPoll = 250 * time.Millisecond
+
+// shutdown stops accepting sessions, asks the clients to reconnect
+// elsewhere, and closes each session once it has no open channels,
+// or when the timeout expires
+func (s *Server) shutdown(timeout time.Duration) {
+	atomic.StoreInt32(&s.shuttingDown, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	//closes the listener, requests in flight through the proxies complete
+	go s.httpServer.Shutdown(ctx)
+	sessions := s.tunnels.list()
+	s.Infof("Shutting down, draining %d sessions for up to %s", len(sessions), timeout)
+	for _, sess := range sessions {
+		go func(sess *session) {
+			if err := sess.tunnel.Notify(ctx, "goaway", nil); err != nil {
+				s.Debugf("session#%d: goaway failed: %s", sess.id, err)
+			}
+		}(sess)
+	}
+	ticker := time.NewTicker(shutdownPoll)
+	defer ticker.Stop()
+	closed := map[int32]bool{}
+	for {
+		remaining := 0
+		for _, sess := range s.tunnels.list() {
+			if closed[sess.id] {
+				continue
+			}
+			if s.openChannels(sess) > 0 {
+				remaining++
+				continue
+			}
+			closed[sess.id] = true
+			sess.close()
+		}
+		if remaining == 0 {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			s.Infof("Closing %d sessions which did not drain in %s", remaining, timeout)
+			for _, sess := range s.tunnels.list() {
+				sess.close()
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package chserver
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jpillora/chisel/share/cio"
+	"github.com/jpillora/chisel/share/cnet"
+	"github.com/jpillora/chisel/share/tunnel"
+)
+
+func TestShutdownDrains(t *testing.T) {
+	s := &Server{
+		Logger:     cio.NewLogger("server"),
+		config:     &Config{},
+		conns:      cnet.NewRegistry(),
+		tunnels:    newSessionStore(),
+		httpServer: cnet.NewHTTPServer(),
+	}
+	closed := make(chan int32, 2)
+	for _, id := range []int32{1, 2} {
+		sess := &session{id: id, tunnel: tunnel.New(tunnel.Config{Logger: s.Logger, Session: fmt.Sprint(id)})}
+		sess.close = func() error {
+			s.tunnels.del(sess.id)
+			closed <- sess.id
+			return nil
+		}
+		s.tunnels.add(sess)
+	}
+	//session 2 has a connection in flight
+	a, b := net.Pipe()
+	defer b.Close()
+	c := s.conns.Track(a, cnet.ConnLabels{Session: "2", Direction: cnet.Accepted})
+	done := make(chan struct{})
+	go func() {
+		s.shutdown(5 * time.Second)
+		close(done)
+	}()
+	if id := <-closed; id != 1 {
+		t.Fatalf("expected the idle session to close first, got %d", id)
+	}
+	//new sessions are refused while draining
+	w := httptest.NewRecorder()
+	s.handleWebsocket(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected a 503, got %d", w.Code)
+	}
+	select {
+	case <-done:
+		t.Fatal("expected the shutdown to wait for the open connection")
+	case <-time.After(500 * time.Millisecond):
+	}
+	c.Close()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the shutdown to finish once drained")
+	}
+	if id := <-closed; id != 2 {
+		t.Fatalf("expected session 2 to close, got %d", id)
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	s := &Server{
+		Logger:     cio.NewLogger("server"),
+		config:     &Config{},
+		conns:      cnet.NewRegistry(),
+		tunnels:    newSessionStore(),
+		httpServer: cnet.NewHTTPServer(),
+	}
+	closed := false
+	sess := &session{id: 1, tunnel: tunnel.New(tunnel.Config{Logger: s.Logger, Session: "1"})}
+	sess.close = func() error {
+		s.tunnels.del(1)
+		closed = true
+		return nil
+	}
+	s.tunnels.add(sess)
+	a, b := net.Pipe()
+	defer b.Close()
+	defer a.Close()
+	s.conns.Track(a, cnet.ConnLabels{Session: "1", Direction: cnet.Accepted})
+	t0 := time.Now()
+	s.shutdown(300 * time.Millisecond)
+	if !closed || time.Since(t0) < 300*time.Millisecond {
+		t.Fatal("expected the session to be closed once the timeout expired")
+	}
+}
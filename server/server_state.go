@@ -0,0 +1,154 @@
+package chserver
+
+import (
+	"context"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jpillora/backoff"
+	"github.com/jpillora/chisel/share/cdb"
+)
+
+// migrations create the tables chisel owns, new
+// steps are only ever appended
+var migrations = []cdb.Migration{
+	{Version: 1, Name: "state tables", SQL: `
+CREATE TABLE IF NOT EXISTS chisel_sessions (
+	server         text NOT NULL,
+	id             integer NOT NULL,
+	"user"         text NOT NULL,
+	remote_addr    text NOT NULL,
+	remotes        text NOT NULL,
+	bytes_sent     bigint NOT NULL,
+	bytes_received bigint NOT NULL,
+	started_at     timestamptz NOT NULL,
+	last_seen      timestamptz NOT NULL,
+	PRIMARY KEY (server, id)
+);
+CREATE TABLE IF NOT EXISTS chisel_proxies (
+	server     text NOT NULL,
+	id         text NOT NULL,
+	user_id    bigint NOT NULL,
+	job_id     bigint NOT NULL,
+	target     text NOT NULL,
+	subdomain  text NOT NULL,
+	source     text NOT NULL,
+	started_at timestamptz NOT NULL,
+	last_seen  timestamptz,
+	PRIMARY KEY (server, id)
+);`},
+	{Version: 2, Name: "saved state", SQL: `
+CREATE TABLE IF NOT EXISTS chisel_saved_state (
+	server   text PRIMARY KEY,
+	state    jsonb NOT NULL,
+	saved_at timestamptz NOT NULL
+);`},
+	{Version: 3, Name: "cluster routes", SQL: `
+CREATE TABLE IF NOT EXISTS chisel_routes (
+	route     text NOT NULL,
+	server    text NOT NULL,
+	url       text NOT NULL,
+	last_seen timestamptz NOT NULL,
+	PRIMARY KEY (route, server)
+);`},
+}
+
+// publishState writes the live sessions and proxies to the
+// database every interval, until ctx is cancelled
+func (s *Server) publishState(ctx context.Context, interval time.Duration) {
+	ctx = cdb.WithSubsystem(ctx, "state")
+	if err := s.db.Migrate(ctx, migrations); err != nil {
+		s.Infof("State publishing disabled, failed to create tables: %s", err)
+		return
+	}
+	server := s.stateServer()
+	s.Infof("Publishing state as %s every %s", server, interval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			//leave no stale rows behind
+			cleanup, cancel := context.WithTimeout(cdb.WithSubsystem(context.Background(), "state"), 5*time.Second)
+			s.db.Exec(cleanup, "DELETE FROM chisel_sessions WHERE server = $1", server)
+			s.db.Exec(cleanup, "DELETE FROM chisel_proxies WHERE server = $1", server)
+			cancel()
+			return
+		case <-ticker.C:
+		}
+		if err := s.writeState(ctx, server); err != nil {
+			s.Debugf("Failed to publish state: %s", err)
+		}
+	}
+}
+
+// stateServer identifies this server in the state tables
+func (s *Server) stateServer() string {
+	host, _ := os.Hostname()
+	return host
+}
+
+// writeState replaces this server's rows in one transaction,
+// so readers never see a partial snapshot
+func (s *Server) writeState(ctx context.Context, server string) error {
+	s.stateMut.Lock()
+	defer s.stateMut.Unlock()
+	return pgx.BeginFunc(ctx, s.db, func(tx pgx.Tx) error {
+		b := &pgx.Batch{}
+		b.Queue("DELETE FROM chisel_sessions WHERE server = $1", server)
+		b.Queue("DELETE FROM chisel_proxies WHERE server = $1", server)
+		for _, sess := range s.tunnels.list() {
+			b.Queue(`INSERT INTO chisel_sessions (server, id, "user", remote_addr, remotes,
+				bytes_sent, bytes_received, started_at, last_seen) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+				server, sess.id, sess.user, sess.remoteAddr, strings.Join(sess.remoteList(), " "),
+				atomic.LoadInt64(&sess.sent), atomic.LoadInt64(&sess.received), sess.startedAt, sess.seen())
+		}
+		s.dynamicReverseProxies.Range(func(pId string, p *DynamicReverseProxy) bool {
+			var lastSeen *time.Time
+			if ns := atomic.LoadInt64(&p.lastSeen); ns > 0 {
+				t := time.Unix(0, ns)
+				lastSeen = &t
+			}
+			b.Queue(`INSERT INTO chisel_proxies (server, id, user_id, job_id, target,
+				subdomain, source, started_at, last_seen) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+				server, pId, p.User, p.JobId, p.Target, p.Subdomain, p.Source, p.Created, lastSeen)
+			return true
+		})
+		return tx.SendBatch(ctx, b).Close()
+	})
+}
+
+// deleteStateProxies deletes the rows of the proxies in one transaction,
+// retrying with backoff. It runs without stateMut, so only rows which
+// are still those of the proxies, by their start time, are deleted.
+func (s *Server) deleteStateProxies(ctx context.Context, proxies []*DynamicReverseProxy) error {
+	if s.db == nil || s.config.StateInterval <= 0 {
+		return nil
+	}
+	server := s.stateServer()
+	ids := make([]string, len(proxies))
+	created := make([]time.Time, len(proxies))
+	for i, p := range proxies {
+		ids[i], created[i] = p.Id, p.Created
+	}
+	ctx = cdb.WithSubsystem(ctx, "state")
+	b := &backoff.Backoff{Min: 100 * time.Millisecond, Max: 2 * time.Second}
+	for attempt := 1; ; attempt++ {
+		err := pgx.BeginFunc(ctx, s.db, func(tx pgx.Tx) error {
+			_, err := tx.Exec(ctx, `DELETE FROM chisel_proxies WHERE server = $1 AND (id, started_at) IN
+				(SELECT * FROM unnest($2::text[], $3::timestamptz[]))`, server, ids, created)
+			return err
+		})
+		if err == nil || attempt == 3 {
+			return err
+		}
+		select {
+		case <-time.After(b.Duration()):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
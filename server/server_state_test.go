@@ -0,0 +1,79 @@
+package chserver
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jpillora/chisel/share/cdb"
+	"github.com/jpillora/chisel/share/cio"
+)
+
+func TestSessionStore(t *testing.T) {
+	store := newSessionStore()
+	store.add(&session{id: 1, user: "alice"})
+	store.add(&session{id: 2, user: "bob"})
+	if sess, ok := store.get(2); !ok || sess.user != "bob" {
+		t.Fatalf("unexpected session %v", sess)
+	}
+	store.del(1)
+	if _, ok := store.get(1); ok {
+		t.Fatal("expected session 1 to be deleted")
+	}
+	if l := store.list(); len(l) != 1 || l[0].id != 2 {
+		t.Fatalf("unexpected sessions %v", l)
+	}
+}
+
+func TestSessionConn(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	sess := &session{}
+	conn := &sessionConn{Conn: a, sess: sess}
+	go func() {
+		buf := make([]byte, 5)
+		io.ReadFull(b, buf)
+		b.Write([]byte("hi"))
+	}()
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 2)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	if sess.sent != 5 || sess.received != 2 {
+		t.Fatalf("expected 5 sent and 2 received, got %d and %d", sess.sent, sess.received)
+	}
+	if time.Since(sess.seen()) > time.Second {
+		t.Fatalf("expected the session to be seen, got %s", sess.seen())
+	}
+}
+
+func TestPublishStateUnavailable(t *testing.T) {
+	db, err := cdb.Open(cdb.Config{URL: "postgres://chisel@127.0.0.1:1/chisel?connect_timeout=1"}, cio.NewLogger("db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	s := &Server{
+		Logger:                cio.NewLogger("server"),
+		db:                    db,
+		tunnels:               newSessionStore(),
+		dynamicReverseProxies: NewProxyStore(),
+	}
+	//publishing gives up when its tables can't be created
+	done := make(chan struct{})
+	go func() {
+		s.publishState(context.Background(), time.Millisecond)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("expected publishing to be disabled")
+	}
+}
@@ -0,0 +1,150 @@
+package chserver
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/jpillora/chisel/share/cio"
+	"github.com/jpillora/chisel/share/cnet"
+)
+
+// statsdPacket is the largest datagram sent, to stay within a typical MTU
+const statsdPacket = 1432
+
+// StatsDConfig pushes key metrics to a StatsD or DogStatsD agent
+type StatsDConfig struct {
+	//Addr is the agent's UDP address, empty disables
+	Addr string
+	//Prefix is prepended to every metric name
+	Prefix string
+	//Tags are DogStatsD tags (e.g. "cluster:eu1")
+	//added to every metric
+	Tags []string
+	//Interval is how often metrics are pushed
+	Interval time.Duration
+}
+
+// statsdMetric is a gauge, or a counter pushed as its
+// increase since the last push
+type statsdMetric struct {
+	name    string
+	tags    []string
+	value   float64
+	counter bool
+}
+
+// statsdMetrics reads the metrics pushed to StatsD
+func (s *Server) statsdMetrics() []statsdMetric {
+	sessions := s.tunnels.list()
+	sent := atomic.LoadInt64(&s.metrics.closedSent)
+	received := atomic.LoadInt64(&s.metrics.closedReceived)
+	for _, sess := range sessions {
+		sent += atomic.LoadInt64(&sess.sent)
+		received += atomic.LoadInt64(&sess.received)
+	}
+	s.metrics.mut.Lock()
+	authFailures := s.metrics.authFailed.count
+	s.metrics.mut.Unlock()
+	out := []statsdMetric{
+		{name: "sessions.active", value: float64(len(sessions))},
+		{name: "connections.open", value: float64(s.conns.Count(cnet.ConnLabels{}))},
+		{name: "proxies", value: float64(s.dynamicReverseProxies.Len())},
+		{name: "tunnel.bytes", tags: []string{"direction:in"}, value: float64(received), counter: true},
+		{name: "tunnel.bytes", tags: []string{"direction:out"}, value: float64(sent), counter: true},
+		{name: "auth.failures", value: float64(authFailures), counter: true},
+		{name: "proxy.requests", value: float64(atomic.LoadInt64(&s.metrics.proxyRequests)), counter: true},
+		{name: "proxy.errors", value: float64(atomic.LoadInt64(&s.metrics.proxyErrors)), counter: true},
+	}
+	rpc := s.rpcMetrics.Snapshot()
+	methods := make([]string, 0, len(rpc))
+	for name := range rpc {
+		methods = append(methods, name)
+	}
+	sort.Strings(methods)
+	for _, name := range methods {
+		tags := []string{"method:" + name[strings.LastIndex(name, "/")+1:]}
+		out = append(out,
+			statsdMetric{name: "dcrpc.calls", tags: tags, value: float64(rpc[name].Calls), counter: true},
+			statsdMetric{name: "dcrpc.errors", tags: tags, value: float64(rpc[name].Errors), counter: true})
+	}
+	return out
+}
+
+// statsd sends metrics over UDP, keeping the
+// last value of each counter
+type statsd struct {
+	*cio.Logger
+	config StatsDConfig
+	conn   net.Conn
+	last   map[string]float64
+}
+
+func newStatsD(c StatsDConfig, l *cio.Logger) (*statsd, error) {
+	conn, err := net.Dial("udp", c.Addr)
+	if err != nil {
+		return nil, err
+	}
+	if c.Interval <= 0 {
+		c.Interval = 10 * time.Second
+	}
+	return &statsd{Logger: l.Fork("statsd"), config: c, conn: conn, last: map[string]float64{}}, nil
+}
+
+// pushStatsD pushes the metrics every interval until ctx is cancelled
+func (s *Server) pushStatsD(ctx context.Context, d *statsd) {
+	defer d.conn.Close()
+	t := time.NewTicker(d.config.Interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if err := d.push(s.statsdMetrics()); err != nil {
+				d.Debugf("Push failed: %s", err)
+			}
+		}
+	}
+}
+
+// push writes the metrics in the DogStatsD format, batched into packets
+func (d *statsd) push(metrics []statsdMetric) error {
+	var packet bytes.Buffer
+	for _, m := range metrics {
+		tags := append(append([]string{}, d.config.Tags...), m.tags...)
+		v, typ := m.value, "g"
+		if m.counter {
+			key := m.name + "|" + strings.Join(m.tags, ",")
+			v, d.last[key] = m.value-d.last[key], m.value
+			typ = "c"
+			if v <= 0 {
+				continue
+			}
+		}
+		line := d.config.Prefix + m.name + ":" + strconv.FormatFloat(v, 'f', -1, 64) + "|" + typ
+		if len(tags) > 0 {
+			line += "|#" + strings.Join(tags, ",")
+		}
+		if packet.Len() > 0 && packet.Len()+1+len(line) > statsdPacket {
+			if _, err := d.conn.Write(packet.Bytes()); err != nil {
+				return err
+			}
+			packet.Reset()
+		}
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(line)
+	}
+	if packet.Len() == 0 {
+		return nil
+	}
+	_, err := d.conn.Write(packet.Bytes())
+	return err
+}
@@ -0,0 +1,61 @@
+package chserver
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jpillora/chisel/share/cio"
+	"github.com/jpillora/chisel/share/craveauth"
+)
+
+func TestStatsD(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	s := &Server{
+		dynamicReverseProxies: NewProxyStore(),
+		tunnels:               newSessionStore(),
+		metrics:               newServerMetrics(),
+		rpcMetrics:            craveauth.NewRPCMetrics(),
+	}
+	s.tunnels.add(&session{id: 1, user: "alice", sent: 10, received: 20})
+	d, err := newStatsD(StatsDConfig{Addr: pc.LocalAddr().String(), Prefix: "chisel.", Tags: []string{"cluster:eu1"}}, cio.NewLogger("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.conn.Close()
+	read := func() string {
+		buf := make([]byte, statsdPacket)
+		pc.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := pc.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(buf[:n])
+	}
+	if err := d.push(s.statsdMetrics()); err != nil {
+		t.Fatal(err)
+	}
+	lines := read()
+	for _, line := range []string{
+		"chisel.sessions.active:1|g|#cluster:eu1",
+		"chisel.proxies:0|g|#cluster:eu1",
+		"chisel.tunnel.bytes:20|c|#cluster:eu1,direction:in",
+		"chisel.tunnel.bytes:10|c|#cluster:eu1,direction:out",
+	} {
+		if !strings.Contains(lines+"\n", line+"\n") {
+			t.Fatalf("expected %q in:\n%s", line, lines)
+		}
+	}
+	//counters are pushed as their increase
+	s.tunnels.add(&session{id: 2, user: "bob", sent: 5})
+	d.push(s.statsdMetrics())
+	lines = read()
+	if !strings.Contains(lines, "chisel.tunnel.bytes:5|c|#cluster:eu1,direction:out") || strings.Contains(lines, "direction:in") {
+		t.Fatalf("expected only the increase, got:\n%s", lines)
+	}
+}
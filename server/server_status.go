@@ -0,0 +1,102 @@
+package chserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	chshare "github.com/jpillora/chisel/share"
+	"github.com/jpillora/chisel/share/cnet"
+)
+
+// Status summarizes the server, as served by /api/status,
+// rates are in bytes per second
+type Status struct {
+	Version       string            `json:"version"`
+	StartedAt     time.Time         `json:"started_at"`
+	UptimeSeconds float64           `json:"uptime_seconds"`
+	Ready         bool              `json:"ready"`
+	Checks        map[string]string `json:"checks"`
+	Sessions      int               `json:"sessions"`
+	Users         int               `json:"users"`
+	OpenChannels  int32             `json:"open_channels"`
+	Connections   int               `json:"connections"`
+	Proxies       int               `json:"proxies"`
+	BytesSent     int64             `json:"bytes_sent"`
+	BytesReceived int64             `json:"bytes_received"`
+	SendRate      float64           `json:"send_rate"`
+	ReceiveRate   float64           `json:"receive_rate"`
+	Goroutines    int               `json:"goroutines"`
+}
+
+// status reads the live state of the server
+func (s *Server) status(ctx context.Context) Status {
+	st := Status{
+		Version:       chshare.BuildVersion,
+		StartedAt:     s.started,
+		UptimeSeconds: time.Since(s.started).Seconds(),
+		Ready:         true,
+		Checks:        map[string]string{},
+		Connections:   s.conns.Count(cnet.ConnLabels{}),
+		Proxies:       s.dynamicReverseProxies.Len(),
+		BytesSent:     atomic.LoadInt64(&s.metrics.closedSent),
+		BytesReceived: atomic.LoadInt64(&s.metrics.closedReceived),
+		Goroutines:    runtime.NumGoroutine(),
+	}
+	for name, err := range s.readiness(ctx) {
+		st.Checks[name] = "ok"
+		if err != nil {
+			st.Ready = false
+			st.Checks[name] = err.Error()
+		}
+	}
+	users := map[string]bool{}
+	for _, sess := range s.tunnels.list() {
+		a := newAPISession(sess)
+		st.Sessions++
+		users[a.User] = true
+		st.OpenChannels += a.OpenChannels
+		st.BytesSent += a.BytesSent
+		st.BytesReceived += a.BytesReceived
+		st.SendRate += a.SendRate
+		st.ReceiveRate += a.ReceiveRate
+	}
+	st.Users = len(users)
+	return st
+}
+
+// handleAPIStatus serves GET /api/status
+func (s *Server) handleAPIStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.status(r.Context()))
+}
+
+// FetchStatus requests /api/status from the admin server at addr
+func FetchStatus(ctx context.Context, addr, token string) (*Status, error) {
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	req, err := http.NewRequest("GET", strings.TrimSuffix(addr, "/")+"/api/status", nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", addr, resp.Status)
+	}
+	st := &Status{}
+	if err := json.NewDecoder(resp.Body).Decode(st); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
@@ -0,0 +1,67 @@
+package chserver
+
+import (
+	"net/http"
+	"runtime"
+	"sort"
+
+	chshare "github.com/jpillora/chisel/share"
+)
+
+// apiVersion is the build and protocol of the server, as served by /api/version
+type apiVersion struct {
+	Version   string   `json:"version"`
+	Commit    string   `json:"commit"`
+	GoVersion string   `json:"go_version"`
+	Protocols []string `json:"protocols"`
+	Features  []string `json:"features"`
+}
+
+// features lists the optional capabilities enabled on this server
+func (s *Server) features() []string {
+	c := s.config
+	f := []string{"dynamic-proxies", "udp"}
+	if c.Reverse {
+		f = append(f, "reverse")
+	}
+	if c.Socks5 {
+		f = append(f, "socks5")
+	}
+	if c.HTTPProxy {
+		f = append(f, "http-proxy")
+	}
+	if c.Transparent {
+		f = append(f, "transparent")
+	}
+	if c.Proxy != "" {
+		f = append(f, "backend")
+	}
+	if c.ProxyDomain != "" {
+		f = append(f, "proxy-subdomains")
+	}
+	if c.Compress.Enabled {
+		f = append(f, "compress")
+	}
+	if c.RPCListen != "" {
+		f = append(f, "dcrpc-proxies")
+	}
+	if c.AdminRPCListen != "" {
+		f = append(f, "admin-grpc")
+	}
+	if c.TLS.Cert != "" || len(c.TLS.Domains) > 0 {
+		f = append(f, "tls")
+	}
+	sort.Strings(f)
+	return f
+}
+
+// handleAPIVersion serves GET /api/version
+func (s *Server) handleAPIVersion(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, apiVersion{
+		Version:   chshare.BuildVersion,
+		Commit:    chshare.BuildCommit,
+		GoVersion: runtime.Version(),
+		Protocols: []string{chshare.ProtocolVersion, chshare.CraveProtocolVersion},
+		Features:  s.features(),
+	})
+}
@@ -0,0 +1,84 @@
+package chserver
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+)
+
+// virtualHosts are the static reverse proxies of --vhost,
+// by the host name their requests are sent to
+type virtualHosts map[string]*httputil.ReverseProxy
+
+// newVirtualHosts parses hosts like "api.example.com=http://10.0.0.5:8080",
+// the name may be a wildcard such as "*.example.com"
+func newVirtualHosts(specs []string) (virtualHosts, error) {
+	v := virtualHosts{}
+	for _, spec := range specs {
+		pair := strings.SplitN(spec, "=", 2)
+		name := strings.ToLower(strings.TrimSuffix(strings.TrimSpace(pair[0]), "."))
+		if len(pair) != 2 || name == "" {
+			return nil, fmt.Errorf("Invalid virtual host '%s', expected <host>=<url>", spec)
+		}
+		u, err := parseBackend(pair[1])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid virtual host '%s': %s", spec, err)
+		}
+		if _, ok := v[name]; ok {
+			return nil, fmt.Errorf("Duplicate virtual host '%s'", name)
+		}
+		v[name] = newBackendProxy(u)
+	}
+	return v, nil
+}
+
+// get returns the proxy of the request's host,
+// an exact name before the closest wildcard
+func (v virtualHosts) get(host string) *httputil.ReverseProxy {
+	if len(v) == 0 {
+		return nil
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if p, ok := v[host]; ok {
+		return p
+	}
+	for i := strings.Index(host, "."); i >= 0; i = strings.Index(host, ".") {
+		host = host[i+1:]
+		if p, ok := v["*."+host]; ok {
+			return p
+		}
+	}
+	return nil
+}
+
+// parseBackend parses the URL of a reverse proxy target
+func parseBackend(s string) (*url.URL, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("Missing protocol (%s)", u)
+	}
+	return u, nil
+}
+
+// newBackendProxy proxies requests to the target,
+// keeping their paths
+func newBackendProxy(u *url.URL) *httputil.ReverseProxy {
+	p := httputil.NewSingleHostReverseProxy(u)
+	//always use proxy host
+	p.Director = func(r *http.Request) {
+		//enforce origin, keep path
+		r.URL.Scheme = u.Scheme
+		r.URL.Host = u.Host
+		r.Host = u.Host
+	}
+	return p
+}
@@ -0,0 +1,49 @@
+package chserver
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/jpillora/chisel/share/cio"
+)
+
+func TestVirtualHosts(t *testing.T) {
+	for _, invalid := range [][]string{{"api.example.com"}, {"=http://a"}, {"a=b"}, {"a=http://x", "A.=http://y"}} {
+		if _, err := newVirtualHosts(invalid); err == nil {
+			t.Fatalf("expected %q to be invalid", invalid)
+		}
+	}
+	backend := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(name + r.URL.Path))
+		}))
+	}
+	api, web, other := backend("api"), backend("web"), backend("other")
+	defer api.Close()
+	defer web.Close()
+	defer other.Close()
+	vhosts, err := newVirtualHosts([]string{"api.example.com=" + api.URL, "*.example.com=" + web.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{Logger: cio.NewLogger("server"), config: &Config{}, vhosts: vhosts}
+	u, _ := url.Parse(other.URL)
+	s.reverseProxy = newBackendProxy(u)
+	for host, expected := range map[string]string{
+		"API.example.com:443": "api/x",
+		"www.example.com":     "web/x",
+		"a.b.example.com.":    "web/x",
+		"example.com":         "other/x",
+		"api.example.org":     "other/x",
+	} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "http://"+host+"/x", nil)
+		s.handleClientHandler(w, r)
+		if b, _ := ioutil.ReadAll(w.Body); string(b) != expected {
+			t.Fatalf("expected %s to be proxied to %s, got %s", host, expected, b)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package chserver
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jpillora/backoff"
+	"github.com/jpillora/chisel/share/cio"
+)
+
+// webhook event types
+const (
+	eventSessionConnected    = "session.connected"
+	eventSessionDisconnected = "session.disconnected"
+	eventProxyRegistered     = "proxy.registered"
+	eventProxyRemoved        = "proxy.removed"
+	eventAuthFailureBurst    = "auth.failure_burst"
+)
+
+// webhookQueue is how many events may wait for delivery,
+// later events are dropped while the webhook is down
+const webhookQueue = 1000
+
+// WebhookConfig posts tunnel lifecycle events to a URL
+type WebhookConfig struct {
+	//URL receives each event as a JSON POST, empty disables
+	URL string
+	//Secret signs the body with HMAC-SHA256, sent as
+	//"sha256=<hex>" in the X-Chisel-Signature header
+	Secret string
+	//Retries is the number of further attempts of a failed delivery
+	Retries int
+	//Timeout bounds each attempt
+	Timeout time.Duration
+	//AuthFailureBurst is the number of auth failures within
+	//AuthFailureWindow which raise an auth.failure_burst event
+	AuthFailureBurst  int
+	AuthFailureWindow time.Duration
+}
+
+// webhookEvent is the body of a webhook request
+type webhookEvent struct {
+	Type string      `json:"type"`
+	Time time.Time   `json:"time"`
+	Data interface{} `json:"data"`
+}
+
+// webhook delivers events in order from a queue, so
+// callers never wait on the receiving endpoint
+type webhook struct {
+	*cio.Logger
+	config WebhookConfig
+	client *http.Client
+	queue  chan webhookEvent
+	//failures are the times of recent auth failures
+	mut       sync.Mutex
+	failures  []time.Time
+	lastBurst time.Time
+}
+
+func newWebhook(c WebhookConfig, l *cio.Logger) *webhook {
+	if c.URL == "" {
+		return nil
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = 10 * time.Second
+	}
+	return &webhook{
+		Logger: l.Fork("webhook"),
+		config: c,
+		client: &http.Client{Timeout: c.Timeout},
+		queue:  make(chan webhookEvent, webhookQueue),
+	}
+}
+
+// run delivers queued events until ctx is cancelled
+func (h *webhook) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev := <-h.queue:
+			if err := h.deliver(ctx, ev); err != nil {
+				h.Infof("Dropped %s event: %s", ev.Type, err)
+			}
+		}
+	}
+}
+
+// send queues an event
+func (h *webhook) send(typ string, data interface{}) {
+	if h == nil {
+		return
+	}
+	select {
+	case h.queue <- webhookEvent{Type: typ, Time: time.Now(), Data: data}:
+	default:
+		h.Debugf("Queue full, dropped %s event", typ)
+	}
+}
+
+// authFailure raises a burst event when the failures within the
+// window reach the threshold, at most once per window
+func (h *webhook) authFailure(f authFailure) {
+	if h == nil || h.config.AuthFailureBurst <= 0 {
+		return
+	}
+	h.mut.Lock()
+	since := f.Time.Add(-h.config.AuthFailureWindow)
+	recent := h.failures[:0]
+	for _, t := range h.failures {
+		if t.After(since) {
+			recent = append(recent, t)
+		}
+	}
+	h.failures = append(recent, f.Time)
+	count := len(h.failures)
+	burst := count >= h.config.AuthFailureBurst && !h.lastBurst.After(since)
+	if burst {
+		h.lastBurst = f.Time
+	}
+	h.mut.Unlock()
+	if burst {
+		h.send(eventAuthFailureBurst, map[string]interface{}{
+			"count":          count,
+			"window_seconds": h.config.AuthFailureWindow.Seconds(),
+			"last":           f,
+		})
+	}
+}
+
+// deliver posts the event, retrying with backoff on
+// network errors, 429 and 5xx responses
+func (h *webhook) deliver(ctx context.Context, ev webhookEvent) error {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	b := &backoff.Backoff{Min: time.Second, Max: 30 * time.Second}
+	for {
+		retry, err := h.post(ctx, ev.Type, body)
+		if err == nil {
+			return nil
+		}
+		if !retry || int(b.Attempt()) >= h.config.Retries {
+			return err
+		}
+		d := b.Duration()
+		h.Debugf("Failed to deliver %s event, retrying in %s: %s", ev.Type, d, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(d):
+		}
+	}
+}
+
+func (h *webhook) post(ctx context.Context, typ string, body []byte) (bool, error) {
+	req, err := http.NewRequest("POST", h.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Chisel-Event", typ)
+	if h.config.Secret != "" {
+		req.Header.Set("X-Chisel-Signature", "sha256="+signWebhook(h.config.Secret, body))
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retry, fmt.Errorf("status %s", resp.Status)
+}
+
+// signWebhook is the hex HMAC-SHA256 of body
+func signWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// notifyProxy audits a proxy event, and sends it with
+// the proxy as listed by /api/proxies
+func (s *Server) notifyProxy(typ, pId string, p *DynamicReverseProxy, by proxyActor) {
+	s.auditProxy(typ, pId, p, by)
+	if s.webhook == nil {
+		return
+	}
+	s.webhook.send(typ, s.newAPIProxy(pId, p))
+}
+
+// authFailed records a rejected login or proxy request
+func (s *Server) authFailed(kind, subject, remoteAddr string, err error) {
+	f := s.authFailures.add(kind, subject, remoteAddr, err)
+	s.webhook.authFailure(f)
+}
@@ -0,0 +1,63 @@
+package chserver
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jpillora/chisel/share/cio"
+)
+
+func TestWebhookDeliver(t *testing.T) {
+	var attempts int32
+	got := make(chan webhookEvent, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		if sig := r.Header.Get("X-Chisel-Signature"); sig != "sha256="+signWebhook("secret", body) {
+			t.Errorf("bad signature %q", sig)
+		}
+		var ev webhookEvent
+		json.Unmarshal(body, &ev)
+		got <- ev
+	}))
+	defer ts.Close()
+	h := newWebhook(WebhookConfig{URL: ts.URL, Secret: "secret", Retries: 2}, cio.NewLogger("test"))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go h.run(ctx)
+	h.send(eventProxyRegistered, map[string]string{"id": "docs"})
+	select {
+	case ev := <-got:
+		if n := atomic.LoadInt32(&attempts); ev.Type != eventProxyRegistered || n != 2 {
+			t.Fatalf("expected %s on the second attempt, got %s on attempt %d", eventProxyRegistered, ev.Type, n)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("event not delivered")
+	}
+}
+
+func TestWebhookAuthFailureBurst(t *testing.T) {
+	h := newWebhook(WebhookConfig{URL: "http://localhost", AuthFailureBurst: 3, AuthFailureWindow: time.Minute}, cio.NewLogger("test"))
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		h.authFailure(authFailure{Time: now.Add(time.Duration(i) * time.Second), Reason: "denied"})
+	}
+	if len(h.queue) != 1 {
+		t.Fatalf("expected one burst within the window, got %d", len(h.queue))
+	}
+	h.authFailure(authFailure{Time: now.Add(2 * time.Minute)})
+	h.authFailure(authFailure{Time: now.Add(2*time.Minute + time.Second)})
+	h.authFailure(authFailure{Time: now.Add(2*time.Minute + 2*time.Second)})
+	if len(h.queue) != 2 {
+		t.Fatalf("expected another burst in the next window, got %d", len(h.queue))
+	}
+}
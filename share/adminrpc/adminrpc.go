@@ -0,0 +1,5 @@
+// Package adminrpc is the generated code of chisel_admin.proto,
+// the admin gRPC API of the server
+package adminrpc
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative chisel_admin.proto
@@ -0,0 +1,429 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.2.0
+// - protoc             (unknown)
+// source: chisel_admin.proto
+
+package adminrpc
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// ChiselAdminClient is the client API for ChiselAdmin service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type ChiselAdminClient interface {
+	ListSessions(ctx context.Context, in *ListSessionsRequest, opts ...grpc.CallOption) (*ListSessionsResponse, error)
+	GetSession(ctx context.Context, in *SessionRequest, opts ...grpc.CallOption) (*Session, error)
+	TerminateSession(ctx context.Context, in *SessionRequest, opts ...grpc.CallOption) (*TerminateSessionResponse, error)
+	ListProxies(ctx context.Context, in *ListProxiesRequest, opts ...grpc.CallOption) (*ListProxiesResponse, error)
+	RemoveProxy(ctx context.Context, in *ProxyRequest, opts ...grpc.CallOption) (*RemoveProxyResponse, error)
+	ListUsers(ctx context.Context, in *ListUsersRequest, opts ...grpc.CallOption) (*ListUsersResponse, error)
+	AddUser(ctx context.Context, in *AddUserRequest, opts ...grpc.CallOption) (*AddUserResponse, error)
+	DeleteUser(ctx context.Context, in *DeleteUserRequest, opts ...grpc.CallOption) (*DeleteUserResponse, error)
+	ListAuthFailures(ctx context.Context, in *ListAuthFailuresRequest, opts ...grpc.CallOption) (*ListAuthFailuresResponse, error)
+	ListProxyAudit(ctx context.Context, in *ListProxyAuditRequest, opts ...grpc.CallOption) (*ListProxyAuditResponse, error)
+}
+
+type chiselAdminClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewChiselAdminClient(cc grpc.ClientConnInterface) ChiselAdminClient {
+	return &chiselAdminClient{cc}
+}
+
+func (c *chiselAdminClient) ListSessions(ctx context.Context, in *ListSessionsRequest, opts ...grpc.CallOption) (*ListSessionsResponse, error) {
+	out := new(ListSessionsResponse)
+	err := c.cc.Invoke(ctx, "/dcrpc.ChiselAdmin/ListSessions", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *chiselAdminClient) GetSession(ctx context.Context, in *SessionRequest, opts ...grpc.CallOption) (*Session, error) {
+	out := new(Session)
+	err := c.cc.Invoke(ctx, "/dcrpc.ChiselAdmin/GetSession", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *chiselAdminClient) TerminateSession(ctx context.Context, in *SessionRequest, opts ...grpc.CallOption) (*TerminateSessionResponse, error) {
+	out := new(TerminateSessionResponse)
+	err := c.cc.Invoke(ctx, "/dcrpc.ChiselAdmin/TerminateSession", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *chiselAdminClient) ListProxies(ctx context.Context, in *ListProxiesRequest, opts ...grpc.CallOption) (*ListProxiesResponse, error) {
+	out := new(ListProxiesResponse)
+	err := c.cc.Invoke(ctx, "/dcrpc.ChiselAdmin/ListProxies", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *chiselAdminClient) RemoveProxy(ctx context.Context, in *ProxyRequest, opts ...grpc.CallOption) (*RemoveProxyResponse, error) {
+	out := new(RemoveProxyResponse)
+	err := c.cc.Invoke(ctx, "/dcrpc.ChiselAdmin/RemoveProxy", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *chiselAdminClient) ListUsers(ctx context.Context, in *ListUsersRequest, opts ...grpc.CallOption) (*ListUsersResponse, error) {
+	out := new(ListUsersResponse)
+	err := c.cc.Invoke(ctx, "/dcrpc.ChiselAdmin/ListUsers", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *chiselAdminClient) AddUser(ctx context.Context, in *AddUserRequest, opts ...grpc.CallOption) (*AddUserResponse, error) {
+	out := new(AddUserResponse)
+	err := c.cc.Invoke(ctx, "/dcrpc.ChiselAdmin/AddUser", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *chiselAdminClient) DeleteUser(ctx context.Context, in *DeleteUserRequest, opts ...grpc.CallOption) (*DeleteUserResponse, error) {
+	out := new(DeleteUserResponse)
+	err := c.cc.Invoke(ctx, "/dcrpc.ChiselAdmin/DeleteUser", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *chiselAdminClient) ListAuthFailures(ctx context.Context, in *ListAuthFailuresRequest, opts ...grpc.CallOption) (*ListAuthFailuresResponse, error) {
+	out := new(ListAuthFailuresResponse)
+	err := c.cc.Invoke(ctx, "/dcrpc.ChiselAdmin/ListAuthFailures", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *chiselAdminClient) ListProxyAudit(ctx context.Context, in *ListProxyAuditRequest, opts ...grpc.CallOption) (*ListProxyAuditResponse, error) {
+	out := new(ListProxyAuditResponse)
+	err := c.cc.Invoke(ctx, "/dcrpc.ChiselAdmin/ListProxyAudit", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ChiselAdminServer is the server API for ChiselAdmin service.
+// All implementations must embed UnimplementedChiselAdminServer
+// for forward compatibility
+type ChiselAdminServer interface {
+	ListSessions(context.Context, *ListSessionsRequest) (*ListSessionsResponse, error)
+	GetSession(context.Context, *SessionRequest) (*Session, error)
+	TerminateSession(context.Context, *SessionRequest) (*TerminateSessionResponse, error)
+	ListProxies(context.Context, *ListProxiesRequest) (*ListProxiesResponse, error)
+	RemoveProxy(context.Context, *ProxyRequest) (*RemoveProxyResponse, error)
+	ListUsers(context.Context, *ListUsersRequest) (*ListUsersResponse, error)
+	AddUser(context.Context, *AddUserRequest) (*AddUserResponse, error)
+	DeleteUser(context.Context, *DeleteUserRequest) (*DeleteUserResponse, error)
+	ListAuthFailures(context.Context, *ListAuthFailuresRequest) (*ListAuthFailuresResponse, error)
+	ListProxyAudit(context.Context, *ListProxyAuditRequest) (*ListProxyAuditResponse, error)
+	mustEmbedUnimplementedChiselAdminServer()
+}
+
+// UnimplementedChiselAdminServer must be embedded to have forward compatible implementations.
+type UnimplementedChiselAdminServer struct {
+}
+
+func (UnimplementedChiselAdminServer) ListSessions(context.Context, *ListSessionsRequest) (*ListSessionsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListSessions not implemented")
+}
+func (UnimplementedChiselAdminServer) GetSession(context.Context, *SessionRequest) (*Session, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetSession not implemented")
+}
+func (UnimplementedChiselAdminServer) TerminateSession(context.Context, *SessionRequest) (*TerminateSessionResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method TerminateSession not implemented")
+}
+func (UnimplementedChiselAdminServer) ListProxies(context.Context, *ListProxiesRequest) (*ListProxiesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListProxies not implemented")
+}
+func (UnimplementedChiselAdminServer) RemoveProxy(context.Context, *ProxyRequest) (*RemoveProxyResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RemoveProxy not implemented")
+}
+func (UnimplementedChiselAdminServer) ListUsers(context.Context, *ListUsersRequest) (*ListUsersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListUsers not implemented")
+}
+func (UnimplementedChiselAdminServer) AddUser(context.Context, *AddUserRequest) (*AddUserResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AddUser not implemented")
+}
+func (UnimplementedChiselAdminServer) DeleteUser(context.Context, *DeleteUserRequest) (*DeleteUserResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteUser not implemented")
+}
+func (UnimplementedChiselAdminServer) ListAuthFailures(context.Context, *ListAuthFailuresRequest) (*ListAuthFailuresResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListAuthFailures not implemented")
+}
+func (UnimplementedChiselAdminServer) ListProxyAudit(context.Context, *ListProxyAuditRequest) (*ListProxyAuditResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListProxyAudit not implemented")
+}
+func (UnimplementedChiselAdminServer) mustEmbedUnimplementedChiselAdminServer() {}
+
+// UnsafeChiselAdminServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ChiselAdminServer will
+// result in compilation errors.
+type UnsafeChiselAdminServer interface {
+	mustEmbedUnimplementedChiselAdminServer()
+}
+
+func RegisterChiselAdminServer(s grpc.ServiceRegistrar, srv ChiselAdminServer) {
+	s.RegisterService(&ChiselAdmin_ServiceDesc, srv)
+}
+
+func _ChiselAdmin_ListSessions_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListSessionsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ChiselAdminServer).ListSessions(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/dcrpc.ChiselAdmin/ListSessions",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ChiselAdminServer).ListSessions(ctx, req.(*ListSessionsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ChiselAdmin_GetSession_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SessionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ChiselAdminServer).GetSession(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/dcrpc.ChiselAdmin/GetSession",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ChiselAdminServer).GetSession(ctx, req.(*SessionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ChiselAdmin_TerminateSession_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SessionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ChiselAdminServer).TerminateSession(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/dcrpc.ChiselAdmin/TerminateSession",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ChiselAdminServer).TerminateSession(ctx, req.(*SessionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ChiselAdmin_ListProxies_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListProxiesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ChiselAdminServer).ListProxies(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/dcrpc.ChiselAdmin/ListProxies",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ChiselAdminServer).ListProxies(ctx, req.(*ListProxiesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ChiselAdmin_RemoveProxy_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ProxyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ChiselAdminServer).RemoveProxy(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/dcrpc.ChiselAdmin/RemoveProxy",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ChiselAdminServer).RemoveProxy(ctx, req.(*ProxyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ChiselAdmin_ListUsers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListUsersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ChiselAdminServer).ListUsers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/dcrpc.ChiselAdmin/ListUsers",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ChiselAdminServer).ListUsers(ctx, req.(*ListUsersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ChiselAdmin_AddUser_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AddUserRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ChiselAdminServer).AddUser(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/dcrpc.ChiselAdmin/AddUser",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ChiselAdminServer).AddUser(ctx, req.(*AddUserRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ChiselAdmin_DeleteUser_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteUserRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ChiselAdminServer).DeleteUser(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/dcrpc.ChiselAdmin/DeleteUser",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ChiselAdminServer).DeleteUser(ctx, req.(*DeleteUserRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ChiselAdmin_ListAuthFailures_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListAuthFailuresRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ChiselAdminServer).ListAuthFailures(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/dcrpc.ChiselAdmin/ListAuthFailures",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ChiselAdminServer).ListAuthFailures(ctx, req.(*ListAuthFailuresRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ChiselAdmin_ListProxyAudit_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListProxyAuditRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ChiselAdminServer).ListProxyAudit(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/dcrpc.ChiselAdmin/ListProxyAudit",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ChiselAdminServer).ListProxyAudit(ctx, req.(*ListProxyAuditRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// ChiselAdmin_ServiceDesc is the grpc.ServiceDesc for ChiselAdmin service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var ChiselAdmin_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "dcrpc.ChiselAdmin",
+	HandlerType: (*ChiselAdminServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListSessions",
+			Handler:    _ChiselAdmin_ListSessions_Handler,
+		},
+		{
+			MethodName: "GetSession",
+			Handler:    _ChiselAdmin_GetSession_Handler,
+		},
+		{
+			MethodName: "TerminateSession",
+			Handler:    _ChiselAdmin_TerminateSession_Handler,
+		},
+		{
+			MethodName: "ListProxies",
+			Handler:    _ChiselAdmin_ListProxies_Handler,
+		},
+		{
+			MethodName: "RemoveProxy",
+			Handler:    _ChiselAdmin_RemoveProxy_Handler,
+		},
+		{
+			MethodName: "ListUsers",
+			Handler:    _ChiselAdmin_ListUsers_Handler,
+		},
+		{
+			MethodName: "AddUser",
+			Handler:    _ChiselAdmin_AddUser_Handler,
+		},
+		{
+			MethodName: "DeleteUser",
+			Handler:    _ChiselAdmin_DeleteUser_Handler,
+		},
+		{
+			MethodName: "ListAuthFailures",
+			Handler:    _ChiselAdmin_ListAuthFailures_Handler,
+		},
+		{
+			MethodName: "ListProxyAudit",
+			Handler:    _ChiselAdmin_ListProxyAudit_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "chisel_admin.proto",
+}
@@ -0,0 +1,61 @@
+package cdb
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Migration is one step of the schema of the tables chisel owns,
+// it is applied once, in order of Version
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// migrationsLock serializes migrations across servers
+// sharing the database, it is an arbitrary advisory lock id
+const migrationsLock = 0x63686973656c
+
+// Migrate applies the migrations which have not been applied yet,
+// each in its own transaction, recording them in chisel_schema_migrations
+func (db *DB) Migrate(ctx context.Context, migrations []Migration) error {
+	ctx = WithSubsystem(ctx, "migrate")
+	if _, err := db.Exec(ctx, `CREATE TABLE IF NOT EXISTS chisel_schema_migrations (
+		version    integer PRIMARY KEY,
+		name       text NOT NULL,
+		applied_at timestamptz NOT NULL DEFAULT now()
+	)`); err != nil {
+		return err
+	}
+	for _, m := range migrations {
+		applied := false
+		err := pgx.BeginFunc(ctx, db.Pool, func(tx pgx.Tx) error {
+			if _, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock($1)", migrationsLock); err != nil {
+				return err
+			}
+			var n int
+			if err := tx.QueryRow(ctx, "SELECT count(*) FROM chisel_schema_migrations WHERE version = $1", m.Version).Scan(&n); err != nil {
+				return err
+			}
+			if n > 0 {
+				return nil
+			}
+			if _, err := tx.Exec(ctx, m.SQL); err != nil {
+				return err
+			}
+			applied = true
+			_, err := tx.Exec(ctx, "INSERT INTO chisel_schema_migrations (version, name) VALUES ($1, $2)", m.Version, m.Name)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("migration %d (%s): %s", m.Version, m.Name, err)
+		}
+		if applied {
+			db.Infof("Applied migration %d (%s)", m.Version, m.Name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package cdb
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jpillora/chisel/share/cio"
+)
+
+type subsystemKey struct{}
+
+// WithSubsystem tags the queries made with ctx, for
+// metrics and slow-query logs
+func WithSubsystem(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, subsystemKey{}, name)
+}
+
+func subsystem(ctx context.Context) string {
+	if name, ok := ctx.Value(subsystemKey{}).(string); ok {
+		return name
+	}
+	return "other"
+}
+
+// QueryBuckets are the upper bounds of the query duration histogram
+var QueryBuckets = []time.Duration{
+	time.Millisecond,
+	5 * time.Millisecond,
+	25 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	5 * time.Second,
+}
+
+// QueryStats is the duration histogram of a subsystem's
+// queries, Buckets[i] counts those up to QueryBuckets[i],
+// the last bucket counts the rest
+type QueryStats struct {
+	Count   int64         `json:"count"`
+	Errors  int64         `json:"errors"`
+	Sum     time.Duration `json:"sum"`
+	Buckets []int64       `json:"buckets"`
+}
+
+// tracer times every query, logging those slower than slow
+type tracer struct {
+	*cio.Logger
+	slow  time.Duration
+	mut   sync.Mutex
+	stats map[string]*QueryStats
+}
+
+type traceStart struct {
+	sql   string
+	start time.Time
+}
+
+type traceKey struct{}
+
+func newTracer(l *cio.Logger, slow time.Duration) *tracer {
+	return &tracer{Logger: l, slow: slow, stats: map[string]*QueryStats{}}
+}
+
+func (t *tracer) start(ctx context.Context, sql string) context.Context {
+	return context.WithValue(ctx, traceKey{}, traceStart{sql: sql, start: time.Now()})
+}
+
+func (t *tracer) end(ctx context.Context, err error) {
+	s, ok := ctx.Value(traceKey{}).(traceStart)
+	if !ok {
+		return
+	}
+	d := time.Since(s.start)
+	name := subsystem(ctx)
+	t.mut.Lock()
+	qs, ok := t.stats[name]
+	if !ok {
+		qs = &QueryStats{Buckets: make([]int64, len(QueryBuckets)+1)}
+		t.stats[name] = qs
+	}
+	qs.Count++
+	qs.Sum += d
+	if err != nil {
+		qs.Errors++
+	}
+	i := 0
+	for i < len(QueryBuckets) && d > QueryBuckets[i] {
+		i++
+	}
+	qs.Buckets[i]++
+	t.mut.Unlock()
+	if t.slow > 0 && d >= t.slow {
+		t.Infof("Slow query (%s) took %s: %s", name, d, strings.Join(strings.Fields(s.sql), " "))
+	}
+}
+
+func (t *tracer) TraceQueryStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	return t.start(ctx, data.SQL)
+}
+
+func (t *tracer) TraceQueryEnd(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryEndData) {
+	t.end(ctx, data.Err)
+}
+
+// batches are timed as a whole
+func (t *tracer) TraceBatchStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceBatchStartData) context.Context {
+	return t.start(ctx, fmt.Sprintf("batch of %d queries", data.Batch.Len()))
+}
+
+func (t *tracer) TraceBatchQuery(ctx context.Context, conn *pgx.Conn, data pgx.TraceBatchQueryData) {
+}
+
+func (t *tracer) TraceBatchEnd(ctx context.Context, conn *pgx.Conn, data pgx.TraceBatchEndData) {
+	t.end(ctx, data.Err)
+}
+
+func (t *tracer) snapshot() map[string]QueryStats {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	out := make(map[string]QueryStats, len(t.stats))
+	for name, qs := range t.stats {
+		c := *qs
+		c.Buckets = append([]int64(nil), qs.Buckets...)
+		out[name] = c
+	}
+	return out
+}
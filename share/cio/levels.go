@@ -0,0 +1,48 @@
+package cio
+
+import (
+	"fmt"
+	"sync"
+)
+
+// levels are the runtime levels of subsystems, see SetLevel
+var levels = struct {
+	sync.RWMutex
+	m map[string]string
+}{m: map[string]string{}}
+
+// SetLevel changes the level of the loggers of a subsystem (see
+// Logger.Subsystem) at runtime, overriding their Info and Debug
+// flags: "debug", "info" or "warn", which only logs warnings.
+// An empty level restores the flags.
+func SetLevel(subsystem, level string) error {
+	switch level {
+	case "", "debug", "info", "warn":
+	default:
+		return fmt.Errorf("Invalid log level (%s)", level)
+	}
+	levels.Lock()
+	defer levels.Unlock()
+	if level == "" {
+		delete(levels.m, subsystem)
+	} else {
+		levels.m[subsystem] = level
+	}
+	return nil
+}
+
+// Level returns the runtime level of a subsystem, if set
+func Level(subsystem string) (string, bool) {
+	levels.RLock()
+	defer levels.RUnlock()
+	level, ok := levels.m[subsystem]
+	return level, ok
+}
+
+// level returns the runtime level of the logger's subsystem, if set
+func (l *Logger) level() (string, bool) {
+	if l.Subsystem == "" {
+		return "", false
+	}
+	return Level(l.Subsystem)
+}
@@ -1,18 +1,47 @@
 package cio
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
-	"os"
+	"sync/atomic"
+	"time"
 )
 
+//format is the output of all loggers, see SetFormat
+var format atomic.Value
+
+//SetFormat selects the output of all loggers, "text" (the
+//default) or "json", which writes one object per line with
+//the time, level, logger prefix, message and fields
+func SetFormat(f string) error {
+	switch f {
+	case "text", "json":
+		format.Store(f)
+		return nil
+	}
+	return fmt.Errorf("Invalid log format (%s)", f)
+}
+
+func isJSON() bool {
+	f, _ := format.Load().(string)
+	return f == "json"
+}
+
+//jsonLogger writes the JSON lines, log.Logger serializes writes
+var jsonLogger = log.New(output, "", 0)
+
 //Logger is pkg/log Logger with prefixing and 2 log levels
 type Logger struct {
 	Info, Debug bool
+	//Subsystem selects the runtime level set by
+	//SetLevel, it is inherited by forks
+	Subsystem string
 	//internal
 	prefix      string
 	logger      *log.Logger
 	info, debug *bool
+	fields      map[string]interface{}
 }
 
 func NewLogger(prefix string) *Logger {
@@ -22,7 +51,7 @@ func NewLogger(prefix string) *Logger {
 func NewLoggerFlag(prefix string, flag int) *Logger {
 	l := &Logger{
 		prefix: prefix,
-		logger: log.New(os.Stderr, "", flag),
+		logger: log.New(output, "", flag),
 		Info:   false,
 		Debug:  false,
 	}
@@ -31,14 +60,56 @@ func NewLoggerFlag(prefix string, flag int) *Logger {
 
 func (l *Logger) Infof(f string, args ...interface{}) {
 	if l.IsInfo() {
-		l.logger.Printf(l.prefix+": "+f, args...)
+		l.output("info", f, args)
+	}
+}
+
+//Warnf logs at the info verbosity, marked as a warning
+func (l *Logger) Warnf(f string, args ...interface{}) {
+	if _, ok := l.level(); ok || l.IsInfo() {
+		l.output("warn", f, args)
 	}
 }
 
 func (l *Logger) Debugf(f string, args ...interface{}) {
 	if l.IsDebug() {
+		l.output("debug", f, args)
+	}
+}
+
+func (l *Logger) output(level, f string, args []interface{}) {
+	if limit.allow(l, level, f, args) {
+		l.write(level, f, args)
+	}
+}
+
+func (l *Logger) write(level, f string, args []interface{}) {
+	lw, leveled := output.get().(LevelWriter)
+	if !isJSON() {
+		if leveled {
+			lw.WriteLevel(level, fmt.Sprintf(l.prefix+": "+f, args...))
+			return
+		}
 		l.logger.Printf(l.prefix+": "+f, args...)
+		return
+	}
+	entry := make(map[string]interface{}, len(l.fields)+4)
+	for k, v := range l.fields {
+		entry[k] = v
+	}
+	entry["time"] = time.Now().UTC().Format(time.RFC3339Nano)
+	entry["level"] = level
+	entry["logger"] = l.prefix
+	entry["msg"] = fmt.Sprintf(f, args...)
+	b, err := json.Marshal(entry)
+	if err != nil {
+		b, _ = json.Marshal(map[string]string{"level": level, "logger": l.prefix, "msg": entry["msg"].(string)})
 	}
+	if leveled {
+		lw.WriteLevel(level, string(b))
+		return
+	}
+	jsonLogger.Println(string(b))
 }
 
 func (l *Logger) Errorf(f string, args ...interface{}) error {
@@ -49,6 +120,8 @@ func (l *Logger) Fork(prefix string, args ...interface{}) *Logger {
 	//slip the parent prefix at the front
 	args = append([]interface{}{l.prefix}, args...)
 	ll := NewLogger(fmt.Sprintf("%s: "+prefix, args...))
+	ll.fields = l.fields
+	ll.Subsystem = l.Subsystem
 	//store link to parent settings too
 	ll.Info = l.Info
 	if l.info != nil {
@@ -65,14 +138,42 @@ func (l *Logger) Fork(prefix string, args ...interface{}) *Logger {
 	return ll
 }
 
+//With returns a logger which adds the field to its JSON
+//output, and which shares this logger's prefix and levels
+func (l *Logger) With(key string, value interface{}) *Logger {
+	ll := l.Fork("")
+	ll.prefix = l.prefix
+	ll.fields = make(map[string]interface{}, len(l.fields)+1)
+	for k, v := range l.fields {
+		ll.fields[k] = v
+	}
+	ll.fields[key] = value
+	return ll
+}
+
+//WithSubsystem returns a logger of the subsystem, which
+//shares this logger's prefix, fields and levels
+func (l *Logger) WithSubsystem(name string) *Logger {
+	ll := l.Fork("")
+	ll.prefix = l.prefix
+	ll.Subsystem = name
+	return ll
+}
+
 func (l *Logger) Prefix() string {
 	return l.prefix
 }
 
 func (l *Logger) IsInfo() bool {
+	if level, ok := l.level(); ok {
+		return level != "warn"
+	}
 	return l.Info || (l.info != nil && *l.info)
 }
 
 func (l *Logger) IsDebug() bool {
+	if level, ok := l.level(); ok {
+		return level == "debug"
+	}
 	return l.Debug || (l.debug != nil && *l.debug)
 }
@@ -0,0 +1,104 @@
+package cio
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestJSONLogger(t *testing.T) {
+	var buf bytes.Buffer
+	jsonLogger.SetOutput(&buf)
+	defer jsonLogger.SetOutput(output)
+	if err := SetFormat("yaml"); err == nil {
+		t.Fatal("expected invalid format")
+	}
+	SetFormat("json")
+	defer SetFormat("text")
+	l := NewLogger("server")
+	l.Info = true
+	sl := l.Fork("session#%d", 1).With("session_id", 1).With("user", "alice")
+	sl.Infof("hello %s", "world")
+	sl.Debugf("hidden")
+	l.Debug = true
+	sl.With("job_id", 7).Debugf("visible")
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %q", buf.String())
+	}
+	var entry map[string]interface{}
+	if err := json.Unmarshal(lines[0], &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry["msg"] != "hello world" || entry["level"] != "info" || entry["logger"] != "server: session#1" ||
+		entry["user"] != "alice" || entry["session_id"] != float64(1) {
+		t.Fatalf("unexpected entry %v", entry)
+	}
+	json.Unmarshal(lines[1], &entry)
+	if entry["level"] != "debug" || entry["job_id"] != float64(7) {
+		t.Fatalf("unexpected entry %v", entry)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	var buf syncBuffer
+	SetOutput(&buf)
+	defer SetOutput(os.Stderr)
+	SetRateLimit(2, 50*time.Millisecond)
+	defer SetRateLimit(0, 0)
+	l := NewLoggerFlag("client", 0)
+	l.Info = true
+	for i := 0; i < 5; i++ {
+		l.Infof("Connecting to %d", i)
+	}
+	l.Infof("Connected")
+	time.Sleep(100 * time.Millisecond)
+	want := "client: Connecting to 0\nclient: Connecting to 1\nclient: Connected\nclient: Connecting to 4 (repeated 3 times)\n"
+	if got := buf.String(); got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+}
+
+type syncBuffer struct {
+	mut sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	return b.buf.String()
+}
+
+func TestSetLevel(t *testing.T) {
+	if err := SetLevel("tunnel", "trace"); err == nil {
+		t.Fatal("expected invalid level")
+	}
+	l := NewLogger("server")
+	l.Info = true
+	tl := l.Fork("tun")
+	tl.Subsystem = "tunnel"
+	sl := tl.Fork("session#1")
+	SetLevel("tunnel", "debug")
+	defer SetLevel("tunnel", "")
+	if !sl.IsDebug() || l.IsDebug() {
+		t.Fatal("expected only the tunnel forks to debug")
+	}
+	SetLevel("tunnel", "warn")
+	if sl.IsInfo() || !l.IsInfo() {
+		t.Fatal("expected only the tunnel forks to warn")
+	}
+	SetLevel("tunnel", "")
+	if !sl.IsInfo() || sl.IsDebug() {
+		t.Fatal("expected the flags to be restored")
+	}
+}
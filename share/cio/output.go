@@ -0,0 +1,45 @@
+package cio
+
+import (
+	"io"
+	"log"
+	"os"
+	"sync/atomic"
+)
+
+// output is where all loggers write, see SetOutput
+var output = &outputWriter{}
+
+type outputWriter struct {
+	w atomic.Value
+}
+
+// writerBox keeps the concrete type stored in the atomic.Value constant
+type writerBox struct {
+	io.Writer
+}
+
+func (o *outputWriter) get() io.Writer {
+	if b, ok := o.w.Load().(writerBox); ok {
+		return b.Writer
+	}
+	return os.Stderr
+}
+
+func (o *outputWriter) Write(p []byte) (int, error) {
+	return o.get().Write(p)
+}
+
+// SetOutput sends all loggers, and the standard logger,
+// to w instead of stderr
+func SetOutput(w io.Writer) {
+	output.w.Store(writerBox{w})
+	log.SetOutput(output)
+}
+
+// LevelWriter is an output which takes each message with its level,
+// instead of a formatted line, such as syslog which adds its own time
+type LevelWriter interface {
+	io.Writer
+	WriteLevel(level, msg string) error
+}
@@ -0,0 +1,79 @@
+package cio
+
+import (
+	"sync"
+	"time"
+)
+
+// limit bounds repeated messages of all loggers, see SetRateLimit
+var limit = &rateLimit{keys: map[string]*rateKey{}}
+
+// SetRateLimit allows at most burst messages with the same level
+// and format per interval, across all loggers, so a flapping client
+// can't flood the logs. The rest are dropped, and summarized with
+// the last of them as "repeated N times" once the interval ends.
+// A zero burst disables the limit.
+func SetRateLimit(burst int, interval time.Duration) {
+	limit.mut.Lock()
+	limit.burst = burst
+	limit.interval = interval
+	limit.keys = map[string]*rateKey{}
+	limit.mut.Unlock()
+}
+
+type rateLimit struct {
+	mut      sync.Mutex
+	burst    int
+	interval time.Duration
+	keys     map[string]*rateKey
+}
+
+// rateKey is the current interval of a level and format
+type rateKey struct {
+	start   time.Time
+	count   int
+	dropped int
+	//the last dropped message
+	l     *Logger
+	level string
+	f     string
+	args  []interface{}
+}
+
+func (r *rateLimit) allow(l *Logger, level, f string, args []interface{}) bool {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	if r.burst <= 0 {
+		return true
+	}
+	now := time.Now()
+	k := level + " " + f
+	e := r.keys[k]
+	if e == nil || now.Sub(e.start) >= r.interval {
+		e = &rateKey{start: now}
+		r.keys[k] = e
+	}
+	e.count++
+	if e.count <= r.burst {
+		return true
+	}
+	if e.dropped == 0 {
+		time.AfterFunc(e.start.Add(r.interval).Sub(now), func() {
+			r.flush(k, e)
+		})
+	}
+	e.dropped++
+	e.l, e.level, e.f, e.args = l, level, f, args
+	return false
+}
+
+// flush summarizes the dropped messages of an ended interval
+func (r *rateLimit) flush(k string, e *rateKey) {
+	r.mut.Lock()
+	if r.keys[k] == e {
+		delete(r.keys, k)
+	}
+	args := append(append([]interface{}{}, e.args...), e.dropped)
+	r.mut.Unlock()
+	e.l.write(e.level, e.f+" (repeated %d times)", args)
+}
@@ -0,0 +1,118 @@
+package cio
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// RotatingFile is a log file which is renamed with a timestamp
+// suffix and reopened once it would exceed MaxSize or is older
+// than MaxAge, keeping at most MaxBackups of the renamed files.
+// Zero values disable each limit.
+type RotatingFile struct {
+	Path       string
+	MaxSize    int64
+	MaxAge     time.Duration
+	MaxBackups int
+	mut        sync.Mutex
+	file       *os.File
+	size       int64
+	opened     time.Time
+}
+
+// OpenRotatingFile opens path for appending
+func OpenRotatingFile(path string, maxSize int64, maxAge time.Duration, maxBackups int) (*RotatingFile, error) {
+	r := &RotatingFile{Path: path, MaxSize: maxSize, MaxAge: maxAge, MaxBackups: maxBackups}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *RotatingFile) open() error {
+	f, err := os.OpenFile(r.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.file = f
+	r.size = info.Size()
+	r.opened = time.Now()
+	return nil
+}
+
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	if r.file == nil {
+		return 0, os.ErrClosed
+	}
+	full := r.MaxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.MaxSize
+	old := r.MaxAge > 0 && time.Since(r.opened) >= r.MaxAge
+	if full || old {
+		if err := r.rotate(); err != nil {
+			//keep writing to the current file
+			fmt.Fprintf(os.Stderr, "log rotation of %s failed: %s\n", r.Path, err)
+		}
+	}
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// rotate renames the file and opens a new one, the caller holds mut
+func (r *RotatingFile) rotate() error {
+	backup := r.Path + "." + time.Now().Format("20060102-150405.000")
+	for i := 1; ; i++ {
+		if _, err := os.Stat(backup); os.IsNotExist(err) {
+			break
+		}
+		backup = fmt.Sprintf("%s.%s-%d", r.Path, time.Now().Format("20060102-150405.000"), i)
+	}
+	if err := os.Rename(r.Path, backup); err != nil {
+		r.opened = time.Now()
+		return err
+	}
+	r.file.Close()
+	if err := r.open(); err != nil {
+		r.file = nil
+		return err
+	}
+	r.prune()
+	return nil
+}
+
+// prune removes the oldest backups beyond MaxBackups
+func (r *RotatingFile) prune() {
+	if r.MaxBackups <= 0 {
+		return
+	}
+	backups, _ := filepath.Glob(r.Path + ".*")
+	if len(backups) <= r.MaxBackups {
+		return
+	}
+	//the timestamp suffixes sort by age
+	sort.Strings(backups)
+	for _, b := range backups[:len(backups)-r.MaxBackups] {
+		os.Remove(b)
+	}
+}
+
+func (r *RotatingFile) Close() error {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
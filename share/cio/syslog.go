@@ -0,0 +1,47 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package cio
+
+import (
+	"log/syslog"
+	"strings"
+)
+
+// NewSyslog connects to syslog at addr, "local" for the local
+// daemon (which journald also serves), or "udp://host:514"
+// or "tcp://host:514" for a remote one
+func NewSyslog(addr, tag string) (LevelWriter, error) {
+	network, raddr := "", ""
+	if addr != "local" {
+		if i := strings.Index(addr, "://"); i >= 0 {
+			network, raddr = addr[:i], addr[i+3:]
+		} else {
+			network, raddr = "udp", addr
+		}
+	}
+	w, err := syslog.Dial(network, raddr, syslog.LOG_INFO|syslog.LOG_DAEMON, tag)
+	if err != nil {
+		return nil, err
+	}
+	return &syslogWriter{w}, nil
+}
+
+type syslogWriter struct {
+	w *syslog.Writer
+}
+
+// Write logs lines of the standard logger at info
+func (s *syslogWriter) Write(p []byte) (int, error) {
+	return len(p), s.w.Info(string(p))
+}
+
+func (s *syslogWriter) WriteLevel(level, msg string) error {
+	switch level {
+	case "debug":
+		return s.w.Debug(msg)
+	case "warn":
+		return s.w.Warning(msg)
+	}
+	return s.w.Info(msg)
+}
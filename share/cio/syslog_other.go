@@ -0,0 +1,11 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package cio
+
+import "errors"
+
+// NewSyslog is not supported on this platform
+func NewSyslog(addr, tag string) (LevelWriter, error) {
+	return nil, errors.New("syslog is not supported on this platform")
+}
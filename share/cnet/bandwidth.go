@@ -0,0 +1,95 @@
+package cnet
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// Bandwidth is a token bucket of bytes per second,
+// shared by all of the connections it limits
+type Bandwidth struct {
+	mut    sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+// NewBandwidth limits to bytesPerSecond, allowing bursts
+// of a second's worth, zero or less is unlimited (nil)
+func NewBandwidth(bytesPerSecond int64) *Bandwidth {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	r := float64(bytesPerSecond)
+	return &Bandwidth{rate: r, tokens: r, last: time.Now()}
+}
+
+// burst is the most a single read or write may move
+func (b *Bandwidth) burst() int {
+	return int(b.rate)
+}
+
+// wait takes n bytes from the bucket, sleeping while it's in debt
+func (b *Bandwidth) wait(n int) {
+	b.mut.Lock()
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.rate {
+		b.tokens = b.rate
+	}
+	b.last = now
+	b.tokens -= float64(n)
+	debt := b.tokens
+	b.mut.Unlock()
+	if debt < 0 {
+		time.Sleep(time.Duration(-debt / b.rate * float64(time.Second)))
+	}
+}
+
+// Limit wraps c, so its reads take from read and its
+// writes from write, nil limits nothing
+func Limit(c net.Conn, read, write *Bandwidth) net.Conn {
+	if read == nil && write == nil {
+		return c
+	}
+	return &limitConn{Conn: c, read: read, write: write}
+}
+
+type limitConn struct {
+	net.Conn
+	read, write *Bandwidth
+}
+
+func (c *limitConn) Read(p []byte) (int, error) {
+	if c.read == nil {
+		return c.Conn.Read(p)
+	}
+	if max := c.read.burst(); len(p) > max {
+		p = p[:max]
+	}
+	n, err := c.Conn.Read(p)
+	c.read.wait(n)
+	return n, err
+}
+
+func (c *limitConn) Write(p []byte) (int, error) {
+	if c.write == nil {
+		return c.Conn.Write(p)
+	}
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if max := c.write.burst(); len(chunk) > max {
+			chunk = chunk[:max]
+		}
+		c.write.wait(len(chunk))
+		n, err := c.Conn.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
@@ -0,0 +1,36 @@
+package cnet
+
+import (
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestBandwidth(t *testing.T) {
+	//1KB/s, so 3KB takes the burst then 2s
+	b := NewBandwidth(1000)
+	a, z := net.Pipe()
+	defer z.Close()
+	go ioutil.ReadAll(z)
+	c := Limit(a, nil, b)
+	t0 := time.Now()
+	if n, err := c.Write(make([]byte, 3000)); err != nil || n != 3000 {
+		t.Fatalf("write %d: %v", n, err)
+	}
+	if d := time.Since(t0); d < 1800*time.Millisecond || d > 3*time.Second {
+		t.Fatalf("expected ~2s, took %s", d)
+	}
+	//reads are limited to the burst, and share the bucket
+	a2, z2 := net.Pipe()
+	defer z2.Close()
+	go z2.Write(make([]byte, 3000))
+	r := Limit(a2, b, nil)
+	buf := make([]byte, 3000)
+	if n, err := r.Read(buf); err != nil || n > 1000 {
+		t.Fatalf("read %d: %v", n, err)
+	}
+	if NewBandwidth(0) != nil || Limit(a, nil, nil) != a {
+		t.Fatal("expected zero to be unlimited")
+	}
+}
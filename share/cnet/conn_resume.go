@@ -0,0 +1,522 @@
+package cnet
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// frames of a ResumableConn's transport
+const (
+	frameHello byte = iota + 1
+	frameData
+	frameAck
+	frameClose
+)
+
+const (
+	//resumeMaxFrame bounds the payload of a frame
+	resumeMaxFrame = 32 << 10
+	//resumeMaxBuffer is how many unacknowledged bytes are
+	//buffered before writes wait for the peer
+	resumeMaxBuffer = 4 << 20
+	//resumeAckEvery is how many bytes are received between acks
+	resumeAckEvery = 64 << 10
+	//acks are also sent on each heartbeat, and a transport
+	//which is silent for resumeIdle is considered lost
+	resumeHeartbeat = 5 * time.Second
+	resumeIdle      = 3 * resumeHeartbeat
+	//resumeHandshake bounds the exchange of each new transport
+	resumeHandshake = 10 * time.Second
+)
+
+var (
+	errClosed        = errors.New("use of closed network connection")
+	errResumeExpired = errors.New("resume window expired")
+)
+
+// ResumableConn is a net.Conn over a replaceable transport, so the
+// connection above it (the SSH connection) survives its transport
+// (the websocket) being reconnected. Bytes written are buffered until
+// the peer acknowledges them, and when a new transport is attached,
+// each side resends those the other hasn't received.
+type ResumableConn struct {
+	mut  sync.Mutex
+	cond *sync.Cond
+	//writeMut orders the frames written to the transport,
+	//attachMut the transports being attached
+	writeMut  sync.Mutex
+	attachMut sync.Mutex
+	transport net.Conn
+	reader    *bufio.Reader
+	//gen changes with each transport, frames
+	//read from earlier transports are dropped
+	gen int
+	//window is how long to wait for a new transport
+	//once one is lost, zero closes the conn instead
+	window    time.Duration
+	suspended bool
+	suspends  int
+	lost      chan struct{}
+	//sent and received count the data bytes, buf holds those
+	//sent after acked, which the peer may not have received
+	sent, acked   uint64
+	received      uint64
+	lastAck       uint64
+	buf           []byte
+	pending       []byte
+	readDeadline  time.Time
+	writeDeadline time.Time
+	local, remote net.Addr
+	acks          chan struct{}
+	closed        bool
+	err           error
+	done          chan struct{}
+}
+
+// NewResumableConn starts a ResumableConn over the transport, the
+// peer must do the same. It isn't resumable until SetResumeWindow.
+func NewResumableConn(transport net.Conn) (*ResumableConn, error) {
+	c := &ResumableConn{
+		suspended: true,
+		lost:      make(chan struct{}),
+		acks:      make(chan struct{}, 1),
+		done:      make(chan struct{}),
+	}
+	close(c.lost)
+	c.cond = sync.NewCond(&c.mut)
+	if err := c.Attach(transport); err != nil {
+		return nil, err
+	}
+	go c.ackLoop()
+	return c, nil
+}
+
+// SetResumeWindow sets how long the conn waits for a new
+// transport to be attached once its transport is lost
+func (c *ResumableConn) SetResumeWindow(d time.Duration) {
+	c.mut.Lock()
+	c.window = d
+	c.mut.Unlock()
+}
+
+// Lost is closed when the current transport is lost,
+// or the conn is closed
+func (c *ResumableConn) Lost() <-chan struct{} {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	return c.lost
+}
+
+// Closed reports whether the conn is closed
+func (c *ResumableConn) Closed() bool {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	return c.closed
+}
+
+// Attach replaces the transport, after exchanging with the
+// peer how many bytes each received, and resends the rest
+func (c *ResumableConn) Attach(t net.Conn) error {
+	c.attachMut.Lock()
+	defer c.attachMut.Unlock()
+	c.mut.Lock()
+	if c.closed {
+		c.mut.Unlock()
+		t.Close()
+		return c.err
+	}
+	c.suspendLocked()
+	received := c.received
+	c.mut.Unlock()
+	peerReceived, r, err := resumeHello(t, received)
+	if err != nil {
+		t.Close()
+		return err
+	}
+	c.writeMut.Lock()
+	defer c.writeMut.Unlock()
+	c.mut.Lock()
+	if c.closed {
+		c.mut.Unlock()
+		t.Close()
+		return c.err
+	}
+	if peerReceived < c.acked || peerReceived > c.sent {
+		err := fmt.Errorf("cannot resume from byte %d, bytes %d-%d are buffered", peerReceived, c.acked, c.sent)
+		c.closeLocked(err)
+		c.mut.Unlock()
+		t.Close()
+		return err
+	}
+	c.ackLocked(peerReceived)
+	c.gen++
+	gen := c.gen
+	c.transport = t
+	c.reader = r
+	c.suspended = false
+	c.lost = make(chan struct{})
+	c.local, c.remote = t.LocalAddr(), t.RemoteAddr()
+	if !c.writeDeadline.IsZero() {
+		t.SetWriteDeadline(c.writeDeadline)
+	}
+	unsent := c.buf
+	c.cond.Broadcast()
+	c.mut.Unlock()
+	for len(unsent) > 0 {
+		n := len(unsent)
+		if n > resumeMaxFrame {
+			n = resumeMaxFrame
+		}
+		if err := writeFrame(t, frameData, unsent[:n]); err != nil {
+			return c.transportLost(gen, err)
+		}
+		unsent = unsent[n:]
+	}
+	return nil
+}
+
+// resumeHello sends how many bytes were received, and returns
+// how many the peer received, both sides send before reading
+func resumeHello(t net.Conn, received uint64) (uint64, *bufio.Reader, error) {
+	t.SetDeadline(time.Now().Add(resumeHandshake))
+	defer t.SetDeadline(time.Time{})
+	sent := make(chan error, 1)
+	go func() {
+		sent <- writeFrame(t, frameHello, encodeCount(received))
+	}()
+	r := bufio.NewReader(t)
+	typ, p, err := readFrame(r)
+	if err == nil && typ != frameHello {
+		err = errors.New("expected resume hello")
+	}
+	if werr := <-sent; err == nil {
+		err = werr
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	return binary.BigEndian.Uint64(p), r, nil
+}
+
+// suspendLocked drops the transport, and closes the conn
+// when no transport is attached within the window
+func (c *ResumableConn) suspendLocked() {
+	if c.transport != nil {
+		c.transport.Close()
+		c.transport = nil
+		c.reader = nil
+	}
+	c.gen++
+	if c.suspended {
+		return
+	}
+	c.suspended = true
+	close(c.lost)
+	c.suspends++
+	n := c.suspends
+	if c.window <= 0 {
+		return
+	}
+	time.AfterFunc(c.window, func() {
+		c.mut.Lock()
+		defer c.mut.Unlock()
+		if c.suspended && c.suspends == n {
+			c.closeLocked(errResumeExpired)
+		}
+	})
+}
+
+// transportLost suspends the conn when it's resumable,
+// else closes it, returning the error
+func (c *ResumableConn) transportLost(gen int, err error) error {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	if c.closed {
+		return c.err
+	}
+	if gen != c.gen {
+		return nil
+	}
+	if c.window <= 0 {
+		c.closeLocked(err)
+		return err
+	}
+	c.suspendLocked()
+	return nil
+}
+
+func (c *ResumableConn) ackLocked(n uint64) {
+	if n <= c.acked || n > c.sent {
+		return
+	}
+	c.buf = c.buf[n-c.acked:]
+	if len(c.buf) == 0 {
+		c.buf = nil
+	}
+	c.acked = n
+	c.cond.Broadcast()
+}
+
+func (c *ResumableConn) closeLocked(err error) {
+	if c.closed {
+		return
+	}
+	c.closed = true
+	c.err = err
+	if c.transport != nil {
+		c.transport.Close()
+		c.transport = nil
+	}
+	if !c.suspended {
+		c.suspended = true
+		close(c.lost)
+	}
+	close(c.done)
+	c.cond.Broadcast()
+}
+
+// ackLoop acknowledges the bytes received, when
+// asked by Read and on each heartbeat
+func (c *ResumableConn) ackLoop() {
+	heartbeat := time.NewTicker(resumeHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-c.acks:
+		case <-heartbeat.C:
+		}
+		c.writeMut.Lock()
+		c.mut.Lock()
+		t, gen, n := c.transport, c.gen, c.received
+		c.lastAck = n
+		c.mut.Unlock()
+		if t != nil {
+			if err := writeFrame(t, frameAck, encodeCount(n)); err != nil {
+				c.transportLost(gen, err)
+			}
+		}
+		c.writeMut.Unlock()
+	}
+}
+
+func (c *ResumableConn) Read(b []byte) (int, error) {
+	if len(c.pending) > 0 {
+		n := copy(b, c.pending)
+		c.pending = c.pending[n:]
+		return n, nil
+	}
+	for {
+		c.mut.Lock()
+		for !c.closed && c.transport == nil {
+			c.cond.Wait()
+		}
+		if c.closed {
+			err := c.err
+			c.mut.Unlock()
+			return 0, err
+		}
+		t, r, gen := c.transport, c.reader, c.gen
+		deadline, idle := c.readDeadline, c.readDeadline
+		if c.window > 0 {
+			if d := time.Now().Add(resumeIdle); idle.IsZero() || d.Before(idle) {
+				idle = d
+			}
+		}
+		c.mut.Unlock()
+		t.SetReadDeadline(idle)
+		typ, p, err := readFrame(r)
+		if err != nil {
+			if !deadline.IsZero() && time.Now().After(deadline) {
+				return 0, err
+			}
+			if err := c.transportLost(gen, err); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		c.mut.Lock()
+		if gen != c.gen {
+			c.mut.Unlock()
+			continue
+		}
+		switch typ {
+		case frameData:
+			c.received += uint64(len(p))
+			if c.received-c.lastAck >= resumeAckEvery {
+				select {
+				case c.acks <- struct{}{}:
+				default:
+				}
+			}
+			c.mut.Unlock()
+			n := copy(b, p)
+			c.pending = p[n:]
+			return n, nil
+		case frameAck:
+			c.ackLocked(binary.BigEndian.Uint64(p))
+			c.mut.Unlock()
+		case frameClose:
+			c.closeLocked(io.EOF)
+			c.mut.Unlock()
+			return 0, io.EOF
+		default:
+			err := fmt.Errorf("unexpected frame type %d", typ)
+			c.closeLocked(err)
+			c.mut.Unlock()
+			return 0, err
+		}
+	}
+}
+
+func (c *ResumableConn) Write(b []byte) (int, error) {
+	written := 0
+	for len(b) > 0 {
+		n := len(b)
+		if n > resumeMaxFrame {
+			n = resumeMaxFrame
+		}
+		if err := c.write(b[:n]); err != nil {
+			return written, err
+		}
+		written += n
+		b = b[n:]
+	}
+	return written, nil
+}
+
+// write buffers b until it's acknowledged, and sends
+// it when there's a transport, else it's resent later
+func (c *ResumableConn) write(b []byte) error {
+	c.mut.Lock()
+	for !c.closed && len(c.buf) >= resumeMaxBuffer {
+		c.cond.Wait()
+	}
+	c.mut.Unlock()
+	c.writeMut.Lock()
+	defer c.writeMut.Unlock()
+	c.mut.Lock()
+	if c.closed {
+		err := c.err
+		c.mut.Unlock()
+		return err
+	}
+	c.buf = append(c.buf, b...)
+	c.sent += uint64(len(b))
+	t, gen := c.transport, c.gen
+	c.mut.Unlock()
+	if t == nil {
+		return nil
+	}
+	if err := writeFrame(t, frameData, b); err != nil {
+		return c.transportLost(gen, err)
+	}
+	return nil
+}
+
+// Close tells the peer, so it doesn't wait to resume, then closes
+func (c *ResumableConn) Close() error {
+	c.mut.Lock()
+	t, closed := c.transport, c.closed
+	c.mut.Unlock()
+	if closed {
+		return nil
+	}
+	if t != nil {
+		sent := make(chan struct{})
+		go func() {
+			c.writeMut.Lock()
+			writeFrame(t, frameClose, nil)
+			c.writeMut.Unlock()
+			close(sent)
+		}()
+		select {
+		case <-sent:
+		case <-time.After(time.Second):
+		}
+	}
+	c.mut.Lock()
+	c.closeLocked(errClosed)
+	c.mut.Unlock()
+	return nil
+}
+
+func (c *ResumableConn) LocalAddr() net.Addr {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	return c.local
+}
+
+func (c *ResumableConn) RemoteAddr() net.Addr {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	return c.remote
+}
+
+func (c *ResumableConn) SetDeadline(t time.Time) error {
+	c.SetReadDeadline(t)
+	return c.SetWriteDeadline(t)
+}
+
+func (c *ResumableConn) SetReadDeadline(t time.Time) error {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	c.readDeadline = t
+	if c.transport != nil {
+		return c.transport.SetReadDeadline(t)
+	}
+	return nil
+}
+
+func (c *ResumableConn) SetWriteDeadline(t time.Time) error {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	c.writeDeadline = t
+	if c.transport != nil {
+		return c.transport.SetWriteDeadline(t)
+	}
+	return nil
+}
+
+// writeFrame writes a frame in one write, so each
+// is a single message of a websocket transport
+func writeFrame(w io.Writer, typ byte, p []byte) error {
+	b := make([]byte, 5+len(p))
+	b[0] = typ
+	binary.BigEndian.PutUint32(b[1:5], uint32(len(p)))
+	copy(b[5:], p)
+	_, err := w.Write(b)
+	return err
+}
+
+func readFrame(r io.Reader) (byte, []byte, error) {
+	var h [5]byte
+	if _, err := io.ReadFull(r, h[:]); err != nil {
+		return 0, nil, err
+	}
+	n := binary.BigEndian.Uint32(h[1:])
+	if n > resumeMaxFrame {
+		return 0, nil, fmt.Errorf("frame of %d bytes is too large", n)
+	}
+	if (h[0] == frameHello || h[0] == frameAck) && n != 8 {
+		return 0, nil, fmt.Errorf("invalid frame of type %d", h[0])
+	}
+	p := make([]byte, n)
+	if _, err := io.ReadFull(r, p); err != nil {
+		return 0, nil, err
+	}
+	return h[0], p, nil
+}
+
+func encodeCount(n uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, n)
+	return b
+}
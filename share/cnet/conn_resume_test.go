@@ -0,0 +1,110 @@
+package cnet
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// dropConn discards the writes after it's cut, as a
+// transport which fails mid-flight would
+type dropConn struct {
+	net.Conn
+	cut int32
+}
+
+func (c *dropConn) Write(b []byte) (int, error) {
+	if atomic.LoadInt32(&c.cut) == 1 {
+		return len(b), nil
+	}
+	return c.Conn.Write(b)
+}
+
+// resumablePair attaches both sides of a pipe, concurrently, as peers do
+func resumablePair(t *testing.T, a, b *ResumableConn) (*dropConn, *dropConn) {
+	p1, p2 := net.Pipe()
+	ta, tb := &dropConn{Conn: p1}, &dropConn{Conn: p2}
+	errs := make(chan error, 1)
+	go func() { errs <- b.Attach(tb) }()
+	if err := a.Attach(ta); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	return ta, tb
+}
+
+func TestResumableConn(t *testing.T) {
+	p1, p2 := net.Pipe()
+	var b *ResumableConn
+	errs := make(chan error, 1)
+	go func() {
+		var err error
+		b, err = NewResumableConn(p2)
+		errs <- err
+	}()
+	a, err := NewResumableConn(p1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	a.SetResumeWindow(5 * time.Second)
+	b.SetResumeWindow(5 * time.Second)
+	//as ssh does, a reads continuously, receiving the acks
+	go ioutil.ReadAll(a)
+	//more than the buffer, so writes wait for acks
+	data := bytes.Repeat([]byte("0123456789abcdef"), resumeMaxBuffer/8)
+	received := make(chan []byte)
+	go func() {
+		got := make([]byte, len(data)+5)
+		_, err := io.ReadFull(b, got)
+		if err != nil {
+			t.Error(err)
+		}
+		received <- got
+	}()
+	if _, err := a.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	//the transport fails, losing a write in flight
+	ta, _ := resumablePair(t, a, b)
+	atomic.StoreInt32(&ta.cut, 1)
+	if _, err := a.Write([]byte("lost ")); err != nil {
+		t.Fatal(err)
+	}
+	resumablePair(t, a, b)
+	if got := <-received; !bytes.Equal(got, append(data, "lost "...)) {
+		t.Fatalf("resumed stream differs after %d bytes", len(got))
+	}
+	//closing tells the peer
+	eof := make(chan error)
+	go func() {
+		_, err := b.Read(make([]byte, 1))
+		eof <- err
+	}()
+	a.Close()
+	if err := <-eof; err != io.EOF {
+		t.Fatalf("expected EOF, got %v", err)
+	}
+}
+
+func TestResumableConnExpires(t *testing.T) {
+	p1, p2 := net.Pipe()
+	go NewResumableConn(p2)
+	a, err := NewResumableConn(p1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.SetResumeWindow(50 * time.Millisecond)
+	p2.Close()
+	if _, err := a.Read(make([]byte, 1)); err != errResumeExpired {
+		t.Fatalf("expected the window to expire, got %v", err)
+	}
+}
@@ -23,6 +23,11 @@ func (c *ConnCount) Close() {
 	atomic.AddInt32(&c.open, -1)
 }
 
+//Opened returns the number of open connections
+func (c *ConnCount) Opened() int32 {
+	return atomic.LoadInt32(&c.open)
+}
+
 func (c *ConnCount) String() string {
 	return fmt.Sprintf("[%d/%d]", atomic.LoadInt32(&c.open), atomic.LoadInt32(&c.count))
 }
@@ -0,0 +1,76 @@
+package cnet
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// IdleTimeout closes c once it has had no traffic, in either
+// direction, for d, calling onIdle, zero never closes it
+func IdleTimeout(c net.Conn, d time.Duration, onIdle func()) net.Conn {
+	if d <= 0 {
+		return c
+	}
+	i := &idleConn{Conn: c, d: d, onIdle: onIdle}
+	i.active()
+	//the timer is assigned before it may fire
+	i.timer = time.AfterFunc(time.Hour, i.check)
+	i.timer.Reset(d)
+	return i
+}
+
+type idleConn struct {
+	//last is the UnixNano of the last traffic, first
+	//since 64-bit atomics must be aligned on 32-bit platforms
+	last   int64
+	closed int32
+	net.Conn
+	d      time.Duration
+	timer  *time.Timer
+	onIdle func()
+}
+
+func (c *idleConn) active() {
+	atomic.StoreInt64(&c.last, time.Now().UnixNano())
+}
+
+// check closes the connection when idle, else waits
+// for the rest of the period since its last traffic
+func (c *idleConn) check() {
+	if atomic.LoadInt32(&c.closed) == 1 {
+		return
+	}
+	idle := time.Since(time.Unix(0, atomic.LoadInt64(&c.last)))
+	if idle < c.d {
+		c.timer.Reset(c.d - idle)
+		return
+	}
+	if c.Close() == nil && c.onIdle != nil {
+		c.onIdle()
+	}
+}
+
+func (c *idleConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.active()
+	}
+	return n, err
+}
+
+func (c *idleConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if n > 0 {
+		c.active()
+	}
+	return n, err
+}
+
+func (c *idleConn) Close() error {
+	if !atomic.CompareAndSwapInt32(&c.closed, 0, 1) {
+		return nil
+	}
+	c.timer.Stop()
+	return c.Conn.Close()
+}
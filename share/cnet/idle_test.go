@@ -0,0 +1,41 @@
+package cnet
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestIdleTimeout(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	var idled int32
+	c := IdleTimeout(a, 100*time.Millisecond, func() { atomic.StoreInt32(&idled, 1) })
+	//traffic keeps it open past the timeout
+	go func() {
+		buf := make([]byte, 1)
+		for {
+			if _, err := b.Read(buf); err != nil {
+				return
+			}
+		}
+	}()
+	for i := 0; i < 4; i++ {
+		time.Sleep(50 * time.Millisecond)
+		if _, err := c.Write([]byte("x")); err != nil {
+			t.Fatalf("expected an active connection to stay open: %s", err)
+		}
+	}
+	//then it idles
+	time.Sleep(200 * time.Millisecond)
+	if atomic.LoadInt32(&idled) != 1 {
+		t.Fatal("expected the idle connection to be closed")
+	}
+	if _, err := c.Write([]byte("x")); err == nil {
+		t.Fatal("expected writes to fail once closed")
+	}
+	if IdleTimeout(a, 0, nil) != a {
+		t.Fatal("expected zero to watch nothing")
+	}
+}
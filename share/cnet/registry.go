@@ -0,0 +1,124 @@
+package cnet
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Directions of tracked connections
+const (
+	Accepted = "accepted"
+	Dialed   = "dialed"
+)
+
+// ConnLabels describe a tracked connection,
+// empty labels match any in Count
+type ConnLabels struct {
+	Session   string `json:"session"`
+	Remote    string `json:"remote"`
+	Direction string `json:"direction"`
+}
+
+func (l ConnLabels) match(filter ConnLabels) bool {
+	return (filter.Session == "" || filter.Session == l.Session) &&
+		(filter.Remote == "" || filter.Remote == l.Remote) &&
+		(filter.Direction == "" || filter.Direction == l.Direction)
+}
+
+// ConnInfo is a tracked connection
+type ConnInfo struct {
+	ID int64 `json:"id"`
+	ConnLabels
+	LocalAddr  string    `json:"local_addr"`
+	RemoteAddr string    `json:"remote_addr"`
+	Opened     time.Time `json:"opened"`
+}
+
+// Age is how long the connection has been open
+func (c ConnInfo) Age() time.Duration {
+	return time.Since(c.Opened)
+}
+
+// Registry tracks live connections, accepted by listeners
+// or dialed to upstreams, a nil Registry tracks nothing
+type Registry struct {
+	mut   sync.Mutex
+	next  int64
+	conns map[int64]ConnInfo
+}
+
+func NewRegistry() *Registry {
+	return &Registry{conns: map[int64]ConnInfo{}}
+}
+
+// Track registers c until it is closed
+func (r *Registry) Track(c net.Conn, labels ConnLabels) net.Conn {
+	if r == nil {
+		return c
+	}
+	r.mut.Lock()
+	r.next++
+	id := r.next
+	r.conns[id] = ConnInfo{
+		ID:         id,
+		ConnLabels: labels,
+		LocalAddr:  c.LocalAddr().String(),
+		RemoteAddr: c.RemoteAddr().String(),
+		Opened:     time.Now(),
+	}
+	r.mut.Unlock()
+	return &trackedConn{Conn: c, r: r, id: id}
+}
+
+func (r *Registry) untrack(id int64) {
+	r.mut.Lock()
+	delete(r.conns, id)
+	r.mut.Unlock()
+}
+
+// Count returns the number of live connections matching filter
+func (r *Registry) Count(filter ConnLabels) int {
+	if r == nil {
+		return 0
+	}
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	n := 0
+	for _, c := range r.conns {
+		if c.match(filter) {
+			n++
+		}
+	}
+	return n
+}
+
+// List returns the live connections matching filter, oldest first
+func (r *Registry) List(filter ConnLabels) []ConnInfo {
+	out := []ConnInfo{}
+	if r == nil {
+		return out
+	}
+	r.mut.Lock()
+	for _, c := range r.conns {
+		if c.match(filter) {
+			out = append(out, c)
+		}
+	}
+	r.mut.Unlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+type trackedConn struct {
+	net.Conn
+	r    *Registry
+	id   int64
+	once sync.Once
+}
+
+func (c *trackedConn) Close() error {
+	c.once.Do(func() { c.r.untrack(c.id) })
+	return c.Conn.Close()
+}
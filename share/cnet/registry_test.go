@@ -0,0 +1,36 @@
+package cnet
+
+import (
+	"net"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	a1, b1 := net.Pipe()
+	a2, b2 := net.Pipe()
+	defer b1.Close()
+	defer b2.Close()
+	c1 := r.Track(a1, ConnLabels{Session: "1", Remote: "R:80", Direction: Accepted})
+	c2 := r.Track(a2, ConnLabels{Session: "1", Remote: "80", Direction: Dialed})
+	if n := r.Count(ConnLabels{Session: "1"}); n != 2 {
+		t.Fatalf("expected 2 connections, got %d", n)
+	}
+	if n := r.Count(ConnLabels{Direction: Dialed}); n != 1 {
+		t.Fatalf("expected 1 dialed connection, got %d", n)
+	}
+	c1.Close()
+	c1.Close()
+	list := r.List(ConnLabels{})
+	if len(list) != 1 || list[0].Remote != "80" {
+		t.Fatalf("unexpected connections %+v", list)
+	}
+	c2.Close()
+	if n := r.Count(ConnLabels{}); n != 0 {
+		t.Fatalf("expected no connections, got %d", n)
+	}
+	var nilRegistry *Registry
+	if c := nilRegistry.Track(a1, ConnLabels{}); c != a1 || nilRegistry.Count(ConnLabels{}) != 0 {
+		t.Fatal("expected a nil registry to track nothing")
+	}
+}
@@ -0,0 +1,71 @@
+package cnet
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+// ErrSlowConsumer closes a connection whose
+// peer persistently can't keep up with writes
+var ErrSlowConsumer = errors.New("slow consumer")
+
+// SlowWrites detects the peer of a connection
+// which can't keep up with its writes
+type SlowWrites struct {
+	//Threshold is how long a write may take before it is slow
+	Threshold time.Duration
+	//Limit is the number of consecutive slow writes which close
+	//the connection, a single write then also fails once it has
+	//taken Limit times the Threshold, zero never closes
+	Limit int
+	//OnSlow is called after each slow write, with the number
+	//of consecutive slow writes and whether it closed the connection
+	OnSlow func(d time.Duration, consecutive int, closed bool)
+}
+
+// Watch wraps c, nil or a zero Threshold watch nothing
+func (s *SlowWrites) Watch(c net.Conn) net.Conn {
+	if s == nil || s.Threshold <= 0 {
+		return c
+	}
+	return &slowConn{Conn: c, s: s}
+}
+
+type slowConn struct {
+	net.Conn
+	s *SlowWrites
+	//slow counts consecutive slow writes, writes
+	//come from a single pipe so aren't concurrent
+	slow int
+}
+
+func (c *slowConn) Write(p []byte) (int, error) {
+	if c.s.Limit > 0 {
+		c.Conn.SetWriteDeadline(time.Now().Add(time.Duration(c.s.Limit) * c.s.Threshold))
+	}
+	t0 := time.Now()
+	n, err := c.Conn.Write(p)
+	d := time.Since(t0)
+	if d < c.s.Threshold {
+		c.slow = 0
+		return n, err
+	}
+	c.slow++
+	closed := false
+	if c.s.Limit > 0 {
+		if e, ok := err.(net.Error); ok && e.Timeout() {
+			closed = true
+		} else if c.slow >= c.s.Limit {
+			closed = true
+		}
+	}
+	if closed {
+		c.Conn.Close()
+		err = ErrSlowConsumer
+	}
+	if c.s.OnSlow != nil {
+		c.s.OnSlow(d, c.slow, closed)
+	}
+	return n, err
+}
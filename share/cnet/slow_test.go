@@ -0,0 +1,42 @@
+package cnet
+
+import (
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSlowWrites(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	var slow []int
+	closed := false
+	s := &SlowWrites{
+		Threshold: 20 * time.Millisecond,
+		Limit:     2,
+		OnSlow: func(d time.Duration, n int, c bool) {
+			slow = append(slow, n)
+			closed = c
+		},
+	}
+	c := s.Watch(a)
+	//a prompt reader is not slow
+	go ioutil.ReadAll(b)
+	if _, err := c.Write([]byte("ok")); err != nil || len(slow) != 0 {
+		t.Fatalf("unexpected slow write %v: %v", slow, err)
+	}
+	//a stalled reader is, and is closed at the limit
+	a2, b2 := net.Pipe()
+	defer b2.Close()
+	c = s.Watch(a2)
+	if _, err := c.Write([]byte("stalled")); err != ErrSlowConsumer {
+		t.Fatalf("expected a slow consumer, got %v", err)
+	}
+	if len(slow) != 1 || !closed {
+		t.Fatalf("expected one closing slow write, got %v %v", slow, closed)
+	}
+	if (*SlowWrites)(nil).Watch(a) != a {
+		t.Fatal("expected nil to watch nothing")
+	}
+}
@@ -0,0 +1,45 @@
+package cnet
+
+import (
+	"io"
+	"sync/atomic"
+)
+
+// Traffic counts the bytes sent and received over connections
+type Traffic struct {
+	sent     int64
+	received int64
+}
+
+// Sent returns the bytes written so far
+func (t *Traffic) Sent() int64 {
+	return atomic.LoadInt64(&t.sent)
+}
+
+// Received returns the bytes read so far
+func (t *Traffic) Received() int64 {
+	return atomic.LoadInt64(&t.received)
+}
+
+// Count wraps rwc, adding its reads to the received
+// bytes and its writes to the sent bytes
+func (t *Traffic) Count(rwc io.ReadWriteCloser) io.ReadWriteCloser {
+	return &trafficRWC{ReadWriteCloser: rwc, t: t}
+}
+
+type trafficRWC struct {
+	io.ReadWriteCloser
+	t *Traffic
+}
+
+func (c *trafficRWC) Read(p []byte) (int, error) {
+	n, err := c.ReadWriteCloser.Read(p)
+	atomic.AddInt64(&c.t.received, int64(n))
+	return n, err
+}
+
+func (c *trafficRWC) Write(p []byte) (int, error) {
+	n, err := c.ReadWriteCloser.Write(p)
+	atomic.AddInt64(&c.t.sent, int64(n))
+	return n, err
+}
@@ -0,0 +1,20 @@
+//go:build !windows
+// +build !windows
+
+package cos
+
+import (
+	"context"
+	"errors"
+)
+
+// RunService runs under the Windows service control
+// manager, elsewhere it never does
+func RunService(name string, run func(ctx context.Context) error) (bool, error) {
+	return false, nil
+}
+
+// ControlService manages Windows services, elsewhere it fails
+func ControlService(name, action string, args []string) error {
+	return errors.New("Services are only supported on Windows, see systemd in --help")
+}
@@ -0,0 +1,23 @@
+//go:build !windows
+// +build !windows
+
+package cos
+
+import (
+	"context"
+	"testing"
+)
+
+func TestRunService(t *testing.T) {
+	ran := false
+	ok, err := RunService("chisel", func(ctx context.Context) error {
+		ran = true
+		return nil
+	})
+	if ok || err != nil || ran {
+		t.Fatalf("expected no service outside of windows, got %v %v", ok, err)
+	}
+	if err := ControlService("chisel", "install", []string{"client"}); err == nil {
+		t.Fatal("expected services to be unsupported")
+	}
+}
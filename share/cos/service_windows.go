@@ -0,0 +1,137 @@
+//go:build windows
+// +build windows
+
+package cos
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// RunService runs run under the Windows service control manager,
+// until the service is stopped, and reports whether it did, which
+// it doesn't when the process wasn't started as a service
+func RunService(name string, run func(ctx context.Context) error) (bool, error) {
+	isService, err := svc.IsWindowsService()
+	if err != nil || !isService {
+		return false, err
+	}
+	h := &serviceHandler{run: run}
+	if err := svc.Run(name, h); err != nil {
+		return true, err
+	}
+	return true, h.err
+}
+
+type serviceHandler struct {
+	run func(ctx context.Context) error
+	err error
+}
+
+func (h *serviceHandler) Execute(args []string, reqs <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- h.run(ctx)
+	}()
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for {
+		select {
+		case err := <-done:
+			//exited by itself, a failure lets the recovery actions restart it
+			if err != nil {
+				h.err = err
+				return true, 1
+			}
+			return false, 0
+		case r := <-reqs:
+			switch r.Cmd {
+			case svc.Interrogate:
+				status <- r.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending}
+				cancel()
+				h.err = <-done
+				return false, 0
+			}
+		}
+	}
+}
+
+// serviceStopTimeout bounds waiting for a stopped service
+const serviceStopTimeout = 30 * time.Second
+
+// ControlService installs, uninstalls, starts or stops the named
+// Windows service, which runs this executable with args
+func ControlService(name, action string, args []string) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("Failed to connect to the service manager: %s", err)
+	}
+	defer m.Disconnect()
+	if action == "install" {
+		return installService(m, name, args)
+	}
+	s, err := m.OpenService(name)
+	if err != nil {
+		return fmt.Errorf("Service %s is not installed: %s", name, err)
+	}
+	defer s.Close()
+	switch action {
+	case "uninstall":
+		return s.Delete()
+	case "start":
+		return s.Start()
+	case "stop":
+		st, err := s.Control(svc.Stop)
+		if err != nil {
+			return err
+		}
+		deadline := time.Now().Add(serviceStopTimeout)
+		for st.State != svc.Stopped {
+			if time.Now().After(deadline) {
+				return errors.New("Timed out waiting for the service to stop")
+			}
+			time.Sleep(300 * time.Millisecond)
+			if st, err = s.Query(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("Unknown service action '%s', expected install, uninstall, start or stop", action)
+}
+
+func installService(m *mgr.Mgr, name string, args []string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if s, err := m.OpenService(name); err == nil {
+		s.Close()
+		return fmt.Errorf("Service %s is already installed", name)
+	}
+	s, err := m.CreateService(name, exe, mgr.Config{
+		DisplayName: name,
+		Description: "chisel tunnel",
+		StartType:   mgr.StartAutomatic,
+	}, args...)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	//restart after failures, instead of a scheduled task
+	return s.SetRecoveryActions([]mgr.RecoveryAction{
+		{Type: mgr.ServiceRestart, Delay: 5 * time.Second},
+		{Type: mgr.ServiceRestart, Delay: 30 * time.Second},
+		{Type: mgr.ServiceRestart, Delay: time.Minute},
+	}, uint32((24 * time.Hour).Seconds()))
+}
@@ -0,0 +1,71 @@
+//go:build windows
+// +build windows
+
+package cos
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+)
+
+// execute runs h as the service manager would, returning
+// the status updates and the channel of change requests
+func execute(h *serviceHandler) (chan svc.ChangeRequest, chan svc.Status, chan uint32) {
+	reqs := make(chan svc.ChangeRequest)
+	status := make(chan svc.Status, 10)
+	exit := make(chan uint32, 1)
+	go func() {
+		_, code := h.Execute(nil, reqs, status)
+		exit <- code
+	}()
+	return reqs, status, exit
+}
+
+func TestServiceStop(t *testing.T) {
+	stopped := make(chan struct{})
+	h := &serviceHandler{run: func(ctx context.Context) error {
+		<-ctx.Done()
+		close(stopped)
+		return nil
+	}}
+	reqs, status, exit := execute(h)
+	if s := <-status; s.State != svc.StartPending {
+		t.Fatalf("expected start pending, got %v", s.State)
+	}
+	if s := <-status; s.State != svc.Running || s.Accepts&svc.AcceptStop == 0 {
+		t.Fatalf("expected running, got %+v", s)
+	}
+	reqs <- svc.ChangeRequest{Cmd: svc.Stop}
+	select {
+	case code := <-exit:
+		if code != 0 || h.err != nil {
+			t.Fatalf("expected a clean stop, got %d %v", code, h.err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the service to stop")
+	}
+	select {
+	case <-stopped:
+	default:
+		t.Fatal("expected run to be cancelled")
+	}
+}
+
+func TestServiceFailure(t *testing.T) {
+	h := &serviceHandler{run: func(ctx context.Context) error {
+		return errors.New("failed to connect")
+	}}
+	_, _, exit := execute(h)
+	select {
+	case code := <-exit:
+		if code != 1 || h.err == nil {
+			t.Fatalf("expected a failure exit code, got %d %v", code, h.err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the service to exit")
+	}
+}
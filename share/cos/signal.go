@@ -3,6 +3,7 @@
 package cos
 
 import (
+	"context"
 	"log"
 	"os"
 	"os/signal"
@@ -30,6 +31,38 @@ func GoStats() {
 	}
 }
 
+//DumpOnSignal calls dump on each SIGUSR1
+//until ctx is cancelled (posix-only)
+func DumpOnSignal(ctx context.Context, dump func()) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGUSR1)
+	defer signal.Stop(c)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-c:
+			dump()
+		}
+	}
+}
+
+//ReloadOnSignal calls reload on each SIGHUP
+//until ctx is cancelled (posix-only)
+func ReloadOnSignal(ctx context.Context, reload func()) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP)
+	defer signal.Stop(c)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-c:
+			reload()
+		}
+	}
+}
+
 //AfterSignal returns a channel which will be closed
 //after the given duration or until a SIGHUP is received
 func AfterSignal(d time.Duration) <-chan struct{} {
@@ -3,6 +3,7 @@
 package cos
 
 import (
+	"context"
 	"time"
 )
 
@@ -10,6 +11,14 @@ func GoStats() {
 	//noop
 }
 
+func DumpOnSignal(ctx context.Context, dump func()) {
+	//noop
+}
+
+func ReloadOnSignal(ctx context.Context, reload func()) {
+	//noop
+}
+
 func AfterSignal(d time.Duration) <-chan struct{} {
 	ch := make(chan struct{})
 	go func() {
@@ -0,0 +1,101 @@
+package cos
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// SdNotify sends a state to systemd (see sd_notify(3)),
+// e.g. "READY=1", and reports whether it was sent, which
+// it isn't when the service manager isn't listening
+func SdNotify(state string) (bool, error) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return false, nil
+	}
+	//abstract namespace socket
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+	c, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return false, err
+	}
+	defer c.Close()
+	if _, err := c.Write([]byte(state)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// SdWatchdogInterval is the watchdog timeout which
+// systemd set for this process, zero when disabled
+func SdWatchdogInterval() time.Duration {
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// SdWatchdog feeds the systemd watchdog at half its interval,
+// until the context is cancelled. The watchdog isn't fed while
+// check fails, so systemd restarts a process which stays unhealthy.
+func SdWatchdog(ctx context.Context, check func() error) {
+	interval := SdWatchdogInterval()
+	if interval <= 0 {
+		return
+	}
+	t := time.NewTicker(interval / 2)
+	defer t.Stop()
+	for {
+		if check == nil || check() == nil {
+			SdNotify("WATCHDOG=1")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// sdListenFdsStart is the first file descriptor passed by systemd
+const sdListenFdsStart = 3
+
+// SdListeners returns the sockets passed by systemd socket
+// activation (see sd_listen_fds(3)), none when not activated.
+// The environment is cleared, so they are only returned once.
+func SdListeners() ([]net.Listener, error) {
+	defer os.Unsetenv("LISTEN_PID")
+	defer os.Unsetenv("LISTEN_FDS")
+	defer os.Unsetenv("LISTEN_FDNAMES")
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return nil, errors.New("invalid LISTEN_FDS")
+	}
+	listeners := make([]net.Listener, n)
+	for i := range listeners {
+		fd := sdListenFdsStart + i
+		f := os.NewFile(uintptr(fd), fmt.Sprintf("LISTEN_FD_%d", fd))
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("systemd socket %d: %s", fd, err)
+		}
+		listeners[i] = l
+	}
+	return listeners, nil
+}
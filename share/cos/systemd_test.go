@@ -0,0 +1,52 @@
+package cos
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSdNotify(t *testing.T) {
+	os.Unsetenv("NOTIFY_SOCKET")
+	if sent, err := SdNotify("READY=1"); sent || err != nil {
+		t.Fatalf("expected nothing sent, got %v %v", sent, err)
+	}
+	dir, err := ioutil.TempDir("", "sdnotify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "notify.sock")
+	c, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	os.Setenv("NOTIFY_SOCKET", path)
+	defer os.Unsetenv("NOTIFY_SOCKET")
+	if sent, err := SdNotify("READY=1"); !sent || err != nil {
+		t.Fatalf("expected sent, got %v %v", sent, err)
+	}
+	b := make([]byte, 64)
+	n, _ := c.Read(b)
+	if string(b[:n]) != "READY=1" {
+		t.Fatalf("unexpected state %q", b[:n])
+	}
+	//the watchdog is fed at half its interval
+	os.Setenv("WATCHDOG_USEC", "100000")
+	defer os.Unsetenv("WATCHDOG_USEC")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go SdWatchdog(ctx, nil)
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	for i := 0; i < 2; i++ {
+		n, err := c.Read(b)
+		if err != nil || string(b[:n]) != "WATCHDOG=1" {
+			t.Fatalf("expected a watchdog ping, got %q %v", b[:n], err)
+		}
+	}
+}
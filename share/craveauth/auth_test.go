@@ -0,0 +1,25 @@
+package craveauth
+
+import (
+	"errors"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestJobFinished(t *testing.T) {
+	for err, finished := range map[error]bool{
+		nil:                                      false,
+		status.Error(codes.NotFound, "job"):      true,
+		status.Error(codes.Unavailable, ""):      false,
+		status.Error(codes.Unknown, ""):          false,
+		status.Error(codes.Internal, ""):         false,
+		errors.New("connection reset"):           false,
+		status.Error(codes.DeadlineExceeded, ""): false,
+	} {
+		if JobFinished(err) != finished {
+			t.Fatalf("expected JobFinished(%v) to be %v", err, finished)
+		}
+	}
+}
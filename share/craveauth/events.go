@@ -0,0 +1,93 @@
+package craveauth
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// Event types exchanged with dcmaster on the events stream
+const (
+	// EventProxyAdded is sent when a dynamic proxy is registered
+	EventProxyAdded = "proxy_added"
+	// EventProxyRemoved is sent when a dynamic proxy is removed
+	EventProxyRemoved = "proxy_removed"
+	// EventReady is received once dcmaster accepts the stream
+	EventReady = "ready"
+	// EventJobFinished is received when a job is no longer running
+	EventJobFinished = "job_finished"
+)
+
+// Event is a single message on the events stream, both ways
+type Event struct {
+	Type    string
+	ProxyId string
+	JobId   int64
+	UserId  int64
+	Target  string
+}
+
+// eventsStreamDesc is the bidirectional DcMasterEvents/Stream method.
+// Messages are google.protobuf.Struct, with the fields of Event, so
+// dcmaster's generated dcrpc code does not need to change in lock step.
+var eventsStreamDesc = &grpc.StreamDesc{
+	StreamName:    "Stream",
+	ServerStreams: true,
+	ClientStreams: true,
+}
+
+const eventsStreamMethod = "/dcrpc.DcMasterEvents/Stream"
+
+// EventStream pushes proxy changes to dcmaster and
+// receives job state changes, in real time
+type EventStream struct {
+	stream grpc.ClientStream
+}
+
+// OpenEventStream starts the events stream on the connection, it
+// ends with ctx. dcmasters without the stream fail the first Recv
+// with codes.Unimplemented, others first send EventReady.
+func OpenEventStream(ctx context.Context, conn *grpc.ClientConn) (*EventStream, error) {
+	stream, err := conn.NewStream(ctx, eventsStreamDesc, eventsStreamMethod)
+	if err != nil {
+		return nil, err
+	}
+	return &EventStream{stream: stream}, nil
+}
+
+// Send an event to dcmaster, Send must not be called concurrently
+func (e *EventStream) Send(ev Event) error {
+	msg, err := structpb.NewStruct(map[string]interface{}{
+		"type":     ev.Type,
+		"proxy_id": ev.ProxyId,
+		"job_id":   ev.JobId,
+		"user_id":  ev.UserId,
+		"target":   ev.Target,
+	})
+	if err != nil {
+		return err
+	}
+	return e.stream.SendMsg(msg)
+}
+
+// Recv blocks until dcmaster sends an event
+func (e *EventStream) Recv() (Event, error) {
+	msg := &structpb.Struct{}
+	if err := e.stream.RecvMsg(msg); err != nil {
+		return Event{}, err
+	}
+	f := msg.GetFields()
+	return Event{
+		Type:    f["type"].GetStringValue(),
+		ProxyId: f["proxy_id"].GetStringValue(),
+		JobId:   int64(f["job_id"].GetNumberValue()),
+		UserId:  int64(f["user_id"].GetNumberValue()),
+		Target:  f["target"].GetStringValue(),
+	}, nil
+}
+
+// Close ends the sending side of the stream
+func (e *EventStream) Close() error {
+	return e.stream.CloseSend()
+}
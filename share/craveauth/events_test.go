@@ -0,0 +1,54 @@
+package craveauth
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func TestEventStream(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	//dcmaster acknowledges, then finishes the job of the first proxy
+	srv := grpc.NewServer(grpc.UnknownServiceHandler(func(_ interface{}, stream grpc.ServerStream) error {
+		ready, _ := structpb.NewStruct(map[string]interface{}{"type": EventReady})
+		if err := stream.SendMsg(ready); err != nil {
+			return err
+		}
+		msg := &structpb.Struct{}
+		if err := stream.RecvMsg(msg); err != nil {
+			return err
+		}
+		msg.Fields["type"] = structpb.NewStringValue(EventJobFinished)
+		return stream.SendMsg(msg)
+	}))
+	go srv.Serve(l)
+	defer srv.Stop()
+	conn, err := grpc.Dial(l.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	events, err := OpenEventStream(context.Background(), conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev, err := events.Recv(); err != nil || ev.Type != EventReady {
+		t.Fatalf("expected ready, got %v %v", ev, err)
+	}
+	if err := events.Send(Event{Type: EventProxyAdded, ProxyId: "abc", JobId: 42, UserId: 7, Target: "http://10.0.0.1:8080"}); err != nil {
+		t.Fatal(err)
+	}
+	ev, err := events.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev != (Event{Type: EventJobFinished, ProxyId: "abc", JobId: 42, UserId: 7, Target: "http://10.0.0.1:8080"}) {
+		t.Fatalf("unexpected event %+v", ev)
+	}
+}
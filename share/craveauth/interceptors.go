@@ -0,0 +1,151 @@
+package craveauth
+
+import (
+	"context"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jpillora/chisel/share/cio"
+	"github.com/jpillora/chisel/share/ctrace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// DCMasterRPC configures the interceptors installed
+// on every dcrpc connection
+type DCMasterRPC struct {
+	//Log logs every call with its latency and status
+	Log bool
+	//Retries is how many times idempotent calls are
+	//retried while dcmaster is unavailable
+	Retries int
+	//RetryBackoff is the first wait between retries, it doubles
+	RetryBackoff time.Duration
+	//Idempotent lists the method names (e.g. "Trace")
+	//which are safe to retry
+	Idempotent []string
+}
+
+// RPCMetrics counts dcrpc calls per method
+type RPCMetrics struct {
+	mut     sync.Mutex
+	methods map[string]*MethodStats
+}
+
+// MethodStats are the totals of one dcrpc method
+type MethodStats struct {
+	Calls   int64         `json:"calls"`
+	Errors  int64         `json:"errors"`
+	Retries int64         `json:"retries"`
+	Latency time.Duration `json:"latency"`
+}
+
+// NewRPCMetrics creates empty metrics
+func NewRPCMetrics() *RPCMetrics {
+	return &RPCMetrics{methods: map[string]*MethodStats{}}
+}
+
+func (m *RPCMetrics) record(method string, d time.Duration, retries int, err error) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	s, ok := m.methods[method]
+	if !ok {
+		s = &MethodStats{}
+		m.methods[method] = s
+	}
+	s.Calls++
+	s.Retries += int64(retries)
+	s.Latency += d
+	if err != nil {
+		s.Errors++
+	}
+}
+
+// Snapshot returns a copy of the current totals
+func (m *RPCMetrics) Snapshot() map[string]MethodStats {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	out := make(map[string]MethodStats, len(m.methods))
+	for k, v := range m.methods {
+		out[k] = *v
+	}
+	return out
+}
+
+// DialOptions returns the interceptors, recording into m
+func (c DCMasterRPC) DialOptions(m *RPCMetrics, l *cio.Logger) []grpc.DialOption {
+	l = l.Fork("dcrpc").WithSubsystem("dcrpc")
+	idempotent := map[string]bool{}
+	for _, name := range c.Idempotent {
+		idempotent[name] = true
+	}
+	unary := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) (err error) {
+		ctx, span := traceRPC(correlate(ctx), method, cc)
+		defer func() { span.End(err) }()
+		t0 := time.Now()
+		retries := 0
+		wait := c.RetryBackoff
+		err = invoker(ctx, method, req, reply, cc, opts...)
+		for err != nil && idempotent[path.Base(method)] && retries < c.Retries && status.Code(err) == codes.Unavailable {
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+			}
+			if ctx.Err() != nil {
+				break
+			}
+			retries++
+			wait *= 2
+			err = invoker(ctx, method, req, reply, cc, opts...)
+		}
+		d := time.Since(t0)
+		m.record(method, d, retries, err)
+		span.SetAttr("rpc.retries", retries)
+		if c.Log {
+			l.Infof("%s to %s: %s in %s (%d retries)", method, cc.Target(), status.Code(err), d, retries)
+		}
+		return err
+	}
+	stream := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		//streams are long lived, only their opening is recorded
+		ctx, span := traceRPC(correlate(ctx), method, cc)
+		t0 := time.Now()
+		s, err := streamer(ctx, desc, cc, method, opts...)
+		d := time.Since(t0)
+		span.End(err)
+		m.record(method, d, 0, err)
+		if c.Log {
+			l.Infof("%s stream to %s: %s in %s", method, cc.Target(), status.Code(err), d)
+		}
+		return s, err
+	}
+	return []grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(unary),
+		grpc.WithChainStreamInterceptor(stream),
+	}
+}
+
+// correlate passes the correlation ID of ctx to dcmaster as metadata
+func correlate(ctx context.Context) context.Context {
+	if id := ctrace.CorrelationID(ctx); id != "" {
+		return metadata.AppendToOutgoingContext(ctx, ctrace.CorrelationMetadata, id)
+	}
+	return ctx
+}
+
+// traceRPC starts a client span for the call, passing
+// it to dcmaster as traceparent metadata
+func traceRPC(ctx context.Context, method string, cc *grpc.ClientConn) (context.Context, *ctrace.Span) {
+	ctx, span := ctrace.Start(ctx, "dcrpc "+strings.TrimPrefix(method, "/"), ctrace.KindClient)
+	if span == nil {
+		return ctx, nil
+	}
+	span.SetAttr("rpc.system", "grpc")
+	span.SetAttr("rpc.method", path.Base(method))
+	span.SetAttr("net.peer.name", cc.Target())
+	return metadata.AppendToOutgoingContext(ctx, "traceparent", ctrace.Traceparent(ctrace.SpanContextFrom(ctx))), span
+}
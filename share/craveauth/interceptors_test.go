@@ -0,0 +1,89 @@
+package craveauth
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jpillora/chisel/share/cio"
+	"github.com/jpillora/chisel/share/ctrace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func TestRPCRetries(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	//unavailable twice, then succeeds
+	var calls int32
+	srv := grpc.NewServer(grpc.UnknownServiceHandler(func(_ interface{}, stream grpc.ServerStream) error {
+		if err := stream.RecvMsg(&structpb.Struct{}); err != nil {
+			return err
+		}
+		if atomic.AddInt32(&calls, 1) <= 2 {
+			return status.Error(codes.Unavailable, "busy")
+		}
+		return stream.SendMsg(&structpb.Struct{})
+	}))
+	go srv.Serve(l)
+	defer srv.Stop()
+	m := NewRPCMetrics()
+	c := DCMasterRPC{Retries: 2, RetryBackoff: time.Millisecond, Idempotent: []string{"Trace"}}
+	opts := append(c.DialOptions(m, cio.NewLogger("test")), grpc.WithInsecure())
+	conn, err := grpc.Dial(l.Addr().String(), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := conn.Invoke(context.Background(), "/dcrpc.DcMasterRPC/Trace", &structpb.Struct{}, &structpb.Struct{}); err != nil {
+		t.Fatal(err)
+	}
+	//other methods are not retried
+	atomic.StoreInt32(&calls, 0)
+	err = conn.Invoke(context.Background(), "/dcrpc.DcMasterRPC/Other", &structpb.Struct{}, &structpb.Struct{})
+	if status.Code(err) != codes.Unavailable {
+		t.Fatalf("expected unavailable, got %v", err)
+	}
+	stats := m.Snapshot()["/dcrpc.DcMasterRPC/Trace"]
+	if stats.Calls != 1 || stats.Retries != 2 || stats.Errors != 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestRPCCorrelationID(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make(chan []string, 1)
+	srv := grpc.NewServer(grpc.UnknownServiceHandler(func(_ interface{}, stream grpc.ServerStream) error {
+		md, _ := metadata.FromIncomingContext(stream.Context())
+		ids <- md.Get(ctrace.CorrelationMetadata)
+		if err := stream.RecvMsg(&structpb.Struct{}); err != nil {
+			return err
+		}
+		return stream.SendMsg(&structpb.Struct{})
+	}))
+	go srv.Serve(l)
+	defer srv.Stop()
+	opts := append(DCMasterRPC{}.DialOptions(NewRPCMetrics(), cio.NewLogger("test")), grpc.WithInsecure())
+	conn, err := grpc.Dial(l.Addr().String(), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	ctx := ctrace.WithCorrelationID(context.Background(), "abc")
+	if err := conn.Invoke(ctx, "/dcrpc.DcMasterRPC/Trace", &structpb.Struct{}, &structpb.Struct{}); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-ids; len(got) != 1 || got[0] != "abc" {
+		t.Fatalf("expected the correlation id, got %v", got)
+	}
+}
@@ -0,0 +1,396 @@
+package craveauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jpillora/chisel/dcrpc"
+	"github.com/jpillora/chisel/share/cio"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+)
+
+// DCMasterPool shares dcmaster gRPC connections between borrowers,
+// keyed by resource host. Connections are health checked in the
+// background and transparently redialled when they fail.
+type DCMasterPool struct {
+	*cio.Logger
+	port           string
+	endpoints      []string
+	active         int
+	healthInterval time.Duration
+	dialOpts       []grpc.DialOption
+	mut            sync.Mutex
+	conns          map[string]*pooledConn
+}
+
+type pooledConn struct {
+	ip     string
+	conn   *grpc.ClientConn
+	client dcrpc.DcMasterRPCClient
+	refs   int
+}
+
+// DCMasterLease is a connection borrowed from a DCMasterPool,
+// it must be released once the borrower is done with it.
+type DCMasterLease struct {
+	pool *DCMasterPool
+	ip   string
+	once sync.Once
+}
+
+// NewDCMasterPool creates an empty pool of dcmaster connections,
+// dialOpts are used for every connection (see ConnectDCMasterRPC)
+func NewDCMasterPool(port string, healthInterval time.Duration, l *cio.Logger, dialOpts ...grpc.DialOption) *DCMasterPool {
+	return &DCMasterPool{
+		Logger:         l.Fork("dcmaster").WithSubsystem("dcrpc"),
+		port:           port,
+		healthInterval: healthInterval,
+		dialOpts:       dialOpts,
+		conns:          map[string]*pooledConn{},
+	}
+}
+
+// Acquire borrows the connection to the given host,
+// dialing a new one if none is pooled yet.
+func (p *DCMasterPool) Acquire(ip string) (*DCMasterLease, error) {
+	p.mut.Lock()
+	if pc, ok := p.conns[ip]; ok {
+		pc.refs++
+		p.mut.Unlock()
+		return &DCMasterLease{pool: p, ip: ip}, nil
+	}
+	if p.port == "" && len(p.endpoints) == 0 {
+		p.mut.Unlock()
+		return nil, errors.New("dcmaster port not yet discovered")
+	}
+	failover := len(p.endpoints) > 1
+	p.mut.Unlock()
+	//dialed without the lock, which the other leases need
+	p.Infof("Connecting to resource host %s", ip)
+	client, conn, err := p.dial(ip)
+	if err != nil && failover {
+		if err = p.failover(); err == nil {
+			client, conn, err = p.dial(ip)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	pc, ok := p.conns[ip]
+	if ok {
+		//acquired by another borrower meanwhile
+		conn.Close()
+	} else {
+		pc = &pooledConn{ip: ip, conn: conn, client: client}
+		p.conns[ip] = pc
+	}
+	pc.refs++
+	return &DCMasterLease{pool: p, ip: ip}, nil
+}
+
+// Port returns the dcmaster port, empty until discovered
+func (p *DCMasterPool) Port() string {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	return p.port
+}
+
+// SetEndpoints replaces per resource host connections with
+// connections to the given dcmaster "host:port" endpoints, the
+// first is active until it fails, then the next healthy one is used.
+// Connections move only if the active endpoint is no longer listed.
+func (p *DCMasterPool) SetEndpoints(endpoints []string) {
+	p.mut.Lock()
+	active := ""
+	if len(p.endpoints) > 0 {
+		active = p.endpoints[p.active]
+	}
+	p.endpoints = endpoints
+	p.active = 0
+	for i, e := range endpoints {
+		if e == active {
+			p.active = i
+			p.mut.Unlock()
+			return
+		}
+	}
+	if len(endpoints) > 0 {
+		p.Infof("Active dcmaster endpoint is %s", endpoints[0])
+	}
+	p.mut.Unlock()
+	p.redialAll()
+}
+
+// Endpoint returns the active dcmaster endpoint,
+// empty unless endpoints are set
+func (p *DCMasterPool) Endpoint() string {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	if len(p.endpoints) == 0 {
+		return ""
+	}
+	return p.endpoints[p.active]
+}
+
+// Available reports whether there is somewhere to connect to
+func (p *DCMasterPool) Available() bool {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	return p.port != "" || len(p.endpoints) > 0
+}
+
+// Healthy fails when dcmaster cannot be reached, either since its
+// port is unknown or since a pooled connection is failing
+func (p *DCMasterPool) Healthy() error {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	if p.port == "" && len(p.endpoints) == 0 {
+		return errors.New("dcmaster port unknown")
+	}
+	failing := []string{}
+	for ip, pc := range p.conns {
+		switch pc.conn.GetState() {
+		case connectivity.TransientFailure, connectivity.Shutdown:
+			failing = append(failing, ip)
+		}
+	}
+	if len(failing) > 0 {
+		sort.Strings(failing)
+		return fmt.Errorf("connections to %s are failing", strings.Join(failing, ", "))
+	}
+	return nil
+}
+
+// SetPort changes the dcmaster port, pooled connections
+// are redialled so existing leases move to the new port
+func (p *DCMasterPool) SetPort(port string) {
+	p.mut.Lock()
+	if p.port == port {
+		p.mut.Unlock()
+		return
+	}
+	p.port = port
+	endpoints := len(p.endpoints) > 0
+	p.mut.Unlock()
+	if endpoints {
+		return
+	}
+	p.redialAll()
+}
+
+// dial connects to dcmaster for the resource host, at the active
+// endpoint if any. It blocks, so the lock must not be held.
+func (p *DCMasterPool) dial(ip string) (dcrpc.DcMasterRPCClient, *grpc.ClientConn, error) {
+	p.mut.Lock()
+	port := p.port
+	if len(p.endpoints) > 0 {
+		var err error
+		ip, port, err = net.SplitHostPort(p.endpoints[p.active])
+		if err != nil {
+			p.mut.Unlock()
+			return nil, nil, err
+		}
+	}
+	p.mut.Unlock()
+	return ConnectDCMasterRPC(ip, port, p.Logger, p.dialOpts...)
+}
+
+// redial replaces the connection, unless it was released meanwhile
+func (p *DCMasterPool) redial(pc *pooledConn) error {
+	client, conn, err := p.dial(pc.ip)
+	if err != nil {
+		return err
+	}
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	if p.conns[pc.ip] != pc {
+		conn.Close()
+		return nil
+	}
+	pc.conn.Close()
+	pc.conn = conn
+	pc.client = client
+	return nil
+}
+
+// redialAll redials every connection
+func (p *DCMasterPool) redialAll() {
+	for _, pc := range p.pooled() {
+		if err := p.redial(pc); err != nil {
+			p.Infof("Reconnect to %s failed: %s", pc.ip, err)
+		}
+	}
+}
+
+// pooled is a snapshot of the connections
+func (p *DCMasterPool) pooled() []*pooledConn {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	pcs := make([]*pooledConn, 0, len(p.conns))
+	for _, pc := range p.conns {
+		pcs = append(pcs, pc)
+	}
+	return pcs
+}
+
+// failover makes the next reachable endpoint active
+// and moves all connections to it
+func (p *DCMasterPool) failover() error {
+	p.mut.Lock()
+	endpoints, active := p.endpoints, p.active
+	p.mut.Unlock()
+	if len(endpoints) == 0 {
+		return errors.New("no dcmaster endpoints")
+	}
+	failed := endpoints[active]
+	for i := 1; i < len(endpoints); i++ {
+		next := endpoints[(active+i)%len(endpoints)]
+		host, port, err := net.SplitHostPort(next)
+		if err != nil {
+			p.Infof("Invalid dcmaster endpoint %s: %s", next, err)
+			continue
+		}
+		_, conn, err := ConnectDCMasterRPC(host, port, p.Logger, p.dialOpts...)
+		if err != nil {
+			continue
+		}
+		conn.Close()
+		//the endpoints may have been replaced meanwhile
+		p.mut.Lock()
+		for j, e := range p.endpoints {
+			if e == next {
+				p.active = j
+			}
+		}
+		p.mut.Unlock()
+		p.Infof("dcmaster endpoint %s failed, active endpoint is now %s", failed, next)
+		p.redialAll()
+		return nil
+	}
+	return fmt.Errorf("no healthy dcmaster endpoint (active %s)", failed)
+}
+
+// Len returns the number of pooled connections
+func (p *DCMasterPool) Len() int {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	return len(p.conns)
+}
+
+// Run health checks the pooled connections until
+// the context is cancelled, then closes them all.
+func (p *DCMasterPool) Run(ctx context.Context) {
+	if p.healthInterval <= 0 {
+		<-ctx.Done()
+		p.Close()
+		return
+	}
+	t := time.NewTicker(p.healthInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			p.Close()
+			return
+		case <-t.C:
+			p.checkHealth()
+		}
+	}
+}
+
+// checkHealth redials any connection which has failed,
+// failing over to the next endpoint if the redial fails
+func (p *DCMasterPool) checkHealth() {
+	p.mut.Lock()
+	failover := len(p.endpoints) > 1
+	p.mut.Unlock()
+	for _, pc := range p.pooled() {
+		p.mut.Lock()
+		conn := pc.conn
+		p.mut.Unlock()
+		switch state := conn.GetState(); state {
+		case connectivity.TransientFailure, connectivity.Shutdown:
+			p.Infof("Connection to %s is %s, reconnecting", pc.ip, state)
+			err := p.redial(pc)
+			if err != nil && failover {
+				//failover moves every connection, so stop here
+				if err = p.failover(); err == nil {
+					return
+				}
+			}
+			if err != nil {
+				p.Infof("Reconnect to %s failed: %s", pc.ip, err)
+			}
+		case connectivity.Idle:
+			//wake idle connections so failures surface before the next request
+			conn.Connect()
+		}
+	}
+}
+
+// Close closes all pooled connections
+func (p *DCMasterPool) Close() error {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	for ip, pc := range p.conns {
+		pc.conn.Close()
+		delete(p.conns, ip)
+	}
+	return nil
+}
+
+// Client returns the current client for the leased host. The
+// underlying connection may change between calls after a reconnect.
+func (l *DCMasterLease) Client() (dcrpc.DcMasterRPCClient, error) {
+	l.pool.mut.Lock()
+	defer l.pool.mut.Unlock()
+	pc, ok := l.pool.conns[l.ip]
+	if !ok {
+		return nil, fmt.Errorf("dcmaster connection to %s closed", l.ip)
+	}
+	return pc.client, nil
+}
+
+// Conn returns the current connection to the leased host,
+// it is closed and replaced on reconnect
+func (l *DCMasterLease) Conn() (*grpc.ClientConn, error) {
+	l.pool.mut.Lock()
+	defer l.pool.mut.Unlock()
+	pc, ok := l.pool.conns[l.ip]
+	if !ok {
+		return nil, fmt.Errorf("dcmaster connection to %s closed", l.ip)
+	}
+	return pc.conn, nil
+}
+
+// IP returns the leased resource host
+func (l *DCMasterLease) IP() string {
+	return l.ip
+}
+
+// Release returns the lease to the pool, closing the
+// underlying connection once it has no more borrowers.
+func (l *DCMasterLease) Release() {
+	l.once.Do(func() {
+		l.pool.mut.Lock()
+		defer l.pool.mut.Unlock()
+		pc, ok := l.pool.conns[l.ip]
+		if !ok {
+			return
+		}
+		pc.refs--
+		if pc.refs <= 0 {
+			pc.conn.Close()
+			delete(l.pool.conns, l.ip)
+		}
+	})
+}
@@ -0,0 +1,151 @@
+package craveauth
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jpillora/chisel/share/cio"
+	"google.golang.org/grpc"
+)
+
+// gatedListener accepts no connections until its gate is
+// closed, so dials to it block as to an unresponsive dcmaster
+type gatedListener struct {
+	net.Listener
+	gate chan struct{}
+}
+
+func (l gatedListener) Accept() (net.Conn, error) {
+	<-l.gate
+	return l.Listener.Accept()
+}
+
+// serveDCMaster serves an empty grpc server, behind the gate if any
+func serveDCMaster(t *testing.T, gate chan struct{}) (port string, stop func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer()
+	if gate != nil {
+		go srv.Serve(gatedListener{Listener: l, gate: gate})
+	} else {
+		go srv.Serve(l)
+	}
+	_, port, _ = net.SplitHostPort(l.Addr().String())
+	return port, srv.Stop
+}
+
+func TestPoolDialsWithoutLock(t *testing.T) {
+	live, stop := serveDCMaster(t, nil)
+	defer stop()
+	gate := make(chan struct{})
+	slow, stopSlow := serveDCMaster(t, gate)
+	defer stopSlow()
+	p := NewDCMasterPool(live, 0, cio.NewLogger("test"))
+	defer p.Close()
+	lease, err := p.Acquire("127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lease.Release()
+	//moving to an unresponsive dcmaster doesn't stall the leases
+	moved := make(chan struct{})
+	go func() {
+		p.SetPort(slow)
+		close(moved)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	got := make(chan error, 1)
+	go func() {
+		_, err := lease.Client()
+		got <- err
+	}()
+	select {
+	case err := <-got:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the lease not to wait for the dial")
+	}
+	close(gate)
+	<-moved
+	if conn, _ := lease.Conn(); !strings.HasSuffix(conn.Target(), ":"+slow) {
+		t.Fatalf("expected the lease to move to port %s, got %s", slow, conn.Target())
+	}
+}
+
+func TestPoolAcquire(t *testing.T) {
+	port, stop := serveDCMaster(t, nil)
+	defer stop()
+	p := NewDCMasterPool(port, 0, cio.NewLogger("test"))
+	defer p.Close()
+	//borrowers of the same host share one connection
+	var wg sync.WaitGroup
+	leases := make([]*DCMasterLease, 4)
+	for i := range leases {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			lease, err := p.Acquire("127.0.0.1")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			leases[i] = lease
+		}(i)
+	}
+	wg.Wait()
+	if p.Len() != 1 {
+		t.Fatalf("expected 1 pooled connection, got %d", p.Len())
+	}
+	//a closed connection is redialled by the health check
+	conn, _ := leases[0].Conn()
+	conn.Close()
+	p.checkHealth()
+	if c, _ := leases[0].Conn(); c == conn {
+		t.Fatal("expected the connection to be redialled")
+	}
+	for _, lease := range leases {
+		lease.Release()
+	}
+	if p.Len() != 0 {
+		t.Fatalf("expected no pooled connections, got %d", p.Len())
+	}
+	if _, err := leases[0].Client(); err == nil {
+		t.Fatal("expected released leases to have no client")
+	}
+}
+
+func TestPoolFailover(t *testing.T) {
+	dead, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dead.Close()
+	live, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer()
+	go srv.Serve(live)
+	defer srv.Stop()
+	p := NewDCMasterPool("", 0, cio.NewLogger("test"))
+	defer p.Close()
+	if p.Available() {
+		t.Fatal("expected pool without port or endpoints to be unavailable")
+	}
+	p.SetEndpoints([]string{dead.Addr().String(), live.Addr().String()})
+	lease, err := p.Acquire("10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lease.Release()
+	if got := p.Endpoint(); got != live.Addr().String() {
+		t.Fatalf("expected active endpoint %s, got %s", live.Addr(), got)
+	}
+}
@@ -0,0 +1,57 @@
+package craveauth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// DCMasterTLS secures dcrpc connections, they
+// are plaintext unless Enabled, CA or Cert is set
+type DCMasterTLS struct {
+	Enabled bool
+	//CA is a path to the PEM bundle which verifies
+	//dcmaster, defaults to the system roots
+	CA string
+	//Cert and Key are paths to the client certificate (mTLS)
+	Cert string
+	Key  string
+	//ServerName overrides the name verified in
+	//dcmaster's certificate, which defaults to its ip
+	ServerName string
+}
+
+// DialOptions returns the grpc transport credentials
+func (t DCMasterTLS) DialOptions() ([]grpc.DialOption, error) {
+	if !t.Enabled && t.CA == "" && t.Cert == "" {
+		return nil, nil
+	}
+	c := &tls.Config{ServerName: t.ServerName}
+	if t.CA != "" {
+		b, err := ioutil.ReadFile(t.CA)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to load file: %s", t.CA)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("Failed to decode PEM: %s", t.CA)
+		}
+		c.RootCAs = pool
+	}
+	if t.Cert != "" || t.Key != "" {
+		if t.Cert == "" || t.Key == "" {
+			return nil, errors.New("Please specify both a dcmaster cert and key")
+		}
+		cert, err := tls.LoadX509KeyPair(t.Cert, t.Key)
+		if err != nil {
+			return nil, fmt.Errorf("Error loading dcmaster client cert and key pair: %v", err)
+		}
+		c.Certificates = []tls.Certificate{cert}
+	}
+	return []grpc.DialOption{grpc.WithTransportCredentials(credentials.NewTLS(c))}, nil
+}
@@ -0,0 +1,133 @@
+package craveauth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// writeTestCert writes a self-signed certificate for 127.0.0.1,
+// usable by both servers and clients, and its key to dir
+func writeTestCert(t *testing.T, dir string) (key, cert string) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "dcmaster"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &priv.PublicKey, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := x509.MarshalECPrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, cert = filepath.Join(dir, "key.pem"), filepath.Join(dir, "cert.pem")
+	if err := ioutil.WriteFile(key, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: b}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return key, cert
+}
+
+func TestDCMasterTLSOptions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chisel-dcmaster-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	key, cert := writeTestCert(t, dir)
+	if opts, err := (DCMasterTLS{}).DialOptions(); err != nil || opts != nil {
+		t.Fatalf("expected plaintext by default, got %v %v", opts, err)
+	}
+	if opts, err := (DCMasterTLS{Enabled: true}).DialOptions(); err != nil || len(opts) != 1 {
+		t.Fatalf("expected transport credentials, got %v %v", opts, err)
+	}
+	for _, c := range []DCMasterTLS{
+		{CA: filepath.Join(dir, "missing.pem")},
+		{CA: key},
+		{Cert: cert},
+		{Cert: cert, Key: cert},
+	} {
+		if _, err := c.DialOptions(); err == nil {
+			t.Fatalf("expected %+v to be rejected", c)
+		}
+	}
+}
+
+func TestDCMasterMutualTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chisel-dcmaster-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	key, cert := writeTestCert(t, dir)
+	pair, err := tls.LoadX509KeyPair(cert, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadFile(cert)
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(b)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer(grpc.Creds(credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{pair},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	})))
+	go srv.Serve(l)
+	defer srv.Stop()
+	dial := func(c DCMasterTLS) error {
+		opts, err := c.DialOptions()
+		if err != nil {
+			return err
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		opts = append(opts, grpc.WithBlock(), grpc.WithReturnConnectionError())
+		conn, err := grpc.DialContext(ctx, l.Addr().String(), opts...)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+	if err := dial(DCMasterTLS{CA: cert, Cert: cert, Key: key}); err != nil {
+		t.Fatalf("expected the mtls handshake to succeed: %s", err)
+	}
+	if err := dial(DCMasterTLS{Enabled: true, Cert: cert, Key: key}); err == nil {
+		t.Fatal("expected dcmaster's certificate to be untrusted without the ca")
+	}
+	if err := dial(DCMasterTLS{CA: cert}); err == nil {
+		t.Fatal("expected dcmaster to require a client certificate")
+	}
+}
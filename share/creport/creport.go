@@ -0,0 +1,93 @@
+// Package creport reports panics and unexpected errors, with
+// their context, to an error tracker such as Sentry. Reports
+// are dropped until a Reporter is set.
+package creport
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// Tags are the context of a report, such as the session, user and job
+type Tags map[string]string
+
+// Event is a panic or unexpected error
+type Event struct {
+	Time  time.Time
+	Err   error
+	Panic bool
+	Tags  Tags
+	//Stack are the program counters where the event was reported
+	Stack []uintptr
+}
+
+// Reporter sends events to an error tracker
+type Reporter interface {
+	//Report queues the event, it must not block
+	Report(e Event)
+	//Flush waits up to timeout for queued events to be sent
+	Flush(timeout time.Duration) bool
+}
+
+var (
+	reporterMut sync.RWMutex
+	reporter    Reporter
+)
+
+// SetReporter enables reporting, nil disables it
+func SetReporter(r Reporter) {
+	reporterMut.Lock()
+	reporter = r
+	reporterMut.Unlock()
+}
+
+func currentReporter() Reporter {
+	reporterMut.RLock()
+	defer reporterMut.RUnlock()
+	return reporter
+}
+
+// Report reports an unexpected error
+func Report(err error, tags Tags) {
+	if r := currentReporter(); r != nil && err != nil {
+		r.Report(newEvent(err, false, tags))
+	}
+}
+
+// Panic reports a recovered panic, and waits for it to be sent
+// since the process is likely to exit
+func Panic(p interface{}, tags Tags) {
+	r := currentReporter()
+	if r == nil {
+		return
+	}
+	err, ok := p.(error)
+	if !ok {
+		err = fmt.Errorf("%v", p)
+	}
+	r.Report(newEvent(err, true, tags))
+	r.Flush(2 * time.Second)
+}
+
+// Recover reports a panic and panics again, it must be deferred:
+//
+//	defer creport.Recover(tags)
+func Recover(tags Tags) {
+	if p := recover(); p != nil {
+		Panic(p, tags)
+		panic(p)
+	}
+}
+
+func newEvent(err error, panicked bool, tags Tags) Event {
+	//skip runtime.Callers, newEvent and Report or Panic
+	pcs := make([]uintptr, 64)
+	n := runtime.Callers(3, pcs)
+	copied := make(Tags, len(tags))
+	for k, v := range tags {
+		copied[k] = v
+	}
+	return Event{Time: time.Now(), Err: err, Panic: panicked, Tags: copied, Stack: pcs[:n]}
+}
@@ -0,0 +1,63 @@
+package creport
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jpillora/chisel/share/cio"
+)
+
+func TestSentry(t *testing.T) {
+	events := make(chan sentryEvent, 2)
+	var auth, path string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth, path = r.Header.Get("X-Sentry-Auth"), r.URL.Path
+		var e sentryEvent
+		json.NewDecoder(r.Body).Decode(&e)
+		events <- e
+	}))
+	defer ts.Close()
+	if _, err := NewSentry(SentryConfig{DSN: ts.URL}, cio.NewLogger("test")); err == nil {
+		t.Fatal("expected a DSN without a key to be invalid")
+	}
+	dsn := strings.Replace(ts.URL, "http://", "http://key@", 1) + "/42"
+	s, err := NewSentry(SentryConfig{DSN: dsn, Release: "1.2.3"}, cio.NewLogger("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetReporter(s)
+	defer SetReporter(nil)
+	Report(errors.New("boom"), Tags{"user": "alice"})
+	if !s.Flush(time.Second) {
+		t.Fatal("expected the event to be sent")
+	}
+	e := <-events
+	if path != "/api/42/store/" || !strings.Contains(auth, "sentry_key=key") {
+		t.Fatalf("unexpected request to %s with %q", path, auth)
+	}
+	ex := e.Exception.Values[0]
+	if e.Level != "error" || e.Release != "1.2.3" || e.Tags["user"] != "alice" || ex.Value != "boom" {
+		t.Fatalf("unexpected event %+v", e)
+	}
+	if top := ex.Stacktrace.Frames[len(ex.Stacktrace.Frames)-1]; top.Function != "TestSentry" || !top.InApp {
+		t.Fatalf("expected the reporting function last, got %+v", top)
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("expected the panic to continue")
+			}
+		}()
+		defer Recover(Tags{"session": "1"})
+		panic("oops")
+	}()
+	e = <-events
+	if e.Level != "fatal" || e.Exception.Values[0].Value != "oops" || e.Tags["session"] != "1" {
+		t.Fatalf("unexpected panic event %+v", e)
+	}
+}
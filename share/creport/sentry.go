@@ -0,0 +1,215 @@
+package creport
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jpillora/chisel/share/cio"
+)
+
+// SentryConfig configures the Sentry reporter
+type SentryConfig struct {
+	//DSN is the project's client key URL,
+	//https://<key>@<host>/<project>
+	DSN string
+	//Release and Environment are sent with every event
+	Release     string
+	Environment string
+}
+
+// sentryQueue is how many events may wait to be sent
+const sentryQueue = 100
+
+// Sentry posts events to the Sentry store API
+type Sentry struct {
+	*cio.Logger
+	config  SentryConfig
+	store   string
+	auth    string
+	client  *http.Client
+	queue   chan Event
+	pending sync.WaitGroup
+}
+
+// NewSentry creates a reporter which sends events in the background
+func NewSentry(c SentryConfig, l *cio.Logger) (*Sentry, error) {
+	u, err := url.Parse(c.DSN)
+	if err != nil || u.User == nil || u.Host == "" {
+		return nil, fmt.Errorf("Invalid Sentry DSN")
+	}
+	project := strings.TrimPrefix(u.Path, "/")
+	i := strings.LastIndex(project, "/")
+	prefix := ""
+	if i >= 0 {
+		prefix, project = "/"+project[:i], project[i+1:]
+	}
+	if project == "" {
+		return nil, fmt.Errorf("Invalid Sentry DSN, missing project")
+	}
+	s := &Sentry{
+		Logger: l.Fork("sentry"),
+		config: c,
+		store:  fmt.Sprintf("%s://%s%s/api/%s/store/", u.Scheme, u.Host, prefix, project),
+		auth:   fmt.Sprintf("Sentry sentry_version=7, sentry_client=chisel/%s, sentry_key=%s", c.Release, u.User.Username()),
+		client: &http.Client{Timeout: 10 * time.Second},
+		queue:  make(chan Event, sentryQueue),
+	}
+	go s.run()
+	return s, nil
+}
+
+// Report queues the event, it is dropped when the queue is full
+func (s *Sentry) Report(e Event) {
+	s.pending.Add(1)
+	select {
+	case s.queue <- e:
+	default:
+		s.pending.Done()
+		s.Debugf("Queue full, dropped %s", e.Err)
+	}
+}
+
+// Flush waits up to timeout for the queue to be sent
+func (s *Sentry) Flush(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		s.pending.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+func (s *Sentry) run() {
+	for e := range s.queue {
+		if err := s.send(e); err != nil {
+			s.Infof("Failed to report %s: %s", e.Err, err)
+		}
+		s.pending.Done()
+	}
+}
+
+func (s *Sentry) send(e Event) error {
+	body, err := json.Marshal(s.event(e))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", s.store, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Sentry-Auth", s.auth)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("status %s", resp.Status)
+	}
+	return nil
+}
+
+// sentryEvent is the body of a store request
+type sentryEvent struct {
+	EventID     string            `json:"event_id"`
+	Timestamp   string            `json:"timestamp"`
+	Level       string            `json:"level"`
+	Platform    string            `json:"platform"`
+	Logger      string            `json:"logger"`
+	ServerName  string            `json:"server_name,omitempty"`
+	Release     string            `json:"release,omitempty"`
+	Environment string            `json:"environment,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	Exception   struct {
+		Values []sentryException `json:"values"`
+	} `json:"exception"`
+}
+
+type sentryException struct {
+	Type       string `json:"type"`
+	Value      string `json:"value"`
+	Stacktrace struct {
+		Frames []sentryFrame `json:"frames"`
+	} `json:"stacktrace"`
+}
+
+type sentryFrame struct {
+	Function string `json:"function"`
+	Module   string `json:"module,omitempty"`
+	Filename string `json:"filename"`
+	AbsPath  string `json:"abs_path"`
+	Lineno   int    `json:"lineno"`
+	InApp    bool   `json:"in_app"`
+}
+
+func (s *Sentry) event(e Event) *sentryEvent {
+	id := make([]byte, 16)
+	rand.Read(id)
+	ev := &sentryEvent{
+		EventID:     hex.EncodeToString(id),
+		Timestamp:   e.Time.UTC().Format(time.RFC3339),
+		Level:       "error",
+		Platform:    "go",
+		Logger:      "chisel",
+		Release:     s.config.Release,
+		Environment: s.config.Environment,
+		Tags:        e.Tags,
+	}
+	ev.ServerName, _ = os.Hostname()
+	if e.Panic {
+		ev.Level = "fatal"
+	}
+	ex := sentryException{Type: reflect.TypeOf(e.Err).String(), Value: e.Err.Error()}
+	if e.Panic {
+		ex.Type = "panic"
+	}
+	//sentry lists frames oldest first
+	frames := runtime.CallersFrames(e.Stack)
+	for {
+		f, more := frames.Next()
+		if f.Function != "" {
+			module, function := splitFunction(f.Function)
+			ex.Stacktrace.Frames = append([]sentryFrame{{
+				Function: function,
+				Module:   module,
+				Filename: f.File[strings.LastIndex(f.File, "/")+1:],
+				AbsPath:  f.File,
+				Lineno:   f.Line,
+				InApp:    strings.HasPrefix(module, "github.com/jpillora/chisel"),
+			}}, ex.Stacktrace.Frames...)
+		}
+		if !more {
+			break
+		}
+	}
+	ev.Exception.Values = []sentryException{ex}
+	return ev
+}
+
+// splitFunction splits a qualified function name
+// into its package path and function
+func splitFunction(name string) (string, string) {
+	slash := strings.LastIndex(name, "/")
+	dot := strings.Index(name[slash+1:], ".")
+	if dot < 0 {
+		return "", name
+	}
+	return name[:slash+1+dot], name[slash+2+dot:]
+}
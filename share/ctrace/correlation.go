@@ -0,0 +1,50 @@
+package ctrace
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// CorrelationHeader carries the correlation ID of a request,
+// and CorrelationMetadata of a dcrpc call
+const (
+	CorrelationHeader   = "X-Correlation-Id"
+	CorrelationMetadata = "x-correlation-id"
+)
+
+type correlationKey struct{}
+
+// NewCorrelationID returns a random ID
+func NewCorrelationID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// ValidCorrelationID accepts IDs from clients, up
+// to 64 letters, digits, dashes and underscores
+func ValidCorrelationID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// WithCorrelationID returns a context carrying id
+func WithCorrelationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationKey{}, id)
+}
+
+// CorrelationID returns the ID carried by ctx, or ""
+func CorrelationID(ctx context.Context) string {
+	id, _ := ctx.Value(correlationKey{}).(string)
+	return id
+}
@@ -0,0 +1,169 @@
+// Package ctrace records OpenTelemetry spans and exports them
+// to an OTLP/HTTP collector. Spans are only recorded once an
+// Exporter is set, until then Start returns a nil *Span, whose
+// methods do nothing.
+package ctrace
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Kind is the OTLP span kind
+type Kind int
+
+const (
+	KindInternal Kind = 1
+	KindServer   Kind = 2
+	KindClient   Kind = 3
+)
+
+// SpanContext identifies a span within a trace
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+}
+
+// Valid is false for the zero SpanContext
+func (sc SpanContext) Valid() bool {
+	return sc.TraceID != [16]byte{}
+}
+
+// Span is a timed operation
+type Span struct {
+	ctx    SpanContext
+	parent [8]byte
+	name   string
+	kind   Kind
+	start  time.Time
+	mut    sync.Mutex
+	end    time.Time
+	attrs  map[string]interface{}
+	err    string
+	ended  bool
+}
+
+// Exporter receives ended spans
+type Exporter interface {
+	Export(s *Span)
+}
+
+var (
+	exporterMut sync.RWMutex
+	exporter    Exporter
+)
+
+// SetExporter enables tracing, nil disables it
+func SetExporter(e Exporter) {
+	exporterMut.Lock()
+	exporter = e
+	exporterMut.Unlock()
+}
+
+func currentExporter() Exporter {
+	exporterMut.RLock()
+	defer exporterMut.RUnlock()
+	return exporter
+}
+
+type spanKey struct{}
+type remoteKey struct{}
+
+// Start begins a span, the child of the span (or remote
+// parent) in ctx, returning a context holding the new span
+func Start(ctx context.Context, name string, kind Kind) (context.Context, *Span) {
+	if currentExporter() == nil {
+		return ctx, nil
+	}
+	s := &Span{name: name, kind: kind, start: time.Now()}
+	if parent := SpanContextFrom(ctx); parent.Valid() {
+		s.ctx.TraceID = parent.TraceID
+		s.parent = parent.SpanID
+	} else {
+		rand.Read(s.ctx.TraceID[:])
+	}
+	rand.Read(s.ctx.SpanID[:])
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// SpanContextFrom returns the span in ctx, or the
+// remote parent set with WithRemote
+func SpanContextFrom(ctx context.Context) SpanContext {
+	if s, ok := ctx.Value(spanKey{}).(*Span); ok {
+		return s.ctx
+	}
+	if sc, ok := ctx.Value(remoteKey{}).(SpanContext); ok {
+		return sc
+	}
+	return SpanContext{}
+}
+
+// WithRemote sets the parent of spans started
+// from ctx, as received from another process
+func WithRemote(ctx context.Context, sc SpanContext) context.Context {
+	if !sc.Valid() {
+		return ctx
+	}
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+// SetAttr records an attribute, value is a string, bool, integer or float
+func (s *Span) SetAttr(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mut.Lock()
+	if s.attrs == nil {
+		s.attrs = map[string]interface{}{}
+	}
+	s.attrs[key] = value
+	s.mut.Unlock()
+}
+
+// End finishes the span, marking it failed when err is not nil
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+	s.mut.Lock()
+	if s.ended {
+		s.mut.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = time.Now()
+	if err != nil {
+		s.err = err.Error()
+	}
+	s.mut.Unlock()
+	if e := currentExporter(); e != nil {
+		e.Export(s)
+	}
+}
+
+// Traceparent formats sc as a W3C traceparent header
+func Traceparent(sc SpanContext) string {
+	return fmt.Sprintf("00-%s-%s-01", hex.EncodeToString(sc.TraceID[:]), hex.EncodeToString(sc.SpanID[:]))
+}
+
+// ParseTraceparent reads a W3C traceparent header,
+// returning the zero SpanContext when it is invalid
+func ParseTraceparent(h string) SpanContext {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(h), "-")
+	if len(parts) < 4 || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return sc
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return SpanContext{}
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return SpanContext{}
+	}
+	return sc
+}
@@ -0,0 +1,108 @@
+package ctrace
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jpillora/chisel/share/cio"
+)
+
+func TestTraceparent(t *testing.T) {
+	sc := SpanContext{TraceID: [16]byte{1, 2, 3}, SpanID: [8]byte{4, 5, 6}}
+	h := Traceparent(sc)
+	if h != "00-01020300000000000000000000000000-0405060000000000-01" {
+		t.Fatalf("unexpected traceparent %s", h)
+	}
+	if got := ParseTraceparent(h); got != sc {
+		t.Fatalf("expected %v, got %v", sc, got)
+	}
+	for _, h := range []string{"", "00-xyz-0405060000000000-01", "garbage"} {
+		if ParseTraceparent(h).Valid() {
+			t.Fatalf("%q: expected invalid", h)
+		}
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	ctx, span := Start(context.Background(), "noop", KindInternal)
+	if span != nil || SpanContextFrom(ctx).Valid() {
+		t.Fatal("expected no span without an exporter")
+	}
+	span.SetAttr("k", "v")
+	span.End(nil)
+}
+
+func TestOTLP(t *testing.T) {
+	received := make(chan map[string]interface{}, 1)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Header.Get("x-api-key") != "k" {
+			http.NotFound(w, r)
+			return
+		}
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		received <- body
+	}))
+	defer collector.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	SetExporter(NewOTLP(ctx, OTLPConfig{
+		Endpoint: collector.URL,
+		Headers:  ParseHeaders("x-api-key=k"),
+		Interval: time.Hour,
+	}, cio.NewLogger("test")))
+	defer SetExporter(nil)
+	remote := SpanContext{TraceID: [16]byte{9}, SpanID: [8]byte{8}}
+	ctx2, parent := Start(WithRemote(context.Background(), remote), "parent", KindServer)
+	_, child := Start(ctx2, "child", KindClient)
+	child.SetAttr("retries", 2)
+	child.End(errors.New("boom"))
+	parent.End(nil)
+	if SpanContextFrom(ctx2).TraceID != remote.TraceID {
+		t.Fatal("expected the remote trace to continue")
+	}
+	//cancelling flushes the pending spans
+	cancel()
+	var body map[string]interface{}
+	select {
+	case body = <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected an export")
+	}
+	spans := body["resourceSpans"].([]interface{})[0].(map[string]interface{})["scopeSpans"].([]interface{})[0].(map[string]interface{})["spans"].([]interface{})
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+	c := spans[0].(map[string]interface{})
+	p := spans[1].(map[string]interface{})
+	if c["name"] != "child" || c["parentSpanId"] != p["spanId"] || c["traceId"] != "09000000000000000000000000000000" {
+		t.Fatalf("unexpected child %v", c)
+	}
+	if c["status"].(map[string]interface{})["message"] != "boom" {
+		t.Fatalf("expected the error status, got %v", c["status"])
+	}
+	if p["parentSpanId"] != "0800000000000000" {
+		t.Fatalf("expected the remote parent, got %v", p["parentSpanId"])
+	}
+}
+
+func TestCorrelationID(t *testing.T) {
+	id := NewCorrelationID()
+	if !ValidCorrelationID(id) || len(id) != 16 {
+		t.Fatalf("invalid id %q", id)
+	}
+	for _, id := range []string{"", "a b", strings.Repeat("a", 65), "x\n"} {
+		if ValidCorrelationID(id) {
+			t.Fatalf("expected %q to be invalid", id)
+		}
+	}
+	ctx := WithCorrelationID(context.Background(), id)
+	if CorrelationID(ctx) != id || CorrelationID(context.Background()) != "" {
+		t.Fatal("expected the id from the context")
+	}
+}
@@ -0,0 +1,226 @@
+package ctrace
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jpillora/chisel/share/cio"
+)
+
+// OTLPConfig configures the OTLP/HTTP exporter
+type OTLPConfig struct {
+	//Endpoint is the collector's base URL, spans are
+	//posted to <Endpoint>/v1/traces
+	Endpoint string
+	//Headers are added to every export, such as an API key
+	Headers map[string]string
+	//Service is the service.name resource attribute
+	Service string
+	//BatchSize is the most spans sent per request
+	BatchSize int
+	//Interval is how often spans are sent
+	Interval time.Duration
+}
+
+// ParseHeaders reads the key1=value1,key2=value2
+// form of OTEL_EXPORTER_OTLP_HEADERS
+func ParseHeaders(s string) map[string]string {
+	h := map[string]string{}
+	for _, kv := range strings.Split(s, ",") {
+		pair := strings.SplitN(kv, "=", 2)
+		if len(pair) == 2 && strings.TrimSpace(pair[0]) != "" {
+			h[strings.TrimSpace(pair[0])] = strings.TrimSpace(pair[1])
+		}
+	}
+	return h
+}
+
+// OTLP batches spans and posts them as OTLP/JSON
+type OTLP struct {
+	*cio.Logger
+	config  OTLPConfig
+	client  *http.Client
+	mut     sync.Mutex
+	pending []*Span
+	flush   chan struct{}
+}
+
+// NewOTLP creates an exporter which sends until ctx is cancelled,
+// flushing the pending spans before it returns
+func NewOTLP(ctx context.Context, c OTLPConfig, l *cio.Logger) *OTLP {
+	if c.BatchSize <= 0 {
+		c.BatchSize = 512
+	}
+	if c.Interval <= 0 {
+		c.Interval = 5 * time.Second
+	}
+	if c.Service == "" {
+		c.Service = "chisel"
+	}
+	c.Endpoint = strings.TrimSuffix(c.Endpoint, "/")
+	o := &OTLP{
+		Logger: l.Fork("otlp"),
+		config: c,
+		client: &http.Client{Timeout: 10 * time.Second},
+		flush:  make(chan struct{}, 1),
+	}
+	go o.run(ctx)
+	return o
+}
+
+// Export queues an ended span
+func (o *OTLP) Export(s *Span) {
+	o.mut.Lock()
+	o.pending = append(o.pending, s)
+	full := len(o.pending) >= o.config.BatchSize
+	o.mut.Unlock()
+	if full {
+		select {
+		case o.flush <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (o *OTLP) run(ctx context.Context) {
+	ticker := time.NewTicker(o.config.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			o.send()
+			return
+		case <-ticker.C:
+		case <-o.flush:
+		}
+		o.send()
+	}
+}
+
+func (o *OTLP) send() {
+	o.mut.Lock()
+	spans := o.pending
+	o.pending = nil
+	o.mut.Unlock()
+	for len(spans) > 0 {
+		n := len(spans)
+		if n > o.config.BatchSize {
+			n = o.config.BatchSize
+		}
+		if err := o.post(spans[:n]); err != nil {
+			o.Debugf("Failed to export %d spans: %s", n, err)
+		}
+		spans = spans[n:]
+	}
+}
+
+func (o *OTLP) post(spans []*Span) error {
+	b, err := json.Marshal(o.encode(spans))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", o.config.Endpoint+"/v1/traces", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range o.config.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("collector returned %s", resp.Status)
+	}
+	return nil
+}
+
+type otlpValue map[string]interface{}
+
+type otlpAttr struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID      string     `json:"traceId"`
+	SpanID       string     `json:"spanId"`
+	ParentSpanID string     `json:"parentSpanId,omitempty"`
+	Name         string     `json:"name"`
+	Kind         Kind       `json:"kind"`
+	Start        string     `json:"startTimeUnixNano"`
+	End          string     `json:"endTimeUnixNano"`
+	Attributes   []otlpAttr `json:"attributes,omitempty"`
+	Status       otlpStatus `json:"status"`
+}
+
+func attr(key string, v interface{}) otlpAttr {
+	switch v := v.(type) {
+	case string:
+		return otlpAttr{key, otlpValue{"stringValue": v}}
+	case bool:
+		return otlpAttr{key, otlpValue{"boolValue": v}}
+	case int:
+		return otlpAttr{key, otlpValue{"intValue": strconv.Itoa(v)}}
+	case int64:
+		return otlpAttr{key, otlpValue{"intValue": strconv.FormatInt(v, 10)}}
+	case float64:
+		return otlpAttr{key, otlpValue{"doubleValue": v}}
+	default:
+		return otlpAttr{key, otlpValue{"stringValue": fmt.Sprint(v)}}
+	}
+}
+
+// encode builds an ExportTraceServiceRequest
+func (o *OTLP) encode(spans []*Span) interface{} {
+	out := make([]otlpSpan, len(spans))
+	for i, s := range spans {
+		s.mut.Lock()
+		sp := otlpSpan{
+			TraceID: hex.EncodeToString(s.ctx.TraceID[:]),
+			SpanID:  hex.EncodeToString(s.ctx.SpanID[:]),
+			Name:    s.name,
+			Kind:    s.kind,
+			Start:   strconv.FormatInt(s.start.UnixNano(), 10),
+			End:     strconv.FormatInt(s.end.UnixNano(), 10),
+		}
+		if s.parent != [8]byte{} {
+			sp.ParentSpanID = hex.EncodeToString(s.parent[:])
+		}
+		for k, v := range s.attrs {
+			sp.Attributes = append(sp.Attributes, attr(k, v))
+		}
+		if s.err != "" {
+			sp.Status = otlpStatus{Code: 2, Message: s.err}
+		}
+		s.mut.Unlock()
+		out[i] = sp
+	}
+	return map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{
+				"attributes": []otlpAttr{attr("service.name", o.config.Service)},
+			},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]interface{}{"name": "github.com/jpillora/chisel"},
+				"spans": out,
+			}},
+		}},
+	}
+}
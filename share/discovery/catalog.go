@@ -0,0 +1,59 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Catalog finds dcmaster endpoints in a service catalog
+type Catalog interface {
+	// Watch returns the healthy "host:port" endpoints of dcmaster,
+	// blocking while they are the same as last. A nil last returns
+	// straight away.
+	Watch(ctx context.Context, last []string) ([]string, error)
+	String() string
+}
+
+// NewCatalog creates the Catalog described by spec, which is one of:
+//
+//	consul-service://<host>/<service>  healthy instances of a consul service
+//	etcd-service://<host>/<prefix>     "host:port" values under an etcd prefix
+//	kubernetes://<namespace>/<service> ready addresses of an in-cluster service,
+//	                                   ?port=<name> picks one of many ports, the
+//	                                   namespace defaults to the pod's own
+func NewCatalog(spec string) (Catalog, error) {
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "consul-service":
+		return NewConsulService(u.Host, strings.Trim(u.Path, "/"))
+	case "etcd-service":
+		return NewEtcdService(u.Host, u.Path)
+	case "kubernetes":
+		return NewKubernetes(u.Host, strings.Trim(u.Path, "/"), u.Query().Get("port"))
+	}
+	return nil, fmt.Errorf("Unknown catalog (%s)", spec)
+}
+
+// sameEndpoints compares sorted endpoint lists
+func sameEndpoints(a, b []string) bool {
+	if a == nil || len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func sortedEndpoints(endpoints []string) []string {
+	sort.Strings(endpoints)
+	return endpoints
+}
@@ -0,0 +1,89 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestConsulServiceWatch(t *testing.T) {
+	//the second instance registers once the first query has blocked
+	var queries int32
+	consul := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/health/service/dcmaster" {
+			http.NotFound(w, r)
+			return
+		}
+		n := atomic.AddInt32(&queries, 1)
+		w.Header().Set("X-Consul-Index", fmt.Sprint(n))
+		body := `[{"Node":{"Address":"10.0.0.1"},"Service":{"Port":20000}}`
+		if n > 2 {
+			body += `,{"Node":{"Address":"10.0.0.9"},"Service":{"Address":"10.0.0.2","Port":20000}}`
+		}
+		w.Write([]byte(body + "]"))
+	}))
+	defer consul.Close()
+	c, err := NewCatalog("consul-service://" + strings.TrimPrefix(consul.URL, "http://") + "/dcmaster")
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := c.Watch(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(first, []string{"10.0.0.1:20000"}) {
+		t.Fatalf("unexpected endpoints %v", first)
+	}
+	next, err := c.Watch(context.Background(), first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(next, []string{"10.0.0.1:20000", "10.0.0.2:20000"}) {
+		t.Fatalf("unexpected endpoints %v", next)
+	}
+	for _, spec := range []string{"consul-service://", "etcd-service://etcd:2379", "zookeeper://zk/dcmaster"} {
+		if _, err := NewCatalog(spec); err == nil {
+			t.Fatalf("%s: expected error", spec)
+		}
+	}
+}
+
+func TestKubernetesWatch(t *testing.T) {
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		subsets := `"subsets":[{"addresses":[{"ip":"10.1.0.1"}],"ports":[{"name":"http","port":8080},{"name":"rpc","port":20000}]}]`
+		switch {
+		case r.URL.Path == "/api/v1/namespaces/crave/endpoints/dcmaster":
+			w.Write([]byte(`{"metadata":{"resourceVersion":"7"},` + subsets + `}`))
+		case r.URL.Path == "/api/v1/namespaces/crave/endpoints" && r.URL.Query().Get("resourceVersion") == "7":
+			w.Write([]byte(`{"type":"MODIFIED","object":{` + subsets + `}}` + "\n"))
+			w.Write([]byte(`{"type":"MODIFIED","object":{"subsets":[{"addresses":[{"ip":"10.1.0.1"},{"ip":"10.1.0.2"}],"ports":[{"name":"rpc","port":20000}]}]}}` + "\n"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer api.Close()
+	k := newKubernetes(api.URL, "token", "crave", "dcmaster", "rpc", api.Client())
+	first, err := k.Watch(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(first, []string{"10.1.0.1:20000"}) {
+		t.Fatalf("unexpected endpoints %v", first)
+	}
+	next, err := k.Watch(context.Background(), first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(next, []string{"10.1.0.1:20000", "10.1.0.2:20000"}) {
+		t.Fatalf("unexpected endpoints %v", next)
+	}
+}
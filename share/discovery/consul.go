@@ -0,0 +1,164 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jpillora/chisel/share/settings"
+)
+
+// Consul reads the port from a consul KV entry, the
+// CONSUL_HTTP_TOKEN environment variable (or file) is sent if set
+type Consul struct {
+	addr   string
+	key    string
+	token  string
+	client *http.Client
+}
+
+func NewConsul(addr, key string) (*Consul, error) {
+	if addr == "" || key == "" {
+		return nil, errors.New("consul discovery requires consul://<host:port>/<key>")
+	}
+	token, err := settings.Secret("CONSUL_HTTP_TOKEN")
+	if err != nil {
+		return nil, err
+	}
+	return &Consul{
+		addr:   addr,
+		key:    key,
+		token:  token,
+		client: &http.Client{},
+	}, nil
+}
+
+func (c *Consul) String() string {
+	return "consul://" + c.addr + "/" + c.key
+}
+
+func (c *Consul) DCMasterPort(ctx context.Context) (string, error) {
+	req, err := http.NewRequest("GET", "http://"+c.addr+"/v1/kv/"+c.key+"?raw", nil)
+	if err != nil {
+		return "", err
+	}
+	if c.token != "" {
+		req.Header.Set("X-Consul-Token", c.token)
+	}
+	resp, err := c.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("consul key %s: %s", c.key, resp.Status)
+	}
+	port := strings.TrimSpace(string(b))
+	return port, validPort(port)
+}
+
+// ConsulService watches the healthy instances of a consul
+// service with blocking queries
+type ConsulService struct {
+	addr    string
+	service string
+	token   string
+	client  *http.Client
+}
+
+func NewConsulService(addr, service string) (*ConsulService, error) {
+	if addr == "" || service == "" {
+		return nil, errors.New("consul service discovery requires consul-service://<host:port>/<service>")
+	}
+	token, err := settings.Secret("CONSUL_HTTP_TOKEN")
+	if err != nil {
+		return nil, err
+	}
+	return &ConsulService{
+		addr:    addr,
+		service: service,
+		token:   token,
+		client:  &http.Client{},
+	}, nil
+}
+
+func (c *ConsulService) String() string {
+	return "consul-service://" + c.addr + "/" + c.service
+}
+
+func (c *ConsulService) Watch(ctx context.Context, last []string) ([]string, error) {
+	var index uint64
+	for {
+		endpoints, next, err := c.query(ctx, index)
+		if err != nil {
+			return nil, err
+		}
+		if !sameEndpoints(last, endpoints) {
+			return endpoints, nil
+		}
+		if next > index {
+			index = next
+			continue
+		}
+		//consul reset its index, start over without spinning
+		index = 0
+		select {
+		case <-time.After(time.Second):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (c *ConsulService) query(ctx context.Context, index uint64) ([]string, uint64, error) {
+	u := fmt.Sprintf("http://%s/v1/health/service/%s?passing&index=%d&wait=5m", c.addr, url.PathEscape(c.service), index)
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	if c.token != "" {
+		req.Header.Set("X-Consul-Token", c.token)
+	}
+	resp, err := c.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("consul service %s: %s", c.service, resp.Status)
+	}
+	var entries []struct {
+		Node struct {
+			Address string
+		}
+		Service struct {
+			Address string
+			Port    int
+		}
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, 0, err
+	}
+	endpoints := []string{}
+	for _, e := range entries {
+		host := e.Service.Address
+		if host == "" {
+			host = e.Node.Address
+		}
+		endpoints = append(endpoints, net.JoinHostPort(host, strconv.Itoa(e.Service.Port)))
+	}
+	index, _ = strconv.ParseUint(resp.Header.Get("X-Consul-Index"), 10, 64)
+	return sortedEndpoints(endpoints), index, nil
+}
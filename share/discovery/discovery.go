@@ -0,0 +1,106 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/jpillora/chisel/share/cdb"
+	"github.com/jpillora/chisel/share/cio"
+)
+
+// Discoverer finds the port dcmaster listens on
+type Discoverer interface {
+	DCMasterPort(ctx context.Context) (string, error)
+	String() string
+}
+
+// New creates the Discoverer described by spec, which is one of:
+//
+//	postgres                         the deployment settings table
+//	mysql://<user:pass@host>/<db>    the same table in MySQL
+//	sqlite:///<path>                 the same table in SQLite
+//	etcd://<host>/<prefix>           the <prefix>/DCMASTER_PORT etcd key
+//	env                              the DCMASTER_PORT env var
+//	static:<port>                    a fixed port
+//	consul://<host>/<key>            a consul KV entry
+//
+// An empty spec defaults to postgres
+func New(spec string, db *cdb.DB, l *cio.Logger) (Discoverer, error) {
+	switch {
+	case spec == "" || spec == "postgres":
+		return NewSettings("postgres", NewPostgres(db, l)), nil
+	case strings.HasPrefix(spec, "mysql://") || strings.HasPrefix(spec, "sqlite://"):
+		u, err := url.Parse(spec)
+		if err != nil {
+			return nil, err
+		}
+		store, err := NewSQL(u)
+		if err != nil {
+			return nil, err
+		}
+		return NewSettings(u.Scheme+"://"+u.Host+u.Path, store), nil
+	case strings.HasPrefix(spec, "etcd://"):
+		u, err := url.Parse(spec)
+		if err != nil {
+			return nil, err
+		}
+		store, err := NewEtcd(u.Host, u.Path)
+		if err != nil {
+			return nil, err
+		}
+		return NewSettings(spec, store), nil
+	case spec == "env":
+		return Env("DCMASTER_PORT"), nil
+	case strings.HasPrefix(spec, "static:"):
+		port := strings.TrimPrefix(spec, "static:")
+		if err := validPort(port); err != nil {
+			return nil, err
+		}
+		return Static(port), nil
+	case strings.HasPrefix(spec, "consul://"):
+		u, err := url.Parse(spec)
+		if err != nil {
+			return nil, err
+		}
+		return NewConsul(u.Host, strings.TrimPrefix(u.Path, "/"))
+	}
+	return nil, fmt.Errorf("Unknown discovery (%s)", spec)
+}
+
+func validPort(port string) error {
+	if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+		return fmt.Errorf("Invalid dcmaster port (%s)", port)
+	}
+	return nil
+}
+
+// Static always returns the same port
+type Static string
+
+func (s Static) DCMasterPort(ctx context.Context) (string, error) {
+	return string(s), nil
+}
+
+func (s Static) String() string {
+	return "static:" + string(s)
+}
+
+// Env reads the port from the named environment variable
+type Env string
+
+func (e Env) DCMasterPort(ctx context.Context) (string, error) {
+	port := strings.TrimSpace(os.Getenv(string(e)))
+	if port == "" {
+		return "", errors.New("missing env " + string(e))
+	}
+	return port, validPort(port)
+}
+
+func (e Env) String() string {
+	return "env:" + string(e)
+}
@@ -0,0 +1,129 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	consul := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/kv/crave/dcmaster_port" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("20001\n"))
+	}))
+	defer consul.Close()
+	etcd := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct{ Key []byte }
+		json.NewDecoder(r.Body).Decode(&req)
+		if r.URL.Path != "/v3/kv/range" || string(req.Key) != "crave/DCMASTER_PORT" {
+			w.Write([]byte(`{}`))
+			return
+		}
+		w.Write([]byte(`{"kvs":[{"value":"MjAwMDQ="}]}`))
+	}))
+	defer etcd.Close()
+	os.Setenv("DCMASTER_PORT", "20002")
+	defer os.Unsetenv("DCMASTER_PORT")
+	for spec, port := range map[string]string{
+		"static:20000": "20000",
+		"env":          "20002",
+		"consul://" + strings.TrimPrefix(consul.URL, "http://") + "/crave/dcmaster_port": "20001",
+		"etcd://" + strings.TrimPrefix(etcd.URL, "http://") + "/crave":                   "20004",
+	} {
+		d, err := New(spec, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := d.DCMasterPort(context.Background())
+		if err != nil {
+			t.Fatalf("%s: %s", spec, err)
+		}
+		if got != port {
+			t.Fatalf("%s: expected port %s, got %s", spec, port, got)
+		}
+	}
+	for _, spec := range []string{"static:http", "zookeeper", "consul://", "etcd://", "sqlserver://db"} {
+		if _, err := New(spec, nil, nil); err == nil {
+			t.Fatalf("%s: expected error", spec)
+		}
+	}
+}
+
+type mapStore map[string]string
+
+func (m mapStore) Setting(ctx context.Context, key string) (string, error) {
+	if v, ok := m[key]; ok {
+		return v, nil
+	}
+	return "", fmt.Errorf("%s %w", key, ErrNotSet)
+}
+
+func TestLoadSettings(t *testing.T) {
+	specs := []SettingSpec{
+		{Key: "DCMASTER_PORT", Type: SettingPort},
+		{Key: "POLL", Type: SettingDuration, Default: "30s"},
+		{Key: "NAME", Type: SettingString},
+	}
+	values, err := LoadSettings(context.Background(), mapStore{"DCMASTER_PORT": "20000", "NAME": "crave"}, specs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values["DCMASTER_PORT"] != "20000" || values["POLL"] != "30s" || values["NAME"] != "crave" {
+		t.Fatalf("unexpected settings %v", values)
+	}
+	for _, store := range []mapStore{
+		{"NAME": "crave"},
+		{"DCMASTER_PORT": "http", "NAME": "crave"},
+		{"DCMASTER_PORT": "20000", "POLL": "soon", "NAME": "crave"},
+	} {
+		if _, err := LoadSettings(context.Background(), store, specs); err == nil {
+			t.Fatalf("%v: expected error", store)
+		}
+	}
+}
+
+type batchStore struct {
+	mapStore
+	batches int
+}
+
+func (b *batchStore) Settings(ctx context.Context, keys []string) (map[string]string, error) {
+	b.batches++
+	values := map[string]string{}
+	for _, key := range keys {
+		if v, ok := b.mapStore[key]; ok {
+			values[key] = v
+		}
+	}
+	return values, nil
+}
+
+func TestDeploymentSettings(t *testing.T) {
+	store := &batchStore{mapStore: mapStore{"DCMASTER_PORT": "20000", "CHISEL_DCMASTER_EVENTS": "true"}}
+	s := NewSettings("test", store)
+	if s.Deployment() != nil {
+		t.Fatal("expected no settings before the first lookup")
+	}
+	port, err := s.DCMasterPort(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if port != "20000" || store.batches != 1 {
+		t.Fatalf("expected port 20000 in one batch, got %s in %d", port, store.batches)
+	}
+	if ds := s.Deployment(); ds.DCMasterPort != 20000 || !ds.DCMasterEvents {
+		t.Fatalf("unexpected settings %+v", ds)
+	}
+	delete(store.mapStore, "CHISEL_DCMASTER_EVENTS")
+	if _, err := s.DCMasterPort(context.Background()); err != nil || s.Deployment().DCMasterEvents {
+		t.Fatalf("expected events to default to off, got %v", err)
+	}
+}
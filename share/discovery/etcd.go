@@ -0,0 +1,201 @@
+package discovery
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/jpillora/chisel/share/settings"
+)
+
+// Etcd reads settings from <prefix>/<key> using the etcd v3
+// JSON gateway, the ETCD_TOKEN environment variable (or file)
+// is sent as the authorization token if set
+type Etcd struct {
+	addr   string
+	prefix string
+	token  string
+	client *http.Client
+}
+
+func NewEtcd(addr, prefix string) (*Etcd, error) {
+	if addr == "" {
+		return nil, errors.New("etcd discovery requires etcd://<host:port>/<prefix>")
+	}
+	token, err := settings.Secret("ETCD_TOKEN")
+	if err != nil {
+		return nil, err
+	}
+	return &Etcd{
+		addr:   addr,
+		prefix: strings.Trim(prefix, "/"),
+		token:  token,
+		client: &http.Client{},
+	}, nil
+}
+
+func (e *Etcd) Setting(ctx context.Context, key string) (string, error) {
+	if e.prefix != "" {
+		key = e.prefix + "/" + key
+	}
+	body, _ := json.Marshal(map[string]string{"key": base64.StdEncoding.EncodeToString([]byte(key))})
+	req, err := http.NewRequest("POST", "http://"+e.addr+"/v3/kv/range", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if e.token != "" {
+		req.Header.Set("Authorization", e.token)
+	}
+	resp, err := e.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("etcd key %s: %s", key, resp.Status)
+	}
+	var result struct {
+		Kvs []struct {
+			Value string `json:"value"`
+		} `json:"kvs"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", err
+	}
+	if len(result.Kvs) == 0 {
+		return "", fmt.Errorf("%s %w", key, ErrNotSet)
+	}
+	value, err := base64.StdEncoding.DecodeString(result.Kvs[0].Value)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(value)), nil
+}
+
+// EtcdService watches the "host:port" values of the keys under
+// <prefix>/, one per dcmaster instance
+type EtcdService struct {
+	*Etcd
+}
+
+func NewEtcdService(addr, prefix string) (*EtcdService, error) {
+	if strings.Trim(prefix, "/") == "" {
+		return nil, errors.New("etcd service discovery requires etcd-service://<host:port>/<prefix>")
+	}
+	e, err := NewEtcd(addr, prefix)
+	if err != nil {
+		return nil, err
+	}
+	return &EtcdService{Etcd: e}, nil
+}
+
+func (e *EtcdService) String() string {
+	return "etcd-service://" + e.addr + "/" + e.prefix
+}
+
+func (e *EtcdService) Watch(ctx context.Context, last []string) ([]string, error) {
+	for {
+		endpoints, revision, err := e.endpoints(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if !sameEndpoints(last, endpoints) {
+			return endpoints, nil
+		}
+		if err := e.watch(ctx, revision+1); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// keyRange covers every key under the prefix
+func (e *EtcdService) keyRange() map[string]string {
+	return map[string]string{
+		"key":       base64.StdEncoding.EncodeToString([]byte(e.prefix + "/")),
+		"range_end": base64.StdEncoding.EncodeToString([]byte(e.prefix + "0")),
+	}
+}
+
+func (e *EtcdService) post(ctx context.Context, path string, body interface{}) (*http.Response, error) {
+	b, _ := json.Marshal(body)
+	req, err := http.NewRequest("POST", "http://"+e.addr+path, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if e.token != "" {
+		req.Header.Set("Authorization", e.token)
+	}
+	resp, err := e.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("etcd prefix %s: %s", e.prefix, resp.Status)
+	}
+	return resp, nil
+}
+
+func (e *EtcdService) endpoints(ctx context.Context) ([]string, int64, error) {
+	resp, err := e.post(ctx, "/v3/kv/range", e.keyRange())
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	var result struct {
+		Header struct {
+			Revision int64 `json:"revision,string"`
+		} `json:"header"`
+		Kvs []struct {
+			Value string `json:"value"`
+		} `json:"kvs"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, 0, err
+	}
+	endpoints := []string{}
+	for _, kv := range result.Kvs {
+		value, err := base64.StdEncoding.DecodeString(kv.Value)
+		if err != nil {
+			return nil, 0, err
+		}
+		endpoints = append(endpoints, strings.TrimSpace(string(value)))
+	}
+	return sortedEndpoints(endpoints), result.Header.Revision, nil
+}
+
+// watch blocks until a key under the prefix changes at or after revision
+func (e *EtcdService) watch(ctx context.Context, revision int64) error {
+	create := e.keyRange()
+	req := map[string]interface{}{"create_request": map[string]interface{}{
+		"key":            create["key"],
+		"range_end":      create["range_end"],
+		"start_revision": revision,
+	}}
+	resp, err := e.post(ctx, "/v3/watch", req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var msg struct {
+			Result struct {
+				Events []json.RawMessage `json:"events"`
+			} `json:"result"`
+		}
+		if err := dec.Decode(&msg); err != nil {
+			return err
+		}
+		if len(msg.Result.Events) > 0 {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package discovery
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const serviceAccount = "/var/run/secrets/kubernetes.io/serviceaccount/"
+
+// Kubernetes watches the ready addresses of a service's
+// Endpoints, using the in-cluster service account
+type Kubernetes struct {
+	api       string
+	token     string
+	namespace string
+	service   string
+	port      string
+	client    *http.Client
+}
+
+// NewKubernetes watches the service in namespace, which defaults
+// to the pod's own. port names the service port when it has many.
+func NewKubernetes(namespace, service, port string) (*Kubernetes, error) {
+	if service == "" {
+		return nil, errors.New("kubernetes discovery requires kubernetes://<namespace>/<service>")
+	}
+	host, hostPort := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || hostPort == "" {
+		return nil, errors.New("kubernetes discovery must run in-cluster")
+	}
+	token, err := ioutil.ReadFile(serviceAccount + "token")
+	if err != nil {
+		return nil, err
+	}
+	ca, err := ioutil.ReadFile(serviceAccount + "ca.crt")
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, errors.New("invalid kubernetes ca.crt")
+	}
+	if namespace == "" {
+		ns, err := ioutil.ReadFile(serviceAccount + "namespace")
+		if err != nil {
+			return nil, err
+		}
+		namespace = strings.TrimSpace(string(ns))
+	}
+	client := &http.Client{Transport: &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: &tls.Config{RootCAs: pool},
+	}}
+	return newKubernetes("https://"+net.JoinHostPort(host, hostPort), strings.TrimSpace(string(token)), namespace, service, port, client), nil
+}
+
+func newKubernetes(api, token, namespace, service, port string, client *http.Client) *Kubernetes {
+	return &Kubernetes{
+		api:       api,
+		token:     token,
+		namespace: namespace,
+		service:   service,
+		port:      port,
+		client:    client,
+	}
+}
+
+func (k *Kubernetes) String() string {
+	s := "kubernetes://" + k.namespace + "/" + k.service
+	if k.port != "" {
+		s += "?port=" + k.port
+	}
+	return s
+}
+
+type k8sEndpoints struct {
+	Metadata struct {
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+	Subsets []struct {
+		Addresses []struct {
+			IP string `json:"ip"`
+		} `json:"addresses"`
+		Ports []struct {
+			Name string `json:"name"`
+			Port int    `json:"port"`
+		} `json:"ports"`
+	} `json:"subsets"`
+}
+
+// endpoints returns the ready "ip:port" pairs, on the named port
+func (k *Kubernetes) endpoints(e *k8sEndpoints) []string {
+	endpoints := []string{}
+	for _, s := range e.Subsets {
+		port := 0
+		for _, p := range s.Ports {
+			if k.port == "" || p.Name == k.port {
+				port = p.Port
+				break
+			}
+		}
+		if port == 0 {
+			continue
+		}
+		for _, a := range s.Addresses {
+			endpoints = append(endpoints, net.JoinHostPort(a.IP, strconv.Itoa(port)))
+		}
+	}
+	return sortedEndpoints(endpoints)
+}
+
+func (k *Kubernetes) get(ctx context.Context, path string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", k.api+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+k.token)
+	resp, err := k.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("kubernetes endpoints %s/%s: %s", k.namespace, k.service, resp.Status)
+	}
+	return resp, nil
+}
+
+func (k *Kubernetes) Watch(ctx context.Context, last []string) ([]string, error) {
+	resp, err := k.get(ctx, "/api/v1/namespaces/"+url.PathEscape(k.namespace)+"/endpoints/"+url.PathEscape(k.service))
+	if err != nil {
+		return nil, err
+	}
+	e := &k8sEndpoints{}
+	err = json.NewDecoder(resp.Body).Decode(e)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if endpoints := k.endpoints(e); !sameEndpoints(last, endpoints) {
+		return endpoints, nil
+	}
+	//unchanged, wait for the next change from this version
+	q := url.Values{
+		"watch":           {"1"},
+		"fieldSelector":   {"metadata.name=" + k.service},
+		"resourceVersion": {e.Metadata.ResourceVersion},
+	}
+	resp, err = k.get(ctx, "/api/v1/namespaces/"+url.PathEscape(k.namespace)+"/endpoints?"+q.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var event struct {
+			Type   string       `json:"type"`
+			Object k8sEndpoints `json:"object"`
+		}
+		if err := dec.Decode(&event); err != nil {
+			return nil, err
+		}
+		switch event.Type {
+		case "ADDED", "MODIFIED":
+			if endpoints := k.endpoints(&event.Object); !sameEndpoints(last, endpoints) {
+				return endpoints, nil
+			}
+		case "DELETED":
+			return []string{}, nil
+		case "ERROR":
+			return nil, errors.New("kubernetes watch expired")
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jpillora/chisel/share/cdb"
+	"github.com/jpillora/chisel/share/cio"
+)
+
+// Postgres reads settings from the deployment settings table
+type Postgres struct {
+	*cio.Logger
+	db *cdb.DB
+}
+
+func NewPostgres(db *cdb.DB, l *cio.Logger) *Postgres {
+	return &Postgres{Logger: l, db: db}
+}
+
+func (p *Postgres) Setting(ctx context.Context, key string) (value string, err error) {
+	if p.db == nil {
+		return "", errors.New("database not configured")
+	}
+	err = p.db.Reader().QueryRow(cdb.WithSubsystem(ctx, "discovery"), "SELECT \"Value\" FROM build_deploymentsetting where \"Key\" = $1;", key).Scan(&value)
+	if err == pgx.ErrNoRows {
+		return "", fmt.Errorf("%s %w", key, ErrNotSet)
+	}
+	if err != nil {
+		p.Infof("Query failed: %v", err)
+	}
+	return
+}
+
+func (p *Postgres) Settings(ctx context.Context, keys []string) (map[string]string, error) {
+	if p.db == nil {
+		return nil, errors.New("database not configured")
+	}
+	rows, err := p.db.Reader().Query(cdb.WithSubsystem(ctx, "discovery"), "SELECT \"Key\", \"Value\" FROM build_deploymentsetting where \"Key\" = ANY($1);", keys)
+	if err != nil {
+		p.Infof("Query failed: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+	values := make(map[string]string, len(keys))
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, err
+		}
+		values[key] = value
+	}
+	return values, rows.Err()
+}
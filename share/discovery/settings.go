@@ -0,0 +1,166 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrNotSet is wrapped by stores when a setting is missing
+var ErrNotSet = errors.New("is not set")
+
+// SettingStore holds deployment settings, such as DCMASTER_PORT
+type SettingStore interface {
+	Setting(ctx context.Context, key string) (string, error)
+}
+
+// BatchSettingStore can read many settings in one round trip,
+// keys which are not set are missing from the result
+type BatchSettingStore interface {
+	Settings(ctx context.Context, keys []string) (map[string]string, error)
+}
+
+// SettingType is the type a setting's value must parse as
+type SettingType int
+
+const (
+	SettingString SettingType = iota
+	SettingInt
+	SettingPort
+	SettingDuration
+	SettingBool
+)
+
+// SettingSpec describes one setting to load
+type SettingSpec struct {
+	Key  string
+	Type SettingType
+	//Default is used when the setting is not set,
+	//an empty Default makes the setting required
+	Default string
+}
+
+func (spec SettingSpec) validate(value string) error {
+	var err error
+	switch spec.Type {
+	case SettingInt:
+		_, err = strconv.ParseInt(value, 10, 64)
+	case SettingPort:
+		return validPort(value)
+	case SettingDuration:
+		_, err = time.ParseDuration(value)
+	case SettingBool:
+		_, err = strconv.ParseBool(value)
+	}
+	if err != nil {
+		return fmt.Errorf("Invalid %s (%s)", spec.Key, value)
+	}
+	return nil
+}
+
+// LoadSettings reads every setting in specs from the store, in one
+// query when it is a BatchSettingStore, failing on the first
+// missing required setting or bad value
+func LoadSettings(ctx context.Context, store SettingStore, specs []SettingSpec) (map[string]string, error) {
+	var batch map[string]string
+	if b, ok := store.(BatchSettingStore); ok {
+		keys := make([]string, len(specs))
+		for i, spec := range specs {
+			keys[i] = spec.Key
+		}
+		var err error
+		if batch, err = b.Settings(ctx, keys); err != nil {
+			return nil, err
+		}
+	}
+	values := make(map[string]string, len(specs))
+	for _, spec := range specs {
+		var value string
+		var err error
+		if batch != nil {
+			var ok bool
+			if value, ok = batch[spec.Key]; !ok {
+				err = fmt.Errorf("%s %w", spec.Key, ErrNotSet)
+			}
+		} else {
+			value, err = store.Setting(ctx, spec.Key)
+		}
+		if errors.Is(err, ErrNotSet) && spec.Default != "" {
+			value, err = spec.Default, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := spec.validate(value); err != nil {
+			return nil, err
+		}
+		values[spec.Key] = value
+	}
+	return values, nil
+}
+
+// DeploymentSettings are the deployment settings chisel uses
+type DeploymentSettings struct {
+	DCMasterPort int
+	//DCMasterEvents enables the dcmaster events stream
+	DCMasterEvents bool
+}
+
+var deploymentSettings = []SettingSpec{
+	{Key: "DCMASTER_PORT", Type: SettingPort},
+	{Key: "CHISEL_DCMASTER_EVENTS", Type: SettingBool, Default: "false"},
+}
+
+// LoadDeploymentSettings reads the DeploymentSettings from the store
+func LoadDeploymentSettings(ctx context.Context, store SettingStore) (*DeploymentSettings, error) {
+	values, err := LoadSettings(ctx, store, deploymentSettings)
+	if err != nil {
+		return nil, err
+	}
+	//validated by LoadSettings
+	ds := &DeploymentSettings{}
+	ds.DCMasterPort, _ = strconv.Atoi(values["DCMASTER_PORT"])
+	ds.DCMasterEvents, _ = strconv.ParseBool(values["CHISEL_DCMASTER_EVENTS"])
+	return ds, nil
+}
+
+// Settings discovers the port from the DCMASTER_PORT setting,
+// along with the other DeploymentSettings
+type Settings struct {
+	SettingStore
+	name string
+	mut  sync.Mutex
+	last *DeploymentSettings
+}
+
+func NewSettings(name string, store SettingStore) *Settings {
+	return &Settings{SettingStore: store, name: name}
+}
+
+// DCMasterPort loads all the deployment settings, so that
+// Deployment reflects the latest lookup
+func (s *Settings) DCMasterPort(ctx context.Context) (string, error) {
+	ds, err := LoadDeploymentSettings(ctx, s.SettingStore)
+	if err != nil {
+		return "", err
+	}
+	s.mut.Lock()
+	s.last = ds
+	s.mut.Unlock()
+	return strconv.Itoa(ds.DCMasterPort), nil
+}
+
+// Deployment returns the settings of the last successful
+// DCMasterPort lookup, nil before then
+func (s *Settings) Deployment() *DeploymentSettings {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.last
+}
+
+func (s *Settings) String() string {
+	return s.name
+}
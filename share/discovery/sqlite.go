@@ -0,0 +1,8 @@
+//go:build sqlite
+// +build sqlite
+
+package discovery
+
+//sqlite is left out of the default build as it
+//does not support all release platforms
+import _ "modernc.org/sqlite"
@@ -0,0 +1,38 @@
+//go:build sqlite
+// +build sqlite
+
+package discovery
+
+import (
+	"context"
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSQLite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "discovery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "settings.db")
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`CREATE TABLE build_deploymentsetting ("Key" TEXT, "Value" TEXT);
+		INSERT INTO build_deploymentsetting VALUES ('DCMASTER_PORT', '20003');`); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	d, err := New("sqlite://"+path, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if port, err := d.DCMasterPort(context.Background()); err != nil || port != "20003" {
+		t.Fatalf("expected port 20003, got %s %v", port, err)
+	}
+}
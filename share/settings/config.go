@@ -8,6 +8,31 @@ import (
 type Config struct {
 	Version string
 	Remotes
+	//RetryRemotes is set by clients which retry the reverse
+	//remotes the server can't yet listen on, see ConfigReply
+	RetryRemotes bool `json:",omitempty"`
+	//AcceptPushed is set by clients which bind the
+	//remotes the server pushes, see ConfigReply
+	AcceptPushed bool `json:",omitempty"`
+}
+
+// ConfigReply is the payload of the server's reply to the config
+// of a client with RetryRemotes, when some of its reverse remotes
+// are Pending, rather than refusing the whole session, or when
+// it allocated the Ports of Ephemeral remotes or exposed Public
+// remotes, or pushed remotes to a client with AcceptPushed. It
+// is also the payload of the reply to a "remotes" request.
+type ConfigReply struct {
+	Pending Remotes
+	//Errors are those of the Pending remotes, in order
+	Errors []string
+	//Ports are the allocated ports, by the remote requested
+	Ports map[string]string `json:",omitempty"`
+	//URLs are those of the Public remotes, by remote
+	URLs map[string]string `json:",omitempty"`
+	//Pushed are the remotes the server added to those of the
+	//client, it binds the reverse ones, the client the others
+	Pushed Remotes `json:",omitempty"`
 }
 
 func DecodeConfig(b []byte) (*Config, error) {
@@ -24,3 +49,36 @@ func EncodeConfig(c Config) []byte {
 	b, _ := json.Marshal(c)
 	return b
 }
+
+func DecodeConfigReply(b []byte) (*ConfigReply, error) {
+	r := &ConfigReply{}
+	if err := json.Unmarshal(b, r); err != nil {
+		return nil, fmt.Errorf("Invalid JSON config reply")
+	}
+	return r, nil
+}
+
+func EncodeConfigReply(r ConfigReply) []byte {
+	b, _ := json.Marshal(r)
+	return b
+}
+
+// RemotesUpdate is the payload of the "remotes" request, which
+// unbinds and binds remotes of an established session
+type RemotesUpdate struct {
+	Add    Remotes
+	Remove Remotes
+}
+
+func DecodeRemotesUpdate(b []byte) (*RemotesUpdate, error) {
+	u := &RemotesUpdate{}
+	if err := json.Unmarshal(b, u); err != nil {
+		return nil, fmt.Errorf("Invalid JSON remotes update")
+	}
+	return u, nil
+}
+
+func EncodeRemotesUpdate(u RemotesUpdate) []byte {
+	b, _ := json.Marshal(u)
+	return b
+}
@@ -1,8 +1,11 @@
 package settings
 
 import (
+	"fmt"
+	"io/ioutil"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -26,3 +29,21 @@ func EnvDuration(name string, def time.Duration) time.Duration {
 	}
 	return def
 }
+
+//Secret returns the environment variable name or, when it is
+//unset, the contents of the file named by name_FILE. This keeps
+//secrets mounted by Docker or Kubernetes out of the environment.
+func Secret(name string) (string, error) {
+	if v := os.Getenv(name); v != "" {
+		return v, nil
+	}
+	path := os.Getenv(name + "_FILE")
+	if path == "" {
+		return "", nil
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("Failed to read %s_FILE: %s", name, err)
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
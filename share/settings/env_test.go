@@ -0,0 +1,82 @@
+package settings
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSecret(t *testing.T) {
+	f, err := ioutil.TempFile("", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("s3cret\n")
+	f.Close()
+	os.Setenv("TEST_SECRET_FILE", f.Name())
+	defer os.Unsetenv("TEST_SECRET_FILE")
+	if v, err := Secret("TEST_SECRET"); err != nil || v != "s3cret" {
+		t.Fatalf("expected secret from file, got %q %v", v, err)
+	}
+	os.Setenv("TEST_SECRET", "env")
+	defer os.Unsetenv("TEST_SECRET")
+	if v, _ := Secret("TEST_SECRET"); v != "env" {
+		t.Fatalf("expected env to take precedence, got %q", v)
+	}
+	os.Unsetenv("TEST_SECRET")
+	os.Setenv("TEST_SECRET_FILE", f.Name()+".missing")
+	if _, err := Secret("TEST_SECRET"); err == nil {
+		t.Fatal("expected missing file error")
+	}
+}
+
+func TestLoadEnviron(t *testing.T) {
+	for k, v := range map[string]string{
+		"CHISEL_DB_HOST":       "db:5432",
+		"DB_HOST":              "legacy:5432",
+		"DB_USER":              "chisel",
+		"CHISEL_SSH_WAIT":      "5s",
+		"CHISEL_DCMASTER_PORT": "20000",
+		"WEBHOOK_URL":          "https://hooks.example.com/chisel",
+		"CHISEL_STATSD_ADDR":   "127.0.0.1:8125",
+		"SENTRY_ENVIRONMENT":   "staging",
+		//only the database and dcmaster names are read unprefixed
+		"WS_TIMEOUT":   "1s",
+		"UDP_DEADLINE": "soon",
+	} {
+		os.Setenv(k, v)
+		defer os.Unsetenv(k)
+	}
+	env, err := LoadEnviron()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env.DBHost != "db:5432" || env.DBUser != "chisel" || env.DCMasterPort != "20000" ||
+		env.WebhookURL != "https://hooks.example.com/chisel" || env.StatsDAddr != "127.0.0.1:8125" ||
+		env.SentryEnvironment != "staging" {
+		t.Fatalf("unexpected environment %+v", env)
+	}
+	if env.SSHWait != 5*time.Second || env.WSTimeout != 45*time.Second || env.UDPDeadline != 15*time.Second || env.DBSSLMode != "disable" {
+		t.Fatalf("unexpected defaults %+v", env)
+	}
+	for k, v := range map[string]string{
+		"CHISEL_DCMASTER_PORT": "http",
+		"CHISEL_WS_BUFF_SIZE":  "-1",
+		"DB_SSLMODE":           "maybe",
+		"CHISEL_SSH_WAIT":      "soon",
+		"CHISEL_WEBHOOK_URL":   "hooks.example.com",
+		"CHISEL_STATSD_ADDR":   "127.0.0.1",
+	} {
+		os.Setenv(k, v)
+		env, err := LoadEnviron()
+		os.Unsetenv(k)
+		if err == nil {
+			t.Fatalf("%s=%s: expected error", k, v)
+		}
+		if env.SSHWait != 35*time.Second && k == "CHISEL_SSH_WAIT" {
+			t.Fatalf("expected the default for an invalid value, got %s", env.SSHWait)
+		}
+	}
+}
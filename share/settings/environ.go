@@ -0,0 +1,165 @@
+package settings
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Environ is chisel's environment. Each field is read from
+// CHISEL_<name>. Fields tagged legacy fall back to the unprefixed
+// <name> which older deployments set. Fields tagged secret may
+// also be read from the file named by <name>_FILE, see Secret.
+type Environ struct {
+	DBHost        string `env:"DB_HOST" legacy:"true" secret:"true"`
+	DBReadHost    string `env:"DB_READ_HOST" legacy:"true" secret:"true"`
+	DBUser        string `env:"DB_USER" legacy:"true" secret:"true"`
+	DBPass        string `env:"DB_PASS" legacy:"true" secret:"true"`
+	DBName        string `env:"DB_NAME" legacy:"true" secret:"true"`
+	DBSSLMode     string `env:"DB_SSLMODE" legacy:"true" default:"disable" validate:"sslmode"`
+	DBSSLRootCert string `env:"DB_SSLROOTCERT" legacy:"true"`
+	DBSSLCert     string `env:"DB_SSLCERT" legacy:"true"`
+	DBSSLKey      string `env:"DB_SSLKEY" legacy:"true"`
+
+	DCMasterPort           string        `env:"DCMASTER_PORT" legacy:"true" validate:"port"`
+	DCMasterHealthInterval time.Duration `env:"DCMASTER_HEALTH_INTERVAL" default:"15s"`
+
+	WSBuffSize    int           `env:"WS_BUFF_SIZE" default:"0"`
+	WSTimeout     time.Duration `env:"WS_TIMEOUT" default:"45s"`
+	SSHTimeout    time.Duration `env:"SSH_TIMEOUT" default:"30s"`
+	SSHWait       time.Duration `env:"SSH_WAIT" default:"35s"`
+	UDPDeadline   time.Duration `env:"UDP_DEADLINE" default:"15s"`
+	ConfigTimeout time.Duration `env:"CONFIG_TIMEOUT" default:"10s"`
+
+	LEEmail string `env:"LE_EMAIL"`
+	LECache string `env:"LE_CACHE"`
+
+	WebhookURL string `env:"WEBHOOK_URL" legacy:"true" secret:"true" validate:"url"`
+	StatsDAddr string `env:"STATSD_ADDR" legacy:"true" validate:"hostport"`
+
+	SentryEnvironment string `env:"SENTRY_ENVIRONMENT" legacy:"true"`
+}
+
+var (
+	environOnce sync.Once
+	environ     *Environ
+)
+
+// Environment returns the environment as parsed on first use,
+// see LoadEnviron. Invalid variables are logged once, and keep
+// their defaults.
+func Environment() *Environ {
+	environOnce.Do(func() {
+		var err error
+		if environ, err = LoadEnviron(); err != nil {
+			log.Printf("%s, using the default", err)
+		}
+	})
+	return environ
+}
+
+// LoadEnviron parses the environment, returning the first invalid
+// variable as an error. Invalid variables keep their defaults.
+func LoadEnviron() (*Environ, error) {
+	e := &Environ{}
+	var first error
+	v := reflect.ValueOf(e).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := f.Tag.Get("env")
+		def := f.Tag.Get("default")
+		if def != "" {
+			//defaults are always valid
+			setField(v.Field(i), def)
+		}
+		raw, err := lookupEnv(name, f.Tag.Get("legacy") == "true", f.Tag.Get("secret") == "true")
+		if err == nil && raw != "" {
+			err = setField(v.Field(i), raw)
+			if err == nil {
+				err = validate(f.Tag.Get("validate"), raw)
+			}
+			if err != nil {
+				err = fmt.Errorf("Invalid %s (%s)", name, raw)
+				if def != "" {
+					setField(v.Field(i), def)
+				} else {
+					v.Field(i).Set(reflect.Zero(f.Type))
+				}
+			}
+		}
+		if err != nil && first == nil {
+			first = err
+		}
+	}
+	return e, first
+}
+
+func lookupEnv(name string, legacy, secret bool) (string, error) {
+	names := []string{"CHISEL_" + name}
+	if legacy {
+		names = append(names, name)
+	}
+	for _, n := range names {
+		if secret {
+			if v, err := Secret(n); err != nil || v != "" {
+				return v, err
+			}
+		} else if v := os.Getenv(n); v != "" {
+			return v, nil
+		}
+	}
+	return "", nil
+}
+
+func setField(v reflect.Value, raw string) error {
+	switch v.Interface().(type) {
+	case time.Duration:
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+	case int:
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid int")
+		}
+		v.SetInt(int64(n))
+	default:
+		v.SetString(raw)
+	}
+	return nil
+}
+
+func validate(rule, raw string) error {
+	switch rule {
+	case "port":
+		if n, err := strconv.Atoi(raw); err != nil || n <= 0 || n > 65535 {
+			return fmt.Errorf("invalid port")
+		}
+	case "hostport":
+		_, port, err := net.SplitHostPort(raw)
+		if err != nil {
+			return fmt.Errorf("invalid address")
+		}
+		return validate("port", port)
+	case "url":
+		if u, err := url.Parse(raw); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid url")
+		}
+	case "sslmode":
+		switch raw {
+		case "disable", "allow", "prefer", "require", "verify-ca", "verify-full":
+		default:
+			return fmt.Errorf("invalid sslmode")
+		}
+	}
+	return nil
+}
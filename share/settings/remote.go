@@ -4,6 +4,7 @@ import (
 	"errors"
 	"net"
 	"net/url"
+	"os"
 	"regexp"
 	"strconv"
 	"strings"
@@ -31,21 +32,66 @@ import (
 //   1.1.1.1:53/udp
 //     local  127.0.0.1:53/udp
 //     remote 1.1.1.1:53/udp
+//   3128:http
+//     local  127.0.0.1:3128
+//     remote http proxy
+//   0.0.0.0:12345:transparent
+//     local  0.0.0.0:12345 (iptables redirected)
+//     remote each connection's original destination
+//   5353:dns
+//     local  127.0.0.1:5353/udp
+//     remote dns (the peer's resolver)
+//   8080:unix:/run/app.sock
+//     local  0.0.0.0:8080
+//     remote unix socket /run/app.sock
+//   /tmp/app.sock:example.com:80
+//     local  unix socket /tmp/app.sock
+//     remote example.com:80
+//   R:@jobs:localhost:3000
+//     local  0.0.0.0, a port of the server's "jobs" pool
+//     remote localhost:3000
+//   R:https://myapp:3000
+//     local  https://myapp.<public-domain> of the server
+//     remote 127.0.0.1:3000
 
 type Remote struct {
 	LocalHost, LocalPort, LocalProto    string
 	RemoteHost, RemotePort, RemoteProto string
-	Socks, Reverse, Stdio               bool
+	Socks, Reverse, Stdio, DNS          bool
+
+	//HTTPProxy remotes serve an HTTP proxy (CONNECT
+	//and absolute-URI requests) from the peer
+	HTTPProxy bool
+	//Transparent remotes accept connections redirected by
+	//iptables, and tunnel them to their original destinations
+	Transparent bool
+	//Subdomain is the name of a public reverse remote, whose HTTP
+	//requests to the subdomain of the server's public domain are
+	//sent to the remote, its LocalProto is "http" or "https"
+	Subdomain string
 }
 
 const revPrefix = "R:"
 
+//unixPrefix marks a remote unix socket
+const unixPrefix = "unix:"
+
+//poolPrefix marks the local port of a reverse
+//remote as a pool of the server, see Pool
+const poolPrefix = "@"
+
 func DecodeRemote(s string) (*Remote, error) {
 	reverse := false
 	if strings.HasPrefix(s, revPrefix) {
 		s = strings.TrimPrefix(s, revPrefix)
 		reverse = true
 	}
+	if reverse && (strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://")) {
+		return decodePublic(s)
+	}
+	if strings.HasPrefix(s, "/") || strings.HasPrefix(s, unixPrefix) || strings.Contains(s, ":"+unixPrefix) {
+		return decodeUnix(s, reverse)
+	}
 	parts := regexp.MustCompile(`(\[[^\[\]]+\]|[^\[\]:]+):?`).FindAllStringSubmatch(s, -1)
 	if len(parts) <= 0 || len(parts) >= 5 {
 		return nil, errors.New("Invalid remote")
@@ -61,6 +107,21 @@ func DecodeRemote(s string) (*Remote, error) {
 			r.Socks = true
 			continue
 		}
+		//remote portion is an http proxy?
+		if i == len(parts)-1 && p == "http" {
+			r.HTTPProxy = true
+			continue
+		}
+		//remote portion is the original destination?
+		if i == len(parts)-1 && p == "transparent" {
+			r.Transparent = true
+			continue
+		}
+		//remote portion is the peer's resolver?
+		if i == len(parts)-1 && (p == "dns" || p == "dns/udp") {
+			r.DNS = true
+			continue
+		}
 		//local portion is stdio?
 		if i == 0 && p == "stdio" {
 			r.Stdio = true
@@ -74,20 +135,22 @@ func DecodeRemote(s string) (*Remote, error) {
 				r.LocalProto = proto
 			}
 		}
-		if isPort(p) {
-			if !r.Socks && r.RemotePort == "" {
+		//the local port of a reverse remote may be 0, see Ephemeral,
+		//or a port pool, see Pool
+		if isPort(p) || ((p == "0" || isPool(p)) && r.Reverse && r.RemotePort != "") {
+			if !r.Socks && !r.DNS && !r.HTTPProxy && !r.Transparent && r.RemotePort == "" {
 				r.RemotePort = p
 			}
 			r.LocalPort = p
 			continue
 		}
-		if !r.Socks && (r.RemotePort == "" && r.LocalPort == "") {
+		if !r.Socks && !r.DNS && !r.HTTPProxy && !r.Transparent && (r.RemotePort == "" && r.LocalPort == "") {
 			return nil, errors.New("Missing ports")
 		}
 		if !isHost(p) {
 			return nil, errors.New("Invalid host")
 		}
-		if !r.Socks && r.RemoteHost == "" {
+		if !r.Socks && !r.DNS && !r.HTTPProxy && !r.Transparent && r.RemoteHost == "" {
 			r.RemoteHost = p
 		} else {
 			r.LocalHost = p
@@ -102,6 +165,32 @@ func DecodeRemote(s string) (*Remote, error) {
 		if r.LocalPort == "" {
 			r.LocalPort = "1080"
 		}
+	} else if r.HTTPProxy {
+		//http proxy defaults
+		if r.LocalHost == "" {
+			r.LocalHost = "127.0.0.1"
+		}
+		if r.LocalPort == "" {
+			r.LocalPort = "3128"
+		}
+	} else if r.Transparent {
+		//transparent defaults, redirected connections
+		//arrive on the address of their interface
+		if r.LocalHost == "" {
+			r.LocalHost = "0.0.0.0"
+		}
+		if r.LocalPort == "" {
+			return nil, errors.New("transparent remotes require a local port")
+		}
+	} else if r.DNS {
+		//dns defaults
+		if r.LocalHost == "" {
+			r.LocalHost = "127.0.0.1"
+		}
+		if r.LocalPort == "" {
+			r.LocalPort = "53"
+		}
+		r.RemoteProto = "udp"
 	} else {
 		//non-socks defaults
 		if r.LocalHost == "" {
@@ -126,12 +215,109 @@ func DecodeRemote(s string) (*Remote, error) {
 	if r.Socks && r.RemoteProto != "tcp" {
 		return nil, errors.New("only TCP SOCKS is supported")
 	}
+	if r.HTTPProxy && r.RemoteProto != "tcp" {
+		return nil, errors.New("only TCP HTTP proxies are supported")
+	}
+	if r.Transparent && (r.RemoteProto != "tcp" || r.Reverse || r.Stdio) {
+		return nil, errors.New("transparent remotes only support local TCP")
+	}
+	if r.Stdio && r.DNS {
+		return nil, errors.New("stdio cannot resolve dns")
+	}
+	if r.Stdio && r.Reverse {
+		return nil, errors.New("stdio cannot be reversed")
+	}
+	return r, nil
+}
+
+//decodeUnix decodes remotes with unix socket endpoints,
+//a local path (starting with /) and/or a remote unix:<path>
+func decodeUnix(s string, reverse bool) (*Remote, error) {
+	r := &Remote{Reverse: reverse}
+	rest := s
+	if strings.HasPrefix(rest, "/") {
+		i := strings.Index(rest, ":")
+		if i == -1 {
+			return nil, errors.New("Missing remote")
+		}
+		r.LocalHost, r.LocalProto = rest[:i], "unix"
+		rest = rest[i+1:]
+	}
+	if i := strings.Index(rest, unixPrefix); i == 0 || (i > 0 && rest[i-1] == ':') {
+		r.RemoteHost, r.RemoteProto = rest[i+len(unixPrefix):], "unix"
+		if r.RemoteHost == "" {
+			return nil, errors.New("Missing socket path")
+		}
+		rest = strings.TrimSuffix(rest[:i], ":")
+	}
+	switch {
+	case r.LocalProto == "unix" && r.RemoteProto == "unix":
+		if rest != "" {
+			return nil, errors.New("Invalid remote")
+		}
+	case r.LocalProto == "unix":
+		//the rest is the remote portion
+		rr, err := DecodeRemote(rest)
+		if err != nil {
+			return nil, err
+		}
+		if rr.RemoteProto != "tcp" {
+			return nil, errors.New("unix sockets only support tcp")
+		}
+		r.RemoteHost, r.RemotePort, r.RemoteProto, r.Socks = rr.RemoteHost, rr.RemotePort, rr.RemoteProto, rr.Socks
+		r.HTTPProxy = rr.HTTPProxy
+	case rest == "stdio":
+		r.Stdio = true
+	default:
+		//the rest is the local portion
+		host, port := "0.0.0.0", rest
+		if i := strings.LastIndex(rest, ":"); i >= 0 {
+			host, port = rest[:i], rest[i+1:]
+		}
+		if !isPort(port) {
+			return nil, errors.New("Missing local port")
+		}
+		if !isHost(host) {
+			return nil, errors.New("Invalid host")
+		}
+		r.LocalHost, r.LocalPort, r.LocalProto = host, port, "tcp"
+	}
+	if r.LocalProto == "" {
+		r.LocalProto = "tcp"
+	}
 	if r.Stdio && r.Reverse {
 		return nil, errors.New("stdio cannot be reversed")
 	}
 	return r, nil
 }
 
+var subdomainLabel = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+//decodePublic decodes a public reverse remote, the scheme and
+//subdomain, then the remote portion which defaults to port 80
+func decodePublic(s string) (*Remote, error) {
+	i := strings.Index(s, "://")
+	r := &Remote{Reverse: true, LocalHost: "0.0.0.0", LocalProto: s[:i]}
+	rest := s[i+3:]
+	target := "80"
+	if j := strings.Index(rest, ":"); j >= 0 {
+		rest, target = rest[:j], rest[j+1:]
+	}
+	if !subdomainLabel.MatchString(rest) {
+		return nil, errors.New("Invalid subdomain")
+	}
+	r.Subdomain = rest
+	rr, err := DecodeRemote(target)
+	if err != nil {
+		return nil, err
+	}
+	if rr.RemoteProto != "tcp" || rr.Socks || rr.HTTPProxy || rr.Transparent || rr.DNS || rr.Stdio || rr.LocalPort != rr.RemotePort {
+		return nil, errors.New("public remotes only support a tcp host and port")
+	}
+	r.RemoteHost, r.RemotePort, r.RemoteProto = rr.RemoteHost, rr.RemotePort, rr.RemoteProto
+	return r, nil
+}
+
 func isPort(s string) bool {
 	n, err := strconv.Atoi(s)
 	if err != nil {
@@ -143,6 +329,12 @@ func isPort(s string) bool {
 	return true
 }
 
+var poolName = regexp.MustCompile(`^` + poolPrefix + `[\w-]+$`)
+
+func isPool(s string) bool {
+	return poolName.MatchString(s)
+}
+
 func isHost(s string) bool {
 	_, err := url.Parse("//" + s)
 	if err != nil {
@@ -198,6 +390,12 @@ func (r Remote) Local() string {
 	if r.Stdio {
 		return "stdio"
 	}
+	if r.Public() {
+		return r.LocalProto + "://" + r.Subdomain
+	}
+	if r.LocalProto == "unix" {
+		return r.LocalHost
+	}
 	if r.LocalHost == "" {
 		r.LocalHost = "0.0.0.0"
 	}
@@ -209,6 +407,18 @@ func (r Remote) Remote() string {
 	if r.Socks {
 		return "socks"
 	}
+	if r.DNS {
+		return "dns"
+	}
+	if r.HTTPProxy {
+		return "http"
+	}
+	if r.Transparent {
+		return "transparent"
+	}
+	if r.RemoteProto == "unix" {
+		return unixPrefix + r.RemoteHost
+	}
 	if r.RemoteHost == "" {
 		r.RemoteHost = "127.0.0.1"
 	}
@@ -219,8 +429,14 @@ func (r Remote) Remote() string {
 //user has access to a given remote
 func (r Remote) UserAddr() string {
 	if r.Reverse {
+		if r.LocalProto == "unix" || r.Public() {
+			return "R:" + r.Local()
+		}
 		return "R:" + r.LocalHost + ":" + r.LocalPort
 	}
+	if r.RemoteProto == "unix" {
+		return r.Remote()
+	}
 	return r.RemoteHost + ":" + r.RemotePort
 }
 
@@ -228,6 +444,10 @@ func (r Remote) UserAddr() string {
 func (r Remote) CanListen() bool {
 	//valid protocols
 	switch r.LocalProto {
+	case "unix":
+		//a stale socket is replaced
+		info, err := os.Lstat(r.LocalHost)
+		return os.IsNotExist(err) || (err == nil && info.Mode()&os.ModeSocket != 0)
 	case "tcp":
 		conn, err := net.Listen("tcp", r.Local())
 		if err == nil {
@@ -251,6 +471,49 @@ func (r Remote) CanListen() bool {
 	return false
 }
 
+//Ephemeral is a reverse remote of local port 0,
+//whose port the server picks, see AllocatePort
+func (r Remote) Ephemeral() bool {
+	return r.Reverse && r.LocalPort == "0" && (r.LocalProto == "tcp" || r.LocalProto == "udp")
+}
+
+//Public is a reverse remote served at a subdomain of the
+//server, rather than by listening, see Subdomain
+func (r Remote) Public() bool {
+	return r.Subdomain != ""
+}
+
+//Pool is the name of the server's port pool which the
+//local port of a reverse remote is allocated from, if any
+func (r Remote) Pool() string {
+	if !r.Reverse || !isPool(r.LocalPort) || (r.LocalProto != "tcp" && r.LocalProto != "udp") {
+		return ""
+	}
+	return strings.TrimPrefix(r.LocalPort, poolPrefix)
+}
+
+//AllocatePort returns a free local port for an Ephemeral remote
+func (r Remote) AllocatePort() (string, error) {
+	var addr net.Addr
+	if r.LocalProto == "udp" {
+		conn, err := net.ListenPacket("udp", r.Local())
+		if err != nil {
+			return "", err
+		}
+		addr = conn.LocalAddr()
+		conn.Close()
+	} else {
+		l, err := net.Listen("tcp", r.Local())
+		if err != nil {
+			return "", err
+		}
+		addr = l.Addr()
+		l.Close()
+	}
+	_, port, err := net.SplitHostPort(addr.String())
+	return port, err
+}
+
 type Remotes []*Remote
 
 //Filter out forward reversed/non-reversed remotes
@@ -265,6 +528,18 @@ func (rs Remotes) Reversed(reverse bool) Remotes {
 	return subset
 }
 
+//Listened filters out the Public remotes, which
+//are served without listening, or the others
+func (rs Remotes) Listened(listened bool) Remotes {
+	subset := Remotes{}
+	for _, r := range rs {
+		if r.Public() != listened {
+			subset = append(subset, r)
+		}
+	}
+	return subset
+}
+
 //Encode back into strings
 func (rs Remotes) Encode() []string {
 	s := make([]string, len(rs))
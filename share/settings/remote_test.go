@@ -90,6 +90,105 @@ func TestRemoteDecode(t *testing.T) {
 			},
 			"localhost:5353:1.1.1.1:53/udp",
 		},
+		{
+			"1080:socks",
+			Remote{
+				LocalHost: "127.0.0.1",
+				LocalPort: "1080",
+				Socks:     true,
+			},
+			"127.0.0.1:1080:socks",
+		},
+		{
+			"[::1]:1081:socks",
+			Remote{
+				LocalHost: "[::1]",
+				LocalPort: "1081",
+				Socks:     true,
+			},
+			"[::1]:1081:socks",
+		},
+		{
+			"3128:http",
+			Remote{
+				LocalHost: "127.0.0.1",
+				LocalPort: "3128",
+				HTTPProxy: true,
+			},
+			"127.0.0.1:3128:http",
+		},
+		{
+			"R:http",
+			Remote{
+				LocalHost: "127.0.0.1",
+				LocalPort: "3128",
+				HTTPProxy: true,
+				Reverse:   true,
+			},
+			"R:127.0.0.1:3128:http",
+		},
+		{
+			"12345:transparent",
+			Remote{
+				LocalHost:   "0.0.0.0",
+				LocalPort:   "12345",
+				Transparent: true,
+			},
+			"0.0.0.0:12345:transparent",
+		},
+		{
+			"5353:dns",
+			Remote{
+				LocalHost:   "127.0.0.1",
+				LocalPort:   "5353",
+				LocalProto:  "udp",
+				RemoteProto: "udp",
+				DNS:         true,
+			},
+			"127.0.0.1:5353:dns/udp",
+		},
+		{
+			"R:dns/udp",
+			Remote{
+				LocalHost:   "127.0.0.1",
+				LocalPort:   "53",
+				LocalProto:  "udp",
+				RemoteProto: "udp",
+				DNS:         true,
+				Reverse:     true,
+			},
+			"R:127.0.0.1:53:dns/udp",
+		},
+		{
+			"8080:unix:/run/app.sock",
+			Remote{
+				LocalPort:   "8080",
+				RemoteHost:  "/run/app.sock",
+				RemoteProto: "unix",
+			},
+			"0.0.0.0:8080:unix:/run/app.sock",
+		},
+		{
+			"R:/tmp/app.sock:example.com:80",
+			Remote{
+				LocalHost:  "/tmp/app.sock",
+				LocalProto: "unix",
+				RemoteHost: "example.com",
+				RemotePort: "80",
+				Reverse:    true,
+			},
+			"R:/tmp/app.sock:example.com:80",
+		},
+		{
+			"/tmp/a.sock:unix:/run/b.sock",
+			Remote{
+				LocalHost:   "/tmp/a.sock",
+				LocalProto:  "unix",
+				RemoteHost:  "/run/b.sock",
+				RemoteProto: "unix",
+			},
+			"/tmp/a.sock:unix:/run/b.sock",
+		},
 		{
 			"[::1]:8080:google.com:80",
 			Remote{
@@ -111,6 +210,50 @@ func TestRemoteDecode(t *testing.T) {
 			},
 			"R:[::]:3000:[::1]:3000",
 		},
+		{
+			"R:0:localhost:3000",
+			Remote{
+				LocalPort:  "0",
+				RemoteHost: "localhost",
+				RemotePort: "3000",
+				Reverse:    true,
+			},
+			"R:0.0.0.0:0:localhost:3000",
+		},
+		{
+			"R:@jobs:localhost:3000",
+			Remote{
+				LocalPort:  "@jobs",
+				RemoteHost: "localhost",
+				RemotePort: "3000",
+				Reverse:    true,
+			},
+			"R:0.0.0.0:@jobs:localhost:3000",
+		},
+		{
+			"R:https://myapp:localhost:3000",
+			Remote{
+				LocalHost:  "0.0.0.0",
+				LocalProto: "https",
+				Subdomain:  "myapp",
+				RemoteHost: "localhost",
+				RemotePort: "3000",
+				Reverse:    true,
+			},
+			"R:https://myapp:localhost:3000",
+		},
+		{
+			"R:http://my-app",
+			Remote{
+				LocalHost:  "0.0.0.0",
+				LocalProto: "http",
+				Subdomain:  "my-app",
+				RemoteHost: "127.0.0.1",
+				RemotePort: "80",
+				Reverse:    true,
+			},
+			"R:http://my-app:127.0.0.1:80",
+		},
 	} {
 		//expected defaults
 		expected := test.Output
@@ -136,3 +279,57 @@ func TestRemoteDecode(t *testing.T) {
 		}
 	}
 }
+
+func TestRemotePool(t *testing.T) {
+	r, err := DecodeRemote("R:127.0.0.1:@ci-jobs:3000/udp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Pool() != "ci-jobs" || r.Ephemeral() || r.LocalProto != "udp" {
+		t.Fatalf("expected the ci-jobs pool, got %#v", r)
+	}
+	for _, invalid := range []string{"@jobs", "R:@jobs"} {
+		if _, err := DecodeRemote(invalid); err == nil {
+			t.Fatalf("expected '%s' to be invalid", invalid)
+		}
+	}
+	if r, _ := DecodeRemote("R:8080:localhost:3000"); r.Pool() != "" {
+		t.Fatal("expected no pool")
+	}
+}
+
+func TestRemotePublic(t *testing.T) {
+	r, err := DecodeRemote("R:https://myapp:3000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.Public() || r.String() != "R:https://myapp=>3000" || r.UserAddr() != "R:https://myapp" {
+		t.Fatalf("unexpected public remote %s (%s)", r, r.UserAddr())
+	}
+	for _, invalid := range []string{"R:https://My_App:3000", "R:https://myapp:3000/udp", "R:https://myapp:socks", "R:https://myapp:80:localhost:3000"} {
+		if _, err := DecodeRemote(invalid); err == nil {
+			t.Fatalf("expected '%s' to be invalid", invalid)
+		}
+	}
+	rs := Remotes{r, {Reverse: true, LocalPort: "80"}}
+	if l := rs.Listened(true); len(l) != 1 || l[0].Public() {
+		t.Fatalf("expected the listened remote, got %v", l)
+	}
+}
+
+func TestRemoteAllocatePort(t *testing.T) {
+	r, err := DecodeRemote("R:127.0.0.1:0:localhost:3000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.Ephemeral() {
+		t.Fatal("expected an ephemeral remote")
+	}
+	port, err := r.AllocatePort()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isPort(port) {
+		t.Fatalf("expected a port, got %q", port)
+	}
+}
@@ -1,8 +1,13 @@
 package settings
 
 import (
+	"crypto/subtle"
+	"fmt"
 	"regexp"
+	"strconv"
 	"strings"
+
+	"golang.org/x/crypto/bcrypt"
 )
 
 var UserAllowAll = regexp.MustCompile("")
@@ -16,9 +21,26 @@ func ParseAuth(auth string) (string, string) {
 }
 
 type User struct {
-	Name  string
-	Pass  string
-	Addrs []*regexp.Regexp
+	Name string
+	Pass string
+	//PassHash is the bcrypt hash of the password of
+	//a restored user, whose Pass is not kept
+	PassHash []byte
+	Addrs    []*regexp.Regexp
+	//ReversePorts are the server ports the user's reverse
+	//remotes may listen on, any port when empty
+	ReversePorts []PortRange
+	//Pushed are the remotes the server pushes
+	//to the user's clients, see Config.AcceptPushed
+	Pushed Remotes
+}
+
+// CheckPassword reports whether pass is the user's password
+func (u *User) CheckPassword(pass []byte) bool {
+	if u.Pass == "" && len(u.PassHash) > 0 {
+		return bcrypt.CompareHashAndPassword(u.PassHash, pass) == nil
+	}
+	return subtle.ConstantTimeCompare([]byte(u.Pass), pass) == 1
 }
 
 func (u *User) HasAccess(addr string) bool {
@@ -31,3 +53,70 @@ func (u *User) HasAccess(addr string) bool {
 	}
 	return m
 }
+
+// CanBind reports whether the user's reverse
+// remotes may listen on the port
+func (u *User) CanBind(port string) bool {
+	if len(u.ReversePorts) == 0 {
+		return true
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		return false
+	}
+	for _, r := range u.ReversePorts {
+		if r.Contains(p) {
+			return true
+		}
+	}
+	return false
+}
+
+// DecodePushed decodes the remotes a server pushes to its
+// clients, which can't use the client's stdio
+func DecodePushed(remotes []string) (Remotes, error) {
+	var out Remotes
+	for _, s := range remotes {
+		r, err := DecodeRemote(s)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid pushed remote '%s': %s", s, err)
+		}
+		if r.Stdio {
+			return nil, fmt.Errorf("Invalid pushed remote '%s': stdio can't be pushed", s)
+		}
+		out = append(out, r)
+	}
+	return out, nil
+}
+
+// PortRange is an inclusive range of ports
+type PortRange struct {
+	From, To int
+}
+
+// ParsePortRange parses a port, or a range
+// of ports such as "8000-8999"
+func ParsePortRange(s string) (PortRange, error) {
+	from, to := s, s
+	if i := strings.Index(s, "-"); i >= 0 {
+		from, to = s[:i], s[i+1:]
+	}
+	f, err1 := strconv.Atoi(strings.TrimSpace(from))
+	t, err2 := strconv.Atoi(strings.TrimSpace(to))
+	if err1 != nil || err2 != nil || f < 1 || t > 65535 || f > t {
+		return PortRange{}, fmt.Errorf("Invalid port range '%s'", s)
+	}
+	return PortRange{From: f, To: t}, nil
+}
+
+// Contains reports whether the port is in the range
+func (r PortRange) Contains(port int) bool {
+	return port >= r.From && port <= r.To
+}
+
+func (r PortRange) String() string {
+	if r.From == r.To {
+		return strconv.Itoa(r.From)
+	}
+	return fmt.Sprintf("%d-%d", r.From, r.To)
+}
@@ -51,6 +51,17 @@ func (u *Users) Del(key string) {
 	u.Unlock()
 }
 
+// List returns the users, in no particular order
+func (u *Users) List() []*User {
+	u.RLock()
+	defer u.RUnlock()
+	out := make([]*User, 0, len(u.inner))
+	for _, user := range u.inner {
+		out = append(out, user)
+	}
+	return out
+}
+
 // AddUser adds a users to the set
 func (u *Users) AddUser(user *User) {
 	u.Set(user.Name, user)
@@ -129,27 +140,15 @@ func (u *UserIndex) loadUserIndex() error {
 	if err != nil {
 		return fmt.Errorf("Failed to read auth file: %s, error: %s", u.configFile, err)
 	}
-	var raw map[string][]string
+	var raw map[string]json.RawMessage
 	if err := json.Unmarshal(b, &raw); err != nil {
 		return errors.New("Invalid JSON: " + err.Error())
 	}
 	users := []*User{}
-	for auth, remotes := range raw {
-		user := &User{}
-		user.Name, user.Pass = ParseAuth(auth)
-		if user.Name == "" {
-			return errors.New("Invalid user:pass string")
-		}
-		for _, r := range remotes {
-			if r == "" || r == "*" {
-				user.Addrs = append(user.Addrs, UserAllowAll)
-			} else {
-				re, err := regexp.Compile(r)
-				if err != nil {
-					return errors.New("Invalid address regex")
-				}
-				user.Addrs = append(user.Addrs, re)
-			}
+	for auth, v := range raw {
+		user, err := parseUser(auth, v)
+		if err != nil {
+			return err
 		}
 		users = append(users, user)
 	}
@@ -157,3 +156,52 @@ func (u *UserIndex) loadUserIndex() error {
 	u.Reset(users)
 	return nil
 }
+
+// userEntry is the object form of a user in the auth file,
+// which also limits the ports of their reverse remotes, and
+// lists the remotes pushed to their clients
+type userEntry struct {
+	Remotes      []string `json:"remotes"`
+	ReversePorts []string `json:"reverse-ports"`
+	Push         []string `json:"push"`
+}
+
+// parseUser parses a user of the auth file, whose value is either
+// the list of their address regexes, or a userEntry
+func parseUser(auth string, v json.RawMessage) (*User, error) {
+	user := &User{}
+	user.Name, user.Pass = ParseAuth(auth)
+	if user.Name == "" {
+		return nil, errors.New("Invalid user:pass string")
+	}
+	var entry userEntry
+	if err := json.Unmarshal(v, &entry.Remotes); err != nil {
+		if err := json.Unmarshal(v, &entry); err != nil {
+			return nil, fmt.Errorf("Invalid user %s: %s", user.Name, err)
+		}
+	}
+	for _, r := range entry.Remotes {
+		if r == "" || r == "*" {
+			user.Addrs = append(user.Addrs, UserAllowAll)
+		} else {
+			re, err := regexp.Compile(r)
+			if err != nil {
+				return nil, errors.New("Invalid address regex")
+			}
+			user.Addrs = append(user.Addrs, re)
+		}
+	}
+	for _, p := range entry.ReversePorts {
+		r, err := ParsePortRange(p)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid user %s: %s", user.Name, err)
+		}
+		user.ReversePorts = append(user.ReversePorts, r)
+	}
+	pushed, err := DecodePushed(entry.Push)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid user %s: %s", user.Name, err)
+	}
+	user.Pushed = pushed
+	return user, nil
+}
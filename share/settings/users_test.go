@@ -0,0 +1,50 @@
+package settings
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jpillora/chisel/share/cio"
+)
+
+func TestLoadUsers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chisel-users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "users.json")
+	ioutil.WriteFile(file, []byte(`{
+		"foo:bar": ["^R:0.0.0.0:80$", ""],
+		"ping:pong": {"remotes": ["^R:"], "reverse-ports": ["8000-8999", "2222"], "push": ["R:2222:localhost:22", "3000"]}
+	}`), 0600)
+	u := NewUserIndex(cio.NewLogger("test"))
+	u.configFile = file
+	if err := u.loadUserIndex(); err != nil {
+		t.Fatal(err)
+	}
+	foo, _ := u.Get("foo")
+	if foo == nil || len(foo.Addrs) != 2 || !foo.CanBind("80") {
+		t.Fatalf("expected foo to bind any port, got %+v", foo)
+	}
+	ping, _ := u.Get("ping")
+	if ping == nil || ping.Pass != "pong" || !ping.HasAccess("R:0.0.0.0:8080") {
+		t.Fatalf("unexpected user %+v", ping)
+	}
+	if len(ping.Pushed) != 2 || !ping.Pushed[0].Reverse || ping.Pushed[1].LocalPort != "3000" {
+		t.Fatalf("unexpected pushed remotes %v", ping.Pushed)
+	}
+	for port, ok := range map[string]bool{"8000": true, "8999": true, "2222": true, "9000": false, "22": false, "x": false} {
+		if ping.CanBind(port) != ok {
+			t.Fatalf("expected CanBind(%s) to be %v", port, ok)
+		}
+	}
+	for _, invalid := range []string{`{"a:b": {"reverse-ports": ["9-1"]}}`, `{"a:b": {"reverse-ports": ["0"]}}`, `{"a:b": "x"}`, `{"a:b": {"push": ["stdio:22"]}}`} {
+		ioutil.WriteFile(file, []byte(invalid), 0600)
+		if err := u.loadUserIndex(); err == nil {
+			t.Fatalf("expected %s to be invalid", invalid)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package tunnel
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"strings"
+)
+
+// defaultResolver is used when resolv.conf names no nameserver
+const defaultResolver = "127.0.0.1:53"
+
+// resolver returns the address of the resolver of "dns"
+// remotes, Config.Resolver or the first system nameserver
+func (t *Tunnel) resolver() string {
+	if t.Config.Resolver != "" {
+		return t.Config.Resolver
+	}
+	return systemResolver("/etc/resolv.conf")
+}
+
+func systemResolver(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return defaultResolver
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			return net.JoinHostPort(fields[1], "53")
+		}
+	}
+	return defaultResolver
+}
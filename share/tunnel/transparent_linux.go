@@ -0,0 +1,75 @@
+//go:build linux
+// +build linux
+
+package tunnel
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strconv"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// listenTransparent listens for connections redirected by
+// iptables, with REDIRECT, or with TPROXY when permitted
+func listenTransparent(addr string) (net.Listener, error) {
+	lc := net.ListenConfig{
+		Control: func(network, address string, c syscall.RawConn) error {
+			c.Control(func(fd uintptr) {
+				//TPROXY needs CAP_NET_ADMIN, REDIRECT works without
+				unix.SetsockoptInt(int(fd), unix.SOL_IP, unix.IP_TRANSPARENT, 1)
+				unix.SetsockoptInt(int(fd), unix.SOL_IPV6, unix.IPV6_TRANSPARENT, 1)
+			})
+			return nil
+		},
+	}
+	return lc.Listen(context.Background(), "tcp", addr)
+}
+
+// originalDst is the destination of a redirected connection,
+// from conntrack (REDIRECT), or its local address (TPROXY)
+func originalDst(c net.Conn) (string, error) {
+	tc, ok := c.(*net.TCPConn)
+	if !ok {
+		return "", errors.New("not a tcp connection")
+	}
+	raw, err := tc.SyscallConn()
+	if err != nil {
+		return "", err
+	}
+	var dst string
+	var sockErr error
+	err = raw.Control(func(fd uintptr) {
+		dst, sockErr = getsockoptOriginalDst(int(fd))
+	})
+	if err != nil {
+		return "", err
+	}
+	if sockErr != nil {
+		//no nat, the tproxy destination is the local address
+		dst = c.LocalAddr().String()
+	}
+	return dst, nil
+}
+
+func getsockoptOriginalDst(fd int) (string, error) {
+	//sockaddr_in, read through the same sized ipv6_mreq
+	if mreq, err := unix.GetsockoptIPv6Mreq(fd, unix.SOL_IP, unix.SO_ORIGINAL_DST); err == nil {
+		a := mreq.Multiaddr
+		port := binary.BigEndian.Uint16(a[2:4])
+		return net.JoinHostPort(net.IP(a[4:8]).String(), strconv.Itoa(int(port))), nil
+	}
+	//sockaddr_in6, read through the larger ip6_mtuinfo
+	info, err := unix.GetsockoptIPv6MTUInfo(fd, unix.SOL_IPV6, unix.SO_ORIGINAL_DST)
+	if err != nil {
+		return "", err
+	}
+	//the port is in network byte order
+	port := binary.BigEndian.Uint16((*[2]byte)(unsafe.Pointer(&info.Addr.Port))[:])
+	return net.JoinHostPort(net.IP(info.Addr.Addr[:]).String(), strconv.Itoa(int(port))), nil
+}
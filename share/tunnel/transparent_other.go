@@ -0,0 +1,19 @@
+//go:build !linux
+// +build !linux
+
+package tunnel
+
+import (
+	"errors"
+	"net"
+)
+
+var errTransparent = errors.New("transparent remotes are only supported on Linux")
+
+func listenTransparent(addr string) (net.Listener, error) {
+	return nil, errTransparent
+}
+
+func originalDst(c net.Conn) (string, error) {
+	return "", errTransparent
+}
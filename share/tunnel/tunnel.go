@@ -4,8 +4,11 @@ import (
 	"bytes"
 	"context"
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"log"
+	"net"
+	"net/http"
 	"os"
 	"sync"
 	"time"
@@ -25,6 +28,49 @@ type Config struct {
 	Outbound  bool
 	Socks     bool
 	KeepAlive time.Duration
+	//Conns tracks the connections accepted by proxies
+	//and dialed for channels, labelled with Session
+	Conns   *cnet.Registry
+	Session string
+	//MaxConns limits the tracked connections of the session, zero is unlimited
+	MaxConns int
+	//SlowWrite and SlowWriteLimit detect the peers of tracked
+	//connections which can't keep up, see cnet.SlowWrites
+	SlowWrite      time.Duration
+	SlowWriteLimit int
+	//OnSlowWrite is called after each slow write to a remote's connection
+	OnSlowWrite func(remote string, d time.Duration, closed bool)
+	//UnixSockets allows the peer's channels to dial unix sockets
+	UnixSockets bool
+	//Resolver is the DNS server which answers the queries
+	//of the peer's "dns" remotes, see resolver
+	Resolver string
+	//OnRemotes applies the peer's "remotes" requests, returning
+	//the payload of the reply, they are refused when it is nil
+	OnRemotes func(u *settings.RemotesUpdate) ([]byte, error)
+	//Upload and Download limit the bandwidth of all of the
+	//tracked connections, which read what is sent to the peer
+	Upload, Download *cnet.Bandwidth
+	//HTTPProxy allows the peer's "http" remotes, whose
+	//proxy requests are sent from this end
+	HTTPProxy bool
+	//Transparent allows the peer's transparent remotes,
+	//whose connections are dialed at their original destinations
+	Transparent bool
+	//KeepAliveTimeout closes the connection when a keepalive
+	//isn't answered in time, zero waits for the transport
+	KeepAliveTimeout time.Duration
+	//DialTimeout bounds dialing the peer's remotes, zero is the OS default
+	DialTimeout time.Duration
+	//IdleChannelTimeout closes the tracked connections, and with them
+	//their channels, after a period without traffic, zero disables
+	IdleChannelTimeout time.Duration
+	//OnBind is called when a remote's proxy listens,
+	//or fails to, with the error
+	OnBind func(remote *settings.Remote, err error)
+	//OnGoAway is called when the peer is shutting down, and
+	//asks to be reconnected to elsewhere once it disconnects
+	OnGoAway func()
 }
 
 //Tunnel represents an SSH tunnel with proxy capabilities.
@@ -40,18 +86,27 @@ type Tunnel struct {
 	activeConnMut  sync.RWMutex
 	activatingConn waitGroup
 	activeConn     ssh.Conn
-	//proxies
+	//proxies, by remote
+	proxyMut   sync.Mutex
 	proxyCount int
+	proxies    map[string]*boundProxy
 	//internals
 	connStats   cnet.ConnCount
 	socksServer *socks5.Server
+	//httpTransport forwards the requests of "http" remotes
+	httpTransport *http.Transport
+	//traffic of the channels of each remote
+	trafficMut sync.Mutex
+	traffic    map[string]*cnet.Traffic
 }
 
 //New Tunnel from the given Config
 func New(c Config) *Tunnel {
-	c.Logger = c.Logger.Fork("tun")
+	c.Logger = c.Logger.Fork("tun").WithSubsystem("tunnel")
 	t := &Tunnel{
-		Config: c,
+		Config:  c,
+		traffic: map[string]*cnet.Traffic{},
+		proxies: map[string]*boundProxy{},
 	}
 	t.activatingConn.Add(1)
 	//setup socks server (not listening on any port!)
@@ -64,6 +119,14 @@ func New(c Config) *Tunnel {
 		t.socksServer, _ = socks5.New(&socks5.Config{Logger: sl})
 		extra += " (SOCKS enabled)"
 	}
+	if c.HTTPProxy {
+		t.httpTransport = &http.Transport{
+			DialContext:        (&net.Dialer{Timeout: c.DialTimeout}).DialContext,
+			DisableCompression: true,
+			IdleConnTimeout:    90 * time.Second,
+		}
+		extra += " (HTTP proxy enabled)"
+	}
 	t.Debugf("Created%s", extra)
 	return t
 }
@@ -92,7 +155,7 @@ func (t *Tunnel) BindSSH(ctx context.Context, c ssh.Conn, reqs <-chan *ssh.Reque
 	}
 	//block until closed
 	go t.handleSSHRequests(reqs)
-	go t.handleSSHChannels(chans)
+	go t.handleSSHChannels(ctx, chans)
 	t.Debugf("SSH connected")
 	err := c.Wait()
 	t.Debugf("SSH disconnected")
@@ -104,6 +167,67 @@ func (t *Tunnel) BindSSH(ctx context.Context, c ssh.Conn, reqs <-chan *ssh.Reque
 	return err
 }
 
+//OpenChannels returns the number of outbound channels in use
+func (t *Tunnel) OpenChannels() int32 {
+	return t.connStats.Opened()
+}
+
+//Traffic returns the byte counters of each remote, keyed
+//by the remote of the proxy or of the peer's channel
+func (t *Tunnel) Traffic() map[string]*cnet.Traffic {
+	t.trafficMut.Lock()
+	defer t.trafficMut.Unlock()
+	out := make(map[string]*cnet.Traffic, len(t.traffic))
+	for remote, c := range t.traffic {
+		out[remote] = c
+	}
+	return out
+}
+
+//connLimited reports whether the session is at MaxConns
+func (t *Tunnel) connLimited() bool {
+	return t.MaxConns > 0 && t.Conns.Count(cnet.ConnLabels{Session: t.Session}) >= t.MaxConns
+}
+
+//track registers c in Conns until it is closed,
+//and watches it for a slow consumer
+func (t *Tunnel) track(c net.Conn, remote, direction string) net.Conn {
+	c = t.Conns.Track(c, cnet.ConnLabels{Session: t.Session, Remote: remote, Direction: direction})
+	c = cnet.Limit(c, t.Upload, t.Download)
+	c = cnet.IdleTimeout(c, t.IdleChannelTimeout, func() {
+		t.Debugf("Closed idle connection on %s (%s)", remote, c.RemoteAddr())
+	})
+	if t.SlowWrite <= 0 {
+		return c
+	}
+	s := &cnet.SlowWrites{
+		Threshold: t.SlowWrite,
+		Limit:     t.SlowWriteLimit,
+		OnSlow: func(d time.Duration, n int, closed bool) {
+			if closed {
+				t.Infof("Closed slow consumer on %s (%s) after %d slow writes", remote, c.RemoteAddr(), n)
+			} else if n == 1 {
+				t.Infof("Slow consumer on %s (%s), write took %s", remote, c.RemoteAddr(), d)
+			}
+			if t.OnSlowWrite != nil {
+				t.OnSlowWrite(remote, d, closed)
+			}
+		},
+	}
+	return s.Watch(c)
+}
+
+func (t *Tunnel) remoteTraffic(remote string) *cnet.Traffic {
+	t.trafficMut.Lock()
+	defer t.trafficMut.Unlock()
+	c, ok := t.traffic[remote]
+	if !ok {
+		c = &cnet.Traffic{}
+		t.traffic[remote] = c
+	}
+	return c
+}
+
 //getSSH blocks while connecting
 func (t *Tunnel) getSSH(ctx context.Context) ssh.Conn {
 	//cancelled already?
@@ -121,7 +245,7 @@ func (t *Tunnel) getSSH(ctx context.Context) ssh.Conn {
 	select {
 	case <-ctx.Done(): //cancelled
 		return nil
-	case <-time.After(settings.EnvDuration("SSH_WAIT", 35*time.Second)):
+	case <-time.After(settings.Environment().SSHWait):
 		return nil //a bit longer than ssh timeout
 	case <-t.activatingConnWait():
 		t.activeConnMut.RLock()
@@ -151,19 +275,18 @@ func (t *Tunnel) BindRemotes(ctx context.Context, remotes []*settings.Remote) er
 	}
 	proxies := make([]*Proxy, len(remotes))
 	for i, remote := range remotes {
-		p, err := NewProxy(t.Logger, t, t.proxyCount, remote)
+		p, err := t.newProxy(remote)
 		if err != nil {
 			return err
 		}
 		proxies[i] = p
-		t.proxyCount++
 	}
 	//TODO: handle tunnel close
 	eg, ctx := errgroup.WithContext(ctx)
 	for _, proxy := range proxies {
 		p := proxy
 		eg.Go(func() error {
-			return p.Run(ctx)
+			return t.runProxy(ctx, p)
 		})
 	}
 	t.Debugf("Bound proxies")
@@ -172,12 +295,138 @@ func (t *Tunnel) BindRemotes(ctx context.Context, remotes []*settings.Remote) er
 	return err
 }
 
+//boundProxy stops a running proxy, see RemoveRemote
+type boundProxy struct {
+	stop context.CancelFunc
+}
+
+func (t *Tunnel) newProxy(remote *settings.Remote) (*Proxy, error) {
+	t.proxyMut.Lock()
+	defer t.proxyMut.Unlock()
+	if _, ok := t.proxies[remote.String()]; ok {
+		return nil, fmt.Errorf("remote %s is already bound", remote)
+	}
+	p, err := NewProxy(t.Logger, t, t.proxyCount, remote)
+	if t.OnBind != nil {
+		t.OnBind(remote, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	t.proxyCount++
+	//reserve the remote until the proxy runs
+	t.proxies[remote.String()] = &boundProxy{stop: func() {}}
+	return p, nil
+}
+
+//runProxy runs the proxy until the context is
+//cancelled or its remote is removed
+func (t *Tunnel) runProxy(ctx context.Context, p *Proxy) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	key := p.remote.String()
+	b := &boundProxy{stop: cancel}
+	t.proxyMut.Lock()
+	if _, reserved := t.proxies[key]; reserved {
+		t.proxies[key] = b
+	} else {
+		//removed before it ran, close its listener
+		cancel()
+	}
+	t.proxyMut.Unlock()
+	err := p.Run(ctx)
+	t.proxyMut.Lock()
+	if t.proxies[key] == b {
+		delete(t.proxies, key)
+	}
+	t.proxyMut.Unlock()
+	return err
+}
+
+//AddRemote binds a remote at runtime, until the context
+//is cancelled or the remote is removed with RemoveRemote
+func (t *Tunnel) AddRemote(ctx context.Context, remote *settings.Remote) error {
+	if !t.Inbound {
+		return errors.New("inbound connections blocked")
+	}
+	p, err := t.newProxy(remote)
+	if err != nil {
+		return err
+	}
+	go func() {
+		if err := t.runProxy(ctx, p); err != nil {
+			p.Infof("Closed: %s", err)
+		}
+	}()
+	return nil
+}
+
+//RemoveRemote stops the proxy of a remote, bound by
+//BindRemotes or AddRemote, and reports if it was bound
+func (t *Tunnel) RemoveRemote(remote *settings.Remote) bool {
+	t.proxyMut.Lock()
+	b, ok := t.proxies[remote.String()]
+	delete(t.proxies, remote.String())
+	t.proxyMut.Unlock()
+	if ok {
+		b.stop()
+	}
+	return ok
+}
+
+//Request sends a request to the peer, once connected,
+//failing with the peer's reply when it is refused
+func (t *Tunnel) Request(ctx context.Context, name string, payload []byte) ([]byte, error) {
+	c := t.getSSH(ctx)
+	if c == nil {
+		return nil, errors.New("not connected")
+	}
+	ok, reply, err := c.SendRequest(name, true, payload)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errors.New(string(reply))
+	}
+	return reply, nil
+}
+
+//Dial opens a channel for the remote, whose connection the peer
+//dials, as for those accepted by the remote's proxy
+func (t *Tunnel) Dial(ctx context.Context, remote *settings.Remote) (net.Conn, error) {
+	if t.connLimited() {
+		return nil, errors.New("connection limit reached")
+	}
+	c := t.getSSH(ctx)
+	if c == nil {
+		return nil, errors.New("not connected")
+	}
+	ch, reqs, err := c.OpenChannel("chisel", []byte(remote.Remote()))
+	if err != nil {
+		return nil, err
+	}
+	go ssh.DiscardRequests(reqs)
+	conn := cnet.NewRWCConn(t.remoteTraffic(remote.String()).Count(ch))
+	return t.track(conn, remote.String(), cnet.Accepted), nil
+}
+
+//Notify sends the peer a request which isn't replied to
+func (t *Tunnel) Notify(ctx context.Context, name string, payload []byte) error {
+	c := t.getSSH(ctx)
+	if c == nil {
+		return errors.New("not connected")
+	}
+	_, _, err := c.SendRequest(name, false, payload)
+	return err
+}
+
 func (t *Tunnel) keepAliveLoop(sshConn ssh.Conn) {
 	//ping forever
 	for {
 		time.Sleep(t.Config.KeepAlive)
-		_, b, err := sshConn.SendRequest("ping", true, nil)
+		b, err := t.ping(sshConn)
 		if err != nil {
+			t.Debugf("keepalive failed: %s", err)
 			break
 		}
 		if len(b) > 0 && !bytes.Equal(b, []byte("pong")) {
@@ -188,3 +437,26 @@ func (t *Tunnel) keepAliveLoop(sshConn ssh.Conn) {
 	//close ssh connection on abnormal ping
 	sshConn.Close()
 }
+
+//ping sends a keepalive, waiting at most KeepAliveTimeout for the reply
+func (t *Tunnel) ping(sshConn ssh.Conn) ([]byte, error) {
+	if t.Config.KeepAliveTimeout <= 0 {
+		_, b, err := sshConn.SendRequest("ping", true, nil)
+		return b, err
+	}
+	type reply struct {
+		b   []byte
+		err error
+	}
+	ch := make(chan reply, 1)
+	go func() {
+		_, b, err := sshConn.SendRequest("ping", true, nil)
+		ch <- reply{b, err}
+	}()
+	select {
+	case r := <-ch:
+		return r.b, r.err
+	case <-time.After(t.Config.KeepAliveTimeout):
+		return nil, fmt.Errorf("no reply within %s", t.Config.KeepAliveTimeout)
+	}
+}
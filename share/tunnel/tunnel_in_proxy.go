@@ -2,10 +2,13 @@ package tunnel
 
 import (
 	"context"
+	"errors"
 	"io"
 	"net"
+	"os"
 
 	"github.com/jpillora/chisel/share/cio"
+	"github.com/jpillora/chisel/share/cnet"
 	"github.com/jpillora/chisel/share/settings"
 	"github.com/jpillora/sizestr"
 	"golang.org/x/crypto/ssh"
@@ -14,6 +17,9 @@ import (
 //sshTunnel exposes a subset of Tunnel to subtypes
 type sshTunnel interface {
 	getSSH(ctx context.Context) ssh.Conn
+	remoteTraffic(remote string) *cnet.Traffic
+	connLimited() bool
+	track(c net.Conn, remote, direction string) net.Conn
 }
 
 //Proxy is the inbound portion of a Tunnel
@@ -24,7 +30,7 @@ type Proxy struct {
 	count  int
 	remote *settings.Remote
 	dialer net.Dialer
-	tcp    *net.TCPListener
+	tcp    net.Listener
 	udp    *udpListener
 }
 
@@ -43,6 +49,13 @@ func NewProxy(logger *cio.Logger, sshTun sshTunnel, index int, remote *settings.
 func (p *Proxy) listen() error {
 	if p.remote.Stdio {
 		//TODO check if pipes active?
+	} else if p.remote.Transparent {
+		l, err := listenTransparent(p.remote.Local())
+		if err != nil {
+			return p.Errorf("transparent: %s", err)
+		}
+		p.Infof("Listening")
+		p.tcp = l
 	} else if p.remote.LocalProto == "tcp" {
 		addr, err := net.ResolveTCPAddr("tcp", p.remote.LocalHost+":"+p.remote.LocalPort)
 		if err != nil {
@@ -54,6 +67,18 @@ func (p *Proxy) listen() error {
 		}
 		p.Infof("Listening")
 		p.tcp = l
+	} else if p.remote.LocalProto == "unix" {
+		//replace a stale socket
+		path := p.remote.LocalHost
+		if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+			os.Remove(path)
+		}
+		l, err := net.Listen("unix", path)
+		if err != nil {
+			return p.Errorf("unix: %s", err)
+		}
+		p.Infof("Listening")
+		p.tcp = l
 	} else if p.remote.LocalProto == "udp" {
 		l, err := listenUDP(p.Logger, p.sshTun, p.remote)
 		if err != nil {
@@ -72,7 +97,7 @@ func (p *Proxy) listen() error {
 func (p *Proxy) Run(ctx context.Context) error {
 	if p.remote.Stdio {
 		return p.runStdio(ctx)
-	} else if p.remote.LocalProto == "tcp" {
+	} else if p.remote.LocalProto == "tcp" || p.remote.LocalProto == "unix" {
 		return p.runTCP(ctx)
 	} else if p.remote.LocalProto == "udp" {
 		return p.udp.run(ctx)
@@ -83,7 +108,7 @@ func (p *Proxy) Run(ctx context.Context) error {
 func (p *Proxy) runStdio(ctx context.Context) error {
 	defer p.Infof("Closed")
 	for {
-		p.pipeRemote(ctx, cio.Stdio)
+		p.pipeRemote(ctx, cio.Stdio, p.remote.Remote())
 		select {
 		case <-ctx.Done():
 			return nil
@@ -116,11 +141,43 @@ func (p *Proxy) runTCP(ctx context.Context) error {
 			close(done)
 			return err
 		}
-		go p.pipeRemote(ctx, src)
+		if p.sshTun.connLimited() {
+			p.Debugf("Connection limit reached, closing %s", src.RemoteAddr())
+			src.Close()
+			continue
+		}
+		remote := p.remote.Remote()
+		if p.remote.Transparent {
+			dst, err := p.originalDst(src)
+			if err != nil {
+				p.Debugf("Closing %s: %s", src.RemoteAddr(), err)
+				src.Close()
+				continue
+			}
+			remote = transparentPrefix + dst
+		}
+		go p.pipeRemote(ctx, p.sshTun.track(src, p.remote.String(), cnet.Accepted), remote)
+	}
+}
+
+//transparentPrefix marks the channels of transparent remotes
+const transparentPrefix = "transparent:"
+
+//originalDst is where a redirected connection was headed,
+//connections which weren't redirected have no destination
+func (p *Proxy) originalDst(src net.Conn) (string, error) {
+	dst, err := originalDst(src)
+	if err != nil {
+		return "", err
+	}
+	_, port, _ := net.SplitHostPort(p.tcp.Addr().String())
+	if _, dstPort, _ := net.SplitHostPort(dst); dst == src.LocalAddr().String() && dstPort == port {
+		return "", errors.New("not a redirected connection")
 	}
+	return dst, nil
 }
 
-func (p *Proxy) pipeRemote(ctx context.Context, src io.ReadWriteCloser) {
+func (p *Proxy) pipeRemote(ctx context.Context, src io.ReadWriteCloser, remote string) {
 	defer src.Close()
 	p.count++
 	cid := p.count
@@ -132,13 +189,13 @@ func (p *Proxy) pipeRemote(ctx context.Context, src io.ReadWriteCloser) {
 		return
 	}
 	//ssh request for tcp connection for this proxy's remote
-	dst, reqs, err := sshConn.OpenChannel("chisel", []byte(p.remote.Remote()))
+	dst, reqs, err := sshConn.OpenChannel("chisel", []byte(remote))
 	if err != nil {
 		l.Infof("Stream error: %s", err)
 		return
 	}
 	go ssh.DiscardRequests(reqs)
 	//then pipe
-	s, r := cio.Pipe(src, dst)
+	s, r := cio.Pipe(src, p.sshTun.remoteTraffic(p.remote.String()).Count(dst))
 	l.Debugf("Close (sent %s received %s)", sizestr.ToString(s), sizestr.ToString(r))
 }
@@ -162,10 +162,11 @@ func (u *udpListener) getUDPChan(ctx context.Context) (*udpChannel, error) {
 	//ssh request for udp packets for this proxy's remote,
 	//just "udp" since the remote address is sent with each packet
 	dstAddr := u.remote.Remote() + "/udp"
-	rwc, reqs, err := sshConn.OpenChannel("chisel", []byte(dstAddr))
+	ch, reqs, err := sshConn.OpenChannel("chisel", []byte(dstAddr))
 	if err != nil {
 		return nil, fmt.Errorf("ssh-chan error: %s", err)
 	}
+	rwc := u.sshTun.remoteTraffic(u.remote.String()).Count(ch)
 	go ssh.DiscardRequests(reqs)
 	//remove on disconnect
 	go u.unsetUDPChan(sshConn)
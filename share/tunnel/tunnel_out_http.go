@@ -0,0 +1,108 @@
+package tunnel
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/jpillora/chisel/share/cio"
+	"github.com/jpillora/chisel/share/cnet"
+)
+
+// hopHeaders apply to a single connection, so aren't forwarded
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+func removeHopHeaders(h http.Header) {
+	for _, f := range h["Connection"] {
+		for _, name := range strings.Split(f, ",") {
+			h.Del(strings.TrimSpace(name))
+		}
+	}
+	for _, name := range hopHeaders {
+		h.Del(name)
+	}
+}
+
+// handleHTTPProxy serves HTTP proxy requests on the channel,
+// CONNECT requests are tunnelled to their host, and the
+// others are forwarded to their absolute URI
+func (t *Tunnel) handleHTTPProxy(l *cio.Logger, src io.ReadWriteCloser, remote string) error {
+	conn := cnet.NewRWCConn(src)
+	r := bufio.NewReader(conn)
+	for {
+		req, err := http.ReadRequest(r)
+		if err != nil {
+			return err
+		}
+		if req.Method == "CONNECT" {
+			return t.httpConnect(l, conn, r, req, remote)
+		}
+		if !req.URL.IsAbs() {
+			httpProxyError(conn, http.StatusBadRequest)
+			return nil
+		}
+		l.Debugf("%s %s", req.Method, req.URL)
+		req.RequestURI = ""
+		removeHopHeaders(req.Header)
+		resp, err := t.httpTransport.RoundTrip(req)
+		if err != nil {
+			httpProxyError(conn, http.StatusBadGateway)
+			return err
+		}
+		removeHopHeaders(resp.Header)
+		//bodies without a length are delimited by closing
+		closing := resp.ContentLength < 0 && len(resp.TransferEncoding) == 0
+		err = resp.Write(conn)
+		resp.Body.Close()
+		if err != nil || closing || req.Close || resp.Close {
+			return err
+		}
+	}
+}
+
+// httpConnect tunnels the connection to the host of req
+func (t *Tunnel) httpConnect(l *cio.Logger, conn net.Conn, r *bufio.Reader, req *http.Request, remote string) error {
+	l.Debugf("CONNECT %s", req.Host)
+	dst, err := net.DialTimeout("tcp", req.Host, t.DialTimeout)
+	if err != nil {
+		httpProxyError(conn, http.StatusBadGateway)
+		return err
+	}
+	dst = t.track(dst, remote, cnet.Dialed)
+	if _, err := io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n"); err != nil {
+		dst.Close()
+		return err
+	}
+	//flush what the client sent early
+	if n := r.Buffered(); n > 0 {
+		b, _ := r.Peek(n)
+		if _, err := dst.Write(b); err != nil {
+			dst.Close()
+			return err
+		}
+	}
+	cio.Pipe(conn, dst)
+	return nil
+}
+
+func httpProxyError(w io.Writer, status int) {
+	resp := &http.Response{
+		StatusCode: status,
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Close:      true,
+	}
+	resp.Write(w)
+}
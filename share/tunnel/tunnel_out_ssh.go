@@ -1,6 +1,8 @@
 package tunnel
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -8,6 +10,7 @@ import (
 
 	"github.com/jpillora/chisel/share/cio"
 	"github.com/jpillora/chisel/share/cnet"
+	"github.com/jpillora/chisel/share/ctrace"
 	"github.com/jpillora/chisel/share/settings"
 	"github.com/jpillora/sizestr"
 	"golang.org/x/crypto/ssh"
@@ -18,25 +21,53 @@ func (t *Tunnel) handleSSHRequests(reqs <-chan *ssh.Request) {
 		switch r.Type {
 		case "ping":
 			r.Reply(true, []byte("pong"))
+		case "remotes":
+			t.handleRemotes(r)
+		case "goaway":
+			if t.OnGoAway != nil {
+				t.OnGoAway()
+			}
 		default:
 			t.Debugf("Unknown request: %s", r.Type)
 		}
 	}
 }
 
-func (t *Tunnel) handleSSHChannels(chans <-chan ssh.NewChannel) {
+func (t *Tunnel) handleRemotes(r *ssh.Request) {
+	if t.OnRemotes == nil {
+		r.Reply(false, []byte("remotes updates not supported"))
+		return
+	}
+	var reply []byte
+	u, err := settings.DecodeRemotesUpdate(r.Payload)
+	if err == nil {
+		reply, err = t.OnRemotes(u)
+	}
+	if err != nil {
+		t.Debugf("Remotes update failed: %s", err)
+		r.Reply(false, []byte(err.Error()))
+		return
+	}
+	r.Reply(true, reply)
+}
+
+func (t *Tunnel) handleSSHChannels(ctx context.Context, chans <-chan ssh.NewChannel) {
 	for ch := range chans {
-		go t.handleSSHChannel(ch)
+		go t.handleSSHChannel(ctx, ch)
 	}
 }
 
-func (t *Tunnel) handleSSHChannel(ch ssh.NewChannel) {
+func (t *Tunnel) handleSSHChannel(ctx context.Context, ch ssh.NewChannel) {
+	remote := string(ch.ExtraData())
+	//the span covers the channel being established, not its traffic
+	ctx, span := ctrace.Start(ctx, "ssh.channel_open", ctrace.KindServer)
+	span.SetAttr("chisel.remote", remote)
 	if !t.Config.Outbound {
 		t.Debugf("Denied outbound connection")
 		ch.Reject(ssh.Prohibited, "Denied outbound connection")
+		span.End(errors.New("denied outbound connection"))
 		return
 	}
-	remote := string(ch.ExtraData())
 	//extract protocol
 	hostPort, proto := settings.L4Proto(remote)
 	udp := proto == "udp"
@@ -44,14 +75,45 @@ func (t *Tunnel) handleSSHChannel(ch ssh.NewChannel) {
 	if socks && t.socksServer == nil {
 		t.Debugf("Denied socks request, please enable socks")
 		ch.Reject(ssh.Prohibited, "SOCKS5 is not enabled")
+		span.End(errors.New("socks is not enabled"))
+		return
+	}
+	httpProxy := hostPort == "http"
+	if httpProxy && t.httpTransport == nil {
+		t.Debugf("Denied http proxy request, please enable the http proxy")
+		ch.Reject(ssh.Prohibited, "HTTP proxy is not enabled")
+		span.End(errors.New("http proxy is not enabled"))
+		return
+	}
+	if strings.HasPrefix(hostPort, transparentPrefix) {
+		if !t.Config.Transparent {
+			t.Debugf("Denied transparent request, please enable transparent remotes")
+			ch.Reject(ssh.Prohibited, "Transparent remotes are not enabled")
+			span.End(errors.New("transparent remotes are not enabled"))
+			return
+		}
+		//label by the remote, not by each destination
+		hostPort, remote = strings.TrimPrefix(hostPort, transparentPrefix), "transparent"
+	}
+	if strings.HasPrefix(hostPort, "unix:") && !t.Config.UnixSockets {
+		t.Debugf("Denied unix socket request, please enable unix sockets")
+		ch.Reject(ssh.Prohibited, "Unix sockets are not enabled")
+		span.End(errors.New("unix sockets are not enabled"))
+		return
+	}
+	if !socks && !httpProxy && !udp && t.connLimited() {
+		t.Debugf("Denied connection to %s, connection limit reached", hostPort)
+		ch.Reject(ssh.ResourceShortage, "Connection limit reached")
+		span.End(errors.New("connection limit reached"))
 		return
 	}
 	sshChan, reqs, err := ch.Accept()
 	if err != nil {
 		t.Debugf("Failed to accept stream: %s", err)
+		span.End(err)
 		return
 	}
-	stream := io.ReadWriteCloser(sshChan)
+	stream := t.remoteTraffic(remote).Count(sshChan)
 	//cnet.MeterRWC(t.Logger.Fork("sshchan"), sshChan)
 	defer stream.Close()
 	go ssh.DiscardRequests(reqs)
@@ -60,11 +122,19 @@ func (t *Tunnel) handleSSHChannel(ch ssh.NewChannel) {
 	t.connStats.Open()
 	l.Debugf("Open %s", t.connStats.String())
 	if socks {
+		span.End(nil)
 		err = t.handleSocks(stream)
+	} else if httpProxy {
+		span.End(nil)
+		err = t.handleHTTPProxy(l, stream, remote)
 	} else if udp {
+		span.End(nil)
+		if hostPort == "dns" {
+			hostPort = t.resolver()
+		}
 		err = t.handleUDP(l, stream, hostPort)
 	} else {
-		err = t.handleTCP(l, stream, hostPort)
+		err = t.handleTCP(ctx, span, l, stream, remote, hostPort)
 	}
 	t.connStats.Close()
 	errmsg := ""
@@ -78,12 +148,21 @@ func (t *Tunnel) handleSocks(src io.ReadWriteCloser) error {
 	return t.socksServer.ServeConn(cnet.NewRWCConn(src))
 }
 
-func (t *Tunnel) handleTCP(l *cio.Logger, src io.ReadWriteCloser, hostPort string) error {
-	dst, err := net.Dial("tcp", hostPort)
+// handleTCP dials hostPort, ending the channel's open span once connected
+func (t *Tunnel) handleTCP(ctx context.Context, open *ctrace.Span, l *cio.Logger, src io.ReadWriteCloser, remote, hostPort string) error {
+	_, span := ctrace.Start(ctx, "upstream.dial", ctrace.KindClient)
+	span.SetAttr("net.peer.name", hostPort)
+	network := "tcp"
+	if strings.HasPrefix(hostPort, "unix:") {
+		network, hostPort = "unix", strings.TrimPrefix(hostPort, "unix:")
+	}
+	dst, err := net.DialTimeout(network, hostPort, t.DialTimeout)
+	span.End(err)
+	open.End(err)
 	if err != nil {
 		return err
 	}
-	s, r := cio.Pipe(src, dst)
+	s, r := cio.Pipe(src, t.track(dst, remote, cnet.Dialed))
 	l.Debugf("sent %s received %s", sizestr.ToString(s), sizestr.ToString(r))
 	return nil
 }
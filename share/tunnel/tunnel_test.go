@@ -0,0 +1,38 @@
+package tunnel
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// pingConn answers keepalives after delay
+type pingConn struct {
+	ssh.Conn
+	delay time.Duration
+}
+
+func (c pingConn) SendRequest(name string, wantReply bool, payload []byte) (bool, []byte, error) {
+	time.Sleep(c.delay)
+	return true, []byte("pong"), nil
+}
+
+func TestPingTimeout(t *testing.T) {
+	tun := &Tunnel{Config: Config{KeepAliveTimeout: 50 * time.Millisecond}}
+	if b, err := tun.ping(pingConn{}); err != nil || string(b) != "pong" {
+		t.Fatalf("expected a pong, got %q %v", b, err)
+	}
+	t0 := time.Now()
+	if _, err := tun.ping(pingConn{delay: time.Second}); err == nil {
+		t.Fatal("expected an unanswered keepalive to time out")
+	}
+	if d := time.Since(t0); d > 500*time.Millisecond {
+		t.Fatalf("expected to give up after the timeout, took %s", d)
+	}
+	//without a timeout, the reply is waited for
+	tun.KeepAliveTimeout = 0
+	if b, err := tun.ping(pingConn{delay: 100 * time.Millisecond}); err != nil || string(b) != "pong" {
+		t.Fatalf("expected a pong, got %q %v", b, err)
+	}
+}
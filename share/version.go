@@ -7,4 +7,24 @@ package chshare
 const ProtocolVersion = "chisel-v3"
 const CraveProtocolVersion = "craveconnect-v3"
 
+//ResumeHeader negotiates resuming sessions, the client sends
+//"new" and the server, when it allows resuming, replies with
+//the session's token and ResumeWindowHeader. The client sends
+//the token to attach a new websocket to the session.
+const ResumeHeader = "X-Chisel-Resume"
+const ResumeWindowHeader = "X-Chisel-Resume-Window"
+
+//AffinityHeader names the server of a cluster the client was
+//connected to, also set as the AffinityCookie, which the client
+//sends when reconnecting to be routed back to the server
+const AffinityHeader = "X-Chisel-Affinity"
+const AffinityCookie = "chisel-affinity"
+
+//UserHeader is the user the client will authenticate as, which
+//servers with per-user session limits check before upgrading
+const UserHeader = "X-Chisel-User"
+
 var BuildVersion = "0.0.0-src"
+
+//BuildCommit is the git commit of the build, set with -ldflags
+var BuildCommit = "unknown"
@@ -0,0 +1,41 @@
+package e2e_test
+
+import (
+	"testing"
+	"time"
+
+	chclient "github.com/jpillora/chisel/client"
+	chserver "github.com/jpillora/chisel/server"
+)
+
+func TestEphemeralReverse(t *testing.T) {
+	conf := testLayout{
+		server: &chserver.Config{
+			Reverse: true,
+		},
+		client: &chclient.Config{
+			Remotes: []string{"R:0:$FILEPORT"},
+		},
+		fileServer: true,
+	}
+	_, client, teardown := conf.setup(t)
+	defer teardown()
+	//the server reports the port it picked
+	port := ""
+	for deadline := time.Now().Add(5 * time.Second); port == ""; {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the allocated port")
+		}
+		time.Sleep(50 * time.Millisecond)
+		if st := client.Status(); len(st.Remotes) == 1 {
+			port = st.Remotes[0].Port
+		}
+	}
+	result, err := post("http://localhost:"+port, "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != "foo!" {
+		t.Fatalf("expected exclamation mark added")
+	}
+}
@@ -0,0 +1,48 @@
+package e2e_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	chclient "github.com/jpillora/chisel/client"
+	chserver "github.com/jpillora/chisel/server"
+)
+
+func TestHTTPProxy(t *testing.T) {
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		w.Write(append(b, '!'))
+	}))
+	defer endpoint.Close()
+	endpointTLS := httptest.NewTLSServer(endpoint.Config.Handler)
+	defer endpointTLS.Close()
+	tmpPort := availablePort()
+	//setup server, client
+	teardown := simpleSetup(t,
+		&chserver.Config{
+			HTTPProxy: true,
+		},
+		&chclient.Config{
+			Remotes: []string{tmpPort + ":http"},
+		})
+	defer teardown()
+	proxyURL, _ := url.Parse("http://127.0.0.1:" + tmpPort)
+	//absolute-URI requests, and CONNECT for https
+	for _, u := range []string{endpoint.URL, endpointTLS.URL} {
+		transport := endpointTLS.Client().Transport.(*http.Transport).Clone()
+		transport.Proxy = http.ProxyURL(proxyURL)
+		resp, err := (&http.Client{Transport: transport}).Post(u, "text/plain", strings.NewReader("foo"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(b) != "foo!" {
+			t.Fatalf("expected exclamation mark added, got %q", b)
+		}
+	}
+}
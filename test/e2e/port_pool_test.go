@@ -0,0 +1,45 @@
+package e2e_test
+
+import (
+	"testing"
+	"time"
+
+	chclient "github.com/jpillora/chisel/client"
+	chserver "github.com/jpillora/chisel/server"
+)
+
+func TestPortPool(t *testing.T) {
+	pool := availablePort()
+	conf := testLayout{
+		server: &chserver.Config{
+			Reverse:   true,
+			PortPools: []string{"jobs:" + pool},
+		},
+		client: &chclient.Config{
+			Remotes: []string{"R:@jobs:$FILEPORT"},
+		},
+		fileServer: true,
+	}
+	_, client, teardown := conf.setup(t)
+	defer teardown()
+	//the server allocates the pool's port
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the allocated port")
+		}
+		time.Sleep(50 * time.Millisecond)
+		if st := client.Status(); len(st.Remotes) == 1 && st.Remotes[0].Port != "" {
+			if st.Remotes[0].Port != pool {
+				t.Fatalf("expected port %s, got %s", pool, st.Remotes[0].Port)
+			}
+			break
+		}
+	}
+	result, err := post("http://localhost:"+pool, "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != "foo!" {
+		t.Fatalf("expected exclamation mark added")
+	}
+}
@@ -0,0 +1,61 @@
+package e2e_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	chclient "github.com/jpillora/chisel/client"
+	chserver "github.com/jpillora/chisel/server"
+)
+
+func TestPublicRemote(t *testing.T) {
+	conf := testLayout{
+		server: &chserver.Config{
+			Reverse:      true,
+			PublicDomain: "demo.example.com",
+		},
+		client: &chclient.Config{
+			Remotes: []string{"R:http://myapp:$FILEPORT"},
+		},
+		fileServer: true,
+	}
+	_, client, teardown := conf.setup(t)
+	defer teardown()
+	//the server reports where it exposed the remote
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the public URL")
+		}
+		time.Sleep(50 * time.Millisecond)
+		if st := client.Status(); len(st.Remotes) == 1 && st.Remotes[0].URL != "" {
+			if st.Remotes[0].URL != "http://myapp.demo.example.com" {
+				t.Fatalf("unexpected URL %s", st.Remotes[0].URL)
+			}
+			break
+		}
+	}
+	req, _ := http.NewRequest("POST", conf.client.Server, strings.NewReader("foo"))
+	req.Host = "myapp.demo.example.com"
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, _ := ioutil.ReadAll(resp.Body)
+	if string(b) != "foo!" {
+		t.Fatalf("expected exclamation mark added, got %s", b)
+	}
+	//other subdomains aren't tunnelled
+	req.Host = "other.demo.example.com"
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected a 404, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,57 @@
+package e2e_test
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	chclient "github.com/jpillora/chisel/client"
+	chserver "github.com/jpillora/chisel/server"
+)
+
+func TestPushRemotes(t *testing.T) {
+	backend := availablePort()
+	l, err := net.Listen("tcp", "127.0.0.1:"+backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go http.Serve(l, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		w.Write(append(b, '!'))
+	}))
+	forward, reverse := availablePort(), availablePort()
+	conf := testLayout{
+		server: &chserver.Config{
+			Reverse: true,
+			PushRemotes: []string{
+				forward + ":127.0.0.1:" + backend,
+				"R:" + reverse + ":127.0.0.1:" + backend,
+			},
+		},
+		//without remotes of its own
+		client: &chclient.Config{AcceptPushed: true},
+	}
+	_, client, teardown := conf.setup(t)
+	defer teardown()
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the pushed remotes")
+		}
+		time.Sleep(50 * time.Millisecond)
+		if st := client.Status(); len(st.Remotes) == 2 && st.Remotes[0].Pushed && st.Remotes[1].Pushed {
+			break
+		}
+	}
+	for _, port := range []string{forward, reverse} {
+		result, err := post("http://localhost:"+port, "foo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result != "foo!" {
+			t.Fatalf("expected exclamation mark added")
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package e2e_test
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	chclient "github.com/jpillora/chisel/client"
+	chserver "github.com/jpillora/chisel/server"
+)
+
+func TestResume(t *testing.T) {
+	//an echo server, behind the tunnel
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+	go func() {
+		for {
+			c, err := echo.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				r := bufio.NewReader(c)
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						c.Close()
+						return
+					}
+					c.Write([]byte(line))
+				}
+			}()
+		}
+	}()
+	//the client's websockets, which the test breaks
+	var mut sync.Mutex
+	var transports []net.Conn
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		c, err := (&net.Dialer{}).DialContext(ctx, network, addr)
+		if err == nil {
+			mut.Lock()
+			transports = append(transports, c)
+			mut.Unlock()
+		}
+		return c, err
+	}
+	tmpPort := availablePort()
+	teardown := simpleSetup(t,
+		&chserver.Config{
+			ResumeWindow: 10 * time.Second,
+		},
+		&chclient.Config{
+			Remotes:     []string{tmpPort + ":" + echo.Addr().String()},
+			DialContext: dial,
+		})
+	defer teardown()
+	conn, err := net.Dial("tcp", "127.0.0.1:"+tmpPort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	roundTrip := func(line string) {
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		if _, err := conn.Write([]byte(line + "\n")); err != nil {
+			t.Fatal(err)
+		}
+		got, err := r.ReadString('\n')
+		if err != nil || got != line+"\n" {
+			t.Fatalf("expected %q echoed, got %q (%v)", line, got, err)
+		}
+	}
+	roundTrip("before")
+	//the network fails, the tunnelled connection survives
+	mut.Lock()
+	for _, c := range transports {
+		c.Close()
+	}
+	mut.Unlock()
+	roundTrip("after")
+	mut.Lock()
+	n := len(transports)
+	mut.Unlock()
+	if n != 2 {
+		t.Fatalf("expected the client to resume with a second websocket, got %d", n)
+	}
+}
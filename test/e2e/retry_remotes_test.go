@@ -0,0 +1,58 @@
+package e2e_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	chclient "github.com/jpillora/chisel/client"
+	chserver "github.com/jpillora/chisel/server"
+)
+
+func TestRetryReverseRemote(t *testing.T) {
+	//the server's port is taken, until the test frees it
+	tmpPort := availablePort()
+	taken, err := net.Listen("tcp", "0.0.0.0:"+tmpPort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+	conf := testLayout{
+		server: &chserver.Config{
+			Reverse: true,
+		},
+		client: &chclient.Config{
+			Remotes:          []string{"R:" + tmpPort + ":$FILEPORT"},
+			MinRetryInterval: 100 * time.Millisecond,
+			MaxRetryInterval: time.Second,
+		},
+		fileServer: true,
+	}
+	_, client, teardown := conf.setup(t)
+	defer teardown()
+	pending := func() bool {
+		st := client.Status()
+		return len(st.Remotes) == 1 && st.Remotes[0].Pending
+	}
+	waitFor := func(what string, cond func() bool) {
+		for deadline := time.Now().Add(5 * time.Second); !cond(); {
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %s", what)
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+	//the session is up, with the remote pending
+	waitFor("the remote to be pending", func() bool {
+		return client.Status().Connected && pending()
+	})
+	taken.Close()
+	waitFor("the remote to bind", func() bool { return !pending() })
+	result, err := post("http://localhost:"+tmpPort, "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != "foo!" {
+		t.Fatalf("expected exclamation mark added")
+	}
+}
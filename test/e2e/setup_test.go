@@ -54,7 +54,10 @@ func (tl *testLayout) setup(t *testing.T) (server *chserver.Server, client *chcl
 			f.Close()
 		}()
 	}
-	//server
+	//server, whose dcmaster is never dialed by the tests
+	if tl.server.DCMasterPort == "" {
+		tl.server.DCMasterPort = "1"
+	}
 	server, err := chserver.NewServer(tl.server)
 	if err != nil {
 		t.Fatal(err)